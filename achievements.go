@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// achievement is a named badge awarded once to a chat or to a user
+type achievement struct {
+	ID          string
+	Title       string
+	Description string
+}
+
+var achievementList = []achievement{
+	{"streak_7", "Неделя чистоты", "первая серия в 7 дней"},
+	{"streak_30", "Месяц тишины", "первая серия в 30 дней"},
+	{"streak_100", "Сотня", "первая серия в 100 дней"},
+	{"streak_365", "Год без", "первая серия в 365 дней"},
+	{"quick_reset", "Рецидив", "сброс меньше чем через 5 минут после предыдущего"},
+	{"triggered_1", "Первая кровь", "впервые сработал счётчик"},
+	{"triggered_10", "Рецидивист", "счётчик сработал 10 раз"},
+	{"triggered_50", "Знаток темы", "счётчик сработал 50 раз"},
+}
+
+// threshold maps a count to the achievement awarded on reaching it
+type threshold struct {
+	n  int
+	id string
+}
+
+var (
+	streakBadges  = []threshold{{7, "streak_7"}, {30, "streak_30"}, {100, "streak_100"}, {365, "streak_365"}}
+	triggerBadges = []threshold{{1, "triggered_1"}, {10, "triggered_10"}, {50, "triggered_50"}}
+)
+
+const quickResetWindow = 5 * time.Minute
+
+func achievementByID(id string) achievement {
+	for _, a := range achievementList {
+		if a.ID == id {
+			return a
+		}
+	}
+	return achievement{ID: id, Title: id}
+}
+
+// UserState is what the bot remembers about a chat member
+type UserState struct {
+	Username     string               `json:"username,omitempty"`
+	Name         string               `json:"name,omitempty"`
+	Achievements map[string]time.Time `json:"achievements,omitempty"`
+}
+
+// User returns the state of the user behind ev, refreshing their names
+func (st *ChatState) User(ev Event) *UserState {
+	if st.Users == nil {
+		st.Users = make(map[int64]*UserState)
+	}
+	u, ok := st.Users[ev.UserID]
+	if !ok {
+		u = &UserState{}
+		st.Users[ev.UserID] = u
+	}
+	u.Username, u.Name = ev.Username, ev.Name
+	return u
+}
+
+// awarded is a freshly unlocked achievement; Who is empty for chat ones
+type awarded struct {
+	ID  string
+	Who string
+}
+
+func grant(set *map[string]time.Time, id string, now time.Time) bool {
+	if *set == nil {
+		*set = make(map[string]time.Time)
+	}
+	if _, ok := (*set)[id]; ok {
+		return false
+	}
+	(*set)[id] = now
+	return true
+}
+
+// streakAchievements awards chat badges for streak lengths reached by any
+// counter. With dryRun set it only reports what would be awarded.
+func (st *ChatState) streakAchievements(now time.Time, dryRun bool) []awarded {
+	var out []awarded
+	for _, name := range st.CounterNames() {
+		ctr := st.CounterByName(name)
+		if ctr.LastMention.IsZero() {
+			continue
+		}
+		for _, t := range streakBadges {
+			if _, ok := st.Achievements[t.id]; ok || ctr.Days() < t.n {
+				continue
+			}
+			if !dryRun {
+				grant(&st.Achievements, t.id, now)
+			}
+			out = append(out, awarded{ID: t.id})
+		}
+	}
+	return out
+}
+
+// resetAchievements must be called after ev has been recorded as a reset
+func (st *ChatState) resetAchievements(ev Event) []awarded {
+	for i := len(st.History) - 2; i >= 0; i-- {
+		prev := st.History[i]
+		if prev.Type != EventReset || prev.Counter != ev.Counter {
+			continue
+		}
+		if ev.Time.Sub(prev.Time) < quickResetWindow && grant(&st.Achievements, "quick_reset", ev.Time) {
+			return []awarded{{ID: "quick_reset"}}
+		}
+		break
+	}
+	return nil
+}
+
+// detectionAchievements must be called after ev has been recorded as a detection
+func (st *ChatState) detectionAchievements(ev Event) []awarded {
+	if ev.Anonymous {
+		return nil
+	}
+	n := st.Trimmed.hits(rankKey(ev))
+	for _, h := range st.History {
+		if h.Type == EventDetection && h.UserID == ev.UserID {
+			n++
+		}
+	}
+	for _, t := range triggerBadges {
+		if t.n == n && grant(&st.User(ev).Achievements, t.id, ev.Time) {
+			return []awarded{{ID: t.id, Who: ev.Who()}}
+		}
+	}
+	return nil
+}
+
+func achievementText(a awarded) string {
+	ach := achievementByID(a.ID)
+	if a.Who != "" {
+		return fmt.Sprintf("🏆 %s получает достижение «%s» — %s!", a.Who, ach.Title, ach.Description)
+	}
+	return fmt.Sprintf("🏆 Достижение чата: «%s» — %s!", ach.Title, ach.Description)
+}
+
+// announceAchievements posts newly unlocked achievements to the chat
+func announceAchievements(b *tb.Bot, store *Store, chatID int64, list []awarded) {
+	for _, a := range list {
+		slog.Info("Achievement unlocked", "chat_id", chatID, "achievement", a.ID, "who", a.Who)
+		if _, err := postToChat(b, store, chatID, achievementText(a)); err != nil {
+			slog.Error("Failed to announce achievement", "chat_id", chatID, "err", err)
+		}
+	}
+}
+
+func checkStreakAchievements(b *tb.Bot, store *Store, now time.Time) {
+	var due []int64
+	store.View(func(s *Storage) {
+		for chatID, st := range s.ActiveChats() {
+			if len(st.streakAchievements(now, true)) > 0 {
+				due = append(due, chatID)
+			}
+		}
+	})
+	if len(due) == 0 {
+		return
+	}
+	unlocked := make(map[int64][]awarded)
+	store.Update(func(s *Storage) {
+		for _, chatID := range due {
+			unlocked[chatID] = s.Chat(chatID).streakAchievements(now, false)
+		}
+	})
+	for chatID, list := range unlocked {
+		announceAchievements(b, store, chatID, list)
+	}
+}
+
+func listAchievements(set map[string]time.Time) []string {
+	var out []string
+	for _, a := range achievementList {
+		if t, ok := set[a.ID]; ok {
+			out = append(out, fmt.Sprintf("«%s» (%s)", a.Title, t.Format("02.01.2006")))
+		}
+	}
+	return out
+}
+
+func handleAchievements(store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		var sb strings.Builder
+		if err := store.ViewContext(ctxOf(c), func(s *Storage) {
+			st := s.Chat(c.Chat().ID)
+			sb.WriteString("🏆 Достижения чата: ")
+			if chat := listAchievements(st.Achievements); len(chat) > 0 {
+				sb.WriteString(strings.Join(chat, ", "))
+			} else {
+				sb.WriteString("пока нет")
+			}
+
+			var users []string
+			for id, u := range st.Users {
+				if list := listAchievements(u.Achievements); len(list) > 0 {
+					who := Event{UserID: id, Username: u.Username, Name: u.Name}.Who()
+					users = append(users, fmt.Sprintf("%s: %s", who, strings.Join(list, ", ")))
+				}
+			}
+			sort.Strings(users)
+			if len(users) > 0 {
+				sb.WriteString("\n\nУчастники:\n" + strings.Join(users, "\n"))
+			}
+		}); err != nil {
+			return err
+		}
+		return c.Send(sb.String())
+	}
+}
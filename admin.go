@@ -0,0 +1,381 @@
+package main
+
+import (
+	"cmp"
+	"crypto/subtle"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AdminConfig serves a web dashboard for operators: every chat of every bot
+// with its counters and history, editing the keywords of chat counters,
+// storage backups and broadcasts. Users are HTTP basic auth logins and
+// their passwords; keep Listen on loopback or behind an HTTPS proxy.
+type AdminConfig struct {
+	Enabled bool              `yaml:"enabled"`
+	Listen  string            `yaml:"listen"`
+	Users   map[string]string `yaml:"users"`
+	// BackupDir receives the storage backups, "backups" by default
+	BackupDir string `yaml:"backup_dir"`
+}
+
+// adminHistory is how many events the chat page lists
+const adminHistory = 100
+
+type adminChatRow struct {
+	Bot      string
+	ID       int64
+	Counters []staticCounter
+	Events   int
+	Last     string
+}
+
+type adminCounter struct {
+	Name     string
+	Topic    string
+	Days     string
+	Keywords string
+	// Default is the counter of the config, its keywords can't be edited here
+	Default bool
+}
+
+type adminEvent struct {
+	Time    string
+	Type    string
+	Counter string
+	Who     string
+	Keyword string
+	Reason  string
+}
+
+type adminPage struct {
+	User    string
+	Message string
+	Bots    []string
+	Chats   []adminChatRow
+	// chat page
+	Bot      string
+	ChatID   int64
+	Counters []adminCounter
+	Events   []adminEvent
+}
+
+var adminTmpl = template.Must(template.New("admin").Parse(`{{define "head"}}<!DOCTYPE html>
+<html lang="ru">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>dayswithout admin</title>
+<style>` + staticCSS + `
+form{margin:.5em 0}input[type=text],textarea{width:100%;box-sizing:border-box}.msg{background:#eef6e8;padding:.5em}</style>
+</head>
+<body>
+<p><a href="/admin/">Все чаты</a> · {{.User}}</p>
+{{if .Message}}<p class="msg">{{.Message}}</p>{{end}}
+{{end}}
+
+{{define "index"}}{{template "head" .}}
+<h1>Чаты</h1>
+<table>
+<tr><th>Бот</th><th>Чат</th><th>Счётчики</th><th>Событий</th><th>Последнее</th></tr>
+{{range .Chats}}<tr><td>@{{.Bot}}</td><td><a href="/admin/chats/{{.Bot}}/{{.ID}}">{{.ID}}</a></td>
+<td>{{range .Counters}}{{.Topic}}: {{.Days}}<br>{{end}}</td><td>{{.Events}}</td><td>{{.Last}}</td></tr>
+{{end}}</table>
+<h2>Рассылка</h2>
+<form method="post" action="/admin/broadcast">
+<textarea name="text" rows="4" required></textarea>
+<select name="bot"><option value="">все боты</option>{{range .Bots}}<option>{{.}}</option>{{end}}</select>
+<button>Отправить во все группы</button>
+</form>
+<h2>Резервная копия</h2>
+<form method="post" action="/admin/backup"><button>Сохранить копию хранилища</button></form>
+</body></html>
+{{end}}
+
+{{define "chat"}}{{template "head" .}}
+<h1>@{{.Bot}} · {{.ChatID}}</h1>
+<h2>Счётчики</h2>
+<table>
+<tr><th>Тема</th><th>Дней</th><th>Ключевые слова</th></tr>
+{{range .Counters}}<tr><td>{{.Topic}}</td><td>{{.Days}}</td><td>{{if .Default}}{{.Keywords}} <span class="muted">(из конфига)</span>{{else}}
+<form method="post" action="/admin/chats/{{$.Bot}}/{{$.ChatID}}/keywords">
+<input type="hidden" name="counter" value="{{.Name}}"><input type="text" name="keywords" value="{{.Keywords}}">
+<button>Сохранить</button></form>{{end}}</td></tr>
+{{end}}</table>
+<h2>Сообщение в чат</h2>
+<form method="post" action="/admin/chats/{{.Bot}}/{{.ChatID}}/broadcast">
+<textarea name="text" rows="3" required></textarea><button>Отправить</button>
+</form>
+<h2>История</h2>
+<table>
+<tr><th>Когда</th><th>Событие</th><th>Счётчик</th><th>Кто</th><th>Слово</th><th>Причина</th></tr>
+{{range .Events}}<tr><td>{{.Time}}</td><td>{{.Type}}</td><td>{{.Counter}}</td><td>{{.Who}}</td><td>{{.Keyword}}</td><td>{{.Reason}}</td></tr>
+{{end}}</table>
+</body></html>
+{{end}}`))
+
+// startAdminServer serves the dashboard in the background:
+//
+//	GET  /admin/                                 chats of all bots
+//	GET  /admin/chats/{bot}/{id}                 counters and history
+//	POST /admin/chats/{bot}/{id}/keywords        counter, keywords (comma separated)
+//	POST /admin/chats/{bot}/{id}/broadcast       text to the chat
+//	POST /admin/broadcast                        text to every group, of bot or all
+//	POST /admin/backup                           copies every storage to backup_dir
+func startAdminServer(cfg Config, bots []*botInstance) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/admin/", http.StatusFound)
+	})
+	mux.HandleFunc("GET /admin/{$}", func(w http.ResponseWriter, r *http.Request) {
+		page := adminPageOf(r)
+		for _, bi := range bots {
+			bot := bi.bot().Me.Username
+			page.Bots = append(page.Bots, bot)
+			bi.store.View(func(s *Storage) {
+				for chatID, st := range s.ActiveChats() {
+					row := adminChatRow{Bot: bot, ID: chatID, Counters: staticChatOf(bi.cfg, chatID, st).Counters, Events: len(st.History)}
+					if n := len(st.History); n > 0 {
+						row.Last = st.History[n-1].Time.In(chatLocation(bi.cfg, st)).Format("02.01.2006 15:04")
+					}
+					page.Chats = append(page.Chats, row)
+				}
+			})
+		}
+		slices.SortFunc(page.Chats, func(a, b adminChatRow) int {
+			if c := strings.Compare(a.Bot, b.Bot); c != 0 {
+				return c
+			}
+			return cmp.Compare(a.ID, b.ID)
+		})
+		renderAdmin(w, "index", page)
+	})
+	mux.HandleFunc("GET /admin/chats/{bot}/{id}", func(w http.ResponseWriter, r *http.Request) {
+		bi, id, ok := adminChatOf(bots, w, r)
+		if !ok {
+			return
+		}
+		page := adminPageOf(r)
+		page.Bot, page.ChatID = r.PathValue("bot"), id
+		bi.store.View(func(s *Storage) {
+			st := s.Chat(id)
+			loc := chatLocation(bi.cfg, st)
+			for _, name := range st.CounterNames() {
+				ctr := st.CounterByName(name)
+				ac := adminCounter{Name: name, Topic: counterTopic(bi.cfg, ctr), Days: "—", Keywords: strings.Join(ctr.Keywords, ", ")}
+				if name == "" {
+					ac.Default, ac.Keywords = true, strings.Join(bi.cfg.Keywords, ", ")
+				}
+				if !ctr.LastMention.IsZero() {
+					ac.Days = strconv.Itoa(ctr.Days())
+				}
+				page.Counters = append(page.Counters, ac)
+			}
+			for i := len(st.History) - 1; i >= 0 && len(page.Events) < adminHistory; i-- {
+				ev := st.History[i]
+				page.Events = append(page.Events, adminEvent{
+					Time:    ev.Time.In(loc).Format("02.01.2006 15:04:05"),
+					Type:    ev.Type,
+					Counter: ev.Counter,
+					Who:     ev.Who(),
+					Keyword: ev.Keyword,
+					Reason:  ev.Reason,
+				})
+			}
+		})
+		renderAdmin(w, "chat", page)
+	})
+	mux.HandleFunc("POST /admin/chats/{bot}/{id}/keywords", func(w http.ResponseWriter, r *http.Request) {
+		bi, id, ok := adminChatOf(bots, w, r)
+		if !ok {
+			return
+		}
+		name := r.FormValue("counter")
+		var keywords []string
+		for _, k := range strings.Split(r.FormValue("keywords"), ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				keywords = append(keywords, k)
+			}
+		}
+		msg := "Ключевые слова сохранены."
+		switch {
+		case name == "":
+			msg = "Ключевые слова основного счётчика задаются в конфиге."
+		case len(keywords) == 0:
+			msg = "Нужно хотя бы одно ключевое слово."
+		default:
+			bi.store.Update(func(s *Storage) {
+				ctr := s.Chat(id).Counters[name]
+				if ctr == nil {
+					msg = "Нет такого счётчика."
+					return
+				}
+				ctr.Keywords = keywords
+				slog.Info("Admin changed keywords", "user", adminUser(r), "chat_id", id, "counter", name, "keywords", keywords)
+			})
+		}
+		adminRedirect(w, r, fmt.Sprintf("/admin/chats/%s/%d", r.PathValue("bot"), id), msg)
+	})
+	mux.HandleFunc("POST /admin/chats/{bot}/{id}/broadcast", func(w http.ResponseWriter, r *http.Request) {
+		bi, id, ok := adminChatOf(bots, w, r)
+		if !ok {
+			return
+		}
+		msg := "Сообщение отправлено."
+		if text := strings.TrimSpace(r.FormValue("text")); text == "" {
+			msg = "Пустое сообщение."
+		} else if _, err := postToChat(bi.bot(), bi.store, id, text); err != nil {
+			msg = "Не удалось отправить: " + err.Error()
+		} else {
+			slog.Info("Admin sent a message", "user", adminUser(r), "chat_id", id)
+		}
+		adminRedirect(w, r, fmt.Sprintf("/admin/chats/%s/%d", r.PathValue("bot"), id), msg)
+	})
+	mux.HandleFunc("POST /admin/broadcast", func(w http.ResponseWriter, r *http.Request) {
+		text, only := strings.TrimSpace(r.FormValue("text")), r.FormValue("bot")
+		if text == "" {
+			adminRedirect(w, r, "/admin/", "Пустое сообщение.")
+			return
+		}
+		total := 0
+		for _, bi := range bots {
+			if only != "" && bi.bot().Me.Username != only {
+				continue
+			}
+			var chats []int64
+			bi.store.View(func(s *Storage) {
+				for chatID := range s.ActiveChats() {
+					// personal counters don't get announcements
+					if chatID < 0 {
+						chats = append(chats, chatID)
+					}
+				}
+			})
+			total += len(chats)
+			go broadcast(bi, chats, text)
+		}
+		slog.Info("Admin started a broadcast", "user", adminUser(r), "chats", total)
+		adminRedirect(w, r, "/admin/", fmt.Sprintf("Рассылка запущена: %s.", plural(total, "chat")))
+	})
+	mux.HandleFunc("POST /admin/backup", func(w http.ResponseWriter, r *http.Request) {
+		var files []string
+		msg := ""
+		for _, bi := range bots {
+			path, err := bi.store.Backup(cfg.Admin.BackupDir, time.Now())
+			if err != nil {
+				msg = "Не удалось сохранить копию: " + err.Error()
+				break
+			}
+			files = append(files, path)
+		}
+		if msg == "" {
+			msg = "Копии сохранены: " + strings.Join(files, ", ")
+		}
+		slog.Info("Admin made a backup", "user", adminUser(r), "files", files)
+		adminRedirect(w, r, "/admin/", msg)
+	})
+
+	slog.Info("Admin dashboard listening", "addr", cfg.Admin.Listen)
+	go func() {
+		if err := http.ListenAndServe(cfg.Admin.Listen, adminAuth(cfg.Admin, mux)); err != nil {
+			slog.Error("Admin server stopped", "err", err)
+		}
+	}()
+}
+
+// broadcast posts text to chats one by one, the queue keeps the pace
+func broadcast(bi *botInstance, chats []int64, text string) {
+	sent := 0
+	for _, id := range chats {
+		if _, err := postToChat(bi.bot(), bi.store, id, text); err != nil {
+			slog.Error("Failed to broadcast", "chat_id", id, "err", err)
+			continue
+		}
+		sent++
+	}
+	slog.Info("Broadcast finished", "sent", sent, "chats", len(chats))
+}
+
+// adminAuth checks the basic auth login, and for changes that the request
+// comes from the dashboard itself, against cross-site form posts
+func adminAuth(cfg AdminConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		want, known := cfg.Users[user]
+		if !ok || !known || subtle.ConstantTimeCompare([]byte(pass), []byte(want)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="dayswithout admin", charset="UTF-8"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet && !sameOrigin(r) {
+			http.Error(w, "cross-origin request", http.StatusForbidden)
+			return
+		}
+		r.Header.Set("X-Admin-User", user)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sameOrigin reports whether the Origin, or else the Referer, of r is the
+// host it was sent to
+func sameOrigin(r *http.Request) bool {
+	from := r.Header.Get("Origin")
+	if from == "" {
+		from = r.Header.Get("Referer")
+	}
+	u, err := url.Parse(from)
+	return err == nil && u.Host != "" && u.Host == r.Host
+}
+
+func adminUser(r *http.Request) string {
+	return r.Header.Get("X-Admin-User")
+}
+
+func adminPageOf(r *http.Request) adminPage {
+	return adminPage{User: adminUser(r), Message: r.URL.Query().Get("msg")}
+}
+
+// adminChatOf finds the bot and chat of a /admin/chats/{bot}/{id} request
+func adminChatOf(bots []*botInstance, w http.ResponseWriter, r *http.Request) (*botInstance, int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err == nil {
+		for _, bi := range bots {
+			if bi.bot().Me.Username != r.PathValue("bot") {
+				continue
+			}
+			var found bool
+			bi.store.View(func(s *Storage) { _, found = s.Chats[id] })
+			if found {
+				return bi, id, true
+			}
+		}
+	}
+	http.NotFound(w, r)
+	return nil, 0, false
+}
+
+// adminRedirect sends the browser back to a page after a form post, with
+// msg to show there
+func adminRedirect(w http.ResponseWriter, r *http.Request, path, msg string) {
+	http.Redirect(w, r, path+"?msg="+url.QueryEscape(msg), http.StatusSeeOther)
+}
+
+func renderAdmin(w http.ResponseWriter, name string, page adminPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := adminTmpl.ExecuteTemplate(w, name, page); err != nil {
+		slog.Error("Failed to render admin page", "page", name, "err", err)
+	}
+}
+
+// backupName is the file name of a backup of the storage file at now
+func backupName(file string, now time.Time) string {
+	return strings.TrimSuffix(filepath.Base(file), ".json") + "-" + now.Format("20060102-150405") + ".json"
+}
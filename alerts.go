@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// AlertsConfig sends operational alerts to AdminID in Telegram, at most one
+// summary per MinInterval. StorageFailures consecutive failed writes of
+// data.json make one storage alert.
+type AlertsConfig struct {
+	AdminID         int64         `yaml:"admin_id"`
+	MinInterval     time.Duration `yaml:"min_interval"`
+	StorageFailures int           `yaml:"storage_failures"`
+}
+
+// alertEntry is one kind of problem collected since the last summary
+type alertEntry struct {
+	count int
+	last  string
+}
+
+// alerter batches problems and sends them to the admin as one summary
+type alerter struct {
+	// bot is the current instance of the first bot, see botInstance.bot
+	bot func() *tb.Bot
+	cfg AlertsConfig
+
+	mu           sync.Mutex
+	pending      map[string]*alertEntry
+	lastSent     time.Time
+	storageFails int
+}
+
+var alerts *alerter
+
+func newAlerter(bot func() *tb.Bot, cfg AlertsConfig) *alerter {
+	return &alerter{bot: bot, cfg: cfg, pending: make(map[string]*alertEntry)}
+}
+
+// Alert records a problem of kind and sends the summary if one is due
+func (a *alerter) Alert(kind, msg string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	e, ok := a.pending[kind]
+	if !ok {
+		e = &alertEntry{}
+		a.pending[kind] = e
+	}
+	e.count++
+	e.last = msg
+	a.mu.Unlock()
+	go a.Flush(time.Now())
+}
+
+// StorageResult tracks writes of data.json, alerting once failures repeat
+func (a *alerter) StorageResult(err error) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	if err == nil {
+		a.storageFails = 0
+		a.mu.Unlock()
+		return
+	}
+	a.storageFails++
+	n := a.storageFails
+	a.mu.Unlock()
+	if n == a.cfg.StorageFailures {
+		a.Alert("хранилище", fmt.Sprintf("неудачных записей подряд: %d (%v)", n, err))
+	}
+}
+
+// Flush sends the collected problems unless a summary went out less than
+// MinInterval ago; the scheduler calls it to deliver what was held back
+func (a *alerter) Flush(now time.Time) {
+	a.mu.Lock()
+	if len(a.pending) == 0 || now.Sub(a.lastSent) < a.cfg.MinInterval {
+		a.mu.Unlock()
+		return
+	}
+	pending := a.pending
+	a.pending = make(map[string]*alertEntry)
+	a.lastSent = now
+	a.mu.Unlock()
+
+	kinds := make([]string, 0, len(pending))
+	for kind := range pending {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	var sb strings.Builder
+	sb.WriteString("⚠️ Проблемы в работе бота:")
+	for _, kind := range kinds {
+		e := pending[kind]
+		fmt.Fprintf(&sb, "\n• %s: %s, последняя — %s", kind, plural(e.count, "time"), e.last)
+	}
+	if _, err := a.bot().Send(tb.ChatID(a.cfg.AdminID), sb.String()); err != nil {
+		slog.Error("Failed to send alert", "chat_id", a.cfg.AdminID, "err", err)
+	}
+}
+
+// alertKind tells Bot API failures from other handler errors
+func alertKind(err error) string {
+	var apiErr *tb.Error
+	var floodErr tb.FloodError
+	var groupErr tb.GroupError
+	if errors.As(err, &apiErr) || errors.As(err, &floodErr) || errors.As(err, &groupErr) {
+		return "Bot API"
+	}
+	return "обработчик"
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const (
+	// announceGrace is how long a handler may be sending its announcement
+	// before the "announcements" job takes over. It only matters when the
+	// handler never finishes; it has to be far longer than a send delayed by
+	// the chat queue and retries plus an LLM call.
+	announceGrace = 30 * time.Minute
+	// announceMaxAge is when an announcement that can't be sent is dropped
+	announceMaxAge = 24 * time.Hour
+)
+
+// Announcement is a reset message saved together with the reset and
+// forgotten once it was sent. Resets are announced only after they are
+// saved, and one whose announcement got lost in a crash or a failed send is
+// announced by the "announcements" job.
+type Announcement struct {
+	ID       int64     `json:"id"`
+	ChatID   int64     `json:"chat_id"`
+	ThreadID int       `json:"thread_id,omitempty"`
+	Text     string    `json:"text"`
+	Template string    `json:"template,omitempty"`
+	Created  time.Time `json:"created"`
+	// Sending is set while a handler posts the announcement; a restart
+	// clears it
+	Sending bool `json:"-"`
+}
+
+// queueAnnouncement records text as due in chatID, to be called in the
+// update that makes the reset. With sending the caller posts it itself and
+// confirms or releases it; otherwise it is left to the "announcements" job.
+func (s *Storage) queueAnnouncement(chatID int64, thread int, text templated, now time.Time, sending bool) int64 {
+	id := now.UnixNano()
+	for _, a := range s.Announcements {
+		if a.ID >= id {
+			id = a.ID + 1
+		}
+	}
+	s.Announcements = append(s.Announcements, Announcement{ID: id, ChatID: chatID, ThreadID: thread, Text: text.Text, Template: text.Template, Created: now, Sending: sending})
+	return id
+}
+
+// confirmAnnouncement forgets the announcement id after it was sent
+func (s *Storage) confirmAnnouncement(id int64) {
+	for i, a := range s.Announcements {
+		if a.ID == id {
+			s.Announcements = append(s.Announcements[:i], s.Announcements[i+1:]...)
+			return
+		}
+	}
+}
+
+// releaseAnnouncement hands the announcement id over to the "announcements"
+// job after the handler failed to send it
+func (s *Storage) releaseAnnouncement(id int64) {
+	for i := range s.Announcements {
+		if s.Announcements[i].ID == id {
+			s.Announcements[i].Sending = false
+		}
+	}
+}
+
+// sendAnnouncements is the "announcements" job, which also catches up after a
+// restart: it posts the announcements no handler is sending, and those a
+// handler didn't finish within announceGrace
+func sendAnnouncements(b *tb.Bot, store *Store, now time.Time) {
+	var due []Announcement
+	store.View(func(s *Storage) {
+		for _, a := range s.Announcements {
+			if !a.Sending || now.Sub(a.Created) >= announceGrace {
+				due = append(due, a)
+			}
+		}
+	})
+	for _, a := range due {
+		_, err := b.Send(tb.ChatID(a.ChatID), templated{Text: a.Text, Template: a.Template}, withThread(a.ThreadID, nil)...)
+		switch {
+		case err == nil:
+			slog.Info("Sent pending reset announcement", "chat_id", a.ChatID, "created", a.Created)
+		case now.Sub(a.Created) > announceMaxAge:
+			slog.Warn("Dropping reset announcement that couldn't be sent", "chat_id", a.ChatID, "created", a.Created, "err", err)
+		default:
+			slog.Error("Failed to send pending reset announcement", "chat_id", a.ChatID, "err", err)
+			continue
+		}
+		store.Update(func(s *Storage) { s.confirmAnnouncement(a.ID) })
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAPIHistory = 50
+	maxAPIHistory     = 1000
+)
+
+// APIConfig enables the read-only JSON API with counter data for embedding
+// the counter elsewhere. Every request needs one of Tokens as a bearer token.
+// Only the listed Chats are served, or every group when the list is empty;
+// private chats have to be listed explicitly.
+type APIConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Listen  string   `yaml:"listen"`
+	Tokens  []string `yaml:"tokens"`
+	Chats   []int64  `yaml:"chats"`
+	// Origins may call the API from a browser (CORS), "*" allows any
+	Origins []string `yaml:"origins"`
+	// Badges serves /badge/<chat id>.svg and .png without a token, for
+	// READMEs and wikis that can't send one, of the BadgeChats only: the
+	// badge shows the topics of the counters
+	Badges     bool    `yaml:"badges"`
+	BadgeChats []int64 `yaml:"badge_chats"`
+	// Feeds serves /feed/<chat id>.rss without a token, for feed readers,
+	// of the FeedChats only: the feed names offenders and reasons
+	Feeds     bool    `yaml:"feeds"`
+	FeedChats []int64 `yaml:"feed_chats"`
+	// Calendars serves /calendar/<chat id>.ics without a token, for
+	// calendar subscriptions, of the CalendarChats only
+	Calendars     bool    `yaml:"calendars"`
+	CalendarChats []int64 `yaml:"calendar_chats"`
+}
+
+type apiCounter struct {
+	Name        string     `json:"name"`
+	Topic       string     `json:"topic"`
+	Days        int        `json:"days"`
+	Streak      int64      `json:"streak_seconds"`
+	LastMention *time.Time `json:"last_mention,omitempty"`
+	Record      int64      `json:"record_seconds"`
+	RecordDays  int        `json:"record_days"`
+	Paused      bool       `json:"paused,omitempty"`
+}
+
+type apiChat struct {
+	ChatID   int64        `json:"chat_id"`
+	Timezone string       `json:"timezone"`
+	Counters []apiCounter `json:"counters"`
+}
+
+type apiEvent struct {
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Counter string    `json:"counter"`
+	Who     string    `json:"who,omitempty"`
+	Keyword string    `json:"keyword,omitempty"`
+	Streak  int64     `json:"streak_seconds,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+}
+
+// startAPIServer serves the API in the background:
+//
+//	GET /api/chats                   IDs of the served chats
+//	GET /api/chats/{id}              counters with streaks and records
+//	GET /api/chats/{id}/history      latest events, ?limit=N&counter=name
+//	GET /api/chats/{id}/trigger      latest events as simpleEvent for Zapier polling, ?type=reset|detection
+//	GET /badge/{id}.svg, .png        public streak badge, see serveBadge
+//	GET /feed/{id}.rss               public RSS feed of resets, see serveFeed
+//	GET /calendar/{id}.ics           public iCal of milestones and resets, see serveCalendar
+//	GET /app/                        Telegram Mini App, see serveMiniApp
+func startAPIServer(cfg Config, bots []*botInstance) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chats", func(w http.ResponseWriter, r *http.Request) {
+		ids := []int64{}
+		for _, bi := range bots {
+			bi.store.View(func(s *Storage) {
+				for id, st := range s.Chats {
+					if st.Left.IsZero() && apiServes(cfg.API, id) && !slices.Contains(ids, id) {
+						ids = append(ids, id)
+					}
+				}
+			})
+		}
+		slices.Sort(ids)
+		writeAPI(w, http.StatusOK, ids)
+	})
+	mux.HandleFunc("GET /api/chats/{id}", func(w http.ResponseWriter, r *http.Request) {
+		bi, id, ok := apiChatOf(cfg, bots, w, r)
+		if !ok {
+			return
+		}
+		var chat apiChat
+		bi.store.View(func(s *Storage) {
+			chat = apiChatState(bi.cfg, id, s.Chat(id))
+		})
+		writeAPI(w, http.StatusOK, chat)
+	})
+	mux.HandleFunc("GET /api/chats/{id}/history", func(w http.ResponseWriter, r *http.Request) {
+		bi, id, ok := apiChatOf(cfg, bots, w, r)
+		if !ok {
+			return
+		}
+		limit := defaultAPIHistory
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeAPIError(w, http.StatusBadRequest, "invalid limit")
+				return
+			}
+			limit = min(n, maxAPIHistory)
+		}
+		counter, filtered := r.URL.Query().Get("counter"), r.URL.Query().Has("counter")
+		events := []apiEvent{}
+		bi.store.View(func(s *Storage) {
+			history := s.Chat(id).History
+			for i := len(history) - 1; i >= 0 && len(events) < limit; i-- {
+				ev := history[i]
+				if filtered && ev.Counter != strings.ToLower(counter) {
+					continue
+				}
+				events = append(events, apiEventOf(ev))
+			}
+		})
+		writeAPI(w, http.StatusOK, events)
+	})
+	mux.HandleFunc("GET /api/chats/{id}/trigger", func(w http.ResponseWriter, r *http.Request) {
+		bi, id, ok := apiChatOf(cfg, bots, w, r)
+		if !ok {
+			return
+		}
+		typ := r.URL.Query().Get("type")
+		if typ != "" && typ != EventReset && typ != EventDetection {
+			writeAPIError(w, http.StatusBadRequest, "invalid type, expected reset or detection")
+			return
+		}
+		bot := bi.bot().Me.Username
+		events := []simpleEvent{}
+		bi.store.View(func(s *Storage) {
+			st := s.Chat(id)
+			for i := len(st.History) - 1; i >= 0 && len(events) < defaultAPIHistory; i-- {
+				ev := st.History[i]
+				if typ != "" && ev.Type != typ {
+					continue
+				}
+				topic := ev.Counter
+				if ctr := st.CounterByName(ev.Counter); ctr != nil {
+					topic = counterTopic(bi.cfg, ctr)
+				}
+				ce := historyEvent(ev.Type, bot, id, topic, ev)
+				// the streak at a detection isn't kept in the history
+				if ev.Type == EventReset {
+					ce.Days, ce.Streak = durationDays(ev.Streak), int64(ev.Streak.Seconds())
+				}
+				events = append(events, simpleEventOf(ce))
+			}
+		})
+		writeAPI(w, http.StatusOK, events)
+	})
+
+	root := http.NewServeMux()
+	root.Handle("/api/", apiAuth(cfg.API, mux))
+	if cfg.API.Badges {
+		root.HandleFunc("GET /badge/{file}", serveBadge(cfg, bots))
+	}
+	if cfg.API.Feeds {
+		root.HandleFunc("GET /feed/{file}", serveFeed(cfg, bots))
+	}
+	if cfg.API.Calendars {
+		root.HandleFunc("GET /calendar/{file}", serveCalendar(cfg, bots))
+	}
+	if slices.ContainsFunc(bots, func(bi *botInstance) bool { return bi.cfg.MiniApp.URL != "" }) {
+		serveMiniApp(root, bots)
+	}
+
+	slog.Info("API listening", "addr", cfg.API.Listen)
+	go func() {
+		if err := http.ListenAndServe(cfg.API.Listen, root); err != nil {
+			slog.Error("API server stopped", "err", err)
+		}
+	}()
+}
+
+// apiChatState describes the counters of a chat
+func apiChatState(cfg Config, id int64, st *ChatState) apiChat {
+	chat := apiChat{ChatID: id, Timezone: chatLocation(cfg, st).String()}
+	for _, name := range st.CounterNames() {
+		ctr := st.CounterByName(name)
+		ac := apiCounter{
+			Name:       name,
+			Topic:      counterTopic(cfg, ctr),
+			Days:       ctr.Days(),
+			Streak:     int64(ctr.Streak().Seconds()),
+			Record:     int64(max(ctr.Record, ctr.Streak()).Seconds()),
+			RecordDays: durationDays(max(ctr.Record, ctr.Streak())),
+			Paused:     ctr.Paused(),
+		}
+		if !ctr.LastMention.IsZero() {
+			ac.LastMention = &ctr.LastMention
+		}
+		chat.Counters = append(chat.Counters, ac)
+	}
+	return chat
+}
+
+func apiEventOf(ev Event) apiEvent {
+	ae := apiEvent{
+		Type:    ev.Type,
+		Time:    ev.Time,
+		Counter: ev.Counter,
+		Keyword: ev.Keyword,
+		Streak:  int64(ev.Streak.Seconds()),
+		Reason:  ev.Reason,
+	}
+	if ev.UserID != 0 || ev.Anonymous {
+		ae.Who = ev.Who()
+	}
+	return ae
+}
+
+// apiAuth answers CORS preflights and lets through requests with a valid
+// bearer token
+func apiAuth(cfg APIConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" && (slices.Contains(cfg.Origins, origin) || slices.Contains(cfg.Origins, "*")) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization")
+			w.Header().Set("Vary", "Origin")
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !validAPIToken(cfg.Tokens, token) {
+			slog.Warn("API request without a valid token", "remote", r.RemoteAddr, "path", r.URL.Path)
+			writeAPIError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func validAPIToken(tokens []string, token string) bool {
+	valid := false
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// apiServes reports whether the chat may be exposed through the API
+func apiServes(cfg APIConfig, chatID int64) bool {
+	if len(cfg.Chats) == 0 {
+		return chatID < 0
+	}
+	return slices.Contains(cfg.Chats, chatID)
+}
+
+// publicChatOf is apiChatOf for the endpoints without a token, which serve
+// only the chats that opted in
+func publicChatOf(cfg Config, bots []*botInstance, chats []int64, w http.ResponseWriter, r *http.Request) (*botInstance, int64, bool) {
+	if id, err := strconv.ParseInt(r.PathValue("id"), 10, 64); err == nil && !slices.Contains(chats, id) {
+		writeAPIError(w, http.StatusNotFound, "unknown chat")
+		return nil, 0, false
+	}
+	return apiChatOf(cfg, bots, w, r)
+}
+
+// apiChatOf finds the bot with the chat of the request, answering 404 when
+// there is none
+func apiChatOf(cfg Config, bots []*botInstance, w http.ResponseWriter, r *http.Request) (*botInstance, int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid chat id")
+		return nil, 0, false
+	}
+	if apiServes(cfg.API, id) {
+		for _, bi := range bots {
+			var found bool
+			bi.store.View(func(s *Storage) {
+				st := s.Chats[id]
+				found = st != nil && st.Left.IsZero()
+			})
+			if found {
+				return bi, id, true
+			}
+		}
+	}
+	writeAPIError(w, http.StatusNotFound, "unknown chat")
+	return nil, 0, false
+}
+
+func writeAPI(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, msg string) {
+	writeAPI(w, status, map[string]string{"error": msg})
+}
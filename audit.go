@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// AuditConfig keeps a log of the messages the bot sends, edits and deletes
+// in File (data-audit.jsonl next to the storage by default), trimmed to the
+// last Keep entries
+type AuditConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	File    string `yaml:"file"`
+	Keep    int    `yaml:"keep"`
+}
+
+// auditEntry is one message action of the bot
+type auditEntry struct {
+	Time      time.Time `json:"time"`
+	ChatID    int64     `json:"chat_id"`
+	MessageID int       `json:"message_id,omitempty"`
+	Method    string    `json:"method"`
+	// Type is the kind of the message: text, photo, sticker, animation...
+	Type     string `json:"type,omitempty"`
+	Template string `json:"template,omitempty"`
+	Text     string `json:"text,omitempty"`
+}
+
+// auditLog holds the last entries in memory and appends new ones to the file
+type auditLog struct {
+	mu      sync.Mutex
+	file    string
+	keep    int
+	entries []auditEntry
+	// lines is the number of lines in the file
+	lines int
+}
+
+// newAuditLog loads the entries written before the restart
+func newAuditLog(cfg AuditConfig) *auditLog {
+	a := &auditLog{file: cfg.File, keep: cfg.Keep}
+	f, err := os.Open(cfg.File)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Failed to read audit log", "file", cfg.File, "err", err)
+		}
+		return a
+	}
+	defer f.Close()
+	lines := bufio.NewScanner(f)
+	lines.Buffer(nil, 1<<20)
+	for lines.Scan() {
+		var e auditEntry
+		if err := json.Unmarshal(lines.Bytes(), &e); err == nil {
+			a.entries = append(a.entries, e)
+		}
+		a.lines++
+	}
+	a.entries = a.entries[max(0, len(a.entries)-a.keep):]
+	return a
+}
+
+// Add records e. The file is rewritten with the kept entries once it grows
+// to twice as many lines.
+func (a *auditLog) Add(e auditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = append(a.entries, e)
+	if len(a.entries) > a.keep {
+		a.entries = append([]auditEntry(nil), a.entries[len(a.entries)-a.keep:]...)
+	}
+	if a.lines+1 > 2*a.keep {
+		a.rewrite()
+		return
+	}
+	line, _ := json.Marshal(e)
+	f, err := os.OpenFile(a.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err == nil {
+		_, err = f.Write(append(line, '\n'))
+		f.Close()
+	}
+	if err != nil {
+		slog.Error("Failed to write audit log", "file", a.file, "err", err)
+		return
+	}
+	a.lines++
+}
+
+func (a *auditLog) rewrite() {
+	var buf bytes.Buffer
+	for _, e := range a.entries {
+		line, _ := json.Marshal(e)
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if err := os.WriteFile(a.file, buf.Bytes(), 0600); err != nil {
+		slog.Error("Failed to write audit log", "file", a.file, "err", err)
+		return
+	}
+	a.lines = len(a.entries)
+}
+
+// Find returns the newest entry of chatID that match accepts, so features
+// acting on earlier bot messages find them after a restart
+func (a *auditLog) Find(chatID int64, match func(auditEntry) bool) (auditEntry, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := len(a.entries) - 1; i >= 0; i-- {
+		if e := a.entries[i]; e.ChatID == chatID && match(e) {
+			return e, true
+		}
+	}
+	return auditEntry{}, false
+}
+
+// Recent returns up to n newest entries of chatID, oldest first
+func (a *auditLog) Recent(chatID int64, n int) []auditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var out []auditEntry
+	for i := len(a.entries) - 1; i >= 0 && len(out) < n; i-- {
+		if a.entries[i].ChatID == chatID {
+			out = append([]auditEntry{a.entries[i]}, out...)
+		}
+	}
+	return out
+}
+
+// audited reports whether the Bot API method acts on a message or the chat
+// appearance
+func audited(method string) bool {
+	switch method {
+	case "deleteMessage", "pinChatMessage", "unpinChatMessage", "setChatTitle", "setChatDescription":
+		return true
+	}
+	return queued(method)
+}
+
+// auditTemplateParam carries the template of a templated text from its
+// send to auditTransport, which takes it out of the request
+const auditTemplateParam = "x_template"
+
+// templated is a text rendered from Template. Sent as it is, the audit log
+// records the template with the message.
+type templated struct {
+	Text     string
+	Template string
+}
+
+func (t templated) Send(b *tb.Bot, to tb.Recipient, opt *tb.SendOptions) (*tb.Message, error) {
+	// buttons and entities need the processing only telebot's own send does
+	if opt == nil || t.Template == "" || opt.ReplyMarkup != nil || len(opt.Entities) > 0 {
+		if opt == nil {
+			return b.Send(to, t.Text)
+		}
+		return b.Send(to, t.Text, opt)
+	}
+	params := map[string]string{
+		"chat_id":          to.Recipient(),
+		"text":             t.Text,
+		auditTemplateParam: t.Template,
+	}
+	if opt.ReplyTo != nil && opt.ReplyTo.ID != 0 {
+		params["reply_to_message_id"] = strconv.Itoa(opt.ReplyTo.ID)
+	}
+	if opt.ThreadID != 0 {
+		params["message_thread_id"] = strconv.Itoa(opt.ThreadID)
+	}
+	if opt.ParseMode != tb.ModeDefault {
+		params["parse_mode"] = opt.ParseMode
+	}
+	for param, set := range map[string]bool{
+		"disable_web_page_preview":    opt.DisableWebPagePreview,
+		"disable_notification":        opt.DisableNotification,
+		"allow_sending_without_reply": opt.AllowWithoutReply,
+		"protect_content":             opt.Protected,
+	} {
+		if set {
+			params[param] = "true"
+		}
+	}
+	data, err := b.Raw("sendMessage", params)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Result *tb.Message
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Result, nil
+}
+
+// auditTransport records successful message actions going through the
+// client in the audit log. It is installed without a log too, to take the
+// template of templated texts out of the requests.
+type auditTransport struct {
+	next http.RoundTripper
+	log  *auditLog
+}
+
+func (t auditTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	method := methodName(r)
+	if !audited(method) {
+		return t.next.RoundTrip(r)
+	}
+	params := make(map[string]any)
+	if r.GetBody != nil {
+		if body, err := r.GetBody(); err == nil {
+			json.NewDecoder(body).Decode(&params)
+		}
+	}
+	tpl, _ := params[auditTemplateParam].(string)
+	if _, ok := params[auditTemplateParam]; ok {
+		delete(params, auditTemplateParam)
+		body, _ := json.Marshal(params)
+		r.Body.Close()
+		r = r.Clone(r.Context())
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+		r.ContentLength = int64(len(body))
+	}
+	if t.log == nil {
+		return t.next.RoundTrip(r)
+	}
+	resp, err := t.next.RoundTrip(r)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return resp, nil
+	}
+
+	e := auditEntry{Time: time.Now(), Method: method}
+	e.ChatID, _ = strconv.ParseInt(fmt.Sprint(params["chat_id"]), 10, 64)
+	e.MessageID, _ = strconv.Atoi(fmt.Sprint(params["message_id"]))
+	if text, ok := params["text"].(string); ok {
+		e.Text = text
+	} else if caption, ok := params["caption"].(string); ok {
+		e.Text = caption
+	}
+	var answer struct {
+		Result json.RawMessage `json:"result"`
+	}
+	var msg tb.Message
+	if json.Unmarshal(data, &answer) == nil && json.Unmarshal(answer.Result, &msg) == nil && msg.ID != 0 {
+		e.MessageID = msg.ID
+		if msg.Chat != nil {
+			e.ChatID = msg.Chat.ID
+		}
+		e.Type = messageType(&msg)
+	}
+	e.Template = tpl
+	t.log.Add(e)
+	return resp, nil
+}
+
+// messageType names the content of msg
+func messageType(msg *tb.Message) string {
+	switch {
+	case msg.Sticker != nil:
+		return "sticker"
+	case msg.Animation != nil:
+		return "animation"
+	case msg.Photo != nil:
+		return "photo"
+	case msg.Document != nil:
+		return "document"
+	case msg.Text != "":
+		return "text"
+	}
+	return ""
+}
+
+// handleAudit lists the last messages the bot sent to the chat
+func handleAudit(log *auditLog) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		if log == nil {
+			return c.Send("Журнал сообщений бота выключен, включите audit.enabled в конфиге.")
+		}
+		entries := log.Recent(c.Chat().ID, 10)
+		if len(entries) == 0 {
+			return c.Send("Бот ещё ничего не отправлял в этот чат.")
+		}
+		var sb strings.Builder
+		sb.WriteString("Последние действия бота в чате:")
+		for _, e := range entries {
+			fmt.Fprintf(&sb, "\n• %s %s #%d", e.Time.Format("02.01 15:04"), e.Method, e.MessageID)
+			if e.Type != "" {
+				fmt.Fprintf(&sb, " (%s)", e.Type)
+			}
+			if e.Text != "" {
+				text := []rune(e.Text)
+				if len(text) > 60 {
+					text = append(text[:60], '…')
+				}
+				fmt.Fprintf(&sb, ": %s", string(text))
+			}
+		}
+		return c.Send(sb.String())
+	}
+}
+
+// handleUndo deletes the newest message the bot sent to the chat that is
+// still there. It is looked up in the audit log, so it works after a
+// restart too.
+func handleUndo(b *tb.Bot, log *auditLog) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		if log == nil {
+			return c.Send("Журнал сообщений бота выключен, без него /undo не знает, что удалять. Включите audit.enabled в конфиге.")
+		}
+		// newest first, so a deletion is seen before the message it deleted
+		deleted := make(map[int]bool)
+		e, ok := log.Find(c.Chat().ID, func(e auditEntry) bool {
+			if e.Method == "deleteMessage" {
+				deleted[e.MessageID] = true
+				return false
+			}
+			sent := strings.HasPrefix(e.Method, "send") || strings.HasPrefix(e.Method, "copy") || strings.HasPrefix(e.Method, "forward")
+			return sent && e.MessageID != 0 && !deleted[e.MessageID]
+		})
+		if !ok {
+			return c.Send("Нечего удалять: журнал не помнит сообщений бота в этом чате.")
+		}
+		if err := b.Delete(tb.StoredMessage{MessageID: strconv.Itoa(e.MessageID), ChatID: e.ChatID}); err != nil {
+			ctxLogger(c).Warn("Failed to delete bot message", "message_id", e.MessageID, "err", err)
+			return c.Send("Не удалось удалить последнее сообщение бота, возможно, оно старше 48 часов.")
+		}
+		ctxLogger(c).Info("Deleted bot message", "message_id", e.MessageID, "template", e.Template)
+		return nil
+	}
+}
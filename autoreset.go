@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// autoResetEnabled reports whether detections reset the chat's counters right
+// away. Strict mode always waits for the admins.
+func autoResetEnabled(cfg Config, st *ChatState) bool {
+	if cfg.StrictReset {
+		return false
+	}
+	if st.AutoReset != nil {
+		return *st.AutoReset
+	}
+	return cfg.AutoReset
+}
+
+// resetText renders the reset announcement for a streak that started at
+// prevLastMention and lasted prevStreak
+func resetText(cfg Config, topic string, now, prevLastMention time.Time, prevStreak time.Duration) templated {
+	prevText := "никогда"
+	if !prevLastMention.IsZero() {
+		prevText = prevLastMention.Format("02.01.2006 15:04:05")
+	}
+	daysWas := durationDays(prevStreak)
+	tpl := pickTemplate(cfg.Templates.Reset)
+	return templated{Template: tpl, Text: renderTemplate(tpl, map[string]string{
+		"topic":     topic,
+		"now":       now.Format("02.01.2006 15:04:05"),
+		"days":      strconv.Itoa(daysWas),
+		"days_text": plural(daysWas, "day"),
+		"count":     strconv.Itoa(daysWas),
+		"prev":      prevText,
+	})}
+}
+
+func handleAutoReset(cfg Config, store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		arg := strings.ToLower(strings.TrimSpace(c.Message().Payload))
+
+		var enabled bool
+		switch arg {
+		case "on", "off":
+			if err := store.UpdateContext(ctxOf(c), func(s *Storage) {
+				enabled = arg == "on"
+				s.Chat(c.Chat().ID).AutoReset = &enabled
+			}); err != nil {
+				return err
+			}
+		case "":
+			if err := store.ViewContext(ctxOf(c), func(s *Storage) {
+				enabled = autoResetEnabled(cfg, s.Chat(c.Chat().ID))
+			}); err != nil {
+				return err
+			}
+		default:
+			return c.Send("Использование: /autoreset on|off")
+		}
+
+		if enabled {
+			return c.Send("Автосброс включён: счётчик сбрасывается сразу при упоминании.")
+		}
+		return c.Send("Автосброс выключен: после упоминания нужно подтвердить /reset.")
+	}
+}
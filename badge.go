@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/font"
+)
+
+const (
+	badgeHeight   = 20
+	badgeFontSize = 11
+	badgePadding  = 6
+	// maxBadgeLabel caps the label in runes, the PNG grows with its width
+	maxBadgeLabel = 64
+)
+
+// Badge colors, as on shields.io
+var (
+	badgeLabelColor = color.RGBA{0x55, 0x55, 0x55, 0xff}
+	badgeRed        = color.RGBA{0xe0, 0x5d, 0x44, 0xff}
+	badgeYellow     = color.RGBA{0xdf, 0xb3, 0x17, 0xff}
+	badgeGreen      = color.RGBA{0x97, 0xca, 0x00, 0xff}
+	badgeBright     = color.RGBA{0x44, 0xcc, 0x11, 0xff}
+)
+
+// badgeColor goes from red right after a reset to bright green for a month+
+func badgeColor(days int) color.RGBA {
+	switch {
+	case days < 1:
+		return badgeRed
+	case days < 7:
+		return badgeYellow
+	case days < 30:
+		return badgeGreen
+	default:
+		return badgeBright
+	}
+}
+
+// badgeWidths measures both halves of a badge with the padding around them
+func badgeWidths(label, value string) (int, int) {
+	loadFonts()
+	face := fontFace(fontRegular, badgeFontSize)
+	return font.MeasureString(face, label).Round() + 2*badgePadding,
+		font.MeasureString(face, value).Round() + 2*badgePadding
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// renderBadgeSVG draws a flat shields.io-style "label | value" badge
+func renderBadgeSVG(label, value string, c color.RGBA) []byte {
+	lw, vw := badgeWidths(label, value)
+	w := lw + vw
+	label, value = html.EscapeString(label), html.EscapeString(value)
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" role="img" aria-label="%s: %s">`, w, badgeHeight, label, value)
+	fmt.Fprintf(&b, `<title>%s: %s</title>`, label, value)
+	fmt.Fprintf(&b, `<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`)
+	fmt.Fprintf(&b, `<clipPath id="r"><rect width="%d" height="%d" rx="3" fill="#fff"/></clipPath>`, w, badgeHeight)
+	fmt.Fprintf(&b, `<g clip-path="url(#r)"><rect width="%d" height="%d" fill="%s"/><rect x="%d" width="%d" height="%d" fill="%s"/><rect width="%d" height="%d" fill="url(#s)"/></g>`,
+		lw, badgeHeight, hexColor(badgeLabelColor), lw, vw, badgeHeight, hexColor(c), w, badgeHeight)
+	fmt.Fprintf(&b, `<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="%d">`, badgeFontSize)
+	for _, part := range []struct {
+		x, w int
+		text string
+	}{{lw / 2, lw - 2*badgePadding, label}, {lw + vw/2, vw - 2*badgePadding, value}} {
+		fmt.Fprintf(&b, `<text x="%d" y="15" fill="#010101" fill-opacity=".3" textLength="%d">%s</text>`, part.x, part.w, part.text)
+		fmt.Fprintf(&b, `<text x="%d" y="14" textLength="%d">%s</text>`, part.x, part.w, part.text)
+	}
+	b.WriteString(`</g></svg>`)
+	return []byte(b.String())
+}
+
+// renderBadgePNG draws the same badge as renderBadgeSVG for places that
+// don't show SVG images
+func renderBadgePNG(label, value string, c color.RGBA) ([]byte, error) {
+	lw, vw := badgeWidths(label, value)
+	img := image.NewRGBA(image.Rect(0, 0, lw+vw, badgeHeight))
+	fillRect(img, image.Rect(0, 0, lw, badgeHeight), badgeLabelColor)
+	fillRect(img, image.Rect(lw, 0, lw+vw, badgeHeight), c)
+	face := fontFace(fontRegular, badgeFontSize)
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	drawText(img, face, badgePadding, 14, white, label)
+	drawText(img, face, lw+badgePadding, 14, white, value)
+	return encodePNG(img)
+}
+
+// serveBadge answers GET /badge/<chat id>.svg or .png, optionally for
+// ?counter=name and with a custom ?label=
+func serveBadge(cfg Config, bots []*botInstance) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		file := r.PathValue("file")
+		idText, ext, _ := strings.Cut(file, ".")
+		if ext != "svg" && ext != "png" {
+			writeAPIError(w, http.StatusNotFound, "badges are .svg or .png")
+			return
+		}
+		r.SetPathValue("id", idText)
+		bi, id, ok := publicChatOf(cfg, bots, cfg.API.BadgeChats, w, r)
+		if !ok {
+			return
+		}
+		var topic string
+		var days int
+		var found bool
+		bi.store.View(func(s *Storage) {
+			ctr := s.Chat(id).CounterByName(strings.ToLower(r.URL.Query().Get("counter")))
+			if found = ctr != nil; found {
+				topic, days = counterTopic(bi.cfg, ctr), ctr.Days()
+			}
+		})
+		if !found {
+			writeAPIError(w, http.StatusNotFound, "unknown counter")
+			return
+		}
+		label := r.URL.Query().Get("label")
+		if label == "" {
+			label = "без " + topic
+		}
+		if runes := []rune(label); len(runes) > maxBadgeLabel {
+			label = string(runes[:maxBadgeLabel-1]) + "…"
+		}
+		value := plural(days, "day")
+
+		// image proxies like GitHub's camo keep the badge for this long
+		w.Header().Set("Cache-Control", "max-age=300")
+		if ext == "svg" {
+			w.Header().Set("Content-Type", "image/svg+xml; charset=utf-8")
+			w.Write(renderBadgeSVG(label, value, badgeColor(days)))
+			return
+		}
+		data, err := renderBadgePNG(label, value, badgeColor(days))
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "failed to render badge")
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// runBench runs the keyword matcher over every line of a corpus file and
+// reports throughput and how the hits spread over the keywords. It returns
+// the process exit code.
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	botIndex := fs.Int("bot", 1, "number of the bots entry whose keywords to use")
+	chatID := fs.Int64("chat", 0, "also match the runtime counters of this chat from the storage")
+	rounds := fs.Int("rounds", 5, "passes over the corpus")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: dayswithout bench [flags] corpus.txt")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 || *rounds < 1 {
+		fs.Usage()
+		return 2
+	}
+
+	configs := loadConfig()
+	if *botIndex < 1 || *botIndex > len(configs) {
+		fmt.Fprintf(os.Stderr, "No bot %d, the config has %d\n", *botIndex, len(configs))
+		return 2
+	}
+	cfg := configs[*botIndex-1]
+
+	lines, size, err := readCorpus(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to read corpus:", err)
+		return 1
+	}
+	if len(lines) == 0 {
+		fmt.Fprintln(os.Stderr, "Corpus is empty")
+		return 1
+	}
+
+	var st ChatState
+	if *chatID != 0 {
+		loadStorage(cfg.DataFile).View(func(s *Storage) {
+			if chat := s.Chats[*chatID]; chat != nil {
+				st = *chat
+			}
+		})
+	}
+	compileStart := time.Now()
+	defaultRe := buildKeywordRegex(cfg.Keywords, cfg.NoSuffix)
+	compiled := time.Since(compileStart)
+
+	// hit distribution, outside of the timed passes
+	keywords := simKeywords(cfg, &st)
+	hits := make(map[simKeyword]int)
+	matched := 0
+	for _, line := range lines {
+		name, _, found := st.matchCounter(line, defaultRe)
+		if found == "" {
+			continue
+		}
+		matched++
+		if kw := whichKeyword(line, name, found, keywords); kw != nil {
+			hits[*kw]++
+		}
+	}
+
+	start := time.Now()
+	for r := 0; r < *rounds; r++ {
+		for _, line := range lines {
+			st.matchCounter(line, defaultRe)
+		}
+	}
+	elapsed := time.Since(start)
+	n := len(lines) * *rounds
+
+	fmt.Printf("Corpus:     %d line(s), %.1f KB, %d round(s)\n", len(lines), float64(size)/1024, *rounds)
+	fmt.Printf("Keywords:   %d, compiled in %s\n", len(keywords), compiled.Round(time.Microsecond))
+	fmt.Printf("Time:       %s, %s per line\n", elapsed.Round(time.Millisecond), (elapsed / time.Duration(n)).Round(time.Nanosecond))
+	fmt.Printf("Throughput: %.0f lines/s, %.2f MB/s\n", float64(n)/elapsed.Seconds(), float64(size)*float64(*rounds)/elapsed.Seconds()/(1<<20))
+	fmt.Printf("Matched:    %d line(s), %.2f%%\n", matched, 100*float64(matched)/float64(len(lines)))
+
+	sort.SliceStable(keywords, func(i, j int) bool { return hits[keywords[i]] > hits[keywords[j]] })
+	fmt.Println("Hits per keyword:")
+	for _, kw := range keywords {
+		share := 0.0
+		if matched > 0 {
+			share = 100 * float64(hits[kw]) / float64(matched)
+		}
+		fmt.Printf("  %6d  %5.1f%%  %s %q\n", hits[kw], share, counterLabel(kw.counter), kw.keyword)
+	}
+	return 0
+}
+
+// readCorpus returns the non-empty lines of path and their total size
+func readCorpus(path string) ([]string, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	var lines []string
+	size := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+			size += len(line)
+		}
+	}
+	return lines, size, scanner.Err()
+}
@@ -3,6 +3,9 @@ set -e
 
 APP_NAME="dayswithout"
 VERSION=$(date +%Y.%m.%d-%H%M)
+COMMIT=$(git rev-parse --short HEAD 2>/dev/null || echo unknown)
+BUILD_DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+LDFLAGS="-X main.version=$VERSION -X main.commit=$COMMIT -X main.buildDate=$BUILD_DATE"
 
 echo "[INFO] Building $APP_NAME version $VERSION"
 
@@ -12,23 +15,23 @@ mkdir -p build
 
 # Build for current OS/ARCH
 echo "[INFO] Building for current system..."
-go build -o build/$APP_NAME main.go
+go build -ldflags "$LDFLAGS" -o build/$APP_NAME .
 
 # Example: cross-compile for Linux amd64
 echo "[INFO] Building for linux/amd64..."
-GOOS=linux GOARCH=amd64 go build -o build/${APP_NAME}-linux-amd64 main.go
+GOOS=linux GOARCH=amd64 go build -ldflags "$LDFLAGS" -o build/${APP_NAME}-linux-amd64 .
 
 # Example: cross-compile for Linux arm64
 echo "[INFO] Building for linux/arm64..."
-GOOS=linux GOARCH=arm64 go build -o build/${APP_NAME}-linux-arm64 main.go
+GOOS=linux GOARCH=arm64 go build -ldflags "$LDFLAGS" -o build/${APP_NAME}-linux-arm64 .
 
 # Example: cross-compile for Windows
 echo "[INFO] Building for windows/amd64..."
-GOOS=windows GOARCH=amd64 go build -o build/${APP_NAME}-windows-amd64.exe main.go
+GOOS=windows GOARCH=amd64 go build -ldflags "$LDFLAGS" -o build/${APP_NAME}-windows-amd64.exe .
 
 # Example: cross-compile for macOS
 echo "[INFO] Building for darwin/amd64..."
-GOOS=darwin GOARCH=amd64 go build -o build/${APP_NAME}-darwin-amd64 main.go
+GOOS=darwin GOARCH=amd64 go build -ldflags "$LDFLAGS" -o build/${APP_NAME}-darwin-amd64 .
 
 echo "[INFO] Build finished. Files are in ./build/"
 ls -lh build/
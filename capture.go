@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// CaptureConfig keeps the last Size raw updates in File for debugging
+// reports like "the bot didn't react to this message"
+type CaptureConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	File    string `yaml:"file"`
+	Size    int    `yaml:"size"`
+}
+
+// tokenRe matches bot tokens that may appear in forwarded texts
+var tokenRe = regexp.MustCompile(`\d{5,}:[A-Za-z0-9_-]{30,}`)
+
+// captureFlushInterval is how often new captured updates are written to the
+// file; the ones of the last interval are lost on a crash
+const captureFlushInterval = 5 * time.Second
+
+// updateCapture is a ring buffer of updates as JSON lines, mirrored to a
+// file in the background so it survives restarts
+type updateCapture struct {
+	mu    sync.Mutex
+	file  string
+	size  int
+	token string
+	lines [][]byte
+	dirty bool
+}
+
+// newUpdateCapture loads the lines captured before the restart
+func newUpdateCapture(cfg CaptureConfig, token string) *updateCapture {
+	c := &updateCapture{file: cfg.File, size: cfg.Size, token: token}
+	data, err := os.ReadFile(cfg.File)
+	if err != nil && !os.IsNotExist(err) {
+		slog.Warn("Failed to read update capture", "file", cfg.File, "err", err)
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) > 0 {
+			c.lines = append(c.lines, line)
+		}
+	}
+	c.trim()
+	go c.run()
+	return c
+}
+
+// Add stores u without tokens, dropping the oldest update when full
+func (c *updateCapture) Add(u *tb.Update) {
+	data, err := json.Marshal(u)
+	if err != nil {
+		slog.Warn("Failed to encode update for capture", "update_id", u.ID, "err", err)
+		return
+	}
+	data = c.sanitize(data)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lines = append(c.lines, data)
+	c.trim()
+	c.dirty = true
+}
+
+// run writes the file whenever updates were added since the last write,
+// off the path of the updates
+func (c *updateCapture) run() {
+	for range time.Tick(captureFlushInterval) {
+		c.mu.Lock()
+		data, dirty := c.dump(), c.dirty
+		c.dirty = false
+		c.mu.Unlock()
+		if !dirty {
+			continue
+		}
+		if err := os.WriteFile(c.file, data, 0600); err != nil {
+			slog.Warn("Failed to write update capture", "file", c.file, "err", err)
+		}
+	}
+}
+
+// Dump returns the captured updates, oldest first, one JSON per line
+func (c *updateCapture) Dump() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dump()
+}
+
+func (c *updateCapture) dump() []byte {
+	var buf bytes.Buffer
+	for _, line := range c.lines {
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+func (c *updateCapture) trim() {
+	if n := len(c.lines); n > c.size {
+		c.lines = append([][]byte(nil), c.lines[n-c.size:]...)
+	}
+}
+
+// sanitize removes the bot's own token and anything that looks like one
+func (c *updateCapture) sanitize(data []byte) []byte {
+	s := string(data)
+	if c.token != "" {
+		s = strings.ReplaceAll(s, c.token, "<token>")
+	}
+	return []byte(tokenRe.ReplaceAllString(s, "<token>"))
+}
+
+// capturePoller records every update as it arrives, before any filtering
+func capturePoller(p tb.Poller, capture *updateCapture) tb.Poller {
+	if capture == nil {
+		return p
+	}
+	return tb.NewMiddlewarePoller(p, func(u *tb.Update) bool {
+		capture.Add(u)
+		return true
+	})
+}
+
+// handleUpdates sends the captured updates to an operator as a file. They
+// come from every chat, private ones included, so they are only sent in the
+// operator's private chat with the bot.
+func handleUpdates(capture *updateCapture) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		if !isPersonal(c.Chat()) {
+			return c.Send("В апдейтах есть сообщения из всех чатов, поэтому /updates работает только в личке с ботом.")
+		}
+		if capture == nil {
+			return c.Send("Запись апдейтов выключена, включите capture.enabled в конфиге.")
+		}
+		data := capture.Dump()
+		if len(data) == 0 {
+			return c.Send("Пока не записано ни одного апдейта.")
+		}
+		return c.Send(&tb.Document{
+			File:     tb.FromReader(bytes.NewReader(data)),
+			FileName: "updates.jsonl",
+			Caption:  "Последние апдейты, по одному JSON в строке.",
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+)
+
+const (
+	cardWidth  = 800
+	cardHeight = 420
+)
+
+// renderCard draws the "N days without X" counter card as PNG
+func renderCard(cfg Config, st *ChatState) ([]byte, error) {
+	loadFonts()
+	img := newCanvas(cardWidth, cardHeight)
+	fillRect(img, image.Rect(0, 0, cardWidth, 8), colorAccent)
+
+	cx := cardWidth / 2
+	topic := counterTopic(cfg, &st.Counter)
+	if st.LastMention.IsZero() {
+		drawTextCentered(img, fontFace(fontBold, 44), cx, 190, colorForeground, "Ещё ни разу")
+		drawTextCentered(img, fontFace(fontRegular, 32), cx, 250, colorMuted, "не упоминали "+topic)
+		return encodePNG(img)
+	}
+
+	days := st.Days()
+	drawTextCentered(img, fontFace(fontBold, 150), cx, 200, colorAccent, strconv.Itoa(days))
+	drawTextCentered(img, fontFace(fontRegular, 36), cx, 260, colorForeground, fmt.Sprintf("%s без %s", pluralWord(days, "day"), topic))
+
+	record := st.Record
+	if cur := st.Streak(); cur > record {
+		record = cur
+	}
+	small := fontFace(fontRegular, 24)
+	drawTextCentered(img, small, cx, 340, colorMuted, "Рекорд: "+plural(durationDays(record), "day"))
+	drawTextCentered(img, small, cx, 380, colorMuted, "Последний сброс: "+st.LastMention.Format("02.01.2006 15:04"))
+	return encodePNG(img)
+}
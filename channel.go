@@ -0,0 +1,134 @@
+package main
+
+import (
+	"log/slog"
+	"regexp"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// ChannelConfig controls keyword tracking in channels where the bot is an
+// admin. Channel posts come from the admins themselves, so a mention resets
+// the counter right away. Mode "comment" answers in the comments of the post
+// (the bot must be in the linked discussion group), "pinned" only keeps the
+// pinned counter post up to date.
+type ChannelConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Mode    string `yaml:"mode"`
+}
+
+// channelPost identifies a post awaiting its comment
+type channelPost struct {
+	chatID int64
+	id     int
+}
+
+// pendingComments holds reset announcements until the post shows up in the
+// discussion group as an automatic forward
+var pendingComments pendingTexts
+
+func handleChannelPost(b *tb.Bot, cfg Config, store *Store, keywordRe *regexp.Regexp) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		msg := c.Message()
+		text := msg.Text
+		if text == "" {
+			text = msg.Caption
+		}
+		ctxLogger(c).Debug("New channel post", "text", text)
+
+		var name, found string
+		var ctr Counter
+		if err := store.ViewContext(ctxOf(c), func(s *Storage) {
+			name, ctr, found = s.Chat(msg.Chat.ID).matchCounter(text, keywordRe)
+		}); err != nil {
+			return err
+		}
+		if found == "" {
+			return nil
+		}
+		if ctr.Paused() || (!ctr.LastMention.IsZero() && clock().Sub(ctr.LastMention) < 2*time.Hour) {
+			ctxLogger(c).Debug("Ignoring channel mention, counter paused or in cooldown", "counter", name)
+			return nil
+		}
+		if !genuineMention(ctxOf(c), cfg, text, found, counterTopic(cfg, &ctr)) {
+			ctxLogger(c).Info("Classifier rejected channel mention", "counter", name, "keyword", found)
+			return nil
+		}
+
+		now := clock()
+		author := msg.Signature
+		if author == "" {
+			author = msg.Chat.Title
+		}
+		var prevStreak time.Duration
+		var pinnedID int
+		var events []counterEvent
+		if err := store.UpdateContext(ctxOf(c), func(s *Storage) {
+			st := s.Chat(msg.Chat.ID)
+			ev := Event{Time: now, Counter: name, Name: author, Keyword: found, MessageID: msg.ID}
+			st.RecordDetection(ev)
+			if cur := st.CounterByName(name); cur != nil {
+				prevStreak = cur.Streak()
+				events = append(events, detectionEvent(b.Me.Username, msg.Chat.ID, counterTopic(cfg, cur), ev, prevStreak))
+			}
+			st.Reset(ev)
+			events = append(events, resetEvent(b.Me.Username, msg.Chat.ID, counterTopic(cfg, &ctr), st))
+			events = append(events, s.resetLinked(cfg, b.Me.Username, msg.Chat.ID, ev,
+				resetText(cfg, counterTopic(cfg, &ctr), now, ctr.LastMention, prevStreak))...)
+			pinnedID = st.PinnedID
+		}); err != nil {
+			return err
+		}
+		for _, ev := range events {
+			publishEvent(ev)
+		}
+		ctxLogger(c).Info("Channel post reset counter", "counter", name, "keyword", found)
+
+		switch cfg.Channels.Mode {
+		case "comment":
+			text := llmText(ctxOf(c), cfg, cfg.LLM.Reset, map[string]string{
+				"topic": counterTopic(cfg, &ctr), "streak": formatStreak(prevStreak, false), "offender": author, "keyword": found,
+			}, resetText(cfg, counterTopic(cfg, &ctr), now, ctr.LastMention, prevStreak))
+			pendingComments.Store(channelPost{chatID: msg.Chat.ID, id: msg.ID}, text)
+		case "pinned":
+			if pinnedID != 0 {
+				refreshPinnedChat(b, cfg, store, msg.Chat.ID)
+				return nil
+			}
+			var text string
+			if err := store.ViewContext(ctxOf(c), func(s *Storage) {
+				text = pinnedText(cfg, &s.Chat(msg.Chat.ID).Counter)
+			}); err != nil {
+				return err
+			}
+			id, err := postPinned(b, store, msg.Chat.ID, text)
+			if err != nil {
+				return err
+			}
+			if err := store.UpdateContext(ctxOf(c), func(s *Storage) {
+				st := s.Chat(msg.Chat.ID)
+				st.PinnedID, st.PinnedText = id, text
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// commentOnForward posts the pending reset announcement under a channel post
+// once it arrives in the discussion group. It reports whether msg was such
+// an automatic forward, which must not be checked for keywords again.
+func commentOnForward(b *tb.Bot, msg *tb.Message) bool {
+	if !msg.AutomaticForward || msg.OriginalChat == nil {
+		return false
+	}
+	text, ok := pendingComments.LoadAndDelete(channelPost{chatID: msg.OriginalChat.ID, id: msg.OriginalMessageID})
+	if ok {
+		if _, err := b.Reply(msg, text); err != nil {
+			slog.Error("Failed to comment on channel post", "chat_id", msg.Chat.ID, "err", err)
+		}
+	}
+	return true
+}
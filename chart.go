@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const (
+	chartWidth   = 900
+	chartHeight  = 480
+	chartBuckets = 12
+)
+
+// bucketStart truncates t to the beginning of its week (Monday) or month
+func bucketStart(t time.Time, monthly bool) time.Time {
+	y, m, d := t.Date()
+	if monthly {
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	}
+	offset := (int(t.Weekday()) + 6) % 7
+	return time.Date(y, m, d-offset, 0, 0, 0, 0, t.Location())
+}
+
+func nextBucket(t time.Time, monthly bool) time.Time {
+	if monthly {
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(0, 0, 7)
+}
+
+type chartBucket struct {
+	Start      time.Time
+	Detections int
+	Resets     int
+}
+
+// chartData splits the last chartBuckets weeks or months of history into buckets
+func chartData(st *ChatState, now time.Time, monthly bool) []chartBucket {
+	start := bucketStart(now, monthly)
+	for i := 1; i < chartBuckets; i++ {
+		if monthly {
+			start = start.AddDate(0, -1, 0)
+		} else {
+			start = start.AddDate(0, 0, -7)
+		}
+	}
+
+	buckets := make([]chartBucket, chartBuckets)
+	for i, t := 0, start; i < chartBuckets; i, t = i+1, nextBucket(t, monthly) {
+		buckets[i].Start = t
+	}
+	for _, ev := range st.History {
+		if ev.Time.Before(start) {
+			continue
+		}
+		i := chartBuckets - 1
+		for i > 0 && ev.Time.Before(buckets[i].Start) {
+			i--
+		}
+		switch ev.Type {
+		case EventDetection:
+			buckets[i].Detections++
+		case EventReset:
+			buckets[i].Resets++
+		}
+	}
+	return buckets
+}
+
+func renderChart(topic string, buckets []chartBucket, monthly bool) ([]byte, error) {
+	loadFonts()
+	img := newCanvas(chartWidth, chartHeight)
+	title := fontFace(fontBold, 26)
+	label := fontFace(fontRegular, 16)
+
+	period := "по неделям"
+	if monthly {
+		period = "по месяцам"
+	}
+	drawText(img, title, 30, 45, colorForeground, fmt.Sprintf("%s: упоминания и сбросы %s", topic, period))
+	fillRect(img, image.Rect(30, 62, 46, 78), colorMuted)
+	drawText(img, label, 52, 76, colorMuted, "срабатывания")
+	fillRect(img, image.Rect(190, 62, 206, 78), colorAccent)
+	drawText(img, label, 212, 76, colorMuted, "сбросы")
+
+	max := 1
+	for _, b := range buckets {
+		if b.Detections > max {
+			max = b.Detections
+		}
+		if b.Resets > max {
+			max = b.Resets
+		}
+	}
+
+	// round up so that the four grid lines land on whole numbers
+	max = (max + 3) / 4 * 4
+
+	left, right, top, bottom := 60, chartWidth-30, 100, chartHeight-50
+	plotH := bottom - top
+	for i := 0; i <= 4; i++ {
+		y := bottom - plotH*i/4
+		fillRect(img, image.Rect(left, y, right, y+1), colorGrid)
+		drawText(img, label, 25, y+5, colorMuted, strconv.Itoa(max*i/4))
+	}
+
+	slot := (right - left) / len(buckets)
+	barW := slot / 3
+	for i, b := range buckets {
+		x := left + i*slot + slot/6
+		dh := plotH * b.Detections / max
+		rh := plotH * b.Resets / max
+		fillRect(img, image.Rect(x, bottom-dh, x+barW, bottom), colorMuted)
+		fillRect(img, image.Rect(x+barW, bottom-rh, x+2*barW, bottom), colorAccent)
+
+		name := b.Start.Format("02.01")
+		if monthly {
+			name = b.Start.Format("01.06")
+		}
+		drawTextCentered(img, label, x+barW, bottom+24, colorMuted, name)
+	}
+	return encodePNG(img)
+}
+
+func handleChart(cfg Config, store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		var monthly bool
+		switch strings.ToLower(strings.TrimSpace(c.Message().Payload)) {
+		case "", "week", "неделя":
+		case "month", "месяц":
+			monthly = true
+		default:
+			return c.Send("Использование: /chart [week|month]")
+		}
+
+		var buckets []chartBucket
+		var topic string
+		if err := store.ViewContext(ctxOf(c), func(s *Storage) {
+			st := s.Chat(c.Chat().ID)
+			buckets = chartData(st, clock(), monthly)
+			topic = counterTopic(cfg, &st.Counter)
+		}); err != nil {
+			return err
+		}
+		png, err := renderChart(topic, buckets, monthly)
+		if err != nil {
+			return err
+		}
+		return c.Send(photoFromPNG(png, ""))
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log/slog"
+	"strconv"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// ChatInfoConfig controls writing the day count into the chat description or title
+type ChatInfoConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Mode is "description" (replace the description) or "title" (append a suffix)
+	Mode string `yaml:"mode"`
+	// Template supports {days} and {count} ("5"), {days_text} ("5 дней")
+	// and {topic}
+	Template string `yaml:"template"`
+	// MinInterval between two edits of the same chat, 1h by default
+	MinInterval time.Duration `yaml:"min_interval"`
+}
+
+const (
+	titleSeparator    = " | "
+	maxTitleLen       = 128
+	maxDescriptionLen = 255
+)
+
+func renderChatInfo(cfg Config, ctr *Counter) string {
+	days := ctr.Days()
+	return renderTemplate(cfg.ChatInfo.Template, map[string]string{
+		"days":      strconv.Itoa(days),
+		"days_text": plural(days, "day"),
+		"count":     strconv.Itoa(days),
+		"topic":     counterTopic(cfg, ctr),
+	})
+}
+
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
+
+// infoTitle is the title written for base with text appended, cut to the
+// limit of Telegram
+func infoTitle(base, text string) string {
+	return truncateRunes(base+titleSeparator+text, maxTitleLen)
+}
+
+// applyChatInfo writes text into the chat and returns the title base to remember
+func applyChatInfo(b *tb.Bot, cfg Config, chatID int64, text, lastText, base string) (string, error) {
+	chat, err := b.ChatByID(chatID)
+	if err != nil {
+		return base, err
+	}
+
+	if cfg.ChatInfo.Mode == "title" {
+		// the title was changed by a human since our last edit: adopt it as the new base
+		if base == "" || chat.Title != infoTitle(base, lastText) {
+			base = chat.Title
+		}
+		return base, b.SetGroupTitle(chat, infoTitle(base, text))
+	}
+	return base, b.SetGroupDescription(chat, truncateRunes(text, maxDescriptionLen))
+}
+
+func updateChatInfo(b *tb.Bot, cfg Config, store *Store, now time.Time) {
+	type update struct {
+		chatID         int64
+		text, lastText string
+		base           string
+	}
+	var due []update
+	store.View(func(s *Storage) {
+		for chatID, st := range s.ActiveChats() {
+			if chatID > 0 || now.Sub(st.InfoUpdated) < cfg.ChatInfo.MinInterval {
+				// private chats have neither title nor description to edit
+				continue
+			}
+			if text := renderChatInfo(cfg, &st.Counter); text != st.InfoText {
+				due = append(due, update{chatID: chatID, text: text, lastText: st.InfoText, base: st.InfoBase})
+			}
+		}
+	})
+
+	for _, u := range due {
+		base, err := applyChatInfo(b, cfg, u.chatID, u.text, u.lastText, u.base)
+		if err != nil {
+			// most likely missing "change info" rights; try again after MinInterval
+			slog.Warn("Failed to update chat info", "chat_id", u.chatID, "mode", cfg.ChatInfo.Mode, "err", err)
+			u.text = u.lastText
+		} else {
+			slog.Debug("Updated chat info", "chat_id", u.chatID, "mode", cfg.ChatInfo.Mode, "text", u.text)
+		}
+		store.Update(func(s *Storage) {
+			st := s.Chat(u.chatID)
+			st.InfoText = u.text
+			st.InfoBase = base
+			st.InfoUpdated = now
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// chatQueueSize is how many updates of one chat may wait before the
+// poller blocks
+const chatQueueSize = 64
+
+// chatQueuePoller hands updates to a worker per chat: chats are handled
+// concurrently, the updates of one chat strictly in order, so a detection
+// and the /reset that follows it never race. The bot has to run with
+// Synchronous so that handlers run in the worker. Updates without a chat
+// share one queue. At most cap(slots) workers run at once; when all are
+// busy the poller waits, which holds back getUpdates during a burst.
+type chatQueuePoller struct {
+	tb.Poller
+	slots chan struct{}
+
+	mu      sync.Mutex
+	workers map[int64]*chatWorker
+}
+
+// chatWorker is the queue of one chat, its goroutine exits once it's empty
+type chatWorker struct {
+	updates chan tb.Update
+	pending int
+}
+
+func newChatQueuePoller(p tb.Poller, workers int) *chatQueuePoller {
+	return &chatQueuePoller{Poller: p, slots: make(chan struct{}, workers), workers: make(map[int64]*chatWorker)}
+}
+
+func (p *chatQueuePoller) Poll(b *tb.Bot, dest chan tb.Update, stop chan struct{}) {
+	updates := make(chan tb.Update)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for u := range updates {
+			p.dispatch(b, u)
+		}
+	}()
+	p.Poller.Poll(b, updates, stop)
+	close(updates)
+	<-done
+}
+
+// dispatch queues u for its chat, starting the worker if there is none
+func (p *chatQueuePoller) dispatch(b *tb.Bot, u tb.Update) {
+	var key int64
+	if chat := b.NewContext(u).Chat(); chat != nil {
+		key = chat.ID
+	}
+	// queued updates count as running handlers for the shutdown
+	inflight.Add(1)
+	p.mu.Lock()
+	w := p.workers[key]
+	if w == nil {
+		// only dispatch starts workers, so nobody else adds one for key
+		// while waiting for a slot
+		p.mu.Unlock()
+		select {
+		case p.slots <- struct{}{}:
+		default:
+			slog.Debug("All update workers busy, waiting", "workers", cap(p.slots))
+			p.slots <- struct{}{}
+		}
+		p.mu.Lock()
+		w = &chatWorker{updates: make(chan tb.Update, chatQueueSize)}
+		p.workers[key] = w
+		go p.work(b, key, w)
+	}
+	w.pending++
+	p.mu.Unlock()
+	w.updates <- u
+}
+
+func (p *chatQueuePoller) work(b *tb.Bot, key int64, w *chatWorker) {
+	for u := range w.updates {
+		b.ProcessUpdate(u)
+		inflight.Done()
+		p.mu.Lock()
+		w.pending--
+		if w.pending == 0 {
+			delete(p.workers, key)
+			p.mu.Unlock()
+			<-p.slots
+			return
+		}
+		p.mu.Unlock()
+	}
+}
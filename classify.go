@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ClassifierConfig double-checks borderline keyword matches (quotes,
+// negations like "давайте не про кофе") with a language model before the
+// bot reacts. URL, APIKey and Model default to those of the llm section;
+// point them to a local model (Ollama, llama.cpp) to keep messages in house.
+type ClassifierConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	URL     string        `yaml:"url"`
+	APIKey  string        `yaml:"api_key"`
+	Model   string        `yaml:"model"`
+	Timeout time.Duration `yaml:"timeout"`
+	// All sends every match to the model, not only the borderline ones
+	All bool `yaml:"all"`
+	// Prompt may use {topic}, {keyword} and {text}, the message; the
+	// model answers "да" or "нет"
+	Prompt string `yaml:"prompt"`
+}
+
+const defaultClassifierPrompt = "Бот в групповом чате считает дни без упоминания темы «{topic}» и реагирует на слово «{keyword}». " +
+	"Сообщение:\n{text}\n\n" +
+	"Человек действительно заговорил об этой теме? Цитата чужих слов, отрицание вроде «давайте не будем про это» " +
+	"или шутка про сам счётчик — не считаются. Ответь одним словом: да или нет."
+
+// negations are the words that make a match borderline
+var negations = map[string]bool{
+	"не": true, "нет": true, "ни": true, "без": true, "хватит": true, "нельзя": true,
+	"никаких": true, "никакого": true, "никакой": true, "запрещено": true,
+	"not": true, "no": true, "don't": true, "dont": true, "without": true,
+}
+
+// validClassifier fills in the endpoint from llm and the defaults
+func validClassifier(cfg *ClassifierConfig, llm LLMConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.URL == "" {
+		cfg.URL, cfg.APIKey = llm.URL, llm.APIKey
+	}
+	if cfg.Model == "" {
+		cfg.Model = llm.Model
+	}
+	if cfg.URL == "" || cfg.Model == "" {
+		return fmt.Errorf("classifier needs url and model, its own or those of llm")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.Prompt == "" {
+		cfg.Prompt = defaultClassifierPrompt
+	}
+	return nil
+}
+
+// borderline reports whether text may mention the keyword without really
+// talking about it: it quotes something or negates
+func borderline(text string) bool {
+	if strings.ContainsAny(text, "«»\"“”„'`") || strings.HasPrefix(strings.TrimSpace(text), ">") {
+		return true
+	}
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	}) {
+		if negations[w] {
+			return true
+		}
+	}
+	return false
+}
+
+// genuineMention asks the classifier whether the match of keyword in text is
+// a real mention. Clear matches, and any answer but "no", count as genuine:
+// a failing model must not silence the bot.
+func genuineMention(ctx context.Context, cfg Config, text, keyword, topic string) bool {
+	if !cfg.Classifier.Enabled || (!cfg.Classifier.All && !borderline(text)) {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.Classifier.Timeout)
+	defer cancel()
+	prompt := renderTemplate(cfg.Classifier.Prompt, map[string]string{"topic": topic, "keyword": keyword, "text": text})
+	answer, err := completeLLM(ctx, LLMConfig{URL: cfg.Classifier.URL, APIKey: cfg.Classifier.APIKey, Model: cfg.Classifier.Model}, prompt)
+	if err != nil {
+		slog.Warn("Classifier failed, counting the mention", "err", err)
+		return true
+	}
+	answer = strings.ToLower(strings.TrimLeftFunc(answer, func(r rune) bool { return !unicode.IsLetter(r) }))
+	genuine := !strings.HasPrefix(answer, "нет") && !strings.HasPrefix(answer, "no")
+	slog.Debug("Classified mention", "keyword", keyword, "genuine", genuine, "answer", answer)
+	return genuine
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const maxCountersPerChat = 10
+
+// isAdmin reports whether the sender may manage the chat's counters.
+// Everyone is an admin of their private chat with the bot.
+func isAdmin(b *tb.Bot, c tb.Context) bool {
+	if isPersonal(c.Chat()) {
+		return true
+	}
+	member, err := b.ChatMemberOf(c.Chat(), c.Sender())
+	if err != nil {
+		ctxLogger(c).Error("Failed to get member status", "err", err)
+		return false
+	}
+	return member.Role == tb.Administrator || member.Role == tb.Creator
+}
+
+// splitArgs splits a command payload on whitespace, keeping "double quoted"
+// and «guillemet quoted» phrases together
+func splitArgs(s string) []string {
+	var args []string
+	var cur strings.Builder
+	var quote rune
+	flush := func() {
+		if cur.Len() > 0 {
+			args = append(args, cur.String())
+			cur.Reset()
+		}
+	}
+	for _, r := range s {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+			flush()
+		case quote == 0 && (r == '"' || r == '«'):
+			flush()
+			quote = r
+			if r == '«' {
+				quote = '»'
+			}
+		case quote == 0 && unicode.IsSpace(r):
+			flush()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	flush()
+	return args
+}
+
+// matchCounter finds the counter whose keyword occurs in text: the default
+// counter (matched by defaultRe) wins, then runtime counters in name order.
+// Timings go to matchDuration and matchStages.
+func (st *ChatState) matchCounter(text string, defaultRe *regexp.Regexp) (name string, ctr Counter, keyword string) {
+	start := time.Now()
+	defer func() { matchDuration.Observe(time.Since(start)) }()
+
+	keyword = findKeyword(text, defaultRe)
+	matchStages["default"].Observe(time.Since(start))
+	if keyword != "" {
+		return "", st.Counter, keyword
+	}
+	stage := time.Now()
+	defer func() { matchStages["counters"].Observe(time.Since(stage)) }()
+	for _, n := range st.CounterNames()[1:] {
+		if keyword = findKeyword(text, counterRegex(st.Counters[n].Keywords)); keyword != "" {
+			return n, *st.Counters[n], keyword
+		}
+	}
+	return "", Counter{}, ""
+}
+
+func handleNewCounter(store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		args := splitArgs(c.Message().Payload)
+		if len(args) < 2 {
+			return c.Send("Использование: /newcounter <тема> <ключевые слова…>\nФразы с пробелами берите в кавычки.")
+		}
+		topic, keywords := args[0], args[1:]
+		name := strings.ToLower(topic)
+
+		var problem string
+		if err := store.UpdateContext(ctxOf(c), func(s *Storage) {
+			st := s.Chat(c.Chat().ID)
+			switch {
+			case st.Counters[name] != nil:
+				problem = "Счётчик «" + topic + "» уже есть."
+			case strings.HasSuffix(name, deletedSuffix):
+				problem = "Так помечаются удалённые счётчики, выберите другую тему."
+			case len(st.Counters) >= maxCountersPerChat:
+				problem = fmt.Sprintf("Слишком много счётчиков, максимум %d.", maxCountersPerChat)
+			default:
+				if st.Counters == nil {
+					st.Counters = make(map[string]*Counter)
+				}
+				st.Counters[name] = &Counter{Topic: topic, Keywords: keywords}
+			}
+		}); err != nil {
+			return err
+		}
+		if problem != "" {
+			return c.Send(problem)
+		}
+		ctxLogger(c).Info("Counter created", "counter", name, "keywords", keywords)
+		return c.Send(fmt.Sprintf("Счётчик «%s» создан, слежу за: %s.", topic, strings.Join(keywords, ", ")))
+	}
+}
+
+func handleDelCounter(store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		name := strings.ToLower(strings.TrimSpace(c.Message().Payload))
+		if name == "" {
+			return c.Send("Использование: /delcounter <тема>")
+		}
+
+		var found bool
+		if err := store.UpdateContext(ctxOf(c), func(s *Storage) {
+			st := s.Chat(c.Chat().ID)
+			if _, found = st.Counters[name]; found {
+				st.deleteCounter(name)
+			}
+		}); err != nil {
+			return err
+		}
+		if !found {
+			return c.Send("Нет такого счётчика. Список: /counters")
+		}
+		ctxLogger(c).Info("Counter deleted", "counter", name)
+		return c.Send("Счётчик удалён.")
+	}
+}
+
+func handleCounters(cfg Config, store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		var lines []string
+		if err := store.ViewContext(ctxOf(c), func(s *Storage) {
+			st := s.Chat(c.Chat().ID)
+			for _, name := range st.CounterNames() {
+				ctr := st.CounterByName(name)
+				days := "ещё не сбрасывался"
+				if !ctr.LastMention.IsZero() {
+					days = plural(ctr.Days(), "day")
+				}
+				if name == "" {
+					lines = append(lines, fmt.Sprintf("• %s (основной): %s", counterTopic(cfg, ctr), days))
+					continue
+				}
+				lines = append(lines, fmt.Sprintf("• %s: %s — %s", ctr.Topic, days, strings.Join(ctr.Keywords, ", ")))
+			}
+		}); err != nil {
+			return err
+		}
+		return c.Send("Счётчики чата:\n" + strings.Join(lines, "\n"))
+	}
+}
+
+// deletedSuffix marks the history events of deleted counters
+const deletedSuffix = " (удалён)"
+
+// deleteCounter removes an extra counter with its strict mode votes. Its
+// history events stay for the stats of the chat but move to a name of
+// their own, so a counter created later under the same name starts clean.
+func (st *ChatState) deleteCounter(name string) {
+	delete(st.Counters, name)
+	delete(st.ResetVotes, name)
+	for i := range st.History {
+		if st.History[i].Counter == name {
+			st.History[i].Counter = name + deletedSuffix
+		}
+	}
+	if st.Pending == name {
+		st.Pending = ""
+	}
+}
+
+// renameCounter changes the topic of an extra counter, moving it to the new name
+func (st *ChatState) renameCounter(name, topic string) {
+	ctr := st.Counters[name]
+	newName := strings.ToLower(topic)
+	ctr.Topic = topic
+	if newName == name {
+		return
+	}
+	delete(st.Counters, name)
+	st.Counters[newName] = ctr
+	for i := range st.History {
+		if st.History[i].Counter == name {
+			st.History[i].Counter = newName
+		}
+	}
+	if st.Pending == name {
+		st.Pending = newName
+	}
+}
+
+func handleRename(cfg Config, store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		payload := strings.TrimSpace(c.Message().Payload)
+		if payload == "" {
+			return c.Send("Использование: /rename <новая тема> или /rename <счётчик> <новая тема>")
+		}
+
+		var old, topic, problem string
+		if err := store.UpdateContext(ctxOf(c), func(s *Storage) {
+			st := s.Chat(c.Chat().ID)
+			// "/rename <counter> <topic>" when the first word names an extra counter
+			if first, rest, ok := strings.Cut(payload, " "); ok && st.Counters[strings.ToLower(first)] != nil {
+				name := strings.ToLower(first)
+				topic = strings.TrimSpace(rest)
+				if _, taken := st.Counters[strings.ToLower(topic)]; taken && strings.ToLower(topic) != name {
+					problem = "Счётчик «" + topic + "» уже есть."
+					return
+				}
+				old = st.Counters[name].Topic
+				st.renameCounter(name, topic)
+				return
+			}
+			old = counterTopic(cfg, &st.Counter)
+			topic = payload
+			st.Topic = topic
+		}); err != nil {
+			return err
+		}
+		if problem != "" {
+			return c.Send(problem)
+		}
+		ctxLogger(c).Info("Counter renamed", "from", old, "to", topic)
+		return c.Send(fmt.Sprintf("Теперь «%s» называется «%s». Счёт сохранён.", old, topic))
+	}
+}
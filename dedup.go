@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// seenUpdatesCap is how many update IDs are remembered, more than one
+// getUpdates batch that may be delivered again after a crash
+const seenUpdatesCap = 256
+
+// FreshUpdate records id and reports whether it wasn't processed before.
+// The IDs are saved with the next storage write: an update whose handling
+// changed storage is never handled twice. Telegram may restart update IDs
+// at random after a quiet week, so the IDs are compared as a set.
+func (s *Store) FreshUpdate(id int) bool {
+	s.acquire(context.Background())
+	defer s.release()
+	for _, seen := range s.data.SeenUpdates {
+		if seen == id {
+			return false
+		}
+	}
+	s.data.SeenUpdates = append(s.data.SeenUpdates, id)
+	if n := len(s.data.SeenUpdates); n > seenUpdatesCap {
+		s.data.SeenUpdates = s.data.SeenUpdates[n-seenUpdatesCap:]
+	}
+	return true
+}
+
+// dedupPoller drops updates that were already handled, e.g. redelivered
+// after a crash or retried by Telegram in webhook mode. The filter runs in
+// the poller before the handlers, so updates are seen in order.
+func dedupPoller(p tb.Poller, store *Store) tb.Poller {
+	return tb.NewMiddlewarePoller(p, func(u *tb.Update) bool {
+		if !store.FreshUpdate(u.ID) {
+			slog.Info("Skipping already handled update", "update_id", u.ID)
+			return false
+		}
+		return true
+	})
+}
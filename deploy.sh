@@ -36,8 +36,10 @@ Description=$APP_NAME Telegram bot
 After=network.target
 
 [Service]
-Type=simple
+Type=notify
+WatchdogSec=5min
 ExecStart=$BIN_PATH
+ExecReload=/bin/kill -HUP \$MAINPID
 WorkingDirectory=$(pwd)
 Restart=always
 RestartSec=2
@@ -45,7 +47,7 @@ User=$USER
 Environment=PATH=/usr/local/bin:/usr/bin:/bin
 
 # Hard kill if shutdown hangs
-TimeoutStopSec=10
+TimeoutStopSec=20
 KillMode=process
 
 [Install]
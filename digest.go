@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// DigestConfig controls the optional daily summary message
+type DigestConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Time    string `yaml:"time"` // "HH:MM", server time
+}
+
+func buildDigest(cfg Config, st *ChatState, now time.Time) string {
+	var sb strings.Builder
+	sb.WriteString("📊 Итоги дня\n")
+
+	for _, name := range st.CounterNames() {
+		ctr := st.CounterByName(name)
+		topic := counterTopic(cfg, ctr)
+		if ctr.LastMention.IsZero() {
+			fmt.Fprintf(&sb, "%s: ещё ни разу не упоминали.\n", topic)
+			continue
+		}
+		days := ctr.Days()
+		fmt.Fprintf(&sb, "%s: %s без упоминания (с %s).\n",
+			topic, plural(days, "day"), ctr.LastMention.Format("02.01.2006 15:04"))
+		if next := upcomingMilestone(cfg.Milestones, days); next > 0 {
+			fmt.Fprintf(&sb, "До следующей вехи (%s) осталось %s.\n", plural(next, "day"), plural(next-days, "day"))
+		}
+	}
+
+	var unconfirmed []string
+	for _, ev := range st.EventsSince(EventDetection, now.Add(-24*time.Hour)) {
+		if !ev.Confirmed {
+			unconfirmed = append(unconfirmed, fmt.Sprintf("«%s» (%s)", ev.Keyword, ev.Time.Format("15:04")))
+		}
+	}
+	if len(unconfirmed) == 0 {
+		sb.WriteString("Неподтверждённых срабатываний за сутки не было.")
+	} else {
+		fmt.Fprintf(&sb, "Неподтверждённые срабатывания за сутки: %s.", strings.Join(unconfirmed, ", "))
+	}
+	return sb.String()
+}
+
+func checkDigest(b *tb.Bot, cfg Config, store *Store, now time.Time) {
+	at, _ := parseClock(cfg.Digest.Time)
+
+	type digest struct {
+		chatID int64
+		text   string
+	}
+	var due []digest
+	var first []int64
+	store.View(func(s *Storage) {
+		for chatID, st := range s.ActiveChats() {
+			switch {
+			case st.LastDigest.IsZero():
+				// a chat seen for the first time starts with the next slot
+				// instead of getting one right away
+				first = append(first, chatID)
+			case dailyDue(at, st.LastDigest, now):
+				due = append(due, digest{chatID: chatID, text: buildDigest(cfg, st, now)})
+			}
+		}
+	})
+	if len(due) == 0 && len(first) == 0 {
+		return
+	}
+	store.Update(func(s *Storage) {
+		for _, chatID := range first {
+			s.Chat(chatID).LastDigest = now
+		}
+		for _, d := range due {
+			s.Chat(d.chatID).LastDigest = now
+		}
+	})
+
+	for _, d := range due {
+		slog.Debug("Sending daily digest", "chat_id", d.chatID)
+		if _, err := postToChat(b, store, d.chatID, d.text); err != nil {
+			slog.Error("Failed to send digest", "chat_id", d.chatID, "err", err)
+		}
+	}
+}
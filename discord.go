@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiscordConfig runs the bot on Discord too: every server (guild) the bot
+// is added to gets its own counters, with the keywords and templates of the
+// bot. Guilds restricts it to these server IDs when not empty.
+type DiscordConfig struct {
+	Token  string   `yaml:"token"`
+	Guilds []string `yaml:"guilds"`
+}
+
+const (
+	discordAPI = "https://discord.com/api/v10"
+	// GUILDS | GUILD_MESSAGES | MESSAGE_CONTENT
+	discordIntents = 1<<0 | 1<<9 | 1<<15
+	// ADMINISTRATOR | MANAGE_GUILD, the permissions of server admins
+	discordAdminPermissions = 1<<3 | 1<<5
+)
+
+// discordCommands are the slash commands registered for the application
+var discordCommands = []map[string]any{
+	{"name": "days", "description": "Сколько дней без упоминания", "options": []map[string]any{
+		{"type": 3, "name": "counter", "description": "Счётчик, по умолчанию основной"},
+	}},
+	{"name": "reset", "description": "Сбросить счётчик", "options": []map[string]any{
+		{"type": 3, "name": "counter", "description": "Счётчик, по умолчанию последнего упоминания"},
+		{"type": 3, "name": "reason", "description": "Причина сброса"},
+	}},
+	{"name": "counters", "description": "Счётчики сервера"},
+	{"name": "history", "description": "Последние сбросы"},
+}
+
+// discordAdapter connects a bot to the Discord gateway, turning messages
+// and slash commands into frontendCore calls
+type discordAdapter struct {
+	cfg    DiscordConfig
+	core   *frontendCore
+	client *http.Client
+
+	// registered is set once the slash commands are registered
+	registered bool
+	me         string
+	appID      string
+
+	mu  sync.Mutex
+	seq *int64
+}
+
+func newDiscordAdapter(cfg Config, store *Store) *discordAdapter {
+	return &discordAdapter{
+		cfg:    cfg.Discord,
+		core:   newFrontendCore("discord", "/", cfg, store),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// run keeps a gateway session up, reconnecting with a growing delay
+func (d *discordAdapter) run() {
+	delay := time.Second
+	for {
+		started := time.Now()
+		err := d.session()
+		if time.Since(started) > time.Minute {
+			delay = time.Second
+		}
+		slog.Warn("Discord gateway session ended, reconnecting", "err", err, "in", delay)
+		time.Sleep(delay)
+		delay = min(2*delay, 2*time.Minute)
+	}
+}
+
+type discordPayload struct {
+	Op int             `json:"op"`
+	D  json.RawMessage `json:"d,omitempty"`
+	S  *int64          `json:"s,omitempty"`
+	T  string          `json:"t,omitempty"`
+}
+
+// session runs one gateway connection until it fails or Discord asks to
+// reconnect. Sessions are not resumed: events missed in between are lost.
+func (d *discordAdapter) session() error {
+	var gw struct {
+		URL string `json:"url"`
+	}
+	if err := d.call(http.MethodGet, "/gateway/bot", nil, &gw); err != nil {
+		return fmt.Errorf("get gateway: %w", err)
+	}
+	conn, err := dialWebsocket(gw.URL + "/?v=10&encoding=json")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var hello struct {
+		Op int `json:"op"`
+		D  struct {
+			HeartbeatInterval int `json:"heartbeat_interval"`
+		} `json:"d"`
+	}
+	data, err := conn.ReadText()
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &hello); err != nil || hello.Op != 10 {
+		return fmt.Errorf("expected hello, got %s", data)
+	}
+	d.mu.Lock()
+	d.seq = nil
+	d.mu.Unlock()
+	stop := make(chan struct{})
+	defer close(stop)
+	go d.heartbeat(conn, time.Duration(hello.D.HeartbeatInterval)*time.Millisecond, stop)
+
+	if err := d.send(conn, 2, map[string]any{
+		"token":      d.cfg.Token,
+		"intents":    discordIntents,
+		"properties": map[string]string{"os": "linux", "browser": "dayswithout", "device": "dayswithout"},
+	}); err != nil {
+		return err
+	}
+
+	for {
+		data, err := conn.ReadText()
+		if err != nil {
+			return err
+		}
+		var p discordPayload
+		if err := json.Unmarshal(data, &p); err != nil {
+			slog.Warn("Bad Discord gateway payload", "err", err)
+			continue
+		}
+		switch p.Op {
+		case 0:
+			d.mu.Lock()
+			d.seq = p.S
+			d.mu.Unlock()
+			d.dispatch(p.T, p.D)
+		case 1:
+			if err := d.sendHeartbeat(conn); err != nil {
+				return err
+			}
+		case 7:
+			return fmt.Errorf("reconnect requested")
+		case 9:
+			return fmt.Errorf("invalid session")
+		}
+	}
+}
+
+func (d *discordAdapter) heartbeat(conn *wsConn, interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			if err := d.sendHeartbeat(conn); err != nil {
+				slog.Warn("Failed to send Discord heartbeat", "err", err)
+				conn.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (d *discordAdapter) sendHeartbeat(conn *wsConn) error {
+	d.mu.Lock()
+	seq := d.seq
+	d.mu.Unlock()
+	return d.send(conn, 1, seq)
+}
+
+func (d *discordAdapter) send(conn *wsConn, op int, payload any) error {
+	data, err := json.Marshal(map[string]any{"op": op, "d": payload})
+	if err != nil {
+		return err
+	}
+	return conn.WriteText(data)
+}
+
+type discordUser struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	GlobalName string `json:"global_name"`
+	Bot        bool   `json:"bot"`
+}
+
+// dispatch handles a gateway event
+func (d *discordAdapter) dispatch(typ string, data json.RawMessage) {
+	switch typ {
+	case "READY":
+		var ready struct {
+			User        discordUser `json:"user"`
+			Application struct {
+				ID string `json:"id"`
+			} `json:"application"`
+		}
+		if err := json.Unmarshal(data, &ready); err != nil {
+			slog.Error("Bad Discord READY event", "err", err)
+			return
+		}
+		d.me, d.appID = ready.User.ID, ready.Application.ID
+		slog.Info("Connected to Discord", "username", ready.User.Username)
+		if !d.registered {
+			if err := d.call(http.MethodPut, "/applications/"+ready.Application.ID+"/commands", discordCommands, nil); err != nil {
+				slog.Error("Failed to register Discord slash commands", "err", err)
+			} else {
+				d.registered = true
+			}
+		}
+	case "MESSAGE_CREATE":
+		var msg struct {
+			ID        string      `json:"id"`
+			ChannelID string      `json:"channel_id"`
+			GuildID   string      `json:"guild_id"`
+			Content   string      `json:"content"`
+			Author    discordUser `json:"author"`
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			slog.Error("Bad Discord message event", "err", err)
+			return
+		}
+		if msg.GuildID == "" || !d.allowed(msg.GuildID) || msg.Author.ID == d.me || (msg.Author.Bot && d.core.cfg.IgnoreBots) {
+			return
+		}
+		p := &discordMessage{d: d, id: msg.ID, channel: msg.ChannelID, guild: msg.GuildID, author: msg.Author, text: msg.Content}
+		if err := d.core.Detect(p); err != nil {
+			slog.Error("Failed to send Discord message", "channel_id", msg.ChannelID, "err", err)
+		}
+	case "INTERACTION_CREATE":
+		var in struct {
+			ID      string `json:"id"`
+			Token   string `json:"token"`
+			Type    int    `json:"type"`
+			GuildID string `json:"guild_id"`
+			Member  struct {
+				User        discordUser `json:"user"`
+				Permissions string      `json:"permissions"`
+			} `json:"member"`
+			Data struct {
+				Name    string `json:"name"`
+				Options []struct {
+					Name  string `json:"name"`
+					Value string `json:"value"`
+				} `json:"options"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(data, &in); err != nil {
+			slog.Error("Bad Discord interaction event", "err", err)
+			return
+		}
+		// 2 is a slash command
+		if in.Type != 2 {
+			return
+		}
+		p := &discordInteraction{d: d, id: in.ID, token: in.Token, guild: in.GuildID, user: in.Member.User, permissions: in.Member.Permissions}
+		// Discord waits 3 seconds for the answer, less than a reset with an
+		// LLM call may take, so the command is acknowledged first and the
+		// answer filled in later
+		if err := p.acknowledge(); err != nil {
+			slog.Error("Failed to acknowledge Discord command", "command", in.Data.Name, "err", err)
+			return
+		}
+		var err error
+		if in.GuildID != "" && d.allowed(in.GuildID) {
+			var args []string
+			for _, name := range []string{"counter", "reason"} {
+				for _, o := range in.Data.Options {
+					if o.Name == name {
+						args = append(args, o.Value)
+					}
+				}
+			}
+			err = d.core.Command(p, in.Data.Name, strings.Join(args, " "))
+		} else {
+			_, err = p.Send("Команды работают только на серверах.")
+		}
+		if err != nil {
+			slog.Error("Failed to answer Discord command", "command", in.Data.Name, "err", err)
+		}
+	}
+}
+
+func (d *discordAdapter) allowed(guild string) bool {
+	return len(d.cfg.Guilds) == 0 || slices.Contains(d.cfg.Guilds, guild)
+}
+
+func discordSender(user discordUser) chatUser {
+	return chatUser{ID: externalUserID("discord", user.ID), Username: user.Username, Name: user.GlobalName}
+}
+
+// discordMessage is a ChatPlatform of a message in a guild channel
+type discordMessage struct {
+	d           *discordAdapter
+	id, channel string
+	guild, text string
+	author      discordUser
+}
+
+func (m *discordMessage) Chat() string     { return "discord:" + m.guild }
+func (m *discordMessage) Sender() chatUser { return discordSender(m.author) }
+func (m *discordMessage) Text() string     { return m.text }
+
+// IsAdmin is false, messages only trigger detection
+func (m *discordMessage) IsAdmin() bool { return false }
+
+func (m *discordMessage) Send(text string) (string, error) {
+	return m.post(map[string]any{"content": text})
+}
+
+func (m *discordMessage) Reply(text string) (string, error) {
+	return m.post(map[string]any{"content": text, "message_reference": map[string]string{"message_id": m.id}})
+}
+
+func (m *discordMessage) post(body map[string]any) (string, error) {
+	var sent struct {
+		ID string `json:"id"`
+	}
+	err := m.d.call(http.MethodPost, "/channels/"+m.channel+"/messages", body, &sent)
+	return sent.ID, err
+}
+
+func (m *discordMessage) Edit(ref, text string) error {
+	return m.d.call(http.MethodPatch, "/channels/"+m.channel+"/messages/"+ref, map[string]string{"content": text}, nil)
+}
+
+// discordInteraction is a ChatPlatform of a slash command, answered by
+// editing the acknowledged interaction
+type discordInteraction struct {
+	d         *discordAdapter
+	id, token string
+	guild     string
+	user      discordUser
+	// permissions is the permission bit set of the member in the channel
+	permissions string
+	// answered is set once the acknowledged answer got its text
+	answered bool
+}
+
+func (in *discordInteraction) Chat() string     { return "discord:" + in.guild }
+func (in *discordInteraction) Sender() chatUser { return discordSender(in.user) }
+func (in *discordInteraction) Text() string     { return "" }
+
+func (in *discordInteraction) IsAdmin() bool {
+	perms, _ := strconv.ParseUint(in.permissions, 10, 64)
+	return perms&discordAdminPermissions != 0
+}
+
+// acknowledge answers the interaction with "thinking…", to be replaced by
+// Send
+func (in *discordInteraction) acknowledge() error {
+	return in.d.call(http.MethodPost, "/interactions/"+in.id+"/"+in.token+"/callback", map[string]any{"type": 5}, nil)
+}
+
+// Send puts text into the acknowledged answer, later texts are follow-up
+// messages
+func (in *discordInteraction) Send(text string) (string, error) {
+	if !in.answered {
+		if err := in.Edit("@original", text); err != nil {
+			return "", err
+		}
+		in.answered = true
+		return "@original", nil
+	}
+	var sent struct {
+		ID string `json:"id"`
+	}
+	err := in.d.call(http.MethodPost, "/webhooks/"+in.d.appID+"/"+in.token, map[string]string{"content": text}, &sent)
+	return sent.ID, err
+}
+
+func (in *discordInteraction) Reply(text string) (string, error) {
+	return in.Send(text)
+}
+
+func (in *discordInteraction) Edit(ref, text string) error {
+	return in.d.call(http.MethodPatch, "/webhooks/"+in.d.appID+"/"+in.token+"/messages/"+ref, map[string]string{"content": text}, nil)
+}
+
+// call makes a Discord REST request, decoding the response into out
+func (d *discordAdapter) call(method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, discordAPI+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bot "+d.cfg.Token)
+	req.Header.Set("User-Agent", "DiscordBot (https://github.com/rgb2hsl/dayswithout, 1.0)")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
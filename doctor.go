@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// errFatal replaces the exit of fatal while the doctor runs
+var errFatal = errors.New("fatal error")
+
+// doctor prints a PASS/FAIL line per check
+type doctor struct {
+	failed bool
+}
+
+func (d *doctor) report(name string, err error, detail string) {
+	if err != nil {
+		d.failed = true
+		fmt.Printf("FAIL  %s: %v\n", name, err)
+		return
+	}
+	fmt.Printf("PASS  %s: %s\n", name, detail)
+}
+
+// try runs fn turning a fatal or a panic into an error
+func try(fn func()) (err error) {
+	defer func() {
+		switch p := recover(); {
+		case p == errFatal:
+			err = errors.New("see the log above")
+		case p != nil:
+			err = fmt.Errorf("%v", p)
+		}
+	}()
+	fn()
+	return nil
+}
+
+// runDoctor checks the setup without starting the bot: config, token,
+// storage and matcher. It returns the process exit code.
+func runDoctor() int {
+	exit = func(int) { panic(errFatal) }
+	d := &doctor{}
+
+	var configs []Config
+	err := try(func() { configs = loadConfig() })
+	d.report("config", err, fmt.Sprintf("%s, %d bot(s)", configFile, len(configs)))
+
+	for i, cfg := range configs {
+		prefix := ""
+		if len(configs) > 1 {
+			prefix = fmt.Sprintf("bot %d ", i+1)
+		}
+		d.checkToken(prefix, cfg)
+		d.checkStorage(prefix, cfg.DataFile)
+		d.checkMatcher(prefix, cfg)
+	}
+	if d.failed {
+		fmt.Println("Some checks failed.")
+		return 1
+	}
+	fmt.Println("All checks passed.")
+	return 0
+}
+
+func (d *doctor) checkToken(prefix string, cfg Config) {
+	var b *tb.Bot
+	err := try(func() {
+		var err error
+		b, err = tb.NewBot(tb.Settings{URL: cfg.APIURL, Token: cfg.BotToken, Client: newHTTPClient(cfg)})
+		if err != nil {
+			panic(err)
+		}
+	})
+	detail := ""
+	if b != nil {
+		detail = fmt.Sprintf("@%s (id %d)", b.Me.Username, b.Me.ID)
+	}
+	d.report(prefix+"token", err, detail)
+}
+
+func (d *doctor) checkStorage(prefix, path string) {
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		d.report(prefix+"storage read", nil, path+" doesn't exist yet")
+	case err != nil:
+		d.report(prefix+"storage read", err, "")
+	default:
+		var s Storage
+		if err := json.Unmarshal(data, &s); err != nil {
+			d.report(prefix+"storage read", fmt.Errorf("%s: %w", path, err), "")
+		} else {
+			d.report(prefix+"storage read", nil, fmt.Sprintf("%s, %d chat(s)", path, len(s.Chats)))
+		}
+	}
+	d.report(prefix+"storage write", storageWritable(path), "directory of "+path+" is writable")
+}
+
+// checkMatcher compiles the keyword matcher and checks that every keyword
+// is found in a message consisting of just that keyword
+func (d *doctor) checkMatcher(prefix string, cfg Config) {
+	err := try(func() {
+		re := buildKeywordRegex(cfg.Keywords, cfg.NoSuffix)
+		for _, kw := range cfg.Keywords {
+			if findKeyword(kw, re) == "" {
+				panic(fmt.Sprintf("keyword %q doesn't match itself", kw))
+			}
+		}
+	})
+	d.report(prefix+"matcher", err, fmt.Sprintf("%d keyword(s) compiled", len(cfg.Keywords)))
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	emailQueueSize = 64
+	emailAttempts  = 3
+	emailTimeout   = 30 * time.Second
+)
+
+// EmailConfig sends counter events by email through an SMTP server. Port
+// 465 is implicit TLS, other ports upgrade with STARTTLS when the server
+// offers it. Recipients are not disclosed to each other.
+type EmailConfig struct {
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	// Events are reset and milestone by default, detection and record are
+	// allowed too
+	Events []string `yaml:"events"`
+	// Chats limits the mails to these chats, all when empty
+	Chats []int64 `yaml:"chats"`
+}
+
+// validEmail checks the email settings and fills in the defaults
+func validEmail(cfg *EmailConfig) error {
+	if cfg.Port == 0 {
+		cfg.Port = 587
+	}
+	if _, err := mail.ParseAddress(cfg.From); err != nil {
+		return fmt.Errorf("invalid email.from %q: %w", cfg.From, err)
+	}
+	if len(cfg.To) == 0 {
+		return fmt.Errorf("email.to must list at least one address")
+	}
+	for _, to := range cfg.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("invalid email.to %q: %w", to, err)
+		}
+	}
+	if len(cfg.Events) == 0 {
+		cfg.Events = []string{HookReset, HookMilestone}
+	}
+	for _, typ := range cfg.Events {
+		if typ != HookDetection && typ != HookReset && typ != HookMilestone && typ != HookRecord {
+			return fmt.Errorf("unknown email event %q, expected detection, reset, milestone or record", typ)
+		}
+	}
+	return nil
+}
+
+// emailSink mails events in the background, retrying failed sends a few
+// times
+type emailSink struct {
+	cfg   EmailConfig
+	queue chan counterEvent
+}
+
+func newEmailSink(cfg EmailConfig) *emailSink {
+	s := &emailSink{cfg: cfg, queue: make(chan counterEvent, emailQueueSize)}
+	go s.run()
+	return s
+}
+
+func (s *emailSink) Publish(ev counterEvent) {
+	if !slices.Contains(s.cfg.Events, ev.Type) || (len(s.cfg.Chats) > 0 && !slices.Contains(s.cfg.Chats, ev.ChatID)) {
+		return
+	}
+	select {
+	case s.queue <- ev:
+	default:
+		slog.Warn("Email queue is full, dropping event", "type", ev.Type, "chat_id", ev.ChatID)
+	}
+}
+
+func (s *emailSink) run() {
+	for ev := range s.queue {
+		msg := s.message(ev)
+		var err error
+		for attempt := 1; attempt <= emailAttempts; attempt++ {
+			if err = s.send(msg); err == nil {
+				break
+			}
+			if attempt < emailAttempts {
+				time.Sleep(time.Duration(attempt) * 5 * time.Second)
+			}
+		}
+		if err != nil {
+			slog.Error("Failed to send email", "host", s.cfg.Host, "type", ev.Type, "err", err)
+		}
+	}
+}
+
+// message renders the mail of ev with the text of the simple hook format
+func (s *emailSink) message(ev counterEvent) []byte {
+	var subject string
+	switch ev.Type {
+	case HookReset:
+		subject = "Сброс: дни без " + ev.Topic
+	case HookMilestone:
+		subject = plural(ev.Days, "day") + " без " + ev.Topic
+	case HookRecord:
+		subject = "Рекорд: дни без " + ev.Topic
+	default:
+		subject = "Упоминание: дни без " + ev.Topic
+	}
+	from, _ := mail.ParseAddress(s.cfg.From)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	buf.WriteString("To: undisclosed-recipients:;\r\n")
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", clock().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%d.%d@%s>\r\n", ev.Time.UnixNano(), ev.ChatID, s.cfg.Host)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&buf)
+	fmt.Fprintf(qp, "%s\r\n\r\nЧат %d, %s\r\n", simpleEventOf(ev).Text, ev.ChatID, ev.Time.Format("02.01.2006 15:04"))
+	qp.Close()
+	return buf.Bytes()
+}
+
+func (s *emailSink) send(msg []byte) error {
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	dialer := &net.Dialer{Timeout: emailTimeout}
+	tlsConfig := &tls.Config{ServerName: s.cfg.Host}
+	var conn net.Conn
+	var err error
+	if s.cfg.Port == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(emailTimeout))
+	c, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok && s.cfg.Port != 465 {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+	if s.cfg.Username != "" {
+		// PlainAuth refuses to send the password unencrypted, except to localhost
+		if err := c.Auth(smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+	from, _ := mail.ParseAddress(s.cfg.From)
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, to := range s.cfg.To {
+		rcpt, _ := mail.ParseAddress(to)
+		if err := c.Rcpt(rcpt.Address); err != nil {
+			return fmt.Errorf("rcpt %s: %w", rcpt.Address, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
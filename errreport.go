@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// ErrorsConfig sends handler errors and panics to Sentry (SentryDSN) and/or
+// as JSON to a generic WebhookURL
+type ErrorsConfig struct {
+	SentryDSN  string `yaml:"sentry_dsn"`
+	WebhookURL string `yaml:"webhook_url"`
+}
+
+// errorReport is a captured failure with the update it happened in
+type errorReport struct {
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"` // "error" or "panic"
+	Error    string    `json:"error"`
+	Stack    string    `json:"stack,omitempty"`
+	UpdateID int       `json:"update_id,omitempty"`
+	ChatID   int64     `json:"chat_id,omitempty"`
+	UserID   int64     `json:"user_id,omitempty"`
+	Username string    `json:"username,omitempty"`
+	Text     string    `json:"text,omitempty"`
+}
+
+// errorReporter delivers reports in the background
+type errorReporter struct {
+	cfg    ErrorsConfig
+	sentry *url.URL
+	client *http.Client
+}
+
+var reporter *errorReporter
+
+func newErrorReporter(cfg ErrorsConfig) (*errorReporter, error) {
+	r := &errorReporter{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+	if cfg.SentryDSN != "" {
+		dsn, err := url.Parse(cfg.SentryDSN)
+		if err != nil || dsn.User == nil || strings.Trim(dsn.Path, "/") == "" {
+			return nil, fmt.Errorf("invalid errors.sentry_dsn")
+		}
+		r.sentry = dsn
+	}
+	return r, nil
+}
+
+// Report captures err that happened while handling c (nil outside of updates)
+func (r *errorReporter) Report(kind string, err error, c tb.Context, stack []byte) {
+	if r == nil {
+		return
+	}
+	rep := errorReport{Time: time.Now(), Kind: kind, Error: err.Error(), Stack: string(stack)}
+	if c != nil {
+		rep.UpdateID = c.Update().ID
+		if chat := c.Chat(); chat != nil {
+			rep.ChatID = chat.ID
+		}
+		if user := c.Sender(); user != nil {
+			rep.UserID, rep.Username = user.ID, user.Username
+		}
+		rep.Text = c.Text()
+	}
+	go r.deliver(rep)
+}
+
+func (r *errorReporter) deliver(rep errorReport) {
+	if r.cfg.WebhookURL != "" {
+		r.post(r.cfg.WebhookURL, nil, rep)
+	}
+	if r.sentry != nil {
+		r.post(r.sentryURL(), map[string]string{"X-Sentry-Auth": r.sentryAuth()}, sentryEvent(rep))
+	}
+}
+
+func (r *errorReporter) post(target string, headers map[string]string, body any) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		slog.Error("Failed to encode error report", "err", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(data))
+	if err != nil {
+		slog.Error("Failed to build error report request", "err", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		slog.Error("Failed to deliver error report", "host", req.URL.Host, "err", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		slog.Error("Error report rejected", "host", req.URL.Host, "status", resp.Status)
+	}
+}
+
+// sentryURL is the store endpoint of the DSN project
+func (r *errorReporter) sentryURL() string {
+	project := r.sentry.Path[strings.LastIndex(r.sentry.Path, "/")+1:]
+	prefix := strings.TrimSuffix(r.sentry.Path[:strings.LastIndex(r.sentry.Path, "/")+1], "/")
+	return fmt.Sprintf("%s://%s%s/api/%s/store/", r.sentry.Scheme, r.sentry.Host, prefix, project)
+}
+
+func (r *errorReporter) sentryAuth() string {
+	return fmt.Sprintf("Sentry sentry_version=7, sentry_client=dayswithout/1.0, sentry_key=%s", r.sentry.User.Username())
+}
+
+// sentryEvent converts rep into a Sentry store API event
+func sentryEvent(rep errorReport) map[string]any {
+	id := make([]byte, 16)
+	rand.Read(id)
+	level := "error"
+	if rep.Kind == "panic" {
+		level = "fatal"
+	}
+	ev := map[string]any{
+		"event_id":  hex.EncodeToString(id),
+		"timestamp": rep.Time.UTC().Format(time.RFC3339),
+		"level":     level,
+		"platform":  "go",
+		"logger":    "dayswithout",
+		"message":   rep.Error,
+		"tags":      map[string]string{"kind": rep.Kind, "chat_id": fmt.Sprint(rep.ChatID)},
+		"extra":     map[string]any{"update_id": rep.UpdateID, "text": rep.Text, "stack": rep.Stack},
+	}
+	if rep.UserID != 0 {
+		ev["user"] = map[string]any{"id": fmt.Sprint(rep.UserID), "username": rep.Username}
+	}
+	return ev
+}
+
+// onError is the bot-wide handler of errors returned by update handlers
+func onError(err error, c tb.Context) {
+	if c != nil {
+		ctxLogger(c).Error("Handler failed", "err", err)
+	} else {
+		slog.Error("Bot error", "err", err)
+	}
+	reporter.Report("error", err, c, nil)
+	alerts.Alert(alertKind(err), err.Error())
+}
+
+// recoverPanics keeps a panicking handler from taking the process down: the
+// panic is logged with its stack and the update, reported and alerted
+func recoverPanics(next tb.HandlerFunc) tb.HandlerFunc {
+	return func(c tb.Context) (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				stack := debug.Stack()
+				update, _ := json.Marshal(c.Update())
+				ctxLogger(c).Error("Handler panicked", "panic", p, "update", string(update), "stack", string(stack))
+				reporter.Report("panic", fmt.Errorf("%v", p), c, stack)
+				alerts.Alert("паника", fmt.Sprint(p))
+				err = nil
+			}
+		}()
+		return next(c)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"time"
+)
+
+// Counter event types published to integrations
+const (
+	HookDetection = "detection"
+	HookReset     = "reset"
+	HookMilestone = "milestone"
+	HookRecord    = "record" // a streak passing the longest finished one
+)
+
+// counterEvent is a change of a counter as integrations see it. Who follows
+// /optout, so anonymous members stay anonymous outside Telegram too.
+type counterEvent struct {
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Bot     string    `json:"bot"`
+	ChatID  int64     `json:"chat_id"`
+	Counter string    `json:"counter"`
+	Topic   string    `json:"topic"`
+	// Days is the current streak, for resets the one that ended
+	Days    int    `json:"days"`
+	Streak  int64  `json:"streak_seconds"`
+	Who     string `json:"who,omitempty"`
+	Keyword string `json:"keyword,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// eventSink receives counter events. Publish must not block the caller.
+type eventSink interface {
+	Publish(ev counterEvent)
+}
+
+// eventSinks are the integrations enabled in config
+var eventSinks []eventSink
+
+// publishEvent hands ev to every integration
+func publishEvent(ev counterEvent) {
+	for _, s := range eventSinks {
+		s.Publish(ev)
+	}
+}
+
+// historyEvent builds the counter event of a history entry of chatID
+func historyEvent(typ, bot string, chatID int64, topic string, ev Event) counterEvent {
+	ce := counterEvent{
+		Type:    typ,
+		Time:    ev.Time,
+		Bot:     bot,
+		ChatID:  chatID,
+		Counter: ev.Counter,
+		Topic:   topic,
+		Keyword: ev.Keyword,
+		Reason:  ev.Reason,
+	}
+	if ev.UserID != 0 || ev.Anonymous || ev.Name != "" {
+		ce.Who = ev.Who()
+	}
+	return ce
+}
+
+// resetEvent is the counter event of the reset just recorded as the last
+// history entry of st
+func resetEvent(bot string, chatID int64, topic string, st *ChatState) counterEvent {
+	ev := st.History[len(st.History)-1]
+	ce := historyEvent(HookReset, bot, chatID, topic, ev)
+	ce.Days, ce.Streak = durationDays(ev.Streak), int64(ev.Streak.Seconds())
+	return ce
+}
+
+// detectionEvent is the counter event of detection ev of a counter with
+// the given current streak
+func detectionEvent(bot string, chatID int64, topic string, ev Event, streak time.Duration) counterEvent {
+	ce := historyEvent(HookDetection, bot, chatID, topic, ev)
+	ce.Days, ce.Streak = durationDays(streak), int64(streak.Seconds())
+	return ce
+}
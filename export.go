@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// ExportConfig writes snapshots of the counters and the history of every
+// chat on a cron schedule, as CSV and/or JSON files into Dir and/or an S3
+// bucket, for archiving and offline analysis
+type ExportConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Schedule is a five-field cron expression in the bot timezone,
+	// "0 3 * * *" (daily at 03:00) by default
+	Schedule string `yaml:"schedule"`
+	// Formats are "csv" and "json", both by default
+	Formats []string `yaml:"formats"`
+	// Dir receives the files, "exports" by default unless S3 is set
+	Dir string   `yaml:"dir"`
+	S3  S3Config `yaml:"s3"`
+}
+
+type exportChat struct {
+	apiChat
+	History []apiEvent `json:"history"`
+}
+
+type exportSnapshot struct {
+	Bot   string       `json:"bot"`
+	Time  time.Time    `json:"time"`
+	Chats []exportChat `json:"chats"`
+}
+
+type exportFile struct {
+	name        string
+	contentType string
+	data        []byte
+}
+
+// runExport is the "export" job
+func runExport(b *tb.Bot, cfg Config, store *Store, now time.Time) {
+	sched, _ := parseCron(cfg.Export.Schedule)
+	now = now.In(cfg.Location)
+	var due bool
+	store.View(func(s *Storage) { due = cronDue(sched, s.LastExport, now) })
+	if !due {
+		return
+	}
+	snap := exportSnapshot{Bot: b.Me.Username, Time: now, Chats: []exportChat{}}
+	store.Update(func(s *Storage) {
+		s.LastExport = now
+		for chatID, st := range s.ActiveChats() {
+			chat := exportChat{apiChat: apiChatState(cfg, chatID, st), History: make([]apiEvent, len(st.History))}
+			for i, ev := range st.History {
+				chat.History[i] = apiEventOf(ev)
+			}
+			snap.Chats = append(snap.Chats, chat)
+		}
+	})
+	slices.SortFunc(snap.Chats, func(a, b exportChat) int { return cmp.Compare(a.ChatID, b.ChatID) })
+
+	files, err := exportFiles(cfg.Export, snap)
+	if err != nil {
+		slog.Error("Failed to encode export", "err", err)
+		return
+	}
+	for _, f := range files {
+		if cfg.Export.Dir != "" {
+			if err := os.MkdirAll(cfg.Export.Dir, 0o755); err != nil {
+				slog.Error("Failed to create export dir", "dir", cfg.Export.Dir, "err", err)
+			} else if err := os.WriteFile(filepath.Join(cfg.Export.Dir, f.name), f.data, 0o600); err != nil {
+				slog.Error("Failed to write export", "file", f.name, "err", err)
+			}
+		}
+		if cfg.Export.S3.Bucket != "" {
+			if err := s3Put(cfg.Export.S3, f.name, f.data, f.contentType, clock()); err != nil {
+				slog.Error("Failed to upload export", "bucket", cfg.Export.S3.Bucket, "file", f.name, "err", err)
+			}
+		}
+	}
+	slog.Info("Exported counters", "chats", len(snap.Chats), "files", len(files))
+}
+
+// exportFiles encodes the snapshot in the configured formats; the names
+// start with the bot and the time, so exports of several bots can share a
+// directory
+func exportFiles(cfg ExportConfig, snap exportSnapshot) ([]exportFile, error) {
+	base := snap.Bot + "-" + snap.Time.Format("20060102-1504")
+	var files []exportFile
+	if slices.Contains(cfg.Formats, "json") {
+		data, err := json.MarshalIndent(snap, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, exportFile{base + ".json", "application/json", data})
+	}
+	if slices.Contains(cfg.Formats, "csv") {
+		var counters, history [][]string
+		counters = append(counters, []string{"chat_id", "counter", "topic", "days", "streak_seconds", "record_seconds", "last_mention", "paused"})
+		history = append(history, []string{"chat_id", "time", "type", "counter", "who", "keyword", "streak_seconds", "reason"})
+		for _, chat := range snap.Chats {
+			id := strconv.FormatInt(chat.ChatID, 10)
+			for _, c := range chat.Counters {
+				var last string
+				if c.LastMention != nil {
+					last = c.LastMention.Format(time.RFC3339)
+				}
+				counters = append(counters, []string{id, c.Name, c.Topic, strconv.Itoa(c.Days),
+					strconv.FormatInt(c.Streak, 10), strconv.FormatInt(c.Record, 10), last, strconv.FormatBool(c.Paused)})
+			}
+			for _, ev := range chat.History {
+				history = append(history, []string{id, ev.Time.Format(time.RFC3339), ev.Type, ev.Counter, ev.Who, ev.Keyword,
+					strconv.FormatInt(ev.Streak, 10), ev.Reason})
+			}
+		}
+		for i, rows := range [][][]string{counters, history} {
+			name := []string{"counters", "history"}[i]
+			var buf bytes.Buffer
+			w := csv.NewWriter(&buf)
+			w.WriteAll(rows)
+			if err := w.Error(); err != nil {
+				return nil, err
+			}
+			files = append(files, exportFile{base + "-" + name + ".csv", "text/csv", buf.Bytes()})
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const feedLimit = 50
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Language    string    `xml:"language"`
+	LastBuild   string    `xml:"lastBuildDate,omitempty"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Description string  `xml:"description"`
+	PubDate     string  `xml:"pubDate"`
+	GUID        rssGUID `xml:"guid"`
+}
+
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+// serveFeed answers GET /feed/<chat id>.rss with the latest resets of the
+// chat, optionally of one ?counter=name
+func serveFeed(cfg Config, bots []*botInstance) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idText, ext, _ := strings.Cut(r.PathValue("file"), ".")
+		if ext != "rss" {
+			writeAPIError(w, http.StatusNotFound, "feeds are .rss")
+			return
+		}
+		r.SetPathValue("id", idText)
+		bi, id, ok := publicChatOf(cfg, bots, cfg.API.FeedChats, w, r)
+		if !ok {
+			return
+		}
+		counter, filtered := strings.ToLower(r.URL.Query().Get("counter")), r.URL.Query().Has("counter")
+
+		feed := rssFeed{Version: "2.0", Channel: rssChannel{
+			Link:     requestURL(r),
+			Language: "ru",
+		}}
+		var unknown bool
+		bi.store.View(func(s *Storage) {
+			st := s.Chat(id)
+			loc := chatLocation(bi.cfg, st)
+			topics := make(map[string]string)
+			for _, name := range st.CounterNames() {
+				topics[name] = counterTopic(bi.cfg, st.CounterByName(name))
+			}
+			title, known := topics[counter]
+			if unknown = !known; unknown {
+				return
+			}
+			feed.Channel.Title = "Дни без " + title
+			feed.Channel.Description = "Сбросы счётчика: кто, когда и какая серия оборвалась"
+
+			resets := st.EventsSince(EventReset, time.Time{})
+			for i := len(resets) - 1; i >= 0 && len(feed.Channel.Items) < feedLimit; i-- {
+				ev := resets[i]
+				if filtered && ev.Counter != counter {
+					continue
+				}
+				topic, ok := topics[ev.Counter]
+				if !ok {
+					topic = ev.Counter
+				}
+				desc := fmt.Sprintf("%s сбросил(а) счётчик %s %s. Серия длилась %s.",
+					ev.Who(), topic, ev.Time.In(loc).Format("02.01.2006 15:04"), formatStreak(ev.Streak, false))
+				if ev.Reason != "" {
+					desc += " Причина: " + ev.Reason
+				}
+				feed.Channel.Items = append(feed.Channel.Items, rssItem{
+					Title:       fmt.Sprintf("Сброс: %s без %s", formatStreak(ev.Streak, false), topic),
+					Description: desc,
+					PubDate:     ev.Time.Format(time.RFC1123Z),
+					GUID:        rssGUID{Value: fmt.Sprintf("dayswithout:%d:%d", id, ev.Time.UnixNano())},
+				})
+			}
+		})
+		if unknown {
+			writeAPIError(w, http.StatusNotFound, "unknown counter")
+			return
+		}
+		if len(feed.Channel.Items) > 0 {
+			feed.Channel.LastBuild = feed.Channel.Items[0].PubDate
+		}
+
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		w.Header().Set("Cache-Control", "max-age=300")
+		w.Write([]byte(xml.Header))
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		enc.Encode(feed)
+	}
+}
+
+// requestURL rebuilds the public URL of r, honoring a TLS-terminating
+// reverse proxy
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
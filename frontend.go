@@ -0,0 +1,487 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// externalChatBase is where storage chat IDs of other platforms start,
+// far below any Telegram chat ID
+const externalChatBase = -1 << 62
+
+// isExternalChat reports whether chatID belongs to a frontend other than
+// Telegram
+func isExternalChat(chatID int64) bool {
+	return chatID <= externalChatBase
+}
+
+// externalChat returns the storage chat ID of a chat on another platform,
+// e.g. "discord:<guild id>", allocating one the first time
+func (s *Storage) externalChat(key string) int64 {
+	if id, ok := s.External[key]; ok {
+		return id
+	}
+	if s.External == nil {
+		s.External = make(map[string]int64)
+	}
+	id := int64(externalChatBase) - int64(len(s.External))
+	s.External[key] = id
+	return id
+}
+
+// externalUserID maps a user of another platform to a negative ID that can't
+// clash with Telegram users, for stats and achievements
+func externalUserID(platform, id string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(platform + ":" + id))
+	return -int64(h.Sum64()>>2) - 1
+}
+
+// frontendCore is the platform-independent part of the bot that every chat
+// platform shares, Telegram included: keyword detection, resets and the
+// basic commands, on the storage and matcher of one bot. Adapters hand
+// their messages over as ChatPlatform, through which the core answers.
+type frontendCore struct {
+	cfg       Config
+	store     *Store
+	keywordRe *regexp.Regexp
+	// bot names the frontend in events, e.g. "discord"
+	bot string
+	// prefix starts the commands of the platform in answers, like "/"
+	prefix  string
+	prompts *slidingLimiter
+}
+
+func newFrontendCore(bot, prefix string, cfg Config, store *Store) *frontendCore {
+	return &frontendCore{
+		cfg:       cfg,
+		store:     store,
+		keywordRe: buildKeywordRegex(cfg.Keywords, cfg.NoSuffix),
+		bot:       bot,
+		prefix:    prefix,
+		prompts:   newSlidingLimiter(cfg.PromptsPerHour, time.Hour),
+	}
+}
+
+// plainPlatform gives a ChatPlatform without the extras of Telegram their
+// plain text fallbacks
+type plainPlatform struct {
+	ChatPlatform
+	frontend string
+}
+
+func (p plainPlatform) Context() context.Context { return context.Background() }
+func (p plainPlatform) Thread() int              { return 0 }
+func (p plainPlatform) MessageID() int           { return 0 }
+func (p plainPlatform) Personal() bool           { return false }
+func (p plainPlatform) AllowDetection() bool     { return true }
+func (p plainPlatform) Changed()                 {}
+
+func (p plainPlatform) Logger() *slog.Logger {
+	return slog.With("frontend", p.frontend, "chat", p.Chat())
+}
+
+func (p plainPlatform) ReplyTemplated(t templated) error {
+	_, err := p.Reply(t.Text)
+	return err
+}
+
+// ReplyMedia does nothing, media are Telegram files
+func (p plainPlatform) ReplyMedia(MediaSet) error { return nil }
+
+// AskApproval can't offer a button, the next admin repeats the command
+func (p plainPlatform) AskApproval(text, counter string) error {
+	_, err := p.Reply(text)
+	return err
+}
+
+func (p plainPlatform) Achieved(list []awarded) {
+	for _, a := range list {
+		p.Logger().Info("Achievement unlocked", "achievement", a.ID, "who", a.Who)
+		if _, err := p.Send(achievementText(a)); err != nil {
+			p.Logger().Error("Failed to announce achievement", "err", err)
+		}
+	}
+}
+
+// rich returns p with the extras of its platform, or their fallbacks
+func (fc *frontendCore) rich(p ChatPlatform) richPlatform {
+	if rp, ok := p.(richPlatform); ok {
+		return rp
+	}
+	return plainPlatform{ChatPlatform: p, frontend: fc.bot}
+}
+
+// frontendHelp lists the commands of the other platforms
+const frontendHelp = "Команды: %[1]sdays [счётчик], %[1]sreset [счётчик] [причина], %[1]scounters, %[1]shistory."
+
+// chatID is the storage chat of a platform chat. Telegram chats are stored
+// under their own IDs.
+func (fc *frontendCore) chatID(ctx context.Context, chat string) (int64, error) {
+	if id, err := strconv.ParseInt(chat, 10, 64); err == nil {
+		return id, nil
+	}
+	var id int64
+	var ok bool
+	if err := fc.store.ViewContext(ctx, func(s *Storage) { id, ok = s.External[chat] }); err != nil || ok {
+		return id, err
+	}
+	err := fc.store.UpdateContext(ctx, func(s *Storage) { id = s.externalChat(chat) })
+	return id, err
+}
+
+// event builds the history event of a message of u
+func (fc *frontendCore) event(s *Storage, u chatUser, counter string) Event {
+	return s.Attribute(Event{
+		Time:     clock(),
+		Counter:  counter,
+		UserID:   u.ID,
+		Username: u.Username,
+		Name:     u.Name,
+	})
+}
+
+// queueAnnouncement queues the announcement of a reset in chatID, see
+// Announcement. The "announcements" job posts through Telegram, so resets
+// on other platforms are only announced by their handler.
+func (fc *frontendCore) queueAnnouncement(s *Storage, chatID int64, thread int, text templated, now time.Time) int64 {
+	if isExternalChat(chatID) {
+		return 0
+	}
+	return s.queueAnnouncement(chatID, thread, text, now, true)
+}
+
+// answer replies with text and a media of set as the media mode says,
+// calling sent once the text, or the media standing in for it, got through
+func (fc *frontendCore) answer(p richPlatform, set MediaSet, text templated, sent func()) error {
+	_, plain := p.(plainPlatform)
+	media := !plain && !set.empty()
+	instead := media && fc.cfg.Media.Mode == "instead"
+	var err error
+	if instead {
+		err = p.ReplyMedia(set)
+	} else {
+		err = p.ReplyTemplated(text)
+	}
+	if err != nil {
+		return err
+	}
+	if sent != nil {
+		sent()
+	}
+	if media && !instead {
+		return p.ReplyMedia(set)
+	}
+	return nil
+}
+
+// announce posts the reset announcement id, confirming it as soon as it got
+// through and leaving it to the "announcements" job when it didn't
+func (fc *frontendCore) announce(p richPlatform, id int64, text templated) error {
+	var sent bool
+	err := fc.answer(p, fc.cfg.Media.Reset, text, func() {
+		sent = true
+		fc.store.Update(func(s *Storage) { s.confirmAnnouncement(id) })
+	})
+	if err != nil && !sent {
+		fc.store.Update(func(s *Storage) { s.releaseAnnouncement(id) })
+	}
+	return err
+}
+
+// Detect checks the message for keywords and answers with a prompt, or
+// with the reset announcement when the chat resets on detection
+func (fc *frontendCore) Detect(p ChatPlatform) error {
+	rp := fc.rich(p)
+	ctx, log := rp.Context(), rp.Logger()
+	chatID, err := fc.chatID(ctx, p.Chat())
+	if err != nil {
+		return err
+	}
+	var name, found string
+	var ctr Counter
+	err = fc.store.ViewContext(ctx, func(s *Storage) {
+		st := s.Chat(chatID)
+		if !st.watchesThread(rp.Thread()) {
+			log.Debug("Ignoring message in unwatched thread")
+			return
+		}
+		name, ctr, found = st.matchCounter(p.Text(), fc.keywordRe)
+	})
+	if err != nil || found == "" {
+		return err
+	}
+	if ctr.Paused() {
+		log.Debug("Ignoring mention, counter is paused", "counter", name)
+		return nil
+	}
+	if !ctr.LastMention.IsZero() && clock().Sub(ctr.LastMention) < 2*time.Hour {
+		log.Debug("Ignoring mention within cooldown", "counter", name, "last_mention", ctr.LastMention)
+		return nil
+	}
+	if !rp.AllowDetection() {
+		log.Warn("Detection limit reached, ignoring", "counter", name, "keyword", found)
+		return nil
+	}
+	if !genuineMention(ctx, fc.cfg, p.Text(), found, counterTopic(fc.cfg, &ctr)) {
+		log.Info("Classifier rejected mention", "counter", name, "keyword", found)
+		return nil
+	}
+
+	var unlocked []awarded
+	var autoReset bool
+	var text templated
+	var announcement int64
+	var events []counterEvent
+	topic := counterTopic(fc.cfg, &ctr)
+	vars := map[string]string{"topic": topic, "keyword": found}
+	err = fc.store.UpdateContext(ctx, func(s *Storage) {
+		st := s.Chat(chatID)
+		st.Pending = name
+		ev := fc.event(s, p.Sender(), name)
+		ev.Keyword = found
+		ev.MessageID = rp.MessageID()
+		st.RecordDetection(ev)
+		unlocked = st.detectionAchievements(ev)
+		vars["offender"] = ev.Who()
+		cur := st.CounterByName(name)
+		if cur == nil {
+			return
+		}
+		vars["streak"] = formatStreak(cur.Streak(), false)
+		events = append(events, detectionEvent(fc.bot, chatID, topic, ev, cur.Streak()))
+		if autoReset = autoResetEnabled(fc.cfg, st); !autoReset {
+			return
+		}
+		text = resetText(fc.cfg, topic, ev.Time, ctr.LastMention, cur.Streak())
+		announcement = fc.queueAnnouncement(s, chatID, rp.Thread(), text, ev.Time)
+		st.Reset(ev)
+		events = append(events, resetEvent(fc.bot, chatID, topic, st))
+		events = append(events, s.resetLinked(fc.cfg, fc.bot, chatID, ev, text)...)
+		unlocked = append(unlocked, st.resetAchievements(ev)...)
+	})
+	if err != nil {
+		// nothing is announced for a reset that wasn't saved
+		fc.store.Update(func(s *Storage) { s.confirmAnnouncement(announcement) })
+		return err
+	}
+	for _, ev := range events {
+		publishEvent(ev)
+	}
+	defer rp.Achieved(unlocked)
+	if autoReset {
+		log.Info("Auto-reset", "counter", name, "keyword", found)
+		go rp.Changed()
+		return fc.announce(rp, announcement, llmText(ctx, fc.cfg, fc.cfg.LLM.Reset, vars, text))
+	}
+	if !fc.prompts.Allow(chatID, clock()) {
+		log.Warn("Prompt limit reached, dropping prompt", "keyword", found)
+		return nil
+	}
+	tpl := pickTemplate(fc.cfg.Templates.Detection)
+	text = templated{Text: renderTemplate(tpl, map[string]string{"keyword": found, "topic": topic}), Template: tpl}
+	text = llmText(ctx, fc.cfg, fc.cfg.LLM.Detection, vars, text)
+	log.Info("Triggered", "counter", name, "keyword", found)
+	return fc.answer(rp, fc.cfg.Media.Detection, text, nil)
+}
+
+// Reset resets counter name on behalf of the sender, or the counter of the
+// last detection when pending is set. In strict mode it only counts the
+// sender's approval until enough admins agreed.
+func (fc *frontendCore) Reset(p ChatPlatform, name, reason string, pending bool) error {
+	rp := fc.rich(p)
+	ctx, log := rp.Context(), rp.Logger()
+	chatID, err := fc.chatID(ctx, p.Chat())
+	if err != nil {
+		return err
+	}
+	// nobody else can confirm anything in a personal counter
+	personal := rp.Personal()
+	strict := fc.cfg.StrictReset && !personal
+	if strict && !p.IsAdmin() {
+		_, err := p.Reply("В этом чате сброс подтверждают только админы.")
+		return err
+	}
+
+	u := p.Sender()
+	var topic string
+	var known, selfConfirm bool
+	var approvals int
+	var done resetDone
+	err = fc.store.UpdateContext(ctx, func(s *Storage) {
+		st := s.Chat(chatID)
+		if pending {
+			name = st.Pending
+		}
+		ctr := st.CounterByName(name)
+		if known = ctr != nil; !known {
+			return
+		}
+		if d := st.PendingDetection(name); fc.cfg.ConfirmByOther && !personal && d != nil && d.UserID == u.ID {
+			selfConfirm = true
+			return
+		}
+		topic = counterTopic(fc.cfg, ctr)
+		ev := fc.event(s, u, name)
+		if strict {
+			if approvals, reason = st.approveReset(name, u.ID, reason, ev.Time); approvals < approvalsNeeded {
+				return
+			}
+		}
+		ev.Reason = reason
+		done = fc.resetLocked(s, chatID, rp.Thread(), ev)
+	})
+	if err != nil {
+		// nothing is announced for a reset that wasn't saved
+		fc.store.Update(func(s *Storage) { s.confirmAnnouncement(done.announcement) })
+		return err
+	}
+	switch {
+	case !known:
+		_, err = p.Reply(fmt.Sprintf("Нет такого счётчика. Список: %scounters", fc.prefix))
+		return err
+	case selfConfirm:
+		log.Debug("Refusing self-confirmed reset", "counter", name)
+		_, err = p.Reply("Упоминание было ваше, так что подтвердить сброс должен кто-то другой.")
+		return err
+	case strict && approvals < approvalsNeeded:
+		log.Debug("Reset approved by admin", "counter", name, "approvals", approvals, "needed", approvalsNeeded)
+		return rp.AskApproval(fmt.Sprintf("Сброс счётчика %s одобрили админы: %d из %d. Нужно подтверждение ещё одного админа.",
+			topic, approvals, approvalsNeeded), name)
+	}
+
+	for _, ev := range done.events {
+		publishEvent(ev)
+	}
+	go rp.Changed()
+	defer rp.Achieved(done.unlocked)
+	return fc.announce(rp, done.announcement, llmText(ctx, fc.cfg, fc.cfg.LLM.Reset, done.vars, done.text))
+}
+
+// resetDone is what a reset made in the storage update leaves to announce
+// and publish after it
+type resetDone struct {
+	text         templated
+	vars         map[string]string
+	announcement int64
+	events       []counterEvent
+	unlocked     []awarded
+}
+
+// resetLocked resets counter ev.Counter of chatID with ev, to be called in
+// the storage update once the reset is allowed: it drops the strict mode
+// votes of the counter, queues the announcement for thread, repeats the
+// reset in the linked chats and unlocks achievements. The counter must
+// exist.
+func (fc *frontendCore) resetLocked(s *Storage, chatID int64, thread int, ev Event) resetDone {
+	st := s.Chat(chatID)
+	ctr := st.CounterByName(ev.Counter)
+	topic := counterTopic(fc.cfg, ctr)
+	delete(st.ResetVotes, ev.Counter)
+	var done resetDone
+	done.text = resetText(fc.cfg, topic, ev.Time, ctr.LastMention, ctr.Streak())
+	if ev.Reason != "" {
+		done.text.Text += "\nПричина: " + ev.Reason
+	}
+	done.vars = map[string]string{"topic": topic, "streak": formatStreak(ctr.Streak(), false), "offender": ev.Who(), "reason": ev.Reason}
+	if d := st.PendingDetection(ev.Counter); d != nil {
+		done.vars["offender"] = d.Who()
+	}
+	done.announcement = fc.queueAnnouncement(s, chatID, thread, done.text, ev.Time)
+	st.Reset(ev)
+	done.events = append(done.events, resetEvent(fc.bot, chatID, topic, st))
+	done.events = append(done.events, s.resetLinked(fc.cfg, fc.bot, chatID, ev, done.text)...)
+	done.unlocked = st.resetAchievements(ev)
+	return done
+}
+
+// Command runs command cmd ("days", "reset", …) with its arguments and
+// answers it
+func (fc *frontendCore) Command(p ChatPlatform, cmd, args string) error {
+	ctx := fc.rich(p).Context()
+	chatID, err := fc.chatID(ctx, p.Chat())
+	if err != nil {
+		return err
+	}
+	args = strings.TrimSpace(args)
+	if cmd == "reset" {
+		name, reason, err := parseResetArgs(ctx, fc.store, chatID, args)
+		if err != nil {
+			return err
+		}
+		return fc.Reset(p, name, reason, name == "")
+	}
+	text, err := fc.command(ctx, chatID, cmd, args)
+	if err != nil {
+		return err
+	}
+	_, err = p.Reply(text)
+	return err
+}
+
+func (fc *frontendCore) command(ctx context.Context, chatID int64, cmd, args string) (string, error) {
+	switch cmd {
+	case "days":
+		text := fmt.Sprintf("Нет такого счётчика. Список: %scounters", fc.prefix)
+		err := fc.store.ViewContext(ctx, func(s *Storage) {
+			if ctr := s.Chat(chatID).CounterByName(strings.ToLower(args)); ctr != nil {
+				text = daysText(fc.cfg, ctr).Text
+			}
+		})
+		return text, err
+	case "counters":
+		var lines []string
+		err := fc.store.ViewContext(ctx, func(s *Storage) {
+			st := s.Chat(chatID)
+			for _, name := range st.CounterNames() {
+				ctr := st.CounterByName(name)
+				days := "ещё не сбрасывался"
+				if !ctr.LastMention.IsZero() {
+					days = plural(ctr.Days(), "day")
+				}
+				lines = append(lines, fmt.Sprintf("• %s: %s", counterTopic(fc.cfg, ctr), days))
+			}
+		})
+		return "Счётчики чата:\n" + strings.Join(lines, "\n"), err
+	case "history":
+		var lines []string
+		err := fc.store.ViewContext(ctx, func(s *Storage) {
+			st := s.Chat(chatID)
+			resets := st.EventsSince(EventReset, time.Time{})
+			for i := len(resets) - 1; i >= 0 && len(lines) < historyLimit; i-- {
+				ev := resets[i]
+				topic := ev.Counter
+				if ctr := st.CounterByName(ev.Counter); ctr != nil {
+					topic = counterTopic(fc.cfg, ctr)
+				}
+				line := fmt.Sprintf("• %s — %s, %s: серия %s", ev.Time.In(chatLocation(fc.cfg, st)).Format("02.01.2006 15:04"), topic, ev.Who(), formatStreak(ev.Streak, false))
+				if ev.Reason != "" {
+					line += "\n  причина: " + ev.Reason
+				}
+				lines = append(lines, line)
+			}
+		})
+		if err != nil || len(lines) == 0 {
+			return "Сбросов ещё не было.", err
+		}
+		return "📜 Последние сбросы:\n" + strings.Join(lines, "\n"), nil
+	}
+	return fmt.Sprintf(frontendHelp, fc.prefix), nil
+}
+
+// splitCommand parses a text command like "!reset кофе" on platforms
+// without native commands
+func splitCommand(text, prefix string) (cmd, args string, ok bool) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(text), prefix)
+	if !ok {
+		return "", "", false
+	}
+	cmd, args, _ = strings.Cut(rest, " ")
+	return strings.ToLower(cmd), args, cmd != ""
+}
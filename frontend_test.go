@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakePlatform is a chat of some platform without the Telegram extras,
+// recording what the core answers
+type fakePlatform struct {
+	chat    string
+	user    chatUser
+	admin   bool
+	text    string
+	replies []string
+}
+
+func (p *fakePlatform) Chat() string     { return p.chat }
+func (p *fakePlatform) Sender() chatUser { return p.user }
+func (p *fakePlatform) IsAdmin() bool    { return p.admin }
+func (p *fakePlatform) Text() string     { return p.text }
+
+func (p *fakePlatform) Send(text string) (string, error) {
+	p.replies = append(p.replies, text)
+	return "", nil
+}
+
+func (p *fakePlatform) Reply(text string) (string, error) {
+	return p.Send(text)
+}
+
+func (p *fakePlatform) Edit(string, string) error {
+	return errEditUnsupported
+}
+
+func newTestCore(t *testing.T, cfg Config) *frontendCore {
+	t.Helper()
+	cfg.BotToken = "1:test"
+	cfg.Keywords = []string{"кофе"}
+	cfg.Templates.Detection = []Template{{Text: "Упомянули {keyword}", Weight: 1}}
+	cfg.Templates.Reset = []Template{{Text: "Сброс {topic}", Weight: 1}}
+	cfg = prepareConfig(cfg)
+	store := newStore(Storage{}, filepath.Join(t.TempDir(), "data.json"))
+	return newFrontendCore("test", "!", cfg, store)
+}
+
+func userOf(id int64) chatUser {
+	return chatUser{ID: id, Name: "user"}
+}
+
+// wasReset reports whether the default counter of the platform chat was reset
+func wasReset(fc *frontendCore, chat string) (reset bool) {
+	id, _ := fc.chatID(context.Background(), chat)
+	fc.store.View(func(s *Storage) { reset = !s.Chat(id).Counter.LastMention.IsZero() })
+	return reset
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name      string
+		autoReset bool
+		text      string
+		reply     string
+		reset     bool
+	}{
+		{"prompt", false, "пойду за кофе", "Упомянули кофе", false},
+		{"auto reset", true, "пойду за кофе", "Сброс", true},
+		{"no keyword", false, "пойду за чаем", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newTestCore(t, Config{AutoReset: tt.autoReset})
+			p := &fakePlatform{chat: "test:1", user: userOf(1), text: tt.text}
+			if err := fc.Detect(p); err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tt.reply == "" && len(p.replies) > 0:
+				t.Errorf("replies = %q, want none", p.replies)
+			case tt.reply != "" && (len(p.replies) == 0 || !strings.HasPrefix(p.replies[0], tt.reply)):
+				t.Errorf("replies = %q, want %q", p.replies, tt.reply)
+			}
+			if got := wasReset(fc, p.chat); got != tt.reset {
+				t.Errorf("reset = %v, want %v", got, tt.reset)
+			}
+		})
+	}
+}
+
+func TestDetectQueuesNoAnnouncementOutsideTelegram(t *testing.T) {
+	fc := newTestCore(t, Config{AutoReset: true})
+	if err := fc.Detect(&fakePlatform{chat: "test:1", user: userOf(1), text: "кофе"}); err != nil {
+		t.Fatal(err)
+	}
+	fc.store.View(func(s *Storage) {
+		if len(s.Announcements) > 0 {
+			t.Errorf("announcements = %v, want none", s.Announcements)
+		}
+	})
+}
+
+func TestResetPending(t *testing.T) {
+	fc := newTestCore(t, Config{ConfirmByOther: true})
+	detected := &fakePlatform{chat: "test:1", user: userOf(1), text: "кофе"}
+	if err := fc.Detect(detected); err != nil {
+		t.Fatal(err)
+	}
+
+	self := &fakePlatform{chat: "test:1", user: userOf(1)}
+	if err := fc.Command(self, "reset", ""); err != nil {
+		t.Fatal(err)
+	}
+	if wasReset(fc, "test:1") {
+		t.Fatal("the author of the mention confirmed its reset")
+	}
+
+	other := &fakePlatform{chat: "test:1", user: userOf(2)}
+	if err := fc.Command(other, "reset", "забыл"); err != nil {
+		t.Fatal(err)
+	}
+	if !wasReset(fc, "test:1") {
+		t.Fatal("counter wasn't reset")
+	}
+	if len(other.replies) == 0 || !strings.Contains(other.replies[0], "Причина: забыл") {
+		t.Errorf("replies = %q, want the announcement with the reason", other.replies)
+	}
+}
+
+func TestResetUnknownCounter(t *testing.T) {
+	fc := newTestCore(t, Config{})
+	p := &fakePlatform{chat: "test:1", user: userOf(1)}
+	if err := fc.Reset(p, "чай", "", false); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.replies) != 1 || !strings.Contains(p.replies[0], "!counters") {
+		t.Errorf("replies = %q, want the hint with the platform prefix", p.replies)
+	}
+}
+
+func TestStrictReset(t *testing.T) {
+	fc := newTestCore(t, Config{StrictReset: true})
+	steps := []struct {
+		user  int64
+		admin bool
+		reset bool
+	}{
+		{1, false, false},
+		{2, true, false},
+		// the same admin doesn't count twice
+		{2, true, false},
+		{3, true, true},
+	}
+	for i, step := range steps {
+		p := &fakePlatform{chat: "test:1", user: userOf(step.user), admin: step.admin}
+		if err := fc.Reset(p, "", "", false); err != nil {
+			t.Fatal(err)
+		}
+		if got := wasReset(fc, "test:1"); got != step.reset {
+			t.Fatalf("step %d: reset = %v, want %v (replies %q)", i, got, step.reset, p.replies)
+		}
+	}
+}
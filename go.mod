@@ -6,3 +6,8 @@ require (
 	gopkg.in/telebot.v3 v3.3.8
 	gopkg.in/yaml.v3 v3.0.1
 )
+
+require (
+	golang.org/x/image v0.24.0
+	golang.org/x/text v0.22.0 // indirect
+)
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GRPCConfig serves the control service of proto/control.proto: querying
+// counters, injecting resets and managing keywords from other tooling.
+// gRPC runs over HTTP/2, which net/http serves over TLS only, so Cert and
+// Key are required. Every call needs one of Tokens in the authorization
+// metadata as "Bearer <token>".
+type GRPCConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Listen  string   `yaml:"listen"`
+	Cert    string   `yaml:"cert"`
+	Key     string   `yaml:"key"`
+	Tokens  []string `yaml:"tokens"`
+}
+
+const (
+	grpcService    = "dayswithout.v1.Control"
+	maxGRPCMessage = 1 << 20
+)
+
+// gRPC status codes
+const (
+	grpcOK                 = 0
+	grpcInvalidArgument    = 3
+	grpcNotFound           = 5
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnauthenticated    = 16
+)
+
+// grpcError is a failed call, its message is sent as grpc-message and has
+// to be ASCII
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e *grpcError) Error() string { return e.msg }
+
+// startGRPCServer serves the control service in the background, one
+// unary method per path:
+//
+//	/dayswithout.v1.Control/ListChats     active chats of all bots
+//	/dayswithout.v1.Control/GetChat       counters with streaks and keywords
+//	/dayswithout.v1.Control/Reset         resets a counter and announces it
+//	/dayswithout.v1.Control/SetKeywords   replaces the keywords of a chat counter
+func startGRPCServer(cfg Config, bots []*botInstance) {
+	slog.Info("gRPC listening", "addr", cfg.GRPC.Listen)
+	go func() {
+		if err := http.ListenAndServeTLS(cfg.GRPC.Listen, cfg.GRPC.Cert, cfg.GRPC.Key, grpcHandler(cfg, bots)); err != nil {
+			slog.Error("gRPC server stopped", "err", err)
+		}
+	}()
+}
+
+func grpcHandler(cfg Config, bots []*botInstance) http.Handler {
+	methods := map[string]func(pbMessage) (*pbWriter, error){
+		"ListChats": func(req pbMessage) (*pbWriter, error) { return grpcListChats(bots, req) },
+		"GetChat": func(req pbMessage) (*pbWriter, error) {
+			bi, id, err := grpcChatOf(bots, req)
+			if err != nil {
+				return nil, err
+			}
+			return grpcChat(bi, id), nil
+		},
+		"Reset":       func(req pbMessage) (*pbWriter, error) { return grpcReset(bots, req) },
+		"SetKeywords": func(req pbMessage) (*pbWriter, error) { return grpcSetKeywords(bots, req) },
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /"+grpcService+"/{method}", func(w http.ResponseWriter, r *http.Request) {
+		ct := r.Header.Get("Content-Type")
+		if ct != "application/grpc" && ct != "application/grpc+proto" {
+			http.Error(w, "expected application/grpc", http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+		method := r.PathValue("method")
+		resp, err := func() (*pbWriter, error) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || !validAPIToken(cfg.GRPC.Tokens, token) {
+				slog.Warn("gRPC call without a valid token", "remote", r.RemoteAddr, "method", method)
+				return nil, &grpcError{grpcUnauthenticated, "invalid token"}
+			}
+			call := methods[method]
+			if call == nil {
+				return nil, &grpcError{grpcUnimplemented, "unknown method " + method}
+			}
+			req, err := readGRPCMessage(r.Body)
+			if err != nil {
+				return nil, err
+			}
+			return call(req)
+		}()
+
+		w.WriteHeader(http.StatusOK)
+		code, msg := grpcOK, ""
+		var gerr *grpcError
+		switch {
+		case err == nil:
+			frame := make([]byte, 5, 5+len(resp.buf))
+			binary.BigEndian.PutUint32(frame[1:], uint32(len(resp.buf)))
+			w.Write(append(frame, resp.buf...))
+		case errors.As(err, &gerr):
+			code, msg = gerr.code, gerr.msg
+		default:
+			slog.Error("gRPC call failed", "method", method, "err", err)
+			code, msg = grpcInternal, "internal error"
+		}
+		w.Header().Set("Grpc-Status", strconv.Itoa(code))
+		w.Header().Set("Grpc-Message", msg)
+	})
+	return mux
+}
+
+// readGRPCMessage reads the single length-prefixed message of a unary call
+func readGRPCMessage(body io.Reader) (pbMessage, error) {
+	data, err := io.ReadAll(io.LimitReader(body, maxGRPCMessage+5+1))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(data) < 5:
+		return nil, &grpcError{grpcInvalidArgument, "missing message"}
+	case data[0] != 0:
+		return nil, &grpcError{grpcUnimplemented, "compressed messages are not supported"}
+	case int(binary.BigEndian.Uint32(data[1:5])) != len(data)-5 || len(data)-5 > maxGRPCMessage:
+		return nil, &grpcError{grpcInvalidArgument, "invalid message length"}
+	}
+	msg, err := pbDecode(data[5:])
+	if err != nil {
+		return nil, &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	return msg, nil
+}
+
+// grpcChatOf finds the chat of a request by bot = 1 and chat_id = 2; without
+// a bot the first one knowing the chat is taken
+func grpcChatOf(bots []*botInstance, req pbMessage) (*botInstance, int64, error) {
+	bot, id := strings.TrimPrefix(req.String(1), "@"), req.Int(2)
+	for _, bi := range bots {
+		if bot != "" && bi.bot().Me.Username != bot {
+			continue
+		}
+		var found bool
+		bi.store.View(func(s *Storage) {
+			st := s.Chats[id]
+			found = st != nil && st.Left.IsZero()
+		})
+		if found {
+			return bi, id, nil
+		}
+	}
+	return nil, 0, &grpcError{grpcNotFound, "unknown chat"}
+}
+
+// grpcListChats answers ListChatsResponse: ChatRef chats = 1, each with
+// bot = 1 and chat_id = 2, of the bot of the request or all of them
+func grpcListChats(bots []*botInstance, req pbMessage) (*pbWriter, error) {
+	bot := strings.TrimPrefix(req.String(1), "@")
+	resp := &pbWriter{}
+	for _, bi := range bots {
+		username := bi.bot().Me.Username
+		if bot != "" && username != bot {
+			continue
+		}
+		var ids []int64
+		bi.store.View(func(s *Storage) {
+			for id := range s.ActiveChats() {
+				ids = append(ids, id)
+			}
+		})
+		slices.Sort(ids)
+		for _, id := range ids {
+			ref := &pbWriter{}
+			ref.String(1, username)
+			ref.Int(2, id)
+			resp.Message(1, ref)
+		}
+	}
+	return resp, nil
+}
+
+// grpcChat encodes the Chat message with the counters of the chat
+func grpcChat(bi *botInstance, id int64) *pbWriter {
+	chat := &pbWriter{}
+	chat.String(1, bi.bot().Me.Username)
+	chat.Int(2, id)
+	bi.store.View(func(s *Storage) {
+		st := s.Chat(id)
+		chat.String(3, chatLocation(bi.cfg, st).String())
+		for _, name := range st.CounterNames() {
+			ctr := st.CounterByName(name)
+			keywords := ctr.Keywords
+			if name == "" {
+				keywords = bi.cfg.Keywords
+			}
+			c := &pbWriter{}
+			c.String(1, name)
+			c.String(2, counterTopic(bi.cfg, ctr))
+			c.Int(3, int64(ctr.Days()))
+			c.Int(4, int64(ctr.Streak().Seconds()))
+			c.Int(5, int64(max(ctr.Record, ctr.Streak()).Seconds()))
+			if !ctr.LastMention.IsZero() {
+				c.Message(6, pbTimestamp(ctr.LastMention))
+			}
+			c.Bool(7, ctr.Paused())
+			c.Strings(8, keywords)
+			chat.Message(4, c)
+		}
+	})
+	return chat
+}
+
+// grpcReset handles ResetRequest: counter = 3, reason = 4 and who = 5, the
+// name the reset is attributed to ("API" by default). The reset is
+// announced in the chat like one made with /reset.
+func grpcReset(bots []*botInstance, req pbMessage) (*pbWriter, error) {
+	bi, id, err := grpcChatOf(bots, req)
+	if err != nil {
+		return nil, err
+	}
+	name, reason, who := strings.ToLower(req.String(3)), req.String(4), req.String(5)
+	if who == "" {
+		who = "API"
+	}
+	b, fc := bi.bot(), bi.frontend()
+	// the API caller counts as a member of its own, like those of the
+	// other platforms
+	u := chatUser{ID: externalUserID("grpc", who), Name: who}
+	var known bool
+	var done resetDone
+	err = bi.store.Update(func(s *Storage) {
+		st := s.Chat(id)
+		if known = st.CounterByName(name) != nil; !known {
+			return
+		}
+		ev := fc.event(s, u, name)
+		ev.Reason = reason
+		done = fc.resetLocked(s, id, st.homeThread(), ev)
+	})
+	if err != nil {
+		bi.store.Update(func(s *Storage) { s.confirmAnnouncement(done.announcement) })
+		return nil, err
+	}
+	if !known {
+		return nil, &grpcError{grpcNotFound, "unknown counter"}
+	}
+	for _, ev := range done.events {
+		publishEvent(ev)
+	}
+	slog.Info("gRPC reset counter", "chat_id", id, "counter", name, "who", who)
+	go refreshPinnedChat(b, bi.cfg, bi.store, id)
+	// left to the "announcements" job when it fails
+	if _, err := postToChat(b, bi.store, id, done.text); err != nil {
+		slog.Warn("Failed to announce gRPC reset", "chat_id", id, "err", err)
+		bi.store.Update(func(s *Storage) { s.releaseAnnouncement(done.announcement) })
+	} else {
+		bi.store.Update(func(s *Storage) { s.confirmAnnouncement(done.announcement) })
+	}
+	announceAchievements(b, bi.store, id, done.unlocked)
+	return grpcChat(bi, id), nil
+}
+
+// grpcSetKeywords handles SetKeywordsRequest: counter = 3 and keywords = 4.
+// The keywords of the default counter come from the config.
+func grpcSetKeywords(bots []*botInstance, req pbMessage) (*pbWriter, error) {
+	bi, id, err := grpcChatOf(bots, req)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.ToLower(req.String(3))
+	if name == "" {
+		return nil, &grpcError{grpcFailedPrecondition, "keywords of the default counter are set in the config"}
+	}
+	var keywords []string
+	for _, k := range req.Strings(4) {
+		if k = strings.TrimSpace(k); k != "" {
+			keywords = append(keywords, k)
+		}
+	}
+	if len(keywords) == 0 {
+		return nil, &grpcError{grpcInvalidArgument, "at least one keyword is required"}
+	}
+	var known bool
+	bi.store.Update(func(s *Storage) {
+		ctr := s.Chat(id).Counters[name]
+		if known = ctr != nil; known {
+			ctr.Keywords = keywords
+		}
+	})
+	if !known {
+		return nil, &grpcError{grpcNotFound, "unknown counter"}
+	}
+	slog.Info("gRPC changed keywords", "chat_id", id, "counter", name, "keywords", keywords)
+	return grpcChat(bi, id), nil
+}
+
+// pbWriter encodes a protobuf message field by field. Zero scalars are
+// left out as proto3 does.
+type pbWriter struct {
+	buf []byte
+}
+
+func (w *pbWriter) tag(field, wire int) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(field<<3|wire))
+}
+
+func (w *pbWriter) bytes(field int, v []byte) {
+	w.tag(field, 2)
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+// Int writes int32 and int64 fields
+func (w *pbWriter) Int(field int, v int64) {
+	if v != 0 {
+		w.tag(field, 0)
+		w.buf = binary.AppendUvarint(w.buf, uint64(v))
+	}
+}
+
+func (w *pbWriter) Bool(field int, v bool) {
+	if v {
+		w.Int(field, 1)
+	}
+}
+
+func (w *pbWriter) String(field int, v string) {
+	if v != "" {
+		w.bytes(field, []byte(v))
+	}
+}
+
+// Strings writes a repeated string field
+func (w *pbWriter) Strings(field int, v []string) {
+	for _, s := range v {
+		w.bytes(field, []byte(s))
+	}
+}
+
+// Message writes an embedded message, also one of a repeated field
+func (w *pbWriter) Message(field int, m *pbWriter) {
+	w.bytes(field, m.buf)
+}
+
+// pbTimestamp is a google.protobuf.Timestamp
+func pbTimestamp(t time.Time) *pbWriter {
+	ts := &pbWriter{}
+	ts.Int(1, t.Unix())
+	ts.Int(2, int64(t.Nanosecond()))
+	return ts
+}
+
+// pbField is a decoded field: varint and fixed-size values in num,
+// length-delimited ones in data
+type pbField struct {
+	field int
+	num   uint64
+	data  []byte
+}
+
+// pbMessage is a decoded message, its fields in wire order
+type pbMessage []pbField
+
+// pbDecode splits a protobuf message into its fields without knowing the
+// schema; groups are not supported
+func pbDecode(data []byte) (pbMessage, error) {
+	var msg pbMessage
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid field key")
+		}
+		data = data[n:]
+		f := pbField{field: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			if f.num, n = binary.Uvarint(data); n <= 0 {
+				return nil, fmt.Errorf("invalid varint in field %d", f.field)
+			}
+			data = data[n:]
+		case 1, 5:
+			size := 8
+			if key&7 == 5 {
+				size = 4
+			}
+			if len(data) < size {
+				return nil, fmt.Errorf("truncated field %d", f.field)
+			}
+			var buf [8]byte
+			copy(buf[:], data[:size])
+			f.num, data = binary.LittleEndian.Uint64(buf[:]), data[size:]
+		case 2:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || size > uint64(len(data)-n) {
+				return nil, fmt.Errorf("truncated field %d", f.field)
+			}
+			f.data, data = data[n:n+int(size)], data[n+int(size):]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d in field %d", key&7, f.field)
+		}
+		msg = append(msg, f)
+	}
+	return msg, nil
+}
+
+// Int returns an int32 or int64 field, the last one if repeated
+func (m pbMessage) Int(field int) int64 {
+	var v int64
+	for _, f := range m {
+		if f.field == field {
+			v = int64(f.num)
+		}
+	}
+	return v
+}
+
+// String returns a string field, the last one if repeated
+func (m pbMessage) String(field int) string {
+	var v string
+	for _, f := range m {
+		if f.field == field {
+			v = string(f.data)
+		}
+	}
+	return v
+}
+
+// Strings returns a repeated string field
+func (m pbMessage) Strings(field int) []string {
+	var v []string
+	for _, f := range m {
+		if f.field == field {
+			v = append(v, string(f.data))
+		}
+	}
+	return v
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPbWriterEncoding(t *testing.T) {
+	// expectations from the protobuf encoding guide and protoc
+	tests := []struct {
+		name  string
+		write func(w *pbWriter)
+		want  string
+	}{
+		{"varint", func(w *pbWriter) { w.Int(1, 150) }, "089601"},
+		{"negative int64", func(w *pbWriter) { w.Int(2, -1) }, "10ffffffffffffffffff01"},
+		{"zero left out", func(w *pbWriter) { w.Int(1, 0); w.Bool(2, false); w.String(3, "") }, ""},
+		{"bool", func(w *pbWriter) { w.Bool(3, true) }, "1801"},
+		{"string", func(w *pbWriter) { w.String(2, "testing") }, "120774657374696e67"},
+		{"repeated string", func(w *pbWriter) { w.Strings(4, []string{"a", ""}) }, "220161" + "2200"},
+		{"message", func(w *pbWriter) { w.Message(3, &pbWriter{buf: []byte{0x08, 0x96, 0x01}}) }, "1a03089601"},
+		{"large field number", func(w *pbWriter) { w.Int(16, 1) }, "800101"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &pbWriter{}
+			tt.write(w)
+			if got := hex.EncodeToString(w.buf); got != tt.want {
+				t.Errorf("encoded %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPbRoundTrip(t *testing.T) {
+	ints := []int64{1, 127, 128, 300, -1, -2, math.MaxInt64, math.MinInt64, math.MaxInt32, math.MinInt32}
+	for _, v := range ints {
+		w := &pbWriter{}
+		w.Int(5, v)
+		msg, err := pbDecode(w.buf)
+		if err != nil {
+			t.Fatalf("%d: %v", v, err)
+		}
+		if got := msg.Int(5); got != v {
+			t.Errorf("Int round trip of %d = %d", v, got)
+		}
+	}
+
+	long := string(bytes.Repeat([]byte("ы"), 200))
+	w := &pbWriter{}
+	w.String(1, "счётчик")
+	w.Strings(2, []string{"кофе", "", long})
+	w.Int(3, -42)
+	w.Bool(4, true)
+	inner := &pbWriter{}
+	inner.String(1, "вложенное")
+	w.Message(5, inner)
+
+	msg, err := pbDecode(w.buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.String(1); got != "счётчик" {
+		t.Errorf("String(1) = %q", got)
+	}
+	if got := msg.Strings(2); !slices.Equal(got, []string{"кофе", "", long}) {
+		t.Errorf("Strings(2) = %q", got)
+	}
+	if got := msg.Int(3); got != -42 {
+		t.Errorf("Int(3) = %d", got)
+	}
+	if got := msg.Int(4); got != 1 {
+		t.Errorf("Int(4) = %d", got)
+	}
+	nested, err := pbDecode([]byte(msg.String(5)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nested.String(1); got != "вложенное" {
+		t.Errorf("nested String(1) = %q", got)
+	}
+	if got := msg.String(9); got != "" {
+		t.Errorf("missing String(9) = %q", got)
+	}
+}
+
+func TestPbTimestamp(t *testing.T) {
+	at := time.Date(2024, 6, 1, 12, 0, 0, 500, time.UTC)
+	msg, err := pbDecode(pbTimestamp(at).buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := time.Unix(msg.Int(1), msg.Int(2)).UTC(); !got.Equal(at) {
+		t.Errorf("timestamp = %s, want %s", got, at)
+	}
+}
+
+func TestPbDecodeFixed(t *testing.T) {
+	// field 1 fixed64 1, field 2 fixed32 2
+	msg, err := pbDecode([]byte{0x09, 1, 0, 0, 0, 0, 0, 0, 0, 0x15, 2, 0, 0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Int(1) != 1 || msg.Int(2) != 2 {
+		t.Errorf("fixed fields = %d, %d, want 1, 2", msg.Int(1), msg.Int(2))
+	}
+}
+
+func TestPbDecodeLastWins(t *testing.T) {
+	w := &pbWriter{}
+	w.Int(1, 1)
+	w.Int(1, 2)
+	msg, err := pbDecode(w.buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.Int(1); got != 2 {
+		t.Errorf("repeated scalar = %d, want the last one", got)
+	}
+}
+
+func TestPbDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"truncated key", "80"},
+		{"truncated varint", "0896"},
+		{"truncated fixed64", "0901020304"},
+		{"truncated fixed32", "150102"},
+		{"length beyond data", "120574657374"},
+		{"truncated length", "1280"},
+		{"group", "0b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := hex.DecodeString(tt.data)
+			if _, err := pbDecode(data); err == nil {
+				t.Errorf("pbDecode(%s) succeeded, want an error", tt.data)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits that keep long-running instances from slowly growing
+
+const (
+	// pendingCommentTTL is how long a reset announcement waits for its
+	// channel post to show up in the discussion group
+	pendingCommentTTL = 10 * time.Minute
+	// maxPendingComments bounds the announcements waiting at once
+	maxPendingComments = 1000
+	// limiterSweepSize is the number of keys above which a limiter drops
+	// keys without recent events
+	limiterSweepSize = 1000
+	// maxCounterRegexes bounds the cached matchers of runtime counters
+	maxCounterRegexes = 1000
+	// defaultMaxHistory is the history kept per chat when max_history is
+	// not set
+	defaultMaxHistory = 10000
+)
+
+// trim drops the oldest history events beyond max per chat (negative keeps
+// all), folding them into the trimmed totals first, and expired strict mode
+// votes
+func (s *Storage) trim(max int, now time.Time) {
+	for _, st := range s.Chats {
+		if max > 0 && len(st.History) > max {
+			st.fold(len(st.History) - max)
+			st.History = append([]Event(nil), st.History[len(st.History)-max:]...)
+		}
+		for name, vote := range st.ResetVotes {
+			if now.Sub(vote.Started) > resetVoteTTL {
+				delete(st.ResetVotes, name)
+			}
+		}
+	}
+}
+
+// HistoryTotals is what the history events dropped by trim counted
+// towards, so that user stats, the hall of shame and achievements still
+// cover the whole life of the chat
+type HistoryTotals struct {
+	// Users are the detections per rankKey of their user; anonymous ones
+	// are left out, as in user stats
+	Users map[string]*UserTotals `json:"users,omitempty"`
+	// Culprits are the resets blamed per rankKey, see eachCulprit. A count
+	// may be negative for a member who ran /reset on a trimmed detection.
+	Culprits map[string]*CulpritTotals `json:"culprits,omitempty"`
+}
+
+// UserTotals are the dropped detections of one user
+type UserTotals struct {
+	// Last is the latest dropped detection, for the names and the matchers
+	// of user stats
+	Last      Event          `json:"last"`
+	Hits      int            `json:"hits"`
+	Confirmed int            `json:"confirmed,omitempty"`
+	Keywords  map[string]int `json:"keywords,omitempty"`
+}
+
+// CulpritTotals are the dropped resets blamed on one user
+type CulpritTotals struct {
+	Who    string `json:"who"`
+	Resets int    `json:"resets"`
+}
+
+// fold adds the first n history events to the trimmed totals
+func (st *ChatState) fold(n int) {
+	if st.Trimmed == nil {
+		st.Trimmed = &HistoryTotals{}
+	}
+	t := st.Trimmed
+	if t.Users == nil {
+		t.Users = make(map[string]*UserTotals)
+	}
+	if t.Culprits == nil {
+		t.Culprits = make(map[string]*CulpritTotals)
+	}
+	for _, ev := range st.History[:n] {
+		if ev.Type == EventDetection && !ev.Anonymous {
+			key := rankKey(ev)
+			u := t.Users[key]
+			if u == nil {
+				u = &UserTotals{Keywords: make(map[string]int)}
+				t.Users[key] = u
+			}
+			u.Last = ev
+			u.Hits++
+			if ev.Confirmed {
+				u.Confirmed++
+			}
+			u.Keywords[strings.ToLower(ev.Keyword)]++
+		}
+	}
+	// the difference of the blame before and after the cut is kept: a reset
+	// that stays while its detection goes would otherwise move to whoever
+	// ran /reset
+	t.blame(st, 1)
+	t.blame(&ChatState{History: st.History[n:]}, -1)
+}
+
+// blame adds delta for every culprit of the history of st
+func (t *HistoryTotals) blame(st *ChatState, delta int) {
+	st.eachCulprit(func(_, culprit Event) {
+		key := rankKey(culprit)
+		c := t.Culprits[key]
+		if c == nil {
+			c = &CulpritTotals{Who: culprit.Who()}
+			t.Culprits[key] = c
+		}
+		if delta > 0 {
+			c.Who = culprit.Who()
+		}
+		if c.Resets += delta; c.Resets == 0 {
+			delete(t.Culprits, key)
+		}
+	})
+}
+
+// hits is the number of dropped detections of the user with rankKey key
+func (t *HistoryTotals) hits(key string) int {
+	if t == nil || t.Users[key] == nil {
+		return 0
+	}
+	return t.Users[key].Hits
+}
+
+// optOut drops the names of userID from the totals: their detections are
+// no longer counted as theirs and their resets go to the anonymous entry
+func (t *HistoryTotals) optOut(userID int64) {
+	if t == nil {
+		return
+	}
+	key := rankKey(Event{UserID: userID})
+	delete(t.Users, key)
+	if c := t.Culprits[key]; c != nil {
+		delete(t.Culprits, key)
+		anon := t.Culprits[anonymousName]
+		if anon == nil {
+			anon = &CulpritTotals{Who: anonymousName}
+			t.Culprits[anonymousName] = anon
+		}
+		anon.Resets += c.Resets
+	}
+}
+
+// pendingTexts is a bounded map of texts waiting for a channel post
+type pendingTexts struct {
+	mu    sync.Mutex
+	items map[channelPost]pendingText
+}
+
+type pendingText struct {
+	text  templated
+	added time.Time
+}
+
+// Store adds a text, dropping expired ones and the oldest when full
+func (p *pendingTexts) Store(key channelPost, text templated) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.items == nil {
+		p.items = make(map[channelPost]pendingText)
+	}
+	now := time.Now()
+	var oldest channelPost
+	var oldestAt time.Time
+	for k, v := range p.items {
+		if now.Sub(v.added) > pendingCommentTTL {
+			delete(p.items, k)
+			continue
+		}
+		if oldestAt.IsZero() || v.added.Before(oldestAt) {
+			oldest, oldestAt = k, v.added
+		}
+	}
+	if len(p.items) >= maxPendingComments {
+		delete(p.items, oldest)
+	}
+	p.items[key] = pendingText{text: text, added: now}
+}
+
+// LoadAndDelete takes the text for key out if it hasn't expired
+func (p *pendingTexts) LoadAndDelete(key channelPost) (templated, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v, ok := p.items[key]
+	if !ok {
+		return templated{}, false
+	}
+	delete(p.items, key)
+	return v.text, time.Since(v.added) <= pendingCommentTTL
+}
+
+// Len is the number of waiting texts
+func (p *pendingTexts) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.items)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const ctxKey = "ctx"
+
+// withContext gives every handler a context that ends after timeout, see
+// ctxOf. Storage calls made with it give up instead of queueing behind a
+// stuck handler forever, and so do the Bot API calls for the chat of the
+// update, see deadlineTransport.
+func withContext(timeout time.Duration, chats *chatDeadlines) tb.MiddlewareFunc {
+	return func(next tb.HandlerFunc) tb.HandlerFunc {
+		return func(c tb.Context) error {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			c.Set(ctxKey, ctx)
+			if chat := c.Chat(); chat != nil {
+				deadline, _ := ctx.Deadline()
+				chats.Store(chat.ID, deadline)
+				defer chats.CompareAndDelete(chat.ID, deadline)
+			}
+			err := next(c)
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				ctxLogger(c).Warn("Handler exceeded its timeout", "timeout", timeout)
+			}
+			return err
+		}
+	}
+}
+
+// ctxOf returns the context of the update, or a background one
+func ctxOf(c tb.Context) context.Context {
+	if ctx, ok := c.Get(ctxKey).(context.Context); ok {
+		return ctx
+	}
+	return context.Background()
+}
+
+// chatDeadlines holds the deadline of the update being handled per chat.
+// The updates of a chat are handled one at a time (see chatQueuePoller),
+// so the Bot API calls for the chat made meanwhile are the handler's, or
+// were started by it, save for a job posting there at the same moment,
+// which then shares the deadline.
+type chatDeadlines struct {
+	sync.Map // int64 → time.Time
+}
+
+// deadlineTransport gives Bot API calls for a chat the deadline of the
+// update handled in it, so that waiting in the chat queue and retries give
+// up with the handler. Only the deadline is taken, calls the handler left
+// running in the background aren't cancelled when it returns.
+type deadlineTransport struct {
+	next  http.RoundTripper
+	chats *chatDeadlines
+}
+
+func (t deadlineTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	deadline, ok := t.deadlineOf(r)
+	if !ok {
+		return t.next.RoundTrip(r)
+	}
+	ctx, cancel := context.WithDeadline(r.Context(), deadline)
+	resp, err := t.next.RoundTrip(r.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// deadlineOf returns the deadline of the chat the request is for
+func (t deadlineTransport) deadlineOf(r *http.Request) (time.Time, bool) {
+	if r.GetBody == nil {
+		return time.Time{}, false
+	}
+	body, err := r.GetBody()
+	if err != nil {
+		return time.Time{}, false
+	}
+	var params struct {
+		ChatID json.RawMessage `json:"chat_id"`
+	}
+	json.NewDecoder(body).Decode(&params)
+	var chatID int64
+	if _, err := fmt.Sscan(string(bytes.Trim(params.ChatID, `"`)), &chatID); err != nil {
+		return time.Time{}, false
+	}
+	deadline, ok := t.chats.Load(chatID)
+	if !ok {
+		return time.Time{}, false
+	}
+	return deadline.(time.Time), true
+}
+
+// cancelOnClose ends the context of a request once its response is read
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
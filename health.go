@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// HealthConfig enables the HTTP probe endpoints /healthz and /readyz.
+// The poller counts as dead when no getUpdates call succeeded for MaxPollAge.
+type HealthConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Listen     string        `yaml:"listen"`
+	MaxPollAge time.Duration `yaml:"max_poll_age"`
+}
+
+// lastPoll is the unix nano time of the last successful getUpdates
+var lastPoll atomic.Int64
+
+// pollTracker notes successful getUpdates calls going through the client
+// in last
+type pollTracker struct {
+	next http.RoundTripper
+	last *atomic.Int64
+}
+
+func (t pollTracker) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(r)
+	if err == nil && resp.StatusCode == http.StatusOK && strings.HasSuffix(r.URL.Path, "/getUpdates") {
+		t.last.Store(time.Now().UnixNano())
+	}
+	return resp, err
+}
+
+// storageWritable checks that the storage file can be written next to its
+// current place
+func storageWritable(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".healthz-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+type healthStatus struct {
+	Status   string `json:"status"`
+	Poller   string `json:"poller"`
+	LastPoll string `json:"last_poll,omitempty"`
+	Storage  string `json:"storage,omitempty"`
+}
+
+// pollerStatus reports the poller state. Webhooks have nothing to poll and
+// are alive as long as the process serves requests.
+func pollerStatus(cfg Config, started time.Time) (healthStatus, error) {
+	if cfg.Webhook.Enabled {
+		return healthStatus{Poller: "webhook"}, nil
+	}
+	last := started
+	hs := healthStatus{Poller: "long_poll"}
+	if t := lastPoll.Load(); t != 0 {
+		last = time.Unix(0, t)
+		hs.LastPoll = last.Format(time.RFC3339)
+	}
+	if age := time.Since(last); age > cfg.Health.MaxPollAge {
+		return hs, errors.New("no successful getUpdates for " + age.Truncate(time.Second).String())
+	}
+	return hs, nil
+}
+
+func writeHealth(w http.ResponseWriter, hs healthStatus, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	hs.Status = "ok"
+	if err != nil {
+		hs.Status = err.Error()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(hs)
+}
+
+// startHealthServer serves the probes in the background
+func startHealthServer(cfg Config, bots []*botInstance) {
+	started := time.Now()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		hs, err := pollerStatus(cfg, started)
+		writeHealth(w, hs, err)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		hs, err := pollerStatus(cfg, started)
+		hs.Storage = "ok"
+		for _, bi := range bots {
+			if serr := storageWritable(bi.store.file); serr != nil {
+				hs.Storage = serr.Error()
+				if err == nil {
+					err = errors.New("storage is not writable")
+				}
+			}
+		}
+		writeHealth(w, hs, err)
+	})
+
+	mux.HandleFunc("/metrics", serveMetrics)
+
+	slog.Info("Health endpoints listening", "addr", cfg.Health.Listen)
+	go func() {
+		if err := http.ListenAndServe(cfg.Health.Listen, mux); err != nil {
+			slog.Error("Health server stopped", "err", err)
+		}
+	}()
+}
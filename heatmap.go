@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const (
+	heatCell   = 30
+	heatLeft   = 60
+	heatTop    = 90
+	heatWidth  = heatLeft + 24*heatCell + 30
+	heatHeight = heatTop + 7*heatCell + 60
+)
+
+var weekdayShort = [7]string{"Пн", "Вт", "Ср", "Чт", "Пт", "Сб", "Вс"}
+
+// heatmapData counts detections per weekday (Monday first) and hour
+func heatmapData(st *ChatState) (cells [7][24]int, total int) {
+	for _, ev := range st.History {
+		if ev.Type != EventDetection {
+			continue
+		}
+		day := (int(ev.Time.Weekday()) + 6) % 7
+		cells[day][ev.Time.Hour()]++
+		total++
+	}
+	return cells, total
+}
+
+// blend mixes a into b by k in [0, 1]
+func blend(a, b color.RGBA, k float64) color.RGBA {
+	mix := func(x, y uint8) uint8 { return uint8(float64(x) + (float64(y)-float64(x))*k) }
+	return color.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), 0xff}
+}
+
+func renderHeatmap(topic string, cells [7][24]int, total int) ([]byte, error) {
+	loadFonts()
+	img := newCanvas(heatWidth, heatHeight)
+	label := fontFace(fontRegular, 14)
+
+	drawText(img, fontFace(fontBold, 24), heatLeft, 40, colorForeground,
+		fmt.Sprintf("Когда упоминают %s", topic))
+	drawText(img, label, heatLeft, 65, colorMuted, fmt.Sprintf("срабатываний всего: %d", total))
+
+	max := 0
+	for _, row := range cells {
+		for _, n := range row {
+			if n > max {
+				max = n
+			}
+		}
+	}
+
+	for d, row := range cells {
+		y := heatTop + d*heatCell
+		drawText(img, label, 25, y+heatCell/2+5, colorMuted, weekdayShort[d])
+		for h, n := range row {
+			x := heatLeft + h*heatCell
+			c := colorGrid
+			if n > 0 {
+				c = blend(colorGrid, colorAccent, 0.2+0.8*float64(n)/float64(max))
+			}
+			fillRect(img, image.Rect(x+1, y+1, x+heatCell-1, y+heatCell-1), c)
+		}
+	}
+	for h := 0; h < 24; h += 3 {
+		drawTextCentered(img, label, heatLeft+h*heatCell+heatCell/2, heatTop+7*heatCell+22, colorMuted, strconv.Itoa(h))
+	}
+	return encodePNG(img)
+}
+
+func handleHeatmap(cfg Config, store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		var cells [7][24]int
+		var total int
+		var topic string
+		if err := store.ViewContext(ctxOf(c), func(s *Storage) {
+			st := s.Chat(c.Chat().ID)
+			cells, total = heatmapData(st)
+			topic = counterTopic(cfg, &st.Counter)
+		}); err != nil {
+			return err
+		}
+		if total == 0 {
+			return c.Send("Пока нечего показывать: срабатываний ещё не было.")
+		}
+		png, err := renderHeatmap(topic, cells, total)
+		if err != nil {
+			return err
+		}
+		return c.Send(photoFromPNG(png, ""))
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const historyLimit = 10
+
+func handleHistory(cfg Config, store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		var resets []Event
+		var topics map[string]string
+		var loc *time.Location
+		if err := store.ViewContext(ctxOf(c), func(s *Storage) {
+			st := s.Chat(c.Chat().ID)
+			resets = st.EventsSince(EventReset, time.Time{})
+			topics = make(map[string]string)
+			for _, name := range st.CounterNames() {
+				topics[name] = counterTopic(cfg, st.CounterByName(name))
+			}
+			loc = chatLocation(cfg, st)
+		}); err != nil {
+			return err
+		}
+		if len(resets) == 0 {
+			return c.Send("Сбросов ещё не было.")
+		}
+		if len(resets) > historyLimit {
+			resets = resets[len(resets)-historyLimit:]
+		}
+
+		lines := []string{"📜 Последние сбросы:"}
+		for i := len(resets) - 1; i >= 0; i-- {
+			ev := resets[i]
+			topic, ok := topics[ev.Counter]
+			if !ok {
+				topic = ev.Counter
+			}
+			line := fmt.Sprintf("• %s — %s, %s: серия %s", ev.Time.In(loc).Format("02.01.2006 15:04"), topic, ev.Who(), formatStreak(ev.Streak, false))
+			if ev.Reason != "" {
+				line += "\n  причина: " + ev.Reason
+			}
+			lines = append(lines, line)
+		}
+		return c.Send(strings.Join(lines, "\n"))
+	}
+}
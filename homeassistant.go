@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// haObjectRe matches what Home Assistant accepts in discovery topic IDs
+var haObjectRe = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// haAttributes are the extra state attributes of a counter sensor
+type haAttributes struct {
+	Topic        string     `json:"topic"`
+	Streak       string     `json:"streak"`
+	RecordDays   int        `json:"record_days"`
+	LastReset    *time.Time `json:"last_reset,omitempty"`
+	LastOffender string     `json:"last_offender,omitempty"`
+	Paused       bool       `json:"paused"`
+}
+
+// haObjectID is a stable ID of counter name of chatID usable in discovery
+// topics; runtime counters are named after their topic, often in Cyrillic
+func haObjectID(bot string, chatID int64, name string) string {
+	id := "dayswithout_" + haObjectRe.ReplaceAllString(bot, "_") + "_" + strconv.FormatInt(chatID, 10)
+	if name != "" {
+		h := fnv.New32a()
+		h.Write([]byte(name))
+		id += "_" + strconv.FormatUint(uint64(h.Sum32()), 16)
+	}
+	return id
+}
+
+// lastOffender is who made the last confirmed detection of counter name
+func lastOffender(st *ChatState, name string) string {
+	for i := len(st.History) - 1; i >= 0; i-- {
+		if ev := st.History[i]; ev.Type == EventDetection && ev.Confirmed && ev.Counter == name {
+			return ev.Who()
+		}
+	}
+	return ""
+}
+
+// sensor returns the retained payloads describing counter name of chatID
+// as a Home Assistant sensor: the discovery config and the attributes
+func (p *mqttPublisher) sensor(bot string, cfg Config, chatID int64, st *ChatState, name string) map[string]string {
+	ctr := st.CounterByName(name)
+	topic := counterTopic(cfg, ctr)
+	attrTopic := p.topic(p.cfg.AttributesTopic, bot, chatID, name)
+	objectID := haObjectID(bot, chatID, name)
+
+	attrs := haAttributes{
+		Topic:        topic,
+		Streak:       formatStreak(ctr.Streak(), true),
+		RecordDays:   durationDays(max(ctr.Record, ctr.Streak())),
+		LastReset:    &ctr.LastMention,
+		LastOffender: lastOffender(st, name),
+		Paused:       ctr.Paused(),
+	}
+	config := map[string]any{
+		"name":                  "Дни без " + topic,
+		"unique_id":             objectID,
+		"object_id":             objectID,
+		"state_topic":           p.topic(p.cfg.DaysTopic, bot, chatID, name),
+		"json_attributes_topic": attrTopic,
+		"unit_of_measurement":   "d",
+		"state_class":           "measurement",
+		"icon":                  "mdi:calendar-check",
+		"device": map[string]any{
+			"identifiers":  []string{"dayswithout_" + bot},
+			"name":         "dayswithout @" + bot,
+			"manufacturer": "dayswithout",
+		},
+	}
+	out := make(map[string]string, 2)
+	for topic, v := range map[string]any{
+		p.cfg.DiscoveryPrefix + "/sensor/" + objectID + "/config": config,
+		attrTopic: attrs,
+	} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			slog.Error("Failed to encode Home Assistant sensor", "topic", topic, "err", err)
+			continue
+		}
+		out[topic] = string(data)
+	}
+	return out
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	hookQueueSize = 256
+	hookAttempts  = 3
+)
+
+// HookConfig is an outbound webhook: every counter event of the listed
+// Events (all when empty) is POSTed to URL as JSON. With Secret the
+// timestamp and the body are signed with HMAC-SHA256 in the
+// X-Dayswithout-Signature header, see signHook.
+type HookConfig struct {
+	URL    string   `yaml:"url"`
+	Secret string   `yaml:"secret"`
+	Events []string `yaml:"events"`
+	// Format "simple" posts the flat simpleEvent instead, for Zapier, IFTTT
+	// and similar services
+	Format string `yaml:"format"`
+}
+
+const hookFormatSimple = "simple"
+
+// simpleEvent is a flat event with a ready-made text and IFTTT's value1-3,
+// for no-code automations. Every field is always present so the services
+// can offer them for mapping.
+type simpleEvent struct {
+	// ID is unique per event, polling triggers deduplicate by it
+	ID      string `json:"id"`
+	Event   string `json:"event"`
+	Time    string `json:"time"`
+	ChatID  int64  `json:"chat_id"`
+	Counter string `json:"counter"`
+	Topic   string `json:"topic"`
+	Days    int    `json:"days"`
+	Who     string `json:"who"`
+	Keyword string `json:"keyword"`
+	Reason  string `json:"reason"`
+	Text    string `json:"text"`
+	Value1  string `json:"value1"`
+	Value2  string `json:"value2"`
+	Value3  string `json:"value3"`
+}
+
+func simpleEventOf(ev counterEvent) simpleEvent {
+	var text string
+	switch ev.Type {
+	case HookReset:
+		text = "Счётчик дней без " + ev.Topic + " сброшен, серия была " + plural(ev.Days, "day")
+		if ev.Who != "" {
+			text += " (" + ev.Who + ")"
+		}
+		if ev.Reason != "" {
+			text += ": " + ev.Reason
+		}
+	case HookDetection:
+		text = "Упоминание «" + ev.Keyword + "»"
+		if ev.Who != "" {
+			text += " от " + ev.Who
+		}
+		text += " в счётчике дней без " + ev.Topic
+		if ev.Streak > 0 {
+			text += ", серия " + plural(ev.Days, "day")
+		}
+	case HookMilestone:
+		text = plural(ev.Days, "day") + " без " + ev.Topic + "!"
+	case HookRecord:
+		text = "Новый рекорд: " + plural(ev.Days, "day") + " без " + ev.Topic + "!"
+	}
+	return simpleEvent{
+		ID:      fmt.Sprintf("%d-%s-%d", ev.ChatID, ev.Type, ev.Time.UnixNano()),
+		Event:   ev.Type,
+		Time:    ev.Time.Format(time.RFC3339),
+		ChatID:  ev.ChatID,
+		Counter: ev.Counter,
+		Topic:   ev.Topic,
+		Days:    ev.Days,
+		Who:     ev.Who,
+		Keyword: ev.Keyword,
+		Reason:  ev.Reason,
+		Text:    text,
+		Value1:  ev.Topic,
+		Value2:  strconv.Itoa(ev.Days),
+		Value3:  text,
+	}
+}
+
+// validHooks checks the webhook settings
+func validHooks(hooks []HookConfig) error {
+	for _, h := range hooks {
+		if u, err := url.Parse(h.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid hooks url %q", h.URL)
+		}
+		if h.Format != "" && h.Format != hookFormatSimple {
+			return fmt.Errorf("unknown hooks format %q, expected simple or none", h.Format)
+		}
+		if h.Format == hookFormatSimple && h.Secret != "" {
+			return fmt.Errorf("hooks with format simple are not signed, remove the secret of %q", h.URL)
+		}
+		for _, typ := range h.Events {
+			if typ != HookDetection && typ != HookReset && typ != HookMilestone && typ != HookRecord {
+				return fmt.Errorf("unknown hooks event %q, expected detection, reset, milestone or record", typ)
+			}
+		}
+	}
+	return nil
+}
+
+// webhookSink delivers events to the configured webhooks in the background.
+// Every hook has its own queue and worker, so a slow or failing receiver
+// only holds up its own deliveries, which stay in order.
+type webhookSink struct {
+	workers []*hookWorker
+}
+
+// hookWorker delivers the events of one hook, retrying failed deliveries a
+// few times
+type hookWorker struct {
+	h      HookConfig
+	queue  chan hookDelivery
+	client *http.Client
+}
+
+// hookDelivery is an encoded event waiting for its hook
+type hookDelivery struct {
+	typ  string
+	body []byte
+}
+
+func newWebhookSink(hooks []HookConfig) *webhookSink {
+	client := &http.Client{Timeout: 10 * time.Second}
+	s := &webhookSink{}
+	for _, h := range hooks {
+		w := &hookWorker{h: h, queue: make(chan hookDelivery, hookQueueSize), client: client}
+		s.workers = append(s.workers, w)
+		go w.run()
+	}
+	return s
+}
+
+func (s *webhookSink) Publish(ev counterEvent) {
+	full, err := json.Marshal(ev)
+	if err != nil {
+		slog.Error("Failed to encode webhook event", "err", err)
+		return
+	}
+	simple, _ := json.Marshal(simpleEventOf(ev))
+	for _, w := range s.workers {
+		if len(w.h.Events) > 0 && !slices.Contains(w.h.Events, ev.Type) {
+			continue
+		}
+		body := full
+		if w.h.Format == hookFormatSimple {
+			body = simple
+		}
+		select {
+		case w.queue <- hookDelivery{typ: ev.Type, body: body}:
+		default:
+			slog.Warn("Webhook queue is full, dropping event", "host", w.host(), "type", ev.Type, "chat_id", ev.ChatID)
+		}
+	}
+}
+
+func (w *hookWorker) run() {
+	for d := range w.queue {
+		w.deliver(d)
+	}
+}
+
+func (w *hookWorker) host() string {
+	u, _ := url.Parse(w.h.URL)
+	return u.Host
+}
+
+func (w *hookWorker) deliver(d hookDelivery) {
+	var err error
+	for attempt := 1; attempt <= hookAttempts; attempt++ {
+		if err = w.post(d); err == nil {
+			return
+		}
+		if attempt < hookAttempts {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+	}
+	slog.Error("Failed to deliver webhook", "host", w.host(), "type", d.typ, "err", err)
+}
+
+func (w *hookWorker) post(d hookDelivery) error {
+	req, err := http.NewRequest(http.MethodPost, w.h.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Dayswithout-Event", d.typ)
+	if w.h.Secret != "" {
+		// every attempt is signed anew, so receivers can refuse stale
+		// timestamps to stop replays
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Dayswithout-Timestamp", ts)
+		req.Header.Set("X-Dayswithout-Signature", "sha256="+signHook(w.h.Secret, ts, d.body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// signHook is the hex HMAC-SHA256 of "<ts>.<body>", as receivers verify it
+func signHook(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
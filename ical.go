@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// calendarResets is how many past resets the calendar lists
+	calendarResets = 100
+	// calendarYears is how many streak anniversaries ahead are listed
+	calendarYears = 5
+)
+
+// icalText escapes a TEXT value
+var icalText = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+// icalWriter builds an iCalendar with CRLF line ends and folded lines
+type icalWriter struct {
+	sb strings.Builder
+}
+
+// line writes a content line, folding it at 75 octets without splitting
+// UTF-8 sequences
+func (w *icalWriter) line(format string, args ...any) {
+	s := fmt.Sprintf(format, args...)
+	for len(s) > 75 {
+		cut := 75
+		for cut > 0 && s[cut]&0xc0 == 0x80 {
+			cut--
+		}
+		w.sb.WriteString(s[:cut] + "\r\n ")
+		s = s[cut:]
+	}
+	w.sb.WriteString(s + "\r\n")
+}
+
+// allDay writes an all-day event on the date of t
+func (w *icalWriter) allDay(uid string, stamp, t time.Time, summary, desc string) {
+	w.line("BEGIN:VEVENT")
+	w.line("UID:%s", uid)
+	w.line("DTSTAMP:%s", stamp.UTC().Format("20060102T150405Z"))
+	w.line("DTSTART;VALUE=DATE:%s", t.Format("20060102"))
+	w.line("DTEND;VALUE=DATE:%s", t.AddDate(0, 0, 1).Format("20060102"))
+	w.line("SUMMARY:%s", icalText.Replace(summary))
+	w.line("DESCRIPTION:%s", icalText.Replace(desc))
+	w.line("TRANSP:TRANSPARENT")
+	w.line("END:VEVENT")
+}
+
+// serveCalendar answers GET /calendar/<chat id>.ics with the upcoming
+// milestones and streak anniversaries of the chat's counters and its past
+// resets, optionally of one ?counter=name. A paused counter has no upcoming
+// dates.
+func serveCalendar(cfg Config, bots []*botInstance) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idText, ext, _ := strings.Cut(r.PathValue("file"), ".")
+		if ext != "ics" {
+			writeAPIError(w, http.StatusNotFound, "calendars are .ics")
+			return
+		}
+		r.SetPathValue("id", idText)
+		bi, id, ok := publicChatOf(cfg, bots, cfg.API.CalendarChats, w, r)
+		if !ok {
+			return
+		}
+		counter, filtered := strings.ToLower(r.URL.Query().Get("counter")), r.URL.Query().Has("counter")
+
+		now := clock()
+		var cal icalWriter
+		var unknown bool
+		bi.store.View(func(s *Storage) {
+			st := s.Chat(id)
+			names := st.CounterNames()
+			if filtered {
+				if unknown = !slices.Contains(names, counter); unknown {
+					return
+				}
+				names = []string{counter}
+			}
+			loc := chatLocation(bi.cfg, st)
+			cal.line("BEGIN:VCALENDAR")
+			cal.line("VERSION:2.0")
+			cal.line("PRODID:-//dayswithout//calendar//RU")
+			cal.line("CALSCALE:GREGORIAN")
+			cal.line("X-WR-CALNAME:%s", icalText.Replace("Дни без "+counterTopic(bi.cfg, st.CounterByName(names[0]))))
+			cal.line("X-WR-TIMEZONE:%s", loc.String())
+
+			topics := make(map[string]string)
+			for _, name := range st.CounterNames() {
+				topics[name] = counterTopic(bi.cfg, st.CounterByName(name))
+			}
+			for _, name := range names {
+				ctr := st.CounterByName(name)
+				if ctr.LastMention.IsZero() || ctr.Paused() {
+					continue
+				}
+				topic, days := topics[name], ctr.Days()
+				// the day count is the streak without paused time
+				start := ctr.LastMention.Add(ctr.PausedTotal)
+				dates := make(map[int]string)
+				for _, m := range bi.cfg.Milestones {
+					if m > days {
+						dates[m] = fmt.Sprintf("День %d без %s", m, topic)
+					}
+				}
+				for y := 1; y <= calendarYears; y++ {
+					if d := y * 365; d > days {
+						dates[d] = fmt.Sprintf("%s без %s", plural(y, "year"), topic)
+					}
+				}
+				uidName := name
+				if uidName == "" {
+					uidName = "default"
+				}
+				keys := make([]int, 0, len(dates))
+				for d := range dates {
+					keys = append(keys, d)
+				}
+				slices.Sort(keys)
+				for _, d := range keys {
+					at := start.Add(time.Duration(d) * 24 * time.Hour).In(loc)
+					cal.allDay(fmt.Sprintf("dayswithout-%d-%s-%d-%d@dayswithout", id, uidName, d, ctr.LastMention.Unix()), now, at, dates[d],
+						fmt.Sprintf("Если никто не упомянет %s, серия дойдёт до %s.", topic, plural(d, "day")))
+				}
+			}
+
+			resets := st.EventsSince(EventReset, time.Time{})
+			for i, n := len(resets)-1, 0; i >= 0 && n < calendarResets; i-- {
+				ev := resets[i]
+				if filtered && ev.Counter != counter {
+					continue
+				}
+				n++
+				topic, ok := topics[ev.Counter]
+				if !ok {
+					topic = ev.Counter
+				}
+				desc := fmt.Sprintf("%s сбросил(а) счётчик. Серия длилась %s.", ev.Who(), formatStreak(ev.Streak, false))
+				if ev.Reason != "" {
+					desc += " Причина: " + ev.Reason
+				}
+				cal.line("BEGIN:VEVENT")
+				cal.line("UID:dayswithout-%d-reset-%d@dayswithout", id, ev.Time.UnixNano())
+				cal.line("DTSTAMP:%s", now.UTC().Format("20060102T150405Z"))
+				cal.line("DTSTART:%s", ev.Time.UTC().Format("20060102T150405Z"))
+				cal.line("SUMMARY:%s", icalText.Replace(fmt.Sprintf("Сброс: %s без %s", formatStreak(ev.Streak, false), topic)))
+				cal.line("DESCRIPTION:%s", icalText.Replace(desc))
+				cal.line("END:VEVENT")
+			}
+			cal.line("END:VCALENDAR")
+		})
+		if unknown {
+			writeAPIError(w, http.StatusNotFound, "unknown counter")
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("Cache-Control", "max-age=300")
+		w.Write([]byte(cal.sb.String()))
+	}
+}
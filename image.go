@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	tb "gopkg.in/telebot.v3"
+)
+
+// Palette shared by all rendered images
+var (
+	colorBackground = color.RGBA{0x1e, 0x1f, 0x26, 0xff}
+	colorForeground = color.RGBA{0xf2, 0xf2, 0xf2, 0xff}
+	colorMuted      = color.RGBA{0x9a, 0x9c, 0xa8, 0xff}
+	colorAccent     = color.RGBA{0xff, 0x6b, 0x5a, 0xff}
+	colorGrid       = color.RGBA{0x36, 0x38, 0x44, 0xff}
+)
+
+var (
+	fontsOnce   sync.Once
+	fontRegular *opentype.Font
+	fontBold    *opentype.Font
+)
+
+// loadFonts parses the embedded Go fonts, which cover Latin and Cyrillic
+func loadFonts() {
+	fontsOnce.Do(func() {
+		var err error
+		if fontRegular, err = opentype.Parse(goregular.TTF); err != nil {
+			fatal("Failed to parse embedded font", "err", err)
+		}
+		if fontBold, err = opentype.Parse(gobold.TTF); err != nil {
+			fatal("Failed to parse embedded font", "err", err)
+		}
+	})
+}
+
+func fontFace(f *opentype.Font, size float64) font.Face {
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		// only fails on invalid options, which are constant here
+		panic(err)
+	}
+	return face
+}
+
+func newCanvas(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), &image.Uniform{colorBackground}, image.Point{}, draw.Src)
+	return img
+}
+
+func fillRect(img draw.Image, r image.Rectangle, c color.Color) {
+	draw.Draw(img, r, &image.Uniform{c}, image.Point{}, draw.Over)
+}
+
+// drawText draws s with its baseline starting at (x, y)
+func drawText(img draw.Image, face font.Face, x, y int, c color.Color, s string) {
+	d := font.Drawer{Dst: img, Src: &image.Uniform{c}, Face: face, Dot: fixed.P(x, y)}
+	d.DrawString(s)
+}
+
+// drawTextCentered draws s horizontally centered on cx with its baseline at y
+func drawTextCentered(img draw.Image, face font.Face, cx, y int, c color.Color, s string) {
+	w := font.MeasureString(face, s).Round()
+	drawText(img, face, cx-w/2, y, c, s)
+}
+
+func encodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// photoFromPNG wraps an encoded image for sending
+func photoFromPNG(data []byte, caption string) *tb.Photo {
+	return &tb.Photo{File: tb.FromReader(bytes.NewReader(data)), Caption: caption}
+}
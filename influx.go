@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// InfluxConfig pushes the streaks and counter events to a time-series
+// database in InfluxDB line protocol, so graphs can go back months:
+//
+//	dayswithout_streak,bot=…,chat=…,counter=… days=12i,seconds=…i,record_days=…i
+//	dayswithout_event,bot=…,chat=…,counter=…,type=reset value=1i,streak_seconds=…i
+//
+// URL is the write endpoint, e.g. InfluxDB 2 /api/v2/write?org=…&bucket=…,
+// InfluxDB 1 /write?db=… or VictoriaMetrics /write. Timestamps are in
+// nanoseconds, the default precision.
+type InfluxConfig struct {
+	URL string `yaml:"url"`
+	// Token is sent as "Authorization: Token …" (InfluxDB 2); for basic
+	// auth put user:password@ into URL
+	Token string `yaml:"token"`
+	// Interval is how often the streaks are written, 1m by default
+	Interval time.Duration `yaml:"interval"`
+}
+
+const (
+	influxQueueSize = 1024
+	influxFlush     = 10 * time.Second
+	// influxMaxPending caps the lines kept while the database is down
+	influxMaxPending = 10000
+)
+
+// influxWriter is the eventSink batching lines to the database, keeping
+// them for the next flush while it's unreachable
+type influxWriter struct {
+	cfg    InfluxConfig
+	client *http.Client
+	lines  chan string
+}
+
+var influxOut *influxWriter
+
+func newInfluxWriter(cfg InfluxConfig) *influxWriter {
+	w := &influxWriter{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}, lines: make(chan string, influxQueueSize)}
+	go w.run()
+	return w
+}
+
+// influxTag escapes a tag value
+var influxTag = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+// influxTags are the tags identifying a counter
+func influxTags(bot string, chatID int64, counter string) string {
+	if counter == "" {
+		counter = "default"
+	}
+	return fmt.Sprintf("bot=%s,chat=%d,counter=%s", influxTag.Replace(bot), chatID, influxTag.Replace(counter))
+}
+
+func (w *influxWriter) send(line string) {
+	select {
+	case w.lines <- line:
+	default:
+		slog.Warn("Influx queue is full, dropping point")
+	}
+}
+
+func (w *influxWriter) Publish(ev counterEvent) {
+	w.send(fmt.Sprintf("dayswithout_event,%s,type=%s value=1i,streak_seconds=%di %d",
+		influxTags(ev.Bot, ev.ChatID, ev.Counter), ev.Type, ev.Streak, ev.Time.UnixNano()))
+}
+
+// WriteStreaks is the "influx" job, writing the current streak of every
+// counter
+func (w *influxWriter) WriteStreaks(b *tb.Bot, store *Store, now time.Time) {
+	var lines []string
+	store.View(func(s *Storage) {
+		for chatID, st := range s.ActiveChats() {
+			for _, name := range st.CounterNames() {
+				ctr := st.CounterByName(name)
+				if ctr.LastMention.IsZero() {
+					continue
+				}
+				lines = append(lines, fmt.Sprintf("dayswithout_streak,%s days=%di,seconds=%di,record_days=%di %d",
+					influxTags(b.Me.Username, chatID, name), ctr.Days(), int64(ctr.Streak().Seconds()), durationDays(ctr.Record), now.UnixNano()))
+			}
+		}
+	})
+	for _, l := range lines {
+		w.send(l)
+	}
+}
+
+func (w *influxWriter) run() {
+	var pending []string
+	t := time.NewTicker(influxFlush)
+	defer t.Stop()
+	for {
+		select {
+		case l := <-w.lines:
+			pending = append(pending, l)
+			continue
+		case <-t.C:
+		}
+		if len(pending) == 0 {
+			continue
+		}
+		if err := w.write(pending); err != nil {
+			slog.Warn("Failed to write to the time-series database, keeping the points", "points", len(pending), "err", err)
+			if len(pending) > influxMaxPending {
+				pending = pending[len(pending)-influxMaxPending:]
+			}
+			continue
+		}
+		pending = pending[:0]
+	}
+}
+
+func (w *influxWriter) write(lines []string) error {
+	req, err := http.NewRequest(http.MethodPost, w.cfg.URL, strings.NewReader(strings.Join(lines, "\n")+"\n"))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.cfg.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.cfg.Token)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strconv.Quote(string(msg)))
+	}
+	return nil
+}
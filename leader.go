@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// HAConfig lets several replicas share one bot: the one holding the Redis
+// lock Key polls Telegram, the others wait to take over. The lock expires
+// after TTL unless renewed, which bounds the failover time. data.json has
+// to be on storage all replicas can reach.
+type HAConfig struct {
+	Redis string        `yaml:"redis"`
+	Key   string        `yaml:"key"`
+	TTL   time.Duration `yaml:"ttl"`
+}
+
+const (
+	renewScript   = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`
+	releaseScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+)
+
+var (
+	errLockLost  = errors.New("lock is held by another replica")
+	errNotLeader = errors.New("not the leader, storage is written by another replica")
+)
+
+// leadership is the lock of this replica when ha is set. While it isn't
+// held no updates are fetched or handled, no jobs run and storage isn't
+// written.
+var leadership *leaderLock
+
+// leaderLock is the Redis lock electing the active replica
+type leaderLock struct {
+	cfg      HAConfig
+	token    string
+	released chan struct{}
+	// fence grows with every acquisition of the lock by any replica, see
+	// checkFence
+	fence atomic.Int64
+
+	// stateMu guards the fields below
+	stateMu sync.Mutex
+	active  bool
+	// changed is closed and replaced whenever active changes
+	changed   chan struct{}
+	onAcquire func()
+
+	mu   sync.Mutex
+	conn *redisConn
+}
+
+func newLeaderLock(cfg HAConfig) *leaderLock {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &leaderLock{cfg: cfg, token: hex.EncodeToString(id), released: make(chan struct{}), changed: make(chan struct{})}
+}
+
+// interval is how often the lock is renewed, which also bounds every Redis
+// call: a renewal that fails or hangs is noticed at 3/4 of TTL at most,
+// before the lock can expire
+func (l *leaderLock) interval() time.Duration {
+	return l.cfg.TTL / 4
+}
+
+// do runs a command, reconnecting first if the last one failed
+func (l *leaderLock) do(args ...string) (any, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn == nil {
+		conn, err := dialRedis(l.cfg.Redis, l.interval())
+		if err != nil {
+			return nil, err
+		}
+		l.conn = conn
+	}
+	reply, err := l.conn.Do(args...)
+	if err != nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+	return reply, err
+}
+
+func (l *leaderLock) ttlMillis() string {
+	return strconv.FormatInt(l.cfg.TTL.Milliseconds(), 10)
+}
+
+// isLeader reports whether this replica may handle updates and write
+// storage, always true without ha
+func isLeader() bool {
+	active, _ := leaderState()
+	return active
+}
+
+// leaderState returns whether this replica is the leader and a channel
+// closed when that changes, nil without ha
+func leaderState() (bool, <-chan struct{}) {
+	if leadership == nil {
+		return true, nil
+	}
+	leadership.stateMu.Lock()
+	defer leadership.stateMu.Unlock()
+	return leadership.active, leadership.changed
+}
+
+func (l *leaderLock) setActive(active bool) {
+	l.stateMu.Lock()
+	defer l.stateMu.Unlock()
+	if l.active != active {
+		l.active = active
+		close(l.changed)
+		l.changed = make(chan struct{})
+	}
+}
+
+// OnAcquire sets fn to run when the lock is taken back after it was lost,
+// before updates are fetched again
+func (l *leaderLock) OnAcquire(fn func()) {
+	l.stateMu.Lock()
+	defer l.stateMu.Unlock()
+	l.onAcquire = fn
+}
+
+// Acquire blocks until this replica holds the lock, or returns false once
+// it is released
+func (l *leaderLock) Acquire() bool {
+	slog.Info("Waiting for leadership", "key", l.cfg.Key)
+	for {
+		reply, err := l.do("SET", l.cfg.Key, l.token, "NX", "PX", l.ttlMillis())
+		switch {
+		case err != nil:
+			slog.Error("Failed to acquire leader lock", "err", err)
+		case reply == "OK":
+			fence, err := l.do("INCR", l.cfg.Key+":fence")
+			n, ok := fence.(int64)
+			if err == nil && ok {
+				l.fence.Store(n)
+				l.stateMu.Lock()
+				fn := l.onAcquire
+				l.stateMu.Unlock()
+				if fn != nil {
+					fn()
+				}
+				l.setActive(true)
+				slog.Info("Became leader", "key", l.cfg.Key, "fence", n)
+				return true
+			}
+			// without a fence the writes couldn't be told apart
+			slog.Error("Failed to get fencing token", "err", err)
+			l.do("EVAL", releaseScript, "1", l.cfg.Key, l.token)
+		}
+		select {
+		case <-l.released:
+			return false
+		case <-time.After(l.interval()):
+		}
+	}
+}
+
+// renew extends the lock, failing when another replica holds it
+func (l *leaderLock) renew() error {
+	reply, err := l.do("EVAL", renewScript, "1", l.cfg.Key, l.token, l.ttlMillis())
+	if err != nil {
+		return err
+	}
+	if reply != int64(1) {
+		return errLockLost
+	}
+	return nil
+}
+
+// Keep renews the lock until it is released. The first renewal that fails
+// or times out steps down right away, while the lock may still be ours:
+// fetching, jobs and writes stop, then the replica waits as a standby to
+// take over again.
+func (l *leaderLock) Keep() {
+	ticker := time.NewTicker(l.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.released:
+			return
+		case <-ticker.C:
+		}
+		err := l.renew()
+		if err == nil {
+			continue
+		}
+		slog.Error("Failed to renew leader lock, going back to standby", "err", err)
+		l.setActive(false)
+		alerts.Alert("лидерство", "реплика потеряла блокировку: "+err.Error())
+		if !l.Acquire() {
+			return
+		}
+		ticker.Reset(l.interval())
+	}
+}
+
+// Release gives the lock up so a standby replica takes over right away
+func (l *leaderLock) Release() {
+	close(l.released)
+	l.setActive(false)
+	if _, err := l.do("EVAL", releaseScript, "1", l.cfg.Key, l.token); err != nil {
+		slog.Error("Failed to release leader lock", "err", err)
+	}
+}
+
+// checkFence refuses to write the storage at path unless this replica is
+// the leader. The fence of the latest leader is kept next to the storage,
+// so a replica that lost the lock without noticing yet can't overwrite what
+// a newer leader wrote.
+func checkFence(path string) error {
+	if leadership == nil {
+		return nil
+	}
+	if !isLeader() {
+		return errNotLeader
+	}
+	fence := leadership.fence.Load()
+	file := path + ".fence"
+	if data, err := os.ReadFile(file); err == nil {
+		latest, _ := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if latest > fence {
+			return fmt.Errorf("fenced off: storage belongs to a newer leader (fence %d, ours %d)", latest, fence)
+		}
+		if latest == fence {
+			return nil
+		}
+	}
+	return os.WriteFile(file, []byte(strconv.FormatInt(fence, 10)+"\n"), 0o644)
+}
+
+// leaderOnly drops updates still queued when the replica stepped down;
+// Telegram hands the unconfirmed ones to the new leader
+func leaderOnly(next tb.HandlerFunc) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		if !isLeader() {
+			ctxLogger(c).Debug("Dropping update, not the leader")
+			return nil
+		}
+		return next(c)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// linkedChats returns the chats sharing their counters with chatID: those
+// of a links group in config with it, and those that joined its /link
+// group
+func (s *Storage) linkedChats(cfg Config, chatID int64) []int64 {
+	var out []int64
+	add := func(id int64) {
+		if st := s.Chats[id]; id != chatID && st != nil && st.Left.IsZero() && !slices.Contains(out, id) {
+			out = append(out, id)
+		}
+	}
+	for _, group := range cfg.Links {
+		if slices.Contains(group, chatID) {
+			for _, id := range group {
+				add(id)
+			}
+		}
+	}
+	if st := s.Chats[chatID]; st != nil && st.Link != "" {
+		for id, other := range s.Chats {
+			if other.Link == st.Link {
+				add(id)
+			}
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// resetLinked repeats reset ev of chatID in the linked chats that have the
+// same counter and queues text as their announcement. To be called in the
+// update that makes the reset; the returned events are to be published
+// after it.
+func (s *Storage) resetLinked(cfg Config, bot string, chatID int64, ev Event, text templated) []counterEvent {
+	var events []counterEvent
+	for _, id := range s.linkedChats(cfg, chatID) {
+		st := s.Chat(id)
+		ctr := st.CounterByName(ev.Counter)
+		if ctr == nil {
+			continue
+		}
+		// the message belongs to the other chat
+		ev.MessageID = 0
+		st.Reset(ev)
+		// nobody is going to send it from a handler, so it is left to
+		// the next run of the "announcements" job
+		text := templated{Text: "🔗 Сброс в связанном чате.\n" + text.Text, Template: text.Template}
+		s.queueAnnouncement(id, st.homeThread(), text, ev.Time, false)
+		events = append(events, resetEvent(bot, id, counterTopic(cfg, ctr), st))
+	}
+	return events
+}
+
+var (
+	approveLinkBtn = tb.Btn{Unique: "approve_link"}
+	declineLinkBtn = tb.Btn{Unique: "decline_link"}
+)
+
+// handleLink shows the link code of the chat, or asks to join the group of
+// another chat with /link <code>. The join waits for an admin of that chat
+// to approve it, see handleApproveLink.
+func handleLink(b *tb.Bot, cfg Config, store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		chatID := c.Chat().ID
+		code := strings.TrimSpace(c.Message().Payload)
+		if code == "" {
+			var linked []int64
+			if err := store.UpdateContext(ctxOf(c), func(s *Storage) {
+				st := s.Chat(chatID)
+				if st.Link == "" {
+					st.Link = newLinkCode()
+				}
+				code = st.Link
+				linked = s.linkedChats(cfg, chatID)
+			}); err != nil {
+				return err
+			}
+			text := fmt.Sprintf("Чтобы вести общий счётчик с другим чатом, отправьте там /link %s (нужны права админа). "+
+				"Присоединение подтверждает админ этого чата.", code)
+			if len(linked) > 0 {
+				text = fmt.Sprintf("Счётчик общий ещё с %s. ", plural(len(linked), "chat")) + text + "\nОтвязать этот чат: /unlink"
+			}
+			return c.Send(text)
+		}
+
+		var origin int64
+		if err := store.UpdateContext(ctxOf(c), func(s *Storage) {
+			for id, st := range s.Chats {
+				if id != chatID && st.Link == code && st.Left.IsZero() {
+					origin = id
+					break
+				}
+			}
+			if origin != 0 {
+				s.Chat(chatID).LinkRequest = code
+			}
+		}); err != nil {
+			return err
+		}
+		if origin == 0 {
+			return c.Send("Нет чата с таким кодом. Код показывает /link в чате, к которому нужно присоединиться.")
+		}
+		menu := &tb.ReplyMarkup{}
+		id := strconv.FormatInt(chatID, 10)
+		menu.Inline(menu.Row(menu.Data("✅ Принять", approveLinkBtn.Unique, id), menu.Data("❌ Отклонить", declineLinkBtn.Unique, id)))
+		title := c.Chat().Title
+		if title == "" {
+			title = c.Sender().FirstName
+		}
+		text := fmt.Sprintf("Чат «%s» просит вести с вами общий счётчик: сброс в любом из чатов сбросит его везде. Решают админы.", title)
+		if _, err := postToChat(b, store, origin, text, menu); err != nil {
+			ctxLogger(c).Error("Failed to send link request", "origin", origin, "err", err)
+			return c.Send("Не удалось отправить запрос в тот чат.")
+		}
+		ctxLogger(c).Info("Chat asked to link", "origin", origin)
+		return c.Send("Запрос отправлен, его должен принять админ того чата.")
+	}
+}
+
+// handleApproveLink joins the chat of the button to the group of the chat
+// the request was posted in. The joining chat takes over the streaks of
+// the counters both chats have.
+func handleApproveLink(b *tb.Bot, store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		joining, _ := strconv.ParseInt(c.Data(), 10, 64)
+		var ok bool
+		var days string
+		if err := store.UpdateContext(ctxOf(c), func(s *Storage) {
+			from, st := s.Chat(c.Chat().ID), s.Chats[joining]
+			if ok = st != nil && st.LinkRequest != "" && st.LinkRequest == from.Link; !ok {
+				return
+			}
+			st.Link, st.LinkRequest = from.Link, ""
+			for _, name := range from.CounterNames() {
+				src, dst := from.CounterByName(name), st.CounterByName(name)
+				if dst == nil {
+					continue
+				}
+				dst.LastMention = src.LastMention
+				dst.LastMilestone = src.LastMilestone
+				dst.RecordBroken = src.RecordBroken
+				dst.PausedAt = src.PausedAt
+				dst.PausedTotal = src.PausedTotal
+			}
+			days = formatStreak(st.Counter.Streak(), false)
+		}); err != nil {
+			return err
+		}
+		c.Respond()
+		if !ok {
+			return c.Edit("Запрос на общий счётчик устарел.")
+		}
+		ctxLogger(c).Info("Chat linked", "joined", joining)
+		if _, err := postToChat(b, store, joining, "Готово: счётчик теперь общий, серия — "+days+". Сброс в любом из чатов сбросит его везде."); err != nil {
+			ctxLogger(c).Warn("Failed to notify linked chat", "chat", joining, "err", err)
+		}
+		return c.Edit("Общий счётчик принят.")
+	}
+}
+
+func handleDeclineLink(store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		joining, _ := strconv.ParseInt(c.Data(), 10, 64)
+		if err := store.UpdateContext(ctxOf(c), func(s *Storage) {
+			if st := s.Chats[joining]; st != nil {
+				st.LinkRequest = ""
+			}
+		}); err != nil {
+			return err
+		}
+		c.Respond()
+		return c.Edit("Запрос на общий счётчик отклонён.")
+	}
+}
+
+func handleUnlink(store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		var linked bool
+		if err := store.UpdateContext(ctxOf(c), func(s *Storage) {
+			st := s.Chat(c.Chat().ID)
+			linked = st.Link != ""
+			st.Link, st.LinkRequest = "", ""
+		}); err != nil {
+			return err
+		}
+		if !linked {
+			return c.Send("Этот чат не связан с другими через /link.")
+		}
+		ctxLogger(c).Info("Chat unlinked")
+		return c.Send("Чат отвязан, счётчик дальше идёт сам по себе.")
+	}
+}
+
+// newLinkCode is a random code naming a group of linked chats
+func newLinkCode() string {
+	buf := make([]byte, 6)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LLMConfig has detection prompts and reset announcements written by a
+// language model behind an OpenAI compatible chat completions endpoint
+// (OpenAI, Ollama, llama.cpp, vLLM…). The usual template text is the
+// fallback when the model fails or takes longer than Timeout.
+type LLMConfig struct {
+	URL     string        `yaml:"url"`
+	APIKey  string        `yaml:"api_key"`
+	Model   string        `yaml:"model"`
+	Timeout time.Duration `yaml:"timeout"`
+	// Detection and Reset are the prompts, with {topic}, {streak},
+	// {offender}, {keyword}, {reason} and {text}, the template answer
+	Detection string `yaml:"detection"`
+	Reset     string `yaml:"reset"`
+}
+
+const (
+	defaultDetectionPrompt = "Ты — ехидный бот в групповом чате, который считает дни без упоминания темы «{topic}». " +
+		"{offender} только что написал(а) «{keyword}», а серия длилась {streak}. " +
+		"Напиши одну короткую язвительную, но беззлобную реплику на русском: спроси, не пора ли сбросить счётчик, " +
+		"и напомни, что подтверждение — командой /reset. Без кавычек и пояснений. Обычный ответ бота: {text}"
+	defaultResetPrompt = "Ты — ехидный бот в групповом чате, который считает дни без упоминания темы «{topic}». " +
+		"Счётчик только что сброшен, серия длилась {streak}, виноват(а) {offender}. Причина сброса: {reason}. " +
+		"Напиши короткое язвительное, но беззлобное объявление о сбросе на русском, без кавычек и пояснений. " +
+		"Обычный ответ бота: {text}"
+	// llmMaxLen caps the answer, models tend to ramble
+	llmMaxLen = 1000
+)
+
+var llmClient = &http.Client{}
+
+// validLLM checks the endpoint settings and fills in the defaults
+func validLLM(cfg *LLMConfig) error {
+	if cfg.URL == "" {
+		return nil
+	}
+	if cfg.Model == "" {
+		return fmt.Errorf("llm.model is required with llm.url")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.Detection == "" {
+		cfg.Detection = defaultDetectionPrompt
+	}
+	if cfg.Reset == "" {
+		cfg.Reset = defaultResetPrompt
+	}
+	return nil
+}
+
+// llmText asks the model for a reply to prompt, returning fallback when no
+// model is configured or it fails. Texts of the model have the template
+// "llm".
+func llmText(ctx context.Context, cfg Config, prompt string, vars map[string]string, fallback templated) templated {
+	if cfg.LLM.URL == "" {
+		return fallback
+	}
+	vars["text"] = fallback.Text
+	if vars["reason"] == "" {
+		vars["reason"] = "не указана"
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.LLM.Timeout)
+	defer cancel()
+	text, err := completeLLM(ctx, cfg.LLM, renderTemplate(prompt, vars))
+	if err != nil {
+		slog.Warn("LLM failed, using the template", "err", err)
+		return fallback
+	}
+	text = strings.Trim(strings.TrimSpace(text), "\"«»")
+	if text == "" {
+		return fallback
+	}
+	if r := []rune(text); len(r) > llmMaxLen {
+		text = string(r[:llmMaxLen]) + "…"
+	}
+	return templated{Text: text, Template: "llm"}
+}
+
+// completeLLM sends prompt as the only user message and returns the answer
+func completeLLM(ctx context.Context, cfg LLMConfig, prompt string) (string, error) {
+	data, err := json.Marshal(map[string]any{
+		"model":    cfg.Model,
+		"messages": []map[string]string{{"role": "user", "content": prompt}},
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.APIKey)
+	}
+	resp, err := llmClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	var res struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", err
+	}
+	if len(res.Choices) == 0 {
+		return "", fmt.Errorf("no choices in the answer")
+	}
+	return res.Choices[0].Message.Content, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogFileConfig writes logs to Path instead of stderr. The file is rotated
+// when it grows beyond MaxSizeMB or gets older than MaxAge; rotated files
+// beyond MaxBackups or older than Retention are deleted. Zero disables the
+// respective limit.
+type LogFileConfig struct {
+	Path       string        `yaml:"path"`
+	MaxSizeMB  int           `yaml:"max_size_mb"`
+	MaxAge     time.Duration `yaml:"max_age"`
+	MaxBackups int           `yaml:"max_backups"`
+	Retention  time.Duration `yaml:"retention"`
+}
+
+const rotatedSuffix = ".20060102-150405"
+
+// rotatedName matches the suffix of rotated files: the time, and a number
+// when several rotations fell into the same second
+var rotatedName = regexp.MustCompile(`^\.\d{8}-\d{6}(?:\.(\d+))?$`)
+
+// rotatingFile is an io.Writer over a log file that rotates itself
+type rotatingFile struct {
+	mu     sync.Mutex
+	cfg    LogFileConfig
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+func openRotating(cfg LogFileConfig) (*rotatingFile, error) {
+	r := &rotatingFile{cfg: cfg}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size, r.opened = f, info.Size(), time.Now()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.due(len(p)) {
+		if err := r.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "log rotation failed: %v\n", err)
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// due reports whether writing n more bytes needs a fresh file
+func (r *rotatingFile) due(n int) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.cfg.MaxSizeMB > 0 && r.size+int64(n) > int64(r.cfg.MaxSizeMB)<<20 {
+		return true
+	}
+	return r.cfg.MaxAge > 0 && time.Since(r.opened) > r.cfg.MaxAge
+}
+
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	renameErr := os.Rename(r.cfg.Path, r.backupName(time.Now()))
+	// after a failed rename this keeps appending to the old file rather
+	// than losing records
+	if err := r.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	r.prune()
+	return nil
+}
+
+// backupName is a free name for the file rotated at now
+func (r *rotatingFile) backupName(now time.Time) string {
+	name := r.cfg.Path + now.Format(rotatedSuffix)
+	for n := 1; ; n++ {
+		if _, err := os.Lstat(name); os.IsNotExist(err) {
+			return name
+		}
+		name = r.cfg.Path + now.Format(rotatedSuffix) + "." + strconv.Itoa(n)
+	}
+}
+
+// backups returns the rotated files of the log, newest first. Other files
+// sharing the prefix are left alone.
+func (r *rotatingFile) backups() []string {
+	matches, _ := filepath.Glob(r.cfg.Path + ".*")
+	type backup struct {
+		name, stamp string
+		n           int
+	}
+	var found []backup
+	for _, name := range matches {
+		m := rotatedName.FindStringSubmatch(strings.TrimPrefix(name, r.cfg.Path))
+		if m == nil {
+			continue
+		}
+		n, _ := strconv.Atoi(m[1])
+		found = append(found, backup{name: name, stamp: strings.TrimSuffix(m[0], "."+m[1]), n: n})
+	}
+	// the timestamp sorts chronologically, the number within a second
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].stamp != found[j].stamp {
+			return found[i].stamp > found[j].stamp
+		}
+		return found[i].n > found[j].n
+	})
+	out := make([]string, len(found))
+	for i, b := range found {
+		out[i] = b.name
+	}
+	return out
+}
+
+// prune deletes rotated files beyond the backup count or retention period
+func (r *rotatingFile) prune() {
+	for i, name := range r.backups() {
+		info, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		tooMany := r.cfg.MaxBackups > 0 && i >= r.cfg.MaxBackups
+		tooOld := r.cfg.Retention > 0 && time.Since(info.ModTime()) > r.cfg.Retention
+		if tooMany || tooOld {
+			os.Remove(name)
+		}
+	}
+}
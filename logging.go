@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// LogConfig selects the log level ("debug", "info", "warn", "error") and
+// output format ("text" or "json")
+type LogConfig struct {
+	Level  string        `yaml:"level"`
+	Format string        `yaml:"format"`
+	File   LogFileConfig `yaml:"file"`
+}
+
+// parseLevel maps a config level name to a slog level
+func parseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(name))
+	return level, err
+}
+
+// setupLogging installs the configured handler as the default logger,
+// writing to stderr or the log file. The legacy debug flag lowers the level
+// to debug.
+func setupLogging(cfg Config) {
+	level, _ := parseLevel(cfg.Log.Level)
+	if cfg.Debug {
+		level = slog.LevelDebug
+	}
+	var out io.Writer = os.Stderr
+	if cfg.Log.File.Path != "" {
+		f, err := openRotating(cfg.Log.File)
+		if err != nil {
+			fatal("Failed to open log file", "file", cfg.Log.File.Path, "err", err)
+		}
+		out = f
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler = slog.NewTextHandler(out, opts)
+	if cfg.Log.Format == "json" {
+		h = slog.NewJSONHandler(out, opts)
+	}
+	slog.SetDefault(slog.New(h))
+}
+
+// exit ends the process, the doctor replaces it to keep going
+var exit = os.Exit
+
+// fatal logs msg at error level and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	exit(1)
+}
+
+const loggerKey = "logger"
+
+// withLogger attaches a logger carrying the bot, chat and user of the update
+// to the context, see ctxLogger
+func withLogger(next tb.HandlerFunc) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		attrs := []any{"bot", c.Bot().Me.Username, "update_id", c.Update().ID}
+		if chat := c.Chat(); chat != nil {
+			attrs = append(attrs, "chat_id", chat.ID)
+		}
+		if msg := c.Message(); msg != nil && msg.ThreadID != 0 {
+			attrs = append(attrs, "thread_id", msg.ThreadID)
+		}
+		if user := c.Sender(); user != nil {
+			attrs = append(attrs, "user_id", user.ID, "username", user.Username)
+		}
+		c.Set(loggerKey, slog.With(attrs...))
+		return next(c)
+	}
+}
+
+// ctxLogger returns the logger of the update, or the default one
+func ctxLogger(c tb.Context) *slog.Logger {
+	if l, ok := c.Get(loggerKey).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// validLogConfig checks the log section of the config
+func validLogConfig(lc LogConfig) error {
+	if lc.Level != "" {
+		if _, err := parseLevel(lc.Level); err != nil {
+			return fmt.Errorf("invalid log.level %q", lc.Level)
+		}
+	}
+	switch lc.Format {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("invalid log.format %q", lc.Format)
+	}
+	if lc.File.MaxSizeMB < 0 || lc.File.MaxAge < 0 || lc.File.MaxBackups < 0 || lc.File.Retention < 0 {
+		return fmt.Errorf("log.file limits must not be negative")
+	}
+	return nil
+}
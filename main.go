@@ -1,13 +1,18 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/url"
 	"os"
 	"regexp"
-	"time"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	tb "gopkg.in/telebot.v3"
 	"gopkg.in/yaml.v3"
@@ -20,77 +25,578 @@ const (
 
 // Config holds bot token, topic, keywords and debug flag
 type Config struct {
-	BotToken string   `yaml:"bot_token"`
-	Topic    string   `yaml:"topic"`
-	Keywords []string `yaml:"keywords"`
-	NoSuffix []string `yaml:"no_suffix"`
-	Debug    bool     `yaml:"debug"`
-}
+	BotToken   string           `yaml:"bot_token"`
+	APIURL     string           `yaml:"api_url"`
+	Proxy      string           `yaml:"proxy"`
+	Topic      string           `yaml:"topic"`
+	Keywords   []string         `yaml:"keywords"`
+	NoSuffix   []string         `yaml:"no_suffix"`
+	Milestones []int            `yaml:"milestones"`
+	Reminders  ReminderConfig   `yaml:"reminders"`
+	Digest     DigestConfig     `yaml:"digest"`
+	Weekly     WeeklyConfig     `yaml:"weekly"`
+	Offender   OffenderConfig   `yaml:"offender"`
+	Nudges     NudgeConfig      `yaml:"nudges"`
+	Channels   ChannelConfig    `yaml:"channels"`
+	Voice      VoiceConfig      `yaml:"voice"`
+	LLM        LLMConfig        `yaml:"llm"`
+	Classifier ClassifierConfig `yaml:"classifier"`
+	Webhook    WebhookConfig    `yaml:"webhook"`
+	Health     HealthConfig     `yaml:"health"`
+	API        APIConfig        `yaml:"api"`
+	Admin      AdminConfig      `yaml:"admin"`
+	GRPC       GRPCConfig       `yaml:"grpc"`
+	MiniApp    MiniAppConfig    `yaml:"mini_app"`
+	Hooks      []HookConfig     `yaml:"hooks"`
+	Email      EmailConfig      `yaml:"email"`
+	Push       PushConfig       `yaml:"push"`
+	MQTT       MQTTConfig       `yaml:"mqtt"`
+	Sheets     SheetsConfig     `yaml:"sheets"`
+	Influx     InfluxConfig     `yaml:"influx"`
+	Discord    DiscordConfig    `yaml:"discord"`
+	Slack      SlackConfig      `yaml:"slack"`
+	Matrix     MatrixConfig     `yaml:"matrix"`
+	Mattermost MattermostConfig `yaml:"mattermost"`
+	Pprof      PprofConfig      `yaml:"pprof"`
+	Errors     ErrorsConfig     `yaml:"errors"`
+	Alerts     AlertsConfig     `yaml:"alerts"`
+	Retry      RetryConfig      `yaml:"retry"`
+	Queue      QueueConfig      `yaml:"queue"`
+	Shutdown   ShutdownConfig   `yaml:"shutdown"`
+	HA         HAConfig         `yaml:"ha"`
+	Capture    CaptureConfig    `yaml:"capture"`
+	Polling    PollingConfig    `yaml:"polling"`
+	Watchdog   WatchdogConfig   `yaml:"watchdog"`
+	Audit      AuditConfig      `yaml:"audit"`
+	Monthly    MonthlyConfig    `yaml:"monthly"`
+	Pinned     PinnedConfig     `yaml:"pinned"`
+	ChatInfo   ChatInfoConfig   `yaml:"chat_info"`
+	StaticPage StaticPageConfig `yaml:"static_page"`
+	Export     ExportConfig     `yaml:"export"`
+	ImageMode  bool             `yaml:"image_mode"`
+	Media      MediaConfig      `yaml:"media"`
+	Templates  TemplatesConfig  `yaml:"templates"`
+	DaysFormat string           `yaml:"days_format"`
+	Timezone   string           `yaml:"timezone"`
+	// AutoReset resets counters on detection without waiting for /reset
+	AutoReset bool `yaml:"auto_reset"`
+	// ConfirmByOther forbids confirming /reset of one's own detection
+	ConfirmByOther bool `yaml:"confirm_by_other"`
+	// StrictReset makes a reset wait for approvals of two distinct admins
+	StrictReset bool `yaml:"strict_reset"`
+	// IgnoreBots skips messages sent by bots or via inline bots
+	IgnoreBots bool `yaml:"ignore_bots"`
+	// PromptsPerHour caps detection prompts per chat, 0 means unlimited
+	PromptsPerHour int `yaml:"prompts_per_hour"`
+	// Operators are the users allowed to run /status, /updates and /reload,
+	// besides alerts.admin_id
+	Operators []int64 `yaml:"operators"`
+	// Links are groups of chats sharing their counters: a reset in one of
+	// them resets the counter of the same name in the others, on top of
+	// the groups joined with /link
+	Links [][]int64 `yaml:"links"`
+	// AllowedChats restricts the bot to these chats when not empty
+	AllowedChats []int64 `yaml:"allowed_chats"`
+	// CommandsPerMinute caps commands per user, 0 means unlimited
+	CommandsPerMinute int `yaml:"commands_per_minute"`
+	// DetectionsPerMinute caps keyword detections per user, 0 means unlimited
+	DetectionsPerMinute int `yaml:"detections_per_minute"`
+	// LeftChatRetention is how long data of chats the bot was removed from
+	// is kept, 0 keeps it forever
+	LeftChatRetention time.Duration `yaml:"left_chat_retention"`
+	// MaxHistory caps the history events kept per chat, negative keeps all
+	MaxHistory int `yaml:"max_history"`
+	// Workers caps the chats whose updates are handled at the same time
+	Workers int `yaml:"workers"`
+	// HandlerTimeout bounds how long a handler waits for storage and
+	// outbound calls
+	HandlerTimeout time.Duration `yaml:"handler_timeout"`
+	Debug          bool          `yaml:"debug"`
+	Log            LogConfig     `yaml:"log"`
+	// DataFile is the storage of the bot, data.json by default
+	DataFile string `yaml:"data_file"`
+	// Bots run several bots in one process, each entry overriding settings
+	// of the top level; see botConfigs
+	Bots []yaml.Node `yaml:"bots"`
 
-// Storage represents persistent storage for the last mention timestamp
-type Storage struct {
-	LastMention time.Time `json:"last_mention"`
+	// Location is the parsed Timezone
+	Location *time.Location `yaml:"-"`
 }
 
-var isDebug bool
-
-func debugLog(format string, v ...any) {
-	if isDebug {
-		log.Printf("[DEBUG] "+format, v...)
-	}
-}
-
-func loadConfig() Config {
-	log.Println("[INFO] Loading config.yaml...")
+// loadConfig returns the config of every bot to run, the first one also
+// holds the process-wide settings
+func loadConfig() []Config {
+	slog.Info("Loading config", "file", configFile)
 	var cfg Config
 	file, err := os.ReadFile(configFile)
 	if err != nil {
-		log.Fatalf("[ERROR] Failed to read %s: %v", configFile, err)
+		fatal("Failed to read config", "file", configFile, "err", err)
 	}
 	err = yaml.Unmarshal(file, &cfg)
 	if err != nil {
-		log.Fatalf("[ERROR] Failed to parse %s: %v", configFile, err)
+		fatal("Failed to parse config", "file", configFile, "err", err)
 	}
-	if len(cfg.Keywords) == 0 {
-	  log.Fatal("[ERROR] keywords is empty in config.yaml")
+	var configs []Config
+	for i, bc := range botConfigs(file, cfg) {
+		bc = prepareConfig(bc)
+		for _, prev := range configs {
+			if bc.Webhook.Enabled && prev.Webhook.Enabled && prev.Webhook.Listen == bc.Webhook.Listen {
+				fatal("Webhook bots need separate webhook.listen addresses", "index", i, "listen", bc.Webhook.Listen)
+			}
+			if name := sharedAdapter(prev, bc); name != "" {
+				fatal("Bots can't share a chat platform, give each bot its own "+name+" section or none", "index", i)
+			}
+			if bc.StaticPage.Dir != "" && prev.StaticPage.Dir == bc.StaticPage.Dir && prev.StaticPage.Index == bc.StaticPage.Index {
+				fatal("Bots sharing static_page.dir need separate static_page.index files", "index", i, "dir", bc.StaticPage.Dir)
+			}
+		}
+		configs = append(configs, bc)
 	}
-	log.Printf("[INFO] Config loaded: topic=%q, keywords=%d, debug=%v", cfg.Topic, len(cfg.Keywords), cfg.Debug)
-	return cfg
+	return configs
 }
 
-func loadStorage() Storage {
-	debugLog("Loading storage from data.json...")
-	var s Storage
-	file, err := os.ReadFile(dataFile)
-	if err != nil {
-		log.Println("[WARN] No data.json found, starting fresh")
-		s.LastMention = time.Time{}
-		return s
+// sharedAdapter names the chat platform adapter a and b both connect with
+// the same credentials, which would answer every message twice. The
+// top-level sections are inherited by every entry of bots.
+func sharedAdapter(a, b Config) string {
+	switch {
+	case b.Discord.Token != "" && a.Discord.Token == b.Discord.Token:
+		return "discord"
+	case b.Slack.BotToken != "" && a.Slack.BotToken == b.Slack.BotToken:
+		return "slack"
+	case b.Matrix.AccessToken != "" && a.Matrix.AccessToken == b.Matrix.AccessToken:
+		return "matrix"
+	case b.Mattermost.Token != "" && a.Mattermost.Token == b.Mattermost.Token:
+		return "mattermost"
 	}
-	err = json.Unmarshal(file, &s)
-	if err != nil {
-		log.Printf("[ERROR] Failed to parse data.json: %v", err)
-		s.LastMention = time.Time{}
+	return ""
+}
+
+// botConfigs splits the config into one per bot. Every entry of bots starts
+// from the top level settings and overrides what it sets; its storage is
+// data-<bot id>.json unless it sets data_file. Process-wide sections (log,
+// errors, alerts, health, pprof, ha, shutdown) are taken from the top level.
+// Every bot decodes the top level of file afresh, so that the maps and
+// pointers of cfg aren't shared and overridden between bots.
+func botConfigs(file []byte, cfg Config) []Config {
+	if len(cfg.Bots) == 0 {
+		if cfg.DataFile == "" {
+			cfg.DataFile = dataFile
+		}
+		return []Config{cfg}
 	}
-	if s.LastMention.IsZero() {
-		debugLog("Storage loaded: no last mention recorded")
-	} else {
-		debugLog("Storage loaded: lastMention=%s", s.LastMention.Format(time.RFC3339))
+	nodes := cfg.Bots
+	cfg.Bots = nil
+	var out []Config
+	for i, node := range nodes {
+		var bc Config
+		if err := yaml.Unmarshal(file, &bc); err != nil {
+			fatal("Failed to parse config", "file", configFile, "err", err)
+		}
+		bc.Bots, bc.DataFile = nil, ""
+		if err := node.Decode(&bc); err != nil {
+			fatal("Failed to parse bots entry", "index", i, "err", err)
+		}
+		if bc.DataFile == "" {
+			id, _, _ := strings.Cut(bc.BotToken, ":")
+			bc.DataFile = "data-" + id + ".json"
+		}
+		for _, prev := range out {
+			if prev.BotToken == bc.BotToken || prev.DataFile == bc.DataFile {
+				fatal("bots entries must differ in bot_token and data_file", "index", i)
+			}
+		}
+		out = append(out, bc)
 	}
-	return s
+	return out
 }
 
-func saveStorage(s Storage) {
-	debugLog("Saving storage: lastMention=%s", s.LastMention.Format(time.RFC3339))
-	data, err := json.MarshalIndent(s, "", "  ")
-	if err != nil {
-		log.Printf("[ERROR] Failed to serialize JSON: %v", err)
-		return
+// prepareConfig validates the config of one bot and fills in defaults
+func prepareConfig(cfg Config) Config {
+	if err := validLogConfig(cfg.Log); err != nil {
+		fatal(err.Error())
 	}
-	err = os.WriteFile(dataFile, data, 0644)
-	if err != nil {
-		log.Printf("[ERROR] Failed to write data.json: %v", err)
+	cfg.APIURL = strings.TrimRight(cfg.APIURL, "/")
+	if cfg.Proxy != "" && !validProxy(cfg.Proxy) {
+		fatal("Invalid proxy, expected http://, https:// or socks5://host:port", "value", cfg.Proxy)
+	}
+	if len(cfg.Keywords) == 0 {
+		fatal("keywords is empty", "file", configFile)
+	}
+	if cfg.Milestones == nil {
+		cfg.Milestones = defaultMilestones
+	}
+	if cfg.MaxHistory == 0 {
+		cfg.MaxHistory = defaultMaxHistory
+	}
+	if cfg.Weekly.Enabled {
+		if _, err := parseWeekday(cfg.Weekly.Weekday); err != nil {
+			fatal("Invalid weekly.weekday", "value", cfg.Weekly.Weekday, "err", err)
+		}
+		if _, err := parseClock(cfg.Weekly.Time); err != nil {
+			fatal("Invalid weekly.time", "value", cfg.Weekly.Time, "err", err)
+		}
+	}
+	if cfg.Offender.Enabled {
+		if _, err := parseWeekday(cfg.Offender.Weekday); err != nil {
+			fatal("Invalid offender.weekday", "value", cfg.Offender.Weekday, "err", err)
+		}
+		if _, err := parseClock(cfg.Offender.Time); err != nil {
+			fatal("Invalid offender.time", "value", cfg.Offender.Time, "err", err)
+		}
+		if len(cfg.Offender.Templates) == 0 {
+			cfg.Offender.Templates = defaultOffenderTemplates
+		}
+	}
+	if cfg.Nudges.Enabled {
+		if cfg.Nudges.Interval <= 0 {
+			cfg.Nudges.Interval = 72 * time.Hour
+		}
+		if cfg.Nudges.Jitter < 0 || cfg.Nudges.Jitter >= cfg.Nudges.Interval {
+			fatal("nudges.jitter must be between 0 and nudges.interval", "value", cfg.Nudges.Jitter)
+		}
+		if len(cfg.Nudges.Templates) == 0 {
+			cfg.Nudges.Templates = defaultNudgeTemplates
+		}
+	}
+	if cfg.Pinned.Interval <= 0 {
+		cfg.Pinned.Interval = time.Hour
+	}
+	if len(cfg.Templates.Detection) == 0 {
+		cfg.Templates.Detection = defaultTemplates.Detection
+	}
+	if len(cfg.Templates.Reset) == 0 {
+		cfg.Templates.Reset = defaultTemplates.Reset
+	}
+	if len(cfg.Templates.Days) == 0 {
+		cfg.Templates.Days = defaultTemplates.Days
+	}
+	cfg.Location = time.Local
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			fatal("Invalid timezone", "value", cfg.Timezone, "err", err)
+		}
+		cfg.Location = loc
+	}
+	switch cfg.DaysFormat {
+	case "":
+		cfg.DaysFormat = "days"
+	case "days", "combined":
+	default:
+		fatal("Invalid days_format", "value", cfg.DaysFormat)
+	}
+	switch cfg.Media.Mode {
+	case "":
+		cfg.Media.Mode = "along"
+	case "along", "instead":
+	default:
+		fatal("Invalid media.mode", "value", cfg.Media.Mode)
+	}
+	if cfg.ChatInfo.Enabled {
+		switch cfg.ChatInfo.Mode {
+		case "":
+			cfg.ChatInfo.Mode = "description"
+		case "description", "title":
+		default:
+			fatal("Invalid chat_info.mode", "value", cfg.ChatInfo.Mode)
+		}
+		if cfg.ChatInfo.Template == "" {
+			cfg.ChatInfo.Template = "День {count} без {topic}"
+		}
+		if cfg.ChatInfo.MinInterval <= 0 {
+			cfg.ChatInfo.MinInterval = time.Hour
+		}
+	}
+	if h := cfg.Reminders.Hour; h != nil && (*h < 0 || *h > 23) {
+		fatal("reminders.hour must be between 0 and 23", "value", *h)
+	}
+	if cfg.Webhook.Enabled {
+		if cfg.Webhook.URL == "" {
+			fatal("webhook.url is required in webhook mode")
+		}
+		if cfg.Webhook.Listen == "" {
+			cfg.Webhook.Listen = ":8443"
+		}
+		if (cfg.Webhook.Cert == "") != (cfg.Webhook.Key == "") && !cfg.Webhook.BehindProxy {
+			fatal("webhook.cert and webhook.key must be set together")
+		}
+		if cfg.Webhook.SelfSigned && cfg.Webhook.Cert == "" {
+			fatal("webhook.self_signed needs webhook.cert")
+		}
+	}
+	if cfg.Health.Enabled && cfg.Health.Listen == "" {
+		cfg.Health.Listen = ":8080"
+	}
+	// also used by the systemd watchdog
+	if cfg.Health.MaxPollAge <= 0 {
+		cfg.Health.MaxPollAge = 2 * time.Minute
+	}
+	if cfg.API.Enabled {
+		if cfg.API.Listen == "" {
+			cfg.API.Listen = ":8090"
+		}
+		if len(cfg.API.Tokens) == 0 || slices.Contains(cfg.API.Tokens, "") {
+			fatal("api.tokens must list at least one non-empty token")
+		}
+		if cfg.API.Badges && len(cfg.API.BadgeChats) == 0 {
+			fatal("api.badges needs api.badge_chats, the chats whose badge may be public")
+		}
+		if cfg.API.Feeds && len(cfg.API.FeedChats) == 0 {
+			fatal("api.feeds needs api.feed_chats, the chats whose feed may be public")
+		}
+		if cfg.API.Calendars && len(cfg.API.CalendarChats) == 0 {
+			fatal("api.calendars needs api.calendar_chats, the chats whose calendar may be public")
+		}
+	}
+	if cfg.StaticPage.Dir != "" {
+		if len(cfg.StaticPage.Chats) == 0 {
+			fatal("static_page needs chats, the chats whose page may be public")
+		}
+		if cfg.StaticPage.Index == "" {
+			cfg.StaticPage.Index = "index.html"
+		}
+	}
+	if cfg.Export.Enabled {
+		if cfg.Export.Schedule == "" {
+			cfg.Export.Schedule = "0 3 * * *"
+		}
+		if _, err := parseCron(cfg.Export.Schedule); err != nil {
+			fatal("Invalid export.schedule", "value", cfg.Export.Schedule, "err", err)
+		}
+		if len(cfg.Export.Formats) == 0 {
+			cfg.Export.Formats = []string{"csv", "json"}
+		}
+		for _, f := range cfg.Export.Formats {
+			if f != "csv" && f != "json" {
+				fatal("Invalid export.formats, expected csv or json", "value", f)
+			}
+		}
+		if s3 := &cfg.Export.S3; s3.Bucket != "" {
+			if u, err := url.Parse(s3.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				fatal("Invalid export.s3.endpoint, expected an http(s) URL", "value", s3.Endpoint)
+			}
+			if s3.AccessKey == "" || s3.SecretKey == "" {
+				fatal("export.s3 needs access_key and secret_key")
+			}
+			if s3.Region == "" {
+				s3.Region = "us-east-1"
+			}
+		} else if cfg.Export.Dir == "" {
+			cfg.Export.Dir = "exports"
+		}
+	}
+	if cfg.MiniApp.URL != "" {
+		if u, err := url.Parse(cfg.MiniApp.URL); err != nil || u.Scheme != "https" || u.Host == "" {
+			fatal("mini_app.url must be an https URL", "url", cfg.MiniApp.URL)
+		}
+		if !cfg.API.Enabled {
+			fatal("mini_app.url needs api.enabled, the app is served by the API server")
+		}
+		if cfg.MiniApp.Button == "" {
+			cfg.MiniApp.Button = "Счётчик"
+		}
+	}
+	if cfg.GRPC.Enabled {
+		if cfg.GRPC.Listen == "" {
+			cfg.GRPC.Listen = ":8093"
+		}
+		if cfg.GRPC.Cert == "" || cfg.GRPC.Key == "" {
+			fatal("grpc needs cert and key, HTTP/2 is served over TLS only")
+		}
+		if len(cfg.GRPC.Tokens) == 0 || slices.Contains(cfg.GRPC.Tokens, "") {
+			fatal("grpc.tokens must list at least one non-empty token")
+		}
+	}
+	if cfg.Admin.Enabled {
+		if cfg.Admin.Listen == "" {
+			cfg.Admin.Listen = "127.0.0.1:8092"
+		}
+		if len(cfg.Admin.Users) == 0 {
+			fatal("admin.users must list at least one login")
+		}
+		for user, pass := range cfg.Admin.Users {
+			if len(pass) < 12 {
+				fatal("admin.users passwords must be at least 12 characters", "user", user)
+			}
+		}
+		if cfg.Admin.BackupDir == "" {
+			cfg.Admin.BackupDir = "backups"
+		}
+	}
+	if cfg.Slack.BotToken != "" {
+		if cfg.Slack.SigningSecret == "" {
+			fatal("slack.signing_secret is required with slack.bot_token")
+		}
+		if cfg.Slack.Listen == "" {
+			cfg.Slack.Listen = ":8091"
+		}
+	}
+	if cfg.Matrix.AccessToken != "" {
+		if u, err := url.Parse(cfg.Matrix.Homeserver); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			fatal("Invalid matrix.homeserver, expected https://host", "value", cfg.Matrix.Homeserver)
+		}
+		cfg.Matrix.Homeserver = strings.TrimSuffix(cfg.Matrix.Homeserver, "/")
+	}
+	if err := validLLM(&cfg.LLM); err != nil {
+		fatal(err.Error())
+	}
+	if err := validClassifier(&cfg.Classifier, cfg.LLM); err != nil {
+		fatal(err.Error())
+	}
+	if err := validVoice(&cfg.Voice); err != nil {
+		fatal(err.Error())
+	}
+	if cfg.Mattermost.Token != "" {
+		if u, err := url.Parse(cfg.Mattermost.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			fatal("Invalid mattermost.url, expected https://host", "value", cfg.Mattermost.URL)
+		}
+		cfg.Mattermost.URL = strings.TrimSuffix(cfg.Mattermost.URL, "/")
+	}
+	if err := validHooks(cfg.Hooks); err != nil {
+		fatal(err.Error())
+	}
+	if cfg.Push.Enabled {
+		if cfg.Push.NtfyURL == "" {
+			cfg.Push.NtfyURL = "https://ntfy.sh"
+		}
+		if u, err := url.Parse(cfg.Push.NtfyURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fatal("Invalid push.ntfy_url, expected https://host", "value", cfg.Push.NtfyURL)
+		}
+		cfg.Push.NtfyURL = strings.TrimSuffix(cfg.Push.NtfyURL, "/")
+	}
+	if cfg.Email.Host != "" {
+		if err := validEmail(&cfg.Email); err != nil {
+			fatal(err.Error())
+		}
+	}
+	if cfg.Influx.URL != "" {
+		if u, err := url.Parse(cfg.Influx.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fatal("Invalid influx.url, expected the http(s) write endpoint", "value", cfg.Influx.URL)
+		}
+		if cfg.Influx.Interval <= 0 {
+			cfg.Influx.Interval = time.Minute
+		}
+	}
+	if cfg.MQTT.Broker != "" {
+		if err := validMQTT(cfg.MQTT); err != nil {
+			fatal(err.Error())
+		}
+		if cfg.MQTT.EventsTopic == "" {
+			cfg.MQTT.EventsTopic = "dayswithout/{chat}/{counter}/event"
+		}
+		if cfg.MQTT.DaysTopic == "" {
+			cfg.MQTT.DaysTopic = "dayswithout/{chat}/{counter}/days"
+		}
+		if cfg.MQTT.AttributesTopic == "" {
+			cfg.MQTT.AttributesTopic = "dayswithout/{chat}/{counter}/attributes"
+		}
+		if cfg.MQTT.DiscoveryPrefix == "" {
+			cfg.MQTT.DiscoveryPrefix = "homeassistant"
+		}
+	}
+	if cfg.Pprof.Enabled {
+		if cfg.Pprof.Listen == "" {
+			cfg.Pprof.Listen = "127.0.0.1:6060"
+		}
+		if !isLoopback(cfg.Pprof.Listen) {
+			fatal("pprof.listen must be a loopback address", "value", cfg.Pprof.Listen)
+		}
+	}
+	if cfg.Retry.Attempts <= 0 {
+		cfg.Retry.Attempts = 3
+	}
+	if cfg.Retry.MaxDelay <= 0 {
+		cfg.Retry.MaxDelay = 30 * time.Second
+	}
+	if cfg.HA.Redis != "" {
+		if u, err := url.Parse(cfg.HA.Redis); err != nil || u.Scheme != "redis" || u.Host == "" {
+			fatal("Invalid ha.redis, expected redis://host:port", "value", cfg.HA.Redis)
+		}
+		if cfg.HA.Key == "" {
+			cfg.HA.Key = "dayswithout:leader"
+		}
+		if cfg.HA.TTL <= 0 {
+			cfg.HA.TTL = 6 * time.Second
+		}
+	}
+	if err := validPolling(cfg.Polling); err != nil {
+		fatal(err.Error())
+	}
+	if cfg.Polling.Timeout <= 0 {
+		cfg.Polling.Timeout = 10 * time.Second
+	}
+	if len(cfg.Polling.AllowedUpdates) == 0 {
+		cfg.Polling.AllowedUpdates = handledUpdates(cfg)
+	}
+	if cfg.Audit.Enabled {
+		if cfg.Audit.File == "" {
+			cfg.Audit.File = strings.TrimSuffix(cfg.DataFile, ".json") + "-audit.jsonl"
+		}
+		if cfg.Audit.Keep <= 0 {
+			cfg.Audit.Keep = 1000
+		}
+	}
+	if cfg.Watchdog.Enabled && cfg.Watchdog.Stall <= 0 {
+		cfg.Watchdog.Stall = 5 * time.Minute
 	}
+	if cfg.Capture.Enabled {
+		if cfg.Capture.File == "" {
+			cfg.Capture.File = strings.TrimSuffix(cfg.DataFile, ".json") + "-updates.jsonl"
+		}
+		if cfg.Capture.Size <= 0 {
+			cfg.Capture.Size = 200
+		}
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 16
+	}
+	if cfg.HandlerTimeout <= 0 {
+		cfg.HandlerTimeout = 30 * time.Second
+	}
+	if cfg.Shutdown.Timeout <= 0 {
+		cfg.Shutdown.Timeout = 10 * time.Second
+	}
+	if cfg.Queue.PerChat <= 0 {
+		cfg.Queue.PerChat = time.Second
+	}
+	if cfg.Queue.GlobalRate <= 0 {
+		cfg.Queue.GlobalRate = 30
+	}
+	if cfg.Alerts.AdminID != 0 {
+		if cfg.Alerts.MinInterval <= 0 {
+			cfg.Alerts.MinInterval = 10 * time.Minute
+		}
+		if cfg.Alerts.StorageFailures <= 0 {
+			cfg.Alerts.StorageFailures = 3
+		}
+	}
+	if cfg.Channels.Enabled {
+		switch cfg.Channels.Mode {
+		case "":
+			cfg.Channels.Mode = "comment"
+		case "comment", "pinned":
+		default:
+			fatal("Invalid channels.mode", "value", cfg.Channels.Mode)
+		}
+	}
+	for _, group := range cfg.Links {
+		if len(group) < 2 {
+			fatal("Every group of links needs at least two chats", "group", group)
+		}
+	}
+	if cfg.Monthly.Enabled {
+		if _, err := parseClock(cfg.Monthly.Time); err != nil {
+			fatal("Invalid monthly.time", "value", cfg.Monthly.Time, "err", err)
+		}
+	}
+	if cfg.Digest.Enabled {
+		if _, err := parseClock(cfg.Digest.Time); err != nil {
+			fatal("Invalid digest.time", "value", cfg.Digest.Time, "err", err)
+		}
+	}
+	slog.Info("Config loaded", "topic", cfg.Topic, "keywords", len(cfg.Keywords), "data_file", cfg.DataFile, "debug", cfg.Debug)
+	return cfg
 }
 
 func buildKeywordRegex(words []string, noSuffix []string) *regexp.Regexp {
@@ -112,9 +618,7 @@ func buildKeywordRegex(words []string, noSuffix []string) *regexp.Regexp {
 
 		key := strings.ToLower(w)
 
-		quoted := regexp.QuoteMeta(w)
-
-		quoted = regexp.MustCompile(`\\ +`).ReplaceAllString(quoted, `\s+`)
+		quoted := keywordStem(w)
 
 		suffix := `[\p{L}\p{N}_]*`
 		if noSuffixSet[key] {
@@ -128,95 +632,522 @@ func buildKeywordRegex(words []string, noSuffix []string) *regexp.Regexp {
 	return regexp.MustCompile(pattern)
 }
 
+// keywordStem is the pattern of w itself: literal, with any run of
+// whitespace where w has spaces
+func keywordStem(w string) string {
+	return regexp.MustCompile(` +`).ReplaceAllString(regexp.QuoteMeta(w), `\s+`)
+}
+
+// fromBot reports whether msg was written by a bot, including inline results
+// posted through one
+func fromBot(msg *tb.Message) bool {
+	return (msg.Sender != nil && msg.Sender.IsBot) || msg.Via != nil
+}
+
 func findKeyword(text string, re *regexp.Regexp) string {
 	m := re.FindStringSubmatch(text)
 	if len(m) >= 2 && m[1] != "" {
-		debugLog("Keyword matched: %q in message=%q", m[1], text)
+		slog.Debug("Keyword matched", "keyword", m[1], "text", text)
 		return m[1]
 	}
-	debugLog("No keyword matched in message=%q", text)
+	slog.Debug("No keyword matched", "text", text)
 	return ""
 }
 
+// counterRegexCache holds the matchers of runtime counters by their
+// keywords, at most maxCounterRegexes of them
+var counterRegexCache = struct {
+	mu    sync.Mutex
+	items map[string]*regexp.Regexp
+}{items: make(map[string]*regexp.Regexp)}
+
+// counterRegex returns the cached matcher for the keywords of a runtime
+// counter. A full cache drops a random entry, which is rebuilt when its
+// counter is matched again.
+func counterRegex(keywords []string) *regexp.Regexp {
+	key := strings.Join(keywords, "\x00")
+	counterRegexCache.mu.Lock()
+	defer counterRegexCache.mu.Unlock()
+	if re, ok := counterRegexCache.items[key]; ok {
+		return re
+	}
+	if len(counterRegexCache.items) >= maxCounterRegexes {
+		for k := range counterRegexCache.items {
+			delete(counterRegexCache.items, k)
+			break
+		}
+	}
+	re := buildKeywordRegex(keywords, nil)
+	counterRegexCache.items[key] = re
+	return re
+}
+
+// counterTopic is the displayed topic of ctr
+func counterTopic(cfg Config, ctr *Counter) string {
+	if ctr.Topic != "" {
+		return ctr.Topic
+	}
+	return cfg.Topic
+}
+
+// daysText is the counter message shown by /days, using a random variant
+func daysText(cfg Config, ctr *Counter) templated {
+	tpl := pickTemplate(cfg.Templates.Days)
+	return templated{Text: formatDays(cfg, ctr, tpl), Template: tpl}
+}
+
+// formatDays renders a /days template; messages that get edited in place
+// pass a fixed template so the text only changes with the count
+func formatDays(cfg Config, ctr *Counter, tpl string) string {
+	topic := counterTopic(cfg, ctr)
+	if ctr.LastMention.IsZero() {
+		return fmt.Sprintf("Ещё ни разу не упоминали '%s'.", topic)
+	}
+	text := renderTemplate(tpl, map[string]string{
+		"topic":     topic,
+		"days":      strconv.Itoa(ctr.Days()),
+		"days_text": formatStreak(ctr.Streak(), cfg.DaysFormat == "combined"),
+		"count":     strconv.Itoa(ctr.Days()),
+		"last":      ctr.LastMention.Format("02.01.2006 15:04:05"),
+	})
+	if ctr.Paused() {
+		text += "\n⏸ На паузе с " + ctr.PausedAt.Format("02.01.2006 15:04") + ", счёт остановлен."
+	}
+	return text
+}
+
 func main() {
-	cfg := loadConfig()
-	isDebug = cfg.Debug
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "doctor":
+			os.Exit(runDoctor())
+		case "replay":
+			os.Exit(runReplay(os.Args[2:]))
+		case "simulate":
+			os.Exit(runSimulate(os.Args[2:]))
+		case "bench":
+			os.Exit(runBench(os.Args[2:]))
+		}
+	}
+	configs := loadConfig()
+	cfg := configs[0]
+	setupLogging(cfg)
+	ver, rev, date := buildInfo()
+	slog.Info("Starting dayswithout", "version", ver, "commit", rev, "build_date", date, "go", runtime.Version())
+	if cfg.Errors.SentryDSN != "" || cfg.Errors.WebhookURL != "" {
+		r, err := newErrorReporter(cfg.Errors)
+		if err != nil {
+			fatal("Failed to set up error reporting", "err", err)
+		}
+		reporter = r
+	}
+	if len(cfg.Hooks) > 0 {
+		eventSinks = append(eventSinks, newWebhookSink(cfg.Hooks))
+	}
+	if cfg.Email.Host != "" {
+		eventSinks = append(eventSinks, newEmailSink(cfg.Email))
+	}
+	if cfg.MQTT.Broker != "" {
+		mqttOut = newMQTTPublisher(cfg.MQTT)
+		eventSinks = append(eventSinks, mqttOut)
+	}
+	if cfg.Influx.URL != "" {
+		influxOut = newInfluxWriter(cfg.Influx)
+		eventSinks = append(eventSinks, influxOut)
+	}
+	if cfg.Sheets.SpreadsheetID != "" {
+		w, err := newSheetsWriter(cfg.Sheets)
+		if err != nil {
+			fatal("Failed to set up Google Sheets", "err", err)
+		}
+		sheetsOut = w
+		eventSinks = append(eventSinks, sheetsOut)
+	}
+
+	// a standby replica only reads storage once it takes over
+	var lock *leaderLock
+	if cfg.HA.Redis != "" {
+		lock = newLeaderLock(cfg.HA)
+		leadership = lock
+		lock.Acquire()
+		go lock.Keep()
+	}
+
+	sched := &Scheduler{}
+	var bots []*botInstance
+	for _, bc := range configs {
+		bi := newBotInstance(bc)
+		bi.schedule(sched, len(configs) > 1)
+		bots = append(bots, bi)
+		if bc.Discord.Token != "" {
+			go newDiscordAdapter(bc, bi.store).run()
+		}
+		if bc.Slack.BotToken != "" {
+			newSlackAdapter(bc, bi.store).start()
+		}
+		if bc.Matrix.AccessToken != "" {
+			go newMatrixAdapter(bc, bi.store).run()
+		}
+		if bc.Mattermost.Token != "" {
+			go newMattermostAdapter(bc, bi.store).run()
+		}
+	}
+	if lock != nil {
+		// the other leader wrote the storage while this replica waited
+		lock.OnAcquire(func() {
+			for _, bi := range bots {
+				bi.store.Reload()
+			}
+		})
+	}
+	if cfg.Push.Enabled {
+		eventSinks = append(eventSinks, newPushSink(cfg.Push, bots))
+	}
+	if cfg.Alerts.AdminID != 0 {
+		alerts = newAlerter(bots[0].bot, cfg.Alerts)
+		sched.Every("alerts", time.Minute, alerts.Flush)
+	}
+	sched.Start()
+
+	if cfg.Health.Enabled {
+		startHealthServer(cfg, bots)
+	}
+	if cfg.API.Enabled {
+		startAPIServer(cfg, bots)
+	}
+	if cfg.Admin.Enabled {
+		startAdminServer(cfg, bots)
+	}
+	if cfg.GRPC.Enabled {
+		startGRPCServer(cfg, bots)
+	}
+	if cfg.Pprof.Enabled {
+		startPprof(cfg)
+	}
+
+	go stopOnSignal(bots, cfg)
+	go reloadOnSignal(bots, cfg)
+	notifySystemd(cfg)
+	slog.Info("Bot started, waiting for updates", "bots", len(bots))
+	var wg sync.WaitGroup
+	for _, bi := range bots {
+		wg.Add(1)
+		go func(bi *botInstance) {
+			defer wg.Done()
+			bi.run()
+		}(bi)
+	}
+	wg.Wait()
+	shutdown(bots, lock)
+}
 
-	keywordRe := buildKeywordRegex(cfg.Keywords, cfg.NoSuffix)
+// botInstance is one bot identity with its own config and storage. The
+// state outlives the telebot instance, which is replaced when the token is
+// rotated or polling stalls (see reconnect).
+type botInstance struct {
+	cfg        Config
+	store      *Store
+	capture    *updateCapture
+	audit      *auditLog
+	prompts    *slidingLimiter
+	detections *userLimit
+	commands   *userLimit
+	// handling is the deadline of the update handled per chat
+	handling chatDeadlines
 
-	storage := loadStorage()
+	// lastPoll is the unix nano time of the last successful getUpdates of
+	// this bot, see watchPolling
+	lastPoll atomic.Int64
+	restarts int
 
-	pref := tb.Settings{
-		Token:  cfg.BotToken,
-		Poller: &tb.LongPoller{Timeout: 10 * time.Second},
+	// mu guards the fields below
+	mu     sync.Mutex
+	b      *tb.Bot
+	poller *drainPoller
+	// core is built on the first connect and kept by the later ones
+	core    *frontendCore
+	token   string
+	stopped bool
+}
+
+// newBotInstance connects a bot and registers its handlers
+func newBotInstance(cfg Config) *botInstance {
+	store := loadStorage(cfg.DataFile)
+	store.maxHistory = cfg.MaxHistory
+
+	bi := &botInstance{
+		cfg:        cfg,
+		store:      store,
+		prompts:    newSlidingLimiter(cfg.PromptsPerHour, time.Hour),
+		detections: newUserLimit(cfg.DetectionsPerMinute),
+		commands:   newUserLimit(cfg.CommandsPerMinute),
+		token:      cfg.BotToken,
+	}
+	if cfg.Capture.Enabled {
+		bi.capture = newUpdateCapture(cfg.Capture, cfg.BotToken)
 	}
+	if cfg.Audit.Enabled {
+		bi.audit = newAuditLog(cfg.Audit)
+	}
+	if cfg.APIURL != "" {
+		slog.Info("Using custom Bot API server", "url", cfg.APIURL)
+	}
+
+	slog.Info("Initializing bot")
+	b, poller, err := bi.connect(cfg.BotToken)
+	if err != nil {
+		fatal("Failed to init bot", "err", err)
+	}
+	bi.b, bi.poller = b, poller
+	slog.Info("Authorized", "username", b.Me.Username, "id", b.Me.ID)
+	if cfg.MiniApp.URL != "" {
+		setMiniAppButton(b, cfg.MiniApp)
+	}
+	return bi
+}
 
-	log.Println("[INFO] Initializing bot...")
-	b, err := tb.NewBot(pref)
+// connect creates a telebot instance for token with the handlers registered
+func (bi *botInstance) connect(token string) (*tb.Bot, *drainPoller, error) {
+	poller := newDrainPoller(newPoller(bi.cfg))
+	client := newHTTPClient(bi.cfg)
+	client.Transport = pollTracker{next: client.Transport, last: &bi.lastPoll}
+	client.Transport = deadlineTransport{next: client.Transport, chats: &bi.handling}
+	client.Transport = auditTransport{next: client.Transport, log: bi.audit}
+	b, err := tb.NewBot(tb.Settings{
+		URL:         bi.cfg.APIURL,
+		Token:       token,
+		Poller:      newChatQueuePoller(dedupPoller(capturePoller(poller, bi.capture), bi.store), bi.cfg.Workers),
+		Synchronous: true,
+		Client:      client,
+		OnError:     onError,
+	})
 	if err != nil {
-		log.Fatalf("[FATAL] Failed to init bot: %v", err)
+		return nil, nil, err
 	}
+	bi.register(b)
+	return b, poller, nil
+}
 
-	log.Printf("[INFO] Authorized as @%s (id=%d)", b.Me.Username, b.Me.ID)
+// bot is the current telebot instance
+func (bi *botInstance) bot() *tb.Bot {
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+	return bi.b
+}
+
+// frontend is the core of the Telegram handlers, nil before the first
+// connect
+func (bi *botInstance) frontend() *frontendCore {
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+	return bi.core
+}
+
+// run handles updates until the bot is stopped; when Start returns because
+// the instance was replaced, the replacement is started
+func (bi *botInstance) run() {
+	for {
+		b := bi.bot()
+		b.Start()
+		if bi.bot() == b {
+			return
+		}
+	}
+}
+
+// register sets up the middleware and the handlers of b
+func (bi *botInstance) register(b *tb.Bot) {
+	cfg, store, capture := bi.cfg, bi.store, bi.capture
+	bi.mu.Lock()
+	if bi.core == nil {
+		bi.core = newFrontendCore(b.Me.Username, "/", cfg, store)
+		bi.core.prompts = bi.prompts
+	}
+	telegram := &telegramFrontend{b: b, cfg: cfg, store: store, detections: bi.detections, core: bi.core}
+	bi.mu.Unlock()
+	keywordRe := telegram.core.keywordRe
+
+	b.Use(
+		trackInflight,
+		withLogger,
+		leaderOnly,
+		withContext(cfg.HandlerTimeout, &bi.handling),
+		recoverPanics,
+		allowChats(cfg.AllowedChats),
+		logCommands,
+		limitCommands(bi.commands),
+		forumThreads,
+	)
+
+	b.Handle("/start", handleStart(cfg, store))
+	b.Handle(tb.OnMigration, handleMigration(store))
+	b.Handle(tb.OnMyChatMember, handleMyChatMember(store))
 
 	// Handle /days
 	b.Handle("/days", func(c tb.Context) error {
-		log.Printf("[INFO] Command /days from user=%s chat=%d", c.Sender().Username, c.Chat().ID)
-		if storage.LastMention.IsZero() {
-			return c.Send(fmt.Sprintf("Ещё ни разу не упоминали '%s'.", cfg.Topic))
-		}
-		days := int(time.Since(storage.LastMention).Hours() / 24)
-		text := fmt.Sprintf(
-			"%d дней без упоминания %s.\nПоследнее упоминание было: %s",
-			days, cfg.Topic, storage.LastMention.Format("02.01.2006 15:04:05"),
-		)
+		name := strings.ToLower(strings.TrimSpace(c.Message().Payload))
+		var st ChatState
+		var extra []string
+		var ctr *Counter
+		err := store.ViewContext(ctxOf(c), func(s *Storage) {
+			chat := s.Chat(c.Chat().ID)
+			st = *chat
+			if ctr = chat.CounterByName(name); ctr != nil {
+				cp := *ctr
+				ctr = &cp
+			}
+			if name != "" {
+				return
+			}
+			for _, n := range chat.CounterNames()[1:] {
+				x := chat.Counters[n]
+				extra = append(extra, daysText(cfg, x).Text)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if ctr == nil {
+			return c.Send("Нет такого счётчика. Список: /counters")
+		}
+		text := daysText(cfg, ctr)
+		if len(extra) > 0 {
+			text.Text += "\n\n" + strings.Join(extra, "\n\n")
+		}
+		if cfg.ImageMode && name == "" {
+			card, err := renderCard(cfg, &st)
+			if err == nil {
+				return c.Send(photoFromPNG(card, text.Text))
+			}
+			ctxLogger(c).Error("Failed to render counter card", "err", err)
+		}
 		return c.Send(text)
 	})
 
-	// Handle /reset
-	b.Handle("/reset", func(c tb.Context) error {
-		log.Printf("[INFO] Command /reset from user=%s chat=%d", c.Sender().Username, c.Chat().ID)
-		
-		// previous mention info
-		prevLastMention := storage.LastMention
-		prevText := "никогда"
-		if !prevLastMention.IsZero() {
-		  prevText = prevLastMention.Format("02.01.2006 15:04:05")
-		}
-		daysWas := 0
-		if !prevLastMention.IsZero() {
-		  daysWas = int(time.Since(prevLastMention).Hours() / 24)
-		}
-		
-		storage.LastMention = time.Now()
-		saveStorage(storage)
-		text := fmt.Sprintf("Кто-то что-то написал про %s %s 💀💀💀 запомнили, мы продержались %d дней.\nПоследнее упоминание до этого было: %s",
-			cfg.Topic, storage.LastMention.Format("02.01.2006 15:04:05"), daysWas, prevText,
-		)
-		return c.Send(text)
-	})
+	b.Handle("/reset", handleReset(telegram))
+	b.Handle(&approveResetBtn, handleApproveReset(telegram))
+
+	b.Handle("/newcounter", handleNewCounter(store), requireAdmin(b, "Создавать счётчики"))
+	b.Handle("/delcounter", handleDelCounter(store), requireAdmin(b, "Удалять счётчики"))
+	b.Handle("/counters", handleCounters(cfg, store))
+	b.Handle("/rename", handleRename(cfg, store), requireAdmin(b, "Переименовывать счётчики"))
+	b.Handle("/pause", handlePause(cfg, store), requireAdmin(b, "Ставить счётчик на паузу"))
+	b.Handle("/resume", handleResume(cfg, store), requireAdmin(b, "Снимать счётчик с паузы"))
+	b.Handle("/since", handleSince(cfg, store))
+	b.Handle("/timezone", handleTimezone(cfg, store), requireAdmin(b, "Менять часовой пояс"))
+	b.Handle("/reminders", handleReminders(cfg, store), requireAdmin(b, "Включать напоминания"))
+	if cfg.Push.Enabled {
+		b.Handle("/push", handlePush(b, cfg, store), requireAdmin(b, "Настраивать уведомления"))
+	}
+	b.Handle("/autoreset", handleAutoReset(cfg, store), requireAdminToChange(b, "Менять режим сброса"))
+	b.Handle("/link", handleLink(b, cfg, store), requireAdmin(b, "Связывать чаты"))
+	b.Handle(&approveLinkBtn, handleApproveLink(b, store), requireAdmin(b, "Принимать общий счётчик"))
+	b.Handle(&declineLinkBtn, handleDeclineLink(store), requireAdmin(b, "Отклонять общий счётчик"))
+	b.Handle("/unlink", handleUnlink(store), requireAdmin(b, "Отвязывать чаты"))
+	b.Handle("/thread", handleThread(store), requireAdminToChange(b, "Менять темы бота"))
+	b.Handle("/pin", handlePin(b, cfg, store), requireAdmin(b, "Закреплять счётчик"))
+	b.Handle("/unpin", handleUnpin(b, store), requireAdmin(b, "Откреплять счётчик"))
+	b.Handle("/chart", handleChart(cfg, store))
+	if cfg.MiniApp.URL != "" {
+		b.Handle("/app", handleMiniApp(cfg))
+	}
+	b.Handle("/heatmap", handleHeatmap(cfg, store))
+	b.Handle("/achievements", handleAchievements(store))
+	b.Handle("/shame", handleShame(store))
+	b.Handle("/history", handleHistory(cfg, store))
+	b.Handle("/whoreset", handleWhoReset(cfg, store))
+	b.Handle("/userstats", handleUserStats(cfg, store))
+	b.Handle("/me", handleMe(store))
+	b.Handle("/optout", handleOptOut(store))
+	b.Handle("/optin", handleOptIn(store))
+	b.Handle("/status", handleStatus(cfg, store), requireOperator(cfg))
+	b.Handle("/version", handleVersion())
+	b.Handle("/updates", handleUpdates(capture), requireOperator(cfg))
+	b.Handle("/reload", handleReload(), requireOperator(cfg))
+	b.Handle("/audit", handleAudit(bi.audit), requireAdmin(b, "Смотреть журнал бота"))
+	b.Handle("/undo", handleUndo(b, bi.audit), requireAdmin(b, "Удалять сообщения бота"))
+
+	// detect matches text, of the message in c or its transcription, against
+	// the counters of the chat
+	detect := func(c tb.Context, text string) error {
+		return telegram.core.Detect(telegram.message(c, text))
+	}
 
 	// Handle all text messages
 	b.Handle(tb.OnText, func(c tb.Context) error {
 		msg := c.Message()
-		debugLog("New text message in chat=%d from=%s text=%q", msg.Chat.ID, msg.Sender.Username, msg.Text)
+		ctxLogger(c).Debug("New text message", "text", msg.Text)
 
-		found := findKeyword(msg.Text, keywordRe)
-		if found != "" {
-			if !storage.LastMention.IsZero() && time.Since(storage.LastMention) < 2*time.Hour {
-				debugLog("Ignoring mention, lastMention=%s (<2h ago)", storage.LastMention.Format(time.RFC3339))
-				return nil
-			}
-			response := fmt.Sprintf(
-				"Кто-то сказал «%s»?\nСбросить счётчик дней без %s? Используйте /reset для подтверждения.",
-				found, cfg.Topic,
-			)
-			log.Printf("[INFO] Triggered by keyword=%q in chat=%d", found, msg.Chat.ID)
-			return c.Send(response)
-		}
-		return nil
+		if commentOnForward(b, msg) {
+			return nil
+		}
+		if cfg.IgnoreBots && fromBot(msg) {
+			ctxLogger(c).Debug("Ignoring message from bot")
+			return nil
+		}
+		return detect(c, msg.Text)
 	})
+	if transcriber := newTranscriber(cfg.Voice); transcriber != nil {
+		b.Handle(tb.OnVoice, handleVoice(b, cfg, transcriber, detect))
+	}
+
+	if cfg.Channels.Enabled {
+		b.Handle(tb.OnChannelPost, handleChannelPost(b, cfg, store, keywordRe))
+	}
 
-	log.Println("[INFO] Bot started, waiting for updates...")
-	b.Start()
+}
+
+// schedule registers the background jobs of the bot, named after it when
+// several bots share the scheduler
+func (bi *botInstance) schedule(sched *Scheduler, named bool) {
+	every := func(name string, interval time.Duration, fn func(now time.Time)) {
+		if named {
+			name += "@" + bi.bot().Me.Username
+		}
+		sched.Every(name, interval, fn)
+	}
+	every("announcements", time.Minute, func(now time.Time) { sendAnnouncements(bi.bot(), bi.store, now) })
+	every("milestones", time.Minute, func(time.Time) { checkMilestones(bi.bot(), bi.cfg, bi.store) })
+	every("achievements", time.Minute, func(now time.Time) { checkStreakAchievements(bi.bot(), bi.store, now) })
+	every("reminders", time.Minute, func(now time.Time) { checkReminders(bi.bot(), bi.cfg, bi.store, now) })
+	if bi.cfg.Digest.Enabled {
+		every("digest", time.Minute, func(now time.Time) { checkDigest(bi.bot(), bi.cfg, bi.store, now) })
+	}
+	if bi.cfg.Weekly.Enabled {
+		every("weekly", time.Minute, func(now time.Time) { checkWeekly(bi.bot(), bi.cfg, bi.store, now) })
+	}
+	if bi.cfg.Nudges.Enabled {
+		every("nudges", time.Minute, func(now time.Time) { checkNudges(bi.bot(), bi.cfg, bi.store, now) })
+	}
+	if bi.cfg.Offender.Enabled {
+		every("offender", time.Minute, func(now time.Time) { checkOffender(bi.bot(), bi.cfg, bi.store, now) })
+	}
+	if mqttOut != nil {
+		every("mqtt", time.Minute, func(time.Time) { mqttOut.PublishState(bi.bot(), bi.cfg, bi.store) })
+	}
+	if influxOut != nil {
+		every("influx", bi.cfg.Influx.Interval, func(now time.Time) { influxOut.WriteStreaks(bi.bot(), bi.store, now) })
+	}
+	if sheetsOut != nil {
+		every("sheets", time.Minute, func(now time.Time) { sheetsOut.Snapshot(bi.bot(), bi.cfg, bi.store, now) })
+	}
+	every("pinned", bi.cfg.Pinned.Interval, func(time.Time) { refreshPinned(bi.bot(), bi.cfg, bi.store) })
+	if bi.cfg.StaticPage.Dir != "" {
+		every("static_page", time.Minute, func(time.Time) { writeStaticPage(bi.cfg, bi.store) })
+	}
+	if bi.cfg.Export.Enabled {
+		every("export", time.Minute, func(now time.Time) { runExport(bi.bot(), bi.cfg, bi.store, now) })
+	}
+	if bi.cfg.ChatInfo.Enabled {
+		every("chat_info", time.Minute, func(now time.Time) { updateChatInfo(bi.bot(), bi.cfg, bi.store, now) })
+	}
+	if bi.cfg.Watchdog.Enabled && !bi.cfg.Webhook.Enabled {
+		every("watchdog", time.Minute, bi.watchPolling)
+	}
+	if bi.cfg.LeftChatRetention > 0 {
+		every("cleanup", time.Hour, func(now time.Time) { cleanupLeftChats(bi.store, bi.cfg.LeftChatRetention, now) })
+	}
+	if bi.cfg.Monthly.Enabled {
+		every("monthly", time.Minute, func(now time.Time) { checkMonthly(bi.bot(), bi.cfg, bi.store, now) })
+	}
 }
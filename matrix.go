@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// MatrixConfig connects the bot to a Matrix homeserver as the account of
+// AccessToken. Every room gets its own counters; the bot joins the rooms it
+// is invited to, or only the listed Rooms when set. Commands are sent as
+// text: !days, !reset, !counters, !history.
+type MatrixConfig struct {
+	Homeserver  string   `yaml:"homeserver"`
+	AccessToken string   `yaml:"access_token"`
+	Rooms       []string `yaml:"rooms"`
+}
+
+const matrixSyncTimeout = 30 * time.Second
+
+// matrixAdapter long-polls /sync and answers in the rooms
+type matrixAdapter struct {
+	cfg    MatrixConfig
+	core   *frontendCore
+	client *http.Client
+	me     string
+	txn    atomic.Int64
+}
+
+func newMatrixAdapter(cfg Config, store *Store) *matrixAdapter {
+	return &matrixAdapter{
+		cfg:    cfg.Matrix,
+		core:   newFrontendCore("matrix", "!", cfg, store),
+		client: &http.Client{Timeout: matrixSyncTimeout + 30*time.Second},
+	}
+}
+
+type matrixEvent struct {
+	Type     string `json:"type"`
+	Sender   string `json:"sender"`
+	EventID  string `json:"event_id"`
+	StateKey string `json:"state_key"`
+	Content  struct {
+		MsgType    string `json:"msgtype"`
+		Body       string `json:"body"`
+		Membership string `json:"membership"`
+	} `json:"content"`
+}
+
+type matrixSync struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []matrixEvent `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+		Invite map[string]json.RawMessage `json:"invite"`
+	} `json:"rooms"`
+}
+
+// run syncs forever. The first sync only sets the position: messages sent
+// while the bot was down are not checked.
+func (m *matrixAdapter) run() {
+	var who struct {
+		UserID string `json:"user_id"`
+	}
+	for {
+		err := m.call(http.MethodGet, "/account/whoami", nil, nil, &who)
+		if err == nil {
+			break
+		}
+		slog.Error("Failed to log in to Matrix, retrying", "err", err)
+		time.Sleep(time.Minute)
+	}
+	m.me = who.UserID
+	slog.Info("Connected to Matrix", "user_id", m.me)
+
+	since := ""
+	delay := time.Second
+	for {
+		q := url.Values{"timeout": {strconv.Itoa(int(matrixSyncTimeout.Milliseconds()))}}
+		if since != "" {
+			q.Set("since", since)
+		} else {
+			q.Set("filter", `{"room":{"timeline":{"limit":1}}}`)
+		}
+		var res matrixSync
+		if err := m.call(http.MethodGet, "/sync", q, nil, &res); err != nil {
+			slog.Warn("Matrix sync failed, retrying", "err", err, "in", delay)
+			time.Sleep(delay)
+			delay = min(2*delay, 2*time.Minute)
+			continue
+		}
+		delay = time.Second
+		first := since == ""
+		since = res.NextBatch
+		for room := range res.Rooms.Invite {
+			m.join(room)
+		}
+		if first {
+			continue
+		}
+		for room, joined := range res.Rooms.Join {
+			for _, ev := range joined.Timeline.Events {
+				m.handle(room, ev)
+			}
+		}
+	}
+}
+
+func (m *matrixAdapter) allowed(room string) bool {
+	return len(m.cfg.Rooms) == 0 || slices.Contains(m.cfg.Rooms, room)
+}
+
+func (m *matrixAdapter) join(room string) {
+	if !m.allowed(room) {
+		slog.Info("Ignoring Matrix invite to a room not in matrix.rooms", "room", room)
+		return
+	}
+	if err := m.call(http.MethodPost, "/rooms/"+url.PathEscape(room)+"/join", nil, map[string]any{}, nil); err != nil {
+		slog.Error("Failed to join Matrix room", "room", room, "err", err)
+		return
+	}
+	slog.Info("Joined Matrix room", "room", room)
+}
+
+func (m *matrixAdapter) handle(room string, ev matrixEvent) {
+	if ev.Type != "m.room.message" || ev.Content.MsgType != "m.text" || ev.Sender == m.me || !m.allowed(room) {
+		return
+	}
+	p := &matrixMessage{m: m, room: room, ev: ev}
+	var err error
+	if cmd, args, ok := splitCommand(ev.Content.Body, "!"); ok {
+		err = m.core.Command(p, cmd, args)
+	} else {
+		err = m.core.Detect(p)
+	}
+	if err != nil {
+		slog.Error("Failed to send Matrix message", "room", room, "err", err)
+	}
+}
+
+// matrixMessage is a ChatPlatform of a text message in a room. The bot
+// answers with notices, which other bots don't react to.
+type matrixMessage struct {
+	m    *matrixAdapter
+	room string
+	ev   matrixEvent
+}
+
+func (p *matrixMessage) Chat() string  { return "matrix:" + p.room }
+func (p *matrixMessage) Text() string  { return p.ev.Content.Body }
+func (p *matrixMessage) IsAdmin() bool { return false }
+
+func (p *matrixMessage) Sender() chatUser {
+	// MXIDs already start with @
+	return chatUser{ID: externalUserID("matrix", p.ev.Sender), Name: p.ev.Sender}
+}
+
+func (p *matrixMessage) Send(text string) (string, error) {
+	return p.send(map[string]any{"msgtype": "m.notice", "body": text})
+}
+
+func (p *matrixMessage) Reply(text string) (string, error) {
+	return p.send(map[string]any{
+		"msgtype":      "m.notice",
+		"body":         text,
+		"m.relates_to": map[string]any{"m.in_reply_to": map[string]string{"event_id": p.ev.EventID}},
+	})
+}
+
+// Edit sends a replacement event, clients without edit support show the
+// "* " fallback
+func (p *matrixMessage) Edit(ref, text string) error {
+	_, err := p.send(map[string]any{
+		"msgtype":       "m.notice",
+		"body":          "* " + text,
+		"m.new_content": map[string]string{"msgtype": "m.notice", "body": text},
+		"m.relates_to":  map[string]string{"rel_type": "m.replace", "event_id": ref},
+	})
+	return err
+}
+
+func (p *matrixMessage) send(content map[string]any) (string, error) {
+	var sent struct {
+		EventID string `json:"event_id"`
+	}
+	txn := fmt.Sprintf("dw%d.%d", time.Now().UnixNano(), p.m.txn.Add(1))
+	err := p.m.call(http.MethodPut, "/rooms/"+url.PathEscape(p.room)+"/send/m.room.message/"+txn, nil, content, &sent)
+	return sent.EventID, err
+}
+
+// call makes a client-server API request, decoding the response into out
+func (m *matrixAdapter) call(method, path string, query url.Values, in, out any) error {
+	u := m.cfg.Homeserver + "/_matrix/client/v3" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.cfg.AccessToken)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MattermostConfig runs the bot on a Mattermost server as the bot account
+// of Token. Every channel the bot is added to gets its own counters, only
+// the listed Channels (IDs) when set. Commands are sent as text: !days,
+// !reset, !counters, !history.
+type MattermostConfig struct {
+	URL      string   `yaml:"url"`
+	Token    string   `yaml:"token"`
+	Channels []string `yaml:"channels"`
+}
+
+// mattermostAdapter listens to the websocket events of the server and
+// answers posts through the REST API
+type mattermostAdapter struct {
+	cfg    MattermostConfig
+	core   *frontendCore
+	client *http.Client
+	me     string
+}
+
+func newMattermostAdapter(cfg Config, store *Store) *mattermostAdapter {
+	return &mattermostAdapter{
+		cfg:    cfg.Mattermost,
+		core:   newFrontendCore("mattermost", "!", cfg, store),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// run keeps a websocket session up, reconnecting with a growing delay
+func (m *mattermostAdapter) run() {
+	delay := time.Second
+	for {
+		started := time.Now()
+		err := m.session()
+		if time.Since(started) > time.Minute {
+			delay = time.Second
+		}
+		slog.Warn("Mattermost session ended, reconnecting", "err", err, "in", delay)
+		time.Sleep(delay)
+		delay = min(2*delay, 2*time.Minute)
+	}
+}
+
+// session runs one websocket connection until it fails. Posts made while
+// disconnected are not checked.
+func (m *mattermostAdapter) session() error {
+	var me struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	}
+	if err := m.call(http.MethodGet, "/users/me", nil, &me); err != nil {
+		return fmt.Errorf("get bot user: %w", err)
+	}
+	m.me = me.ID
+
+	// https://host → wss://host, http://host → ws://host
+	conn, err := dialWebsocket("ws" + strings.TrimPrefix(m.cfg.URL, "http") + "/api/v4/websocket")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	auth, err := json.Marshal(map[string]any{
+		"seq":    1,
+		"action": "authentication_challenge",
+		"data":   map[string]string{"token": m.cfg.Token},
+	})
+	if err != nil {
+		return err
+	}
+	if err := conn.WriteText(auth); err != nil {
+		return err
+	}
+	slog.Info("Connected to Mattermost", "username", me.Username)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go m.keepAlive(conn, stop)
+	for {
+		data, err := conn.ReadText()
+		if err != nil {
+			return err
+		}
+		var ev struct {
+			Event string `json:"event"`
+			Data  struct {
+				// Post is JSON encoded a second time
+				Post       string `json:"post"`
+				SenderName string `json:"sender_name"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(data, &ev); err != nil {
+			slog.Warn("Bad Mattermost event", "err", err)
+			continue
+		}
+		if ev.Event == "posted" {
+			m.posted(ev.Data.Post, ev.Data.SenderName)
+		}
+	}
+}
+
+// keepAlive pings the server, whose proxies tend to drop idle websockets
+func (m *mattermostAdapter) keepAlive(conn *wsConn, stop <-chan struct{}) {
+	t := time.NewTicker(30 * time.Second)
+	defer t.Stop()
+	for seq := 2; ; seq++ {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			if err := conn.WriteText(fmt.Appendf(nil, `{"seq":%d,"action":"ping"}`, seq)); err != nil {
+				slog.Warn("Failed to ping Mattermost", "err", err)
+				conn.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (m *mattermostAdapter) posted(raw, sender string) {
+	var post struct {
+		ID        string `json:"id"`
+		RootID    string `json:"root_id"`
+		UserID    string `json:"user_id"`
+		ChannelID string `json:"channel_id"`
+		Message   string `json:"message"`
+		// Type is empty for user posts, "system_…" for joins and the like
+		Type  string `json:"type"`
+		Props struct {
+			FromBot string `json:"from_bot"`
+		} `json:"props"`
+	}
+	if err := json.Unmarshal([]byte(raw), &post); err != nil {
+		slog.Warn("Bad Mattermost post", "err", err)
+		return
+	}
+	if post.Type != "" || post.UserID == m.me || !m.allowed(post.ChannelID) {
+		return
+	}
+	if post.Props.FromBot == "true" && m.core.cfg.IgnoreBots {
+		return
+	}
+	// answer in the thread of the post
+	root := post.RootID
+	if root == "" {
+		root = post.ID
+	}
+	p := &mattermostPost{
+		m:        m,
+		channel:  post.ChannelID,
+		root:     root,
+		userID:   post.UserID,
+		username: strings.TrimPrefix(sender, "@"),
+		text:     post.Message,
+	}
+	var err error
+	if cmd, args, ok := splitCommand(post.Message, "!"); ok {
+		err = m.core.Command(p, cmd, args)
+	} else {
+		err = m.core.Detect(p)
+	}
+	if err != nil {
+		slog.Error("Failed to send Mattermost post", "channel_id", post.ChannelID, "err", err)
+	}
+}
+
+// mattermostPost is a ChatPlatform of a post in a channel
+type mattermostPost struct {
+	m             *mattermostAdapter
+	channel, root string
+	userID        string
+	username      string
+	text          string
+}
+
+func (p *mattermostPost) Chat() string  { return "mattermost:" + p.channel }
+func (p *mattermostPost) Text() string  { return p.text }
+func (p *mattermostPost) IsAdmin() bool { return false }
+
+func (p *mattermostPost) Sender() chatUser {
+	return chatUser{ID: externalUserID("mattermost", p.userID), Username: p.username}
+}
+
+func (p *mattermostPost) Send(text string) (string, error) {
+	return p.post(map[string]string{"channel_id": p.channel, "message": text})
+}
+
+func (p *mattermostPost) Reply(text string) (string, error) {
+	return p.post(map[string]string{"channel_id": p.channel, "message": text, "root_id": p.root})
+}
+
+func (p *mattermostPost) post(body map[string]string) (string, error) {
+	var sent struct {
+		ID string `json:"id"`
+	}
+	err := p.m.call(http.MethodPost, "/posts", body, &sent)
+	return sent.ID, err
+}
+
+func (p *mattermostPost) Edit(ref, text string) error {
+	return p.m.call(http.MethodPut, "/posts/"+ref+"/patch", map[string]string{"message": text}, nil)
+}
+
+func (m *mattermostAdapter) allowed(channel string) bool {
+	return len(m.cfg.Channels) == 0 || slices.Contains(m.cfg.Channels, channel)
+}
+
+// call makes a REST API v4 request, decoding the response into out
+func (m *mattermostAdapter) call(method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, m.cfg.URL+"/api/v4"+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.cfg.Token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// shameRank returns the 1-based place of the user with rankKey key in the
+// hall of shame, or 0
+func shameRank(entries []shameEntry, key string) int {
+	for i, e := range entries {
+		if e.key == key {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func handleMe(store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		id := c.Sender().ID
+		who := Event{UserID: id, Username: c.Sender().Username, Name: c.Sender().FirstName}.Who()
+		var us userStats
+		var entries []shameEntry
+		var hidden bool
+		if err := store.ViewContext(ctxOf(c), func(s *Storage) {
+			hidden = s.OptedOut[id]
+			st := s.Chat(c.Chat().ID)
+			us = statsForUser(st, func(ev Event) bool { return ev.UserID == id })
+			entries = hallOfShame(st)
+		}); err != nil {
+			return err
+		}
+		if us.Hits == 0 {
+			return c.Send(fmt.Sprintf("%s, вы ещё ни разу не попадались. Образцовый участник!", who))
+		}
+
+		text := fmt.Sprintf("📋 %s\nСрабатываний: %d (привели к сбросу: %d)\nЧистая серия: %s",
+			who, us.Hits, us.Confirmed, formatStreak(clock().Sub(us.LastHit), true))
+		if hidden {
+			text += "\nВы скрыты из рейтингов (/optout)."
+		} else if rank := shameRank(entries, rankKey(Event{UserID: id})); rank > 0 {
+			text += fmt.Sprintf("\nМесто в зале позора: %d из %d", rank, len(entries))
+		} else {
+			text += "\nВ зале позора вас нет."
+		}
+		return c.Send(text)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// MediaSet lists stickers and GIFs to pick from for one event.
+// Entries are Telegram file IDs; animations may also be http(s) URLs.
+type MediaSet struct {
+	Stickers   []string `yaml:"stickers"`
+	Animations []string `yaml:"animations"`
+}
+
+// MediaConfig configures stickers and GIFs sent on detection and reset
+type MediaConfig struct {
+	// Mode is "along" (text followed by media) or "instead" (media only)
+	Mode      string   `yaml:"mode"`
+	Detection MediaSet `yaml:"detection"`
+	Reset     MediaSet `yaml:"reset"`
+}
+
+func fileRef(ref string) tb.File {
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+		return tb.FromURL(ref)
+	}
+	return tb.File{FileID: ref}
+}
+
+// pick returns a random sticker or animation from the set, or nil if it is empty
+func (m MediaSet) pick() tb.Sendable {
+	n := len(m.Stickers) + len(m.Animations)
+	if n == 0 {
+		return nil
+	}
+	i := rand.Intn(n)
+	if i < len(m.Stickers) {
+		return &tb.Sticker{File: fileRef(m.Stickers[i])}
+	}
+	return &tb.Animation{File: fileRef(m.Animations[i-len(m.Stickers)])}
+}
+
+// empty reports whether the set has nothing to pick from
+func (m MediaSet) empty() bool {
+	return len(m.Stickers)+len(m.Animations) == 0
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// ActiveChats are the Telegram chats the bot is still a member of, the ones
+// scheduled jobs work on
+func (s *Storage) ActiveChats() map[int64]*ChatState {
+	out := make(map[int64]*ChatState, len(s.Chats))
+	for id, st := range s.Chats {
+		if st.Left.IsZero() && !isExternalChat(id) {
+			out[id] = st
+		}
+	}
+	return out
+}
+
+// handleMyChatMember notes when the bot is removed from a chat or comes back
+func handleMyChatMember(store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		upd := c.ChatMember()
+		if upd == nil || upd.NewChatMember == nil {
+			return nil
+		}
+		chatID := upd.Chat.ID
+		switch upd.NewChatMember.Role {
+		case tb.Left, tb.Kicked:
+			ctxLogger(c).Info("Removed from chat, archiving it", "role", upd.NewChatMember.Role)
+			if err := store.UpdateContext(ctxOf(c), func(s *Storage) {
+				if st, ok := s.Chats[chatID]; ok {
+					st.Left = clock()
+				}
+			}); err != nil {
+				return err
+			}
+		case tb.Member, tb.Administrator, tb.Creator, tb.Restricted:
+			if err := store.UpdateContext(ctxOf(c), func(s *Storage) {
+				if st, ok := s.Chats[chatID]; ok && !st.Left.IsZero() {
+					ctxLogger(c).Info("Back in chat, restoring it")
+					st.Left = time.Time{}
+				}
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// cleanupLeftChats deletes the chats the bot left more than retention ago
+func cleanupLeftChats(store *Store, retention time.Duration, now time.Time) {
+	var stale []int64
+	store.View(func(s *Storage) {
+		for id, st := range s.Chats {
+			if !st.Left.IsZero() && now.Sub(st.Left) > retention {
+				stale = append(stale, id)
+			}
+		}
+	})
+	if len(stale) == 0 {
+		return
+	}
+	store.Update(func(s *Storage) {
+		for _, id := range stale {
+			slog.Info("Deleting data of left chat", "chat_id", id, "left", s.Chats[id].Left)
+			delete(s.Chats, id)
+		}
+	})
+}
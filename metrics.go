@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// matcherBuckets are the upper bounds in seconds of the matcher histograms,
+// from a microsecond up to 50ms
+var matcherBuckets = []float64{1e-6, 5e-6, 1e-5, 5e-5, 1e-4, 5e-4, 1e-3, 5e-3, 1e-2, 5e-2}
+
+// histogram is a cumulative Prometheus style histogram of durations
+type histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+// Observe records one duration
+func (h *histogram) Observe(d time.Duration) {
+	v := d.Seconds()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.bounds {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// write prints the histogram as metric name with the given label prefix,
+// e.g. `stage="default",`
+func (h *histogram) write(w io.Writer, name, labels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", name, labels, strconv.FormatFloat(b, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+	braces := ""
+	if labels != "" {
+		braces = "{" + labels[:len(labels)-1] + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, braces, h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, braces, h.count)
+}
+
+var (
+	// matchDuration is the time matchCounter takes per message
+	matchDuration = newHistogram(matcherBuckets)
+	// matchStages split matchDuration: "default" is the configured keywords,
+	// "counters" the runtime counters of the chat
+	matchStages = map[string]*histogram{
+		"default":  newHistogram(matcherBuckets),
+		"counters": newHistogram(matcherBuckets),
+	}
+)
+
+// serveMetrics writes the metrics in the Prometheus text format
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP dayswithout_match_duration_seconds Keyword matching time per message.")
+	fmt.Fprintln(w, "# TYPE dayswithout_match_duration_seconds histogram")
+	matchDuration.write(w, "dayswithout_match_duration_seconds", "")
+
+	fmt.Fprintln(w, "# HELP dayswithout_match_stage_duration_seconds Keyword matching time per message and stage.")
+	fmt.Fprintln(w, "# TYPE dayswithout_match_stage_duration_seconds histogram")
+	stages := make([]string, 0, len(matchStages))
+	for stage := range matchStages {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+	for _, stage := range stages {
+		matchStages[stage].write(w, "dayswithout_match_stage_duration_seconds", fmt.Sprintf("stage=%q,", stage))
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	gauge(w, "dayswithout_goroutines", "Number of goroutines.", float64(runtime.NumGoroutine()))
+	gauge(w, "dayswithout_heap_alloc_bytes", "Bytes of allocated heap objects.", float64(mem.HeapAlloc))
+	gauge(w, "dayswithout_heap_sys_bytes", "Bytes of heap memory obtained from the OS.", float64(mem.HeapSys))
+	gauge(w, "dayswithout_pending_comments", "Channel reset announcements waiting for the discussion group.", float64(pendingComments.Len()))
+}
+
+func gauge(w io.Writer, name, help string, v float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", name, help, name, name, v)
+}
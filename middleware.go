@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strings"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// Middlewares shared by the handlers. The global chain is set up in
+// newBotInstance with b.Use; per-command checks are passed to b.Handle.
+
+// requireAdmin lets only chat admins through; others are told that action
+// ("Создавать счётчики") is for admins
+func requireAdmin(b *tb.Bot, action string) tb.MiddlewareFunc {
+	return func(next tb.HandlerFunc) tb.HandlerFunc {
+		return func(c tb.Context) error {
+			if !isAdmin(b, c) {
+				if c.Callback() != nil {
+					return c.Respond(&tb.CallbackResponse{Text: action + " могут только админы.", ShowAlert: true})
+				}
+				return c.Send(action + " могут только админы.")
+			}
+			return next(c)
+		}
+	}
+}
+
+// requireAdminToChange is requireAdmin for commands that show a setting
+// to everyone when run without arguments and change it with them
+func requireAdminToChange(b *tb.Bot, action string) tb.MiddlewareFunc {
+	admin := requireAdmin(b, action)
+	return func(next tb.HandlerFunc) tb.HandlerFunc {
+		checked := admin(next)
+		return func(c tb.Context) error {
+			if msg := c.Message(); msg != nil && strings.TrimSpace(msg.Payload) == "" {
+				return next(c)
+			}
+			return checked(c)
+		}
+	}
+}
+
+// requireOperator lets only the operators of the bot through
+func requireOperator(cfg Config) tb.MiddlewareFunc {
+	return func(next tb.HandlerFunc) tb.HandlerFunc {
+		return func(c tb.Context) error {
+			if !isOperator(cfg, c) {
+				return c.Send("Эта команда только для операторов бота.")
+			}
+			return next(c)
+		}
+	}
+}
+
+// allowChats drops updates from chats missing in ids; an empty list allows
+// every chat
+func allowChats(ids []int64) tb.MiddlewareFunc {
+	allowed := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		allowed[id] = true
+	}
+	return func(next tb.HandlerFunc) tb.HandlerFunc {
+		return func(c tb.Context) error {
+			if len(allowed) > 0 && c.Chat() != nil && !allowed[c.Chat().ID] {
+				ctxLogger(c).Debug("Ignoring update from chat not in allowed_chats")
+				return nil
+			}
+			return next(c)
+		}
+	}
+}
+
+// commandOf returns the command of the update without the bot mention
+// ("/days"), the unique of a pressed button, or "" for anything else
+func commandOf(c tb.Context) string {
+	if cb := c.Callback(); cb != nil {
+		return cb.Unique
+	}
+	msg := c.Message()
+	if msg == nil || !strings.HasPrefix(msg.Text, "/") {
+		return ""
+	}
+	cmd, _, _ := strings.Cut(msg.Text, " ")
+	cmd, _, _ = strings.Cut(cmd, "@")
+	return cmd
+}
+
+// logCommands records incoming commands and button presses
+func logCommands(next tb.HandlerFunc) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		if cmd := commandOf(c); cmd != "" {
+			ctxLogger(c).Info("Command", "command", cmd)
+		}
+		return next(c)
+	}
+}
+
+// limitCommands ignores commands of a user beyond the limit, warning them
+// once (see userLimit, 0 per minute disables it). Other messages are not
+// counted.
+func limitCommands(limit *userLimit) tb.MiddlewareFunc {
+	return func(next tb.HandlerFunc) tb.HandlerFunc {
+		return func(c tb.Context) error {
+			if commandOf(c) != "" && !limit.Allow(c) {
+				ctxLogger(c).Warn("Command limit reached, ignoring", "command", commandOf(c))
+				return nil
+			}
+			return next(c)
+		}
+	}
+}
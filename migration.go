@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// empty reports whether the chat has nothing worth keeping, like the state
+// created by the first message in a fresh supergroup
+func (st *ChatState) empty() bool {
+	return st.LastMention.IsZero() && len(st.Counters) == 0 && len(st.History) == 0
+}
+
+// MigrateChat moves the state of a group to the supergroup it was upgraded
+// to. A supergroup that already collected state of its own keeps it.
+func (s *Storage) MigrateChat(from, to int64) bool {
+	st, ok := s.Chats[from]
+	if !ok {
+		return false
+	}
+	if cur, ok := s.Chats[to]; ok && !cur.empty() {
+		slog.Warn("Not migrating chat, target already has state", "chat_id", from, "to", to)
+		return false
+	}
+	// the message of the pinned counter stays behind in the old group
+	st.PinnedID, st.PinnedText = 0, ""
+	s.Chats[to] = st
+	delete(s.Chats, from)
+	return true
+}
+
+// handleMigration follows a group upgraded to a supergroup
+func handleMigration(store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		from, to := c.Migration()
+		migrateChat(store, from, to)
+		return nil
+	}
+}
+
+func migrateChat(store *Store, from, to int64) {
+	var moved bool
+	store.Update(func(s *Storage) {
+		moved = s.MigrateChat(from, to)
+	})
+	if moved {
+		slog.Info("Chat migrated to supergroup", "chat_id", from, "to", to)
+	}
+}
+
+// followMigration moves the chat state when err says the group became a
+// supergroup, returning the new chat ID or 0
+func followMigration(store *Store, chatID int64, err error) int64 {
+	var ge tb.GroupError
+	if !errors.As(err, &ge) || ge.MigratedTo == 0 {
+		return 0
+	}
+	migrateChat(store, chatID, ge.MigratedTo)
+	return ge.MigratedTo
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+var defaultMilestones = []int{7, 30, 100, 365}
+
+// chatDays is a chat that is due for a day-count announcement
+type chatDays struct {
+	chatID  int64
+	days    int
+	counter string
+	topic   string
+	// streak is the full current streak, days may be a milestone below it
+	streak time.Duration
+}
+
+// nextMilestone returns the highest milestone reached by days that is above last, or 0
+func nextMilestone(milestones []int, days, last int) int {
+	reached := 0
+	for _, m := range milestones {
+		if m <= days && m > last && m > reached {
+			reached = m
+		}
+	}
+	return reached
+}
+
+// upcomingMilestone returns the smallest milestone above days, or 0
+func upcomingMilestone(milestones []int, days int) int {
+	next := 0
+	for _, m := range milestones {
+		if m > days && (next == 0 || m < next) {
+			next = m
+		}
+	}
+	return next
+}
+
+// dueMilestones lists the counters that reached a milestone and those
+// whose streak has just passed their record
+func dueMilestones(cfg Config, s *Storage) (due, records []chatDays) {
+	for chatID, st := range s.ActiveChats() {
+		for _, name := range st.CounterNames() {
+			ctr := st.CounterByName(name)
+			if ctr.LastMention.IsZero() {
+				continue
+			}
+			if m := nextMilestone(cfg.Milestones, ctr.Days(), ctr.LastMilestone); m != 0 {
+				due = append(due, chatDays{chatID: chatID, days: m, counter: name, topic: counterTopic(cfg, ctr), streak: ctr.Streak()})
+			}
+			if ctr.Record > 0 && !ctr.RecordBroken && ctr.Streak() > ctr.Record {
+				records = append(records, chatDays{chatID: chatID, days: ctr.Days(), counter: name, topic: counterTopic(cfg, ctr), streak: ctr.Streak()})
+			}
+		}
+	}
+	return due, records
+}
+
+// checkMilestones announces the milestones reached by the counters and
+// publishes the streaks that have just passed their record
+func checkMilestones(b *tb.Bot, cfg Config, store *Store) {
+	var due, records []chatDays
+	store.View(func(s *Storage) {
+		due, records = dueMilestones(cfg, s)
+	})
+	if len(due) == 0 && len(records) == 0 {
+		return
+	}
+	// a reset may have come in since, so only what is still due in the
+	// update is marked and announced
+	store.Update(func(s *Storage) {
+		due, records = dueMilestones(cfg, s)
+		for _, a := range due {
+			s.Chat(a.chatID).CounterByName(a.counter).LastMilestone = a.days
+		}
+		for _, a := range records {
+			s.Chat(a.chatID).CounterByName(a.counter).RecordBroken = true
+		}
+	})
+
+	for _, a := range records {
+		slog.Info("Record broken", "chat_id", a.chatID, "counter", a.counter, "days", a.days)
+		publishEvent(counterEvent{
+			Type:    HookRecord,
+			Time:    clock(),
+			Bot:     b.Me.Username,
+			ChatID:  a.chatID,
+			Counter: a.counter,
+			Topic:   a.topic,
+			Days:    a.days,
+			Streak:  int64(a.streak.Seconds()),
+		})
+	}
+
+	for _, a := range due {
+		text := fmt.Sprintf("🎉 Уже %s без упоминания %s! Так держать.", plural(a.days, "day"), a.topic)
+		slog.Info("Milestone reached", "chat_id", a.chatID, "counter", a.counter, "days", a.days)
+		publishEvent(counterEvent{
+			Type:    HookMilestone,
+			Time:    clock(),
+			Bot:     b.Me.Username,
+			ChatID:  a.chatID,
+			Counter: a.counter,
+			Topic:   a.topic,
+			Days:    a.days,
+			Streak:  int64(a.streak.Seconds()),
+		})
+		if _, err := postToChat(b, store, a.chatID, text); err != nil {
+			slog.Error("Failed to send milestone", "chat_id", a.chatID, "err", err)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// MiniAppConfig serves a Telegram Mini App with the live counters, records
+// and charts of a chat at /app/ of the API server. URL is its public HTTPS
+// address, set as the menu button of private chats. Groups open the app
+// through a t.me link, which needs ShortName: the name of the app
+// registered with /newapp in BotFather.
+type MiniAppConfig struct {
+	URL       string `yaml:"url"`
+	ShortName string `yaml:"short_name"`
+	// Button is the text of the menu button, "Счётчик" by default
+	Button string `yaml:"button"`
+}
+
+const (
+	// miniAppMaxAge is how long a signed initData is accepted
+	miniAppMaxAge = 24 * time.Hour
+	// miniAppMemberTTL is how long a membership check of a user is cached
+	miniAppMemberTTL = 5 * time.Minute
+)
+
+type miniAppBucket struct {
+	Start      string `json:"start"`
+	Detections int    `json:"detections"`
+	Resets     int    `json:"resets"`
+}
+
+type miniAppState struct {
+	apiChat
+	Weeks  []miniAppBucket `json:"weeks"`
+	Months []miniAppBucket `json:"months"`
+}
+
+// miniApp answers the requests of the app, authenticated by the initData
+// Telegram signs with the bot token
+type miniApp struct {
+	bots []*botInstance
+
+	mu      sync.Mutex
+	members map[[2]int64]time.Time
+}
+
+// serveMiniApp adds the app to the API server:
+//
+//	GET /app/         the page opened inside Telegram
+//	GET /app/state    counters and charts of the chat the app was opened for
+func serveMiniApp(mux *http.ServeMux, bots []*botInstance) {
+	app := &miniApp{bots: bots, members: map[[2]int64]time.Time{}}
+	mux.HandleFunc("GET /app/{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		fmt.Fprint(w, miniAppHTML)
+	})
+	mux.HandleFunc("GET /app/state", app.state)
+}
+
+func (app *miniApp) state(w http.ResponseWriter, r *http.Request) {
+	initData, ok := strings.CutPrefix(r.Header.Get("Authorization"), "tma ")
+	if !ok {
+		writeAPIError(w, http.StatusUnauthorized, "missing init data")
+		return
+	}
+	var bi *botInstance
+	var user int64
+	var start string
+	for _, b := range app.bots {
+		if b.cfg.MiniApp.URL == "" {
+			continue
+		}
+		var err error
+		if user, start, err = validInitData(initData, b.currentToken(), clock()); err == nil {
+			bi = b
+			break
+		}
+	}
+	if bi == nil {
+		slog.Warn("Mini App request with invalid init data", "remote", r.RemoteAddr)
+		writeAPIError(w, http.StatusUnauthorized, "invalid init data")
+		return
+	}
+
+	// the app opened from the menu button shows the personal counter, a
+	// t.me link passes the group in startapp
+	chatID := user
+	if start != "" {
+		id, err := strconv.ParseInt(start, 10, 64)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid chat id")
+			return
+		}
+		chatID = id
+	}
+	var found bool
+	bi.store.View(func(s *Storage) {
+		st := s.Chats[chatID]
+		found = st != nil && st.Left.IsZero()
+	})
+	if !found {
+		writeAPIError(w, http.StatusNotFound, "unknown chat")
+		return
+	}
+	if chatID != user && !app.member(bi, chatID, user) {
+		writeAPIError(w, http.StatusForbidden, "not a member of the chat")
+		return
+	}
+
+	var state miniAppState
+	bi.store.View(func(s *Storage) {
+		st := s.Chat(chatID)
+		now := clock().In(chatLocation(bi.cfg, st))
+		state = miniAppState{
+			apiChat: apiChatState(bi.cfg, chatID, st),
+			Weeks:   miniAppBuckets(chartData(st, now, false)),
+			Months:  miniAppBuckets(chartData(st, now, true)),
+		}
+	})
+	writeAPI(w, http.StatusOK, state)
+}
+
+// member reports whether the user is in the chat, caching positive answers
+// for miniAppMemberTTL since the app refreshes every minute
+func (app *miniApp) member(bi *botInstance, chatID, user int64) bool {
+	key := [2]int64{chatID, user}
+	now := clock()
+	app.mu.Lock()
+	valid := now.Before(app.members[key])
+	app.mu.Unlock()
+	if valid {
+		return true
+	}
+
+	m, err := bi.bot().ChatMemberOf(&tb.Chat{ID: chatID}, &tb.User{ID: user})
+	if err != nil {
+		slog.Warn("Failed to get member status for the Mini App", "chat", chatID, "user", user, "err", err)
+		return false
+	}
+	switch m.Role {
+	case tb.Creator, tb.Administrator, tb.Member:
+	case tb.Restricted:
+		if !m.Member {
+			return false
+		}
+	default:
+		return false
+	}
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	for k, until := range app.members {
+		if !now.Before(until) {
+			delete(app.members, k)
+		}
+	}
+	app.members[key] = now.Add(miniAppMemberTTL)
+	return true
+}
+
+func miniAppBuckets(buckets []chartBucket) []miniAppBucket {
+	out := make([]miniAppBucket, len(buckets))
+	for i, b := range buckets {
+		out[i] = miniAppBucket{Start: b.Start.Format(time.DateOnly), Detections: b.Detections, Resets: b.Resets}
+	}
+	return out
+}
+
+// validInitData checks the signature of the initData Telegram passes to the
+// Mini App and returns the user who opened it and the start parameter
+func validInitData(initData, token string, now time.Time) (int64, string, error) {
+	vals, err := url.ParseQuery(initData)
+	if err != nil {
+		return 0, "", err
+	}
+	hash := vals.Get("hash")
+	if hash == "" {
+		return 0, "", errors.New("no hash")
+	}
+	keys := make([]string, 0, len(vals))
+	for k := range vals {
+		if k != "hash" {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + vals.Get(k)
+	}
+
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(token))
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(strings.Join(pairs, "\n")))
+	got, err := hex.DecodeString(hash)
+	if err != nil || !hmac.Equal(got, mac.Sum(nil)) {
+		return 0, "", errors.New("bad hash")
+	}
+
+	authDate, err := strconv.ParseInt(vals.Get("auth_date"), 10, 64)
+	if err != nil {
+		return 0, "", errors.New("no auth_date")
+	}
+	if now.Sub(time.Unix(authDate, 0)) > miniAppMaxAge {
+		return 0, "", errors.New("init data expired")
+	}
+	var user struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(vals.Get("user")), &user); err != nil || user.ID == 0 {
+		return 0, "", errors.New("no user")
+	}
+	return user.ID, vals.Get("start_param"), nil
+}
+
+// currentToken is the token the bot is connected with, which changes when
+// it is rotated on reload
+func (bi *botInstance) currentToken() string {
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+	return bi.token
+}
+
+// setMiniAppButton makes the app the default menu button of private chats
+func setMiniAppButton(b *tb.Bot, cfg MiniAppConfig) {
+	// SetMenuButton always sends a chat, the default button needs none
+	_, err := b.Raw("setChatMenuButton", map[string]any{
+		"menu_button": &tb.MenuButton{Type: tb.MenuButtonWebApp, Text: cfg.Button, WebApp: &tb.WebApp{URL: cfg.URL}},
+	})
+	if err != nil {
+		slog.Error("Failed to set the Mini App menu button", "err", err)
+	}
+}
+
+// handleMiniApp answers /app with a button opening the app for the chat
+func handleMiniApp(cfg Config) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		markup := &tb.ReplyMarkup{}
+		if isPersonal(c.Chat()) {
+			markup.Inline(markup.Row(markup.WebApp(cfg.MiniApp.Button, &tb.WebApp{URL: cfg.MiniApp.URL})))
+			return c.Send("Счётчик, рекорд и графики — в приложении:", markup)
+		}
+		if cfg.MiniApp.ShortName == "" {
+			return c.Send("Приложение со счётчиком открывается из лички с ботом: @" + c.Bot().Me.Username)
+		}
+		link := fmt.Sprintf("https://t.me/%s/%s?startapp=%d", c.Bot().Me.Username, cfg.MiniApp.ShortName, c.Chat().ID)
+		markup.Inline(markup.Row(markup.URL(cfg.MiniApp.Button, link)))
+		return c.Send("Счётчик, рекорд и графики этого чата — в приложении:", markup)
+	}
+}
+
+const miniAppHTML = `<!DOCTYPE html>
+<html lang="ru">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Дни без</title>
+<script src="https://telegram.org/js/telegram-web-app.js"></script>
+<style>
+body{margin:0;padding:16px;font-family:system-ui,sans-serif;background:var(--tg-theme-bg-color,#fff);color:var(--tg-theme-text-color,#222)}
+.card{background:var(--tg-theme-secondary-bg-color,#f2f2f2);border-radius:12px;padding:16px;margin-bottom:12px}
+.topic{font-size:15px;color:var(--tg-theme-hint-color,#888)}
+.days{font-size:48px;font-weight:700;margin:4px 0}
+.streak,.record{font-size:14px;color:var(--tg-theme-hint-color,#888)}
+.tabs{display:flex;gap:8px;margin:8px 0}
+.tabs button{flex:1;border:0;border-radius:8px;padding:8px;background:var(--tg-theme-secondary-bg-color,#eee);color:inherit}
+.tabs button.on{background:var(--tg-theme-button-color,#2481cc);color:var(--tg-theme-button-text-color,#fff)}
+svg{width:100%;height:auto}
+.legend{font-size:12px;color:var(--tg-theme-hint-color,#888)}
+.error{color:#d33}
+</style>
+</head>
+<body>
+<div id="counters"></div>
+<div class="tabs"><button id="weeks" class="on">Недели</button><button id="months">Месяцы</button></div>
+<div class="card"><div id="chart"></div><div class="legend">■ упоминания &nbsp; <span style="color:#d33">■</span> сбросы</div></div>
+<script>
+const tg = window.Telegram.WebApp;
+tg.ready();
+tg.expand();
+let state = null, loaded = 0, monthly = false;
+
+function plural(n, one, few, many) {
+  const m10 = n % 10, m100 = n % 100;
+  if (m10 == 1 && m100 != 11) return one;
+  if (m10 >= 2 && m10 <= 4 && (m100 < 12 || m100 > 14)) return few;
+  return many;
+}
+function duration(s) {
+  const d = Math.floor(s / 86400), h = Math.floor(s % 86400 / 3600), m = Math.floor(s % 3600 / 60);
+  return d + " " + plural(d, "день", "дня", "дней") + " " + h + " ч " + m + " мин";
+}
+function el(tag, cls, text) {
+  const e = document.createElement(tag);
+  if (cls) e.className = cls;
+  if (text !== undefined) e.textContent = text;
+  return e;
+}
+function renderCounters() {
+  const box = document.getElementById("counters");
+  box.replaceChildren();
+  const elapsed = Math.floor((Date.now() - loaded) / 1000);
+  for (const c of state.counters) {
+    const streak = c.paused ? c.streak_seconds : c.streak_seconds + elapsed;
+    const days = Math.floor(streak / 86400);
+    const card = el("div", "card");
+    card.append(el("div", "topic", "Дней без " + c.topic + (c.paused ? " (пауза)" : "")));
+    card.append(el("div", "days", String(days)));
+    card.append(el("div", "streak", "Серия: " + duration(streak)));
+    card.append(el("div", "record", "Рекорд: " + duration(Math.max(c.record_seconds, streak))));
+    box.append(card);
+  }
+}
+function renderChart() {
+  const buckets = monthly ? state.months : state.weeks;
+  const w = 360, h = 180, pad = 20, bw = (w - pad) / buckets.length;
+  const top = Math.max(1, ...buckets.map(b => Math.max(b.detections, b.resets)));
+  const ns = "http://www.w3.org/2000/svg";
+  const svg = document.createElementNS(ns, "svg");
+  svg.setAttribute("viewBox", "0 0 " + w + " " + h);
+  const color = getComputedStyle(document.body).getPropertyValue("--tg-theme-button-color") || "#2481cc";
+  buckets.forEach((b, i) => {
+    [[b.detections, color, 0], [b.resets, "#d33", 1]].forEach(([v, fill, k]) => {
+      const bh = (h - pad) * v / top;
+      const r = document.createElementNS(ns, "rect");
+      r.setAttribute("x", pad / 2 + i * bw + 2 + k * (bw - 4) / 2);
+      r.setAttribute("y", h - pad - bh);
+      r.setAttribute("width", (bw - 4) / 2);
+      r.setAttribute("height", bh);
+      r.setAttribute("fill", fill);
+      svg.append(r);
+    });
+    if (i % 3 == 0) {
+      const t = document.createElementNS(ns, "text");
+      t.setAttribute("x", pad / 2 + i * bw);
+      t.setAttribute("y", h - 4);
+      t.setAttribute("font-size", "10");
+      t.setAttribute("fill", "currentColor");
+      t.textContent = b.start.slice(5).split("-").reverse().join(".");
+      svg.append(t);
+    }
+  });
+  document.getElementById("chart").replaceChildren(svg);
+}
+async function load() {
+  const resp = await fetch("state", {headers: {Authorization: "tma " + tg.initData}});
+  const body = await resp.json();
+  if (!resp.ok) {
+    document.getElementById("counters").replaceChildren(el("div", "card error", body.error || resp.statusText));
+    return;
+  }
+  state = body;
+  loaded = Date.now();
+  renderCounters();
+  renderChart();
+}
+for (const id of ["weeks", "months"]) {
+  document.getElementById(id).onclick = () => {
+    monthly = id == "months";
+    document.getElementById("weeks").classList.toggle("on", !monthly);
+    document.getElementById("months").classList.toggle("on", monthly);
+    if (state) renderChart();
+  };
+}
+load();
+setInterval(() => state && renderCounters(), 1000);
+setInterval(load, 60000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// MonthlyConfig controls the recap posted on the first day of every month
+type MonthlyConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Time    string `yaml:"time"` // "HH:MM", server time
+}
+
+var monthNames = [...]string{
+	"январь", "февраль", "март", "апрель", "май", "июнь",
+	"июль", "август", "сентябрь", "октябрь", "ноябрь", "декабрь",
+}
+
+// markdownSpecial are the characters MarkdownV2 wants escaped outside of
+// markup
+const markdownSpecial = "_*[]()~`>#+-=|{}.!\\"
+
+// escapeMarkdown escapes text for the MarkdownV2 parse mode
+func escapeMarkdown(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(markdownSpecial, r) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// boldMarkdown is s in bold for MarkdownV2
+func boldMarkdown(s string) string {
+	return "*" + escapeMarkdown(s) + "*"
+}
+
+func buildMonthly(cfg Config, st *ChatState, from, to time.Time) string {
+	var longest time.Duration
+	var resets, newRecord int
+	keywords := make(map[string]int)
+	for _, ev := range st.History {
+		if ev.Time.Before(from) || !ev.Time.Before(to) {
+			continue
+		}
+		switch ev.Type {
+		case EventReset:
+			resets++
+			if ev.Streak > longest {
+				longest = ev.Streak
+			}
+			if ev.Streak > 0 && ev.Streak == st.Record {
+				newRecord++
+			}
+		case EventDetection:
+			keywords[strings.ToLower(ev.Keyword)]++
+		}
+	}
+	// the streak that is still running counts too
+	if !st.LastMention.IsZero() && st.LastMention.Before(to) {
+		if cur := to.Sub(st.LastMention); cur > longest {
+			longest = cur
+		}
+	}
+
+	var topKeyword string
+	var topHits int
+	for kw, n := range keywords {
+		if n > topHits || (n == topHits && kw < topKeyword) {
+			topKeyword, topHits = kw, n
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s\n", boldMarkdown(fmt.Sprintf("Итоги месяца: %s %d", monthNames[from.Month()-1], from.Year())))
+	fmt.Fprintf(&sb, "Тема: %s\n\n", escapeMarkdown(counterTopic(cfg, &st.Counter)))
+	fmt.Fprintf(&sb, "🏃 Самая длинная серия: %s\n", boldMarkdown(plural(durationDays(longest), "day")))
+	fmt.Fprintf(&sb, "💀 Сбросов: %s\n", boldMarkdown(strconv.Itoa(resets)))
+	if topHits > 0 {
+		fmt.Fprintf(&sb, "🔑 Чаще всего срабатывало: «%s» \\(%d\\)\n", escapeMarkdown(topKeyword), topHits)
+	}
+
+	record := st.Record
+	if cur := st.Streak(); cur > record {
+		record = cur
+	}
+	fmt.Fprintf(&sb, "🏆 Рекорд за всё время: %s", boldMarkdown(plural(durationDays(record), "day")))
+	if newRecord > 0 {
+		sb.WriteString(escapeMarkdown(" — новый рекорд в этом месяце!"))
+	}
+	return sb.String()
+}
+
+func checkMonthly(b *tb.Bot, cfg Config, store *Store, now time.Time) {
+	at, _ := parseClock(cfg.Monthly.Time)
+	y, m, _ := now.Date()
+	to := time.Date(y, m, 1, 0, 0, 0, 0, now.Location())
+	from := to.AddDate(0, -1, 0)
+
+	type recap struct {
+		chatID int64
+		text   string
+	}
+	var due []recap
+	var first []int64
+	store.View(func(s *Storage) {
+		for chatID, st := range s.ActiveChats() {
+			switch {
+			case st.LastMonthly.IsZero():
+				// a chat seen for the first time starts with the next slot
+				// instead of getting one right away
+				first = append(first, chatID)
+			case monthlyDue(at, st.LastMonthly, now):
+				due = append(due, recap{chatID: chatID, text: buildMonthly(cfg, st, from, to)})
+			}
+		}
+	})
+	if len(due) == 0 && len(first) == 0 {
+		return
+	}
+	store.Update(func(s *Storage) {
+		for _, chatID := range first {
+			s.Chat(chatID).LastMonthly = now
+		}
+		for _, r := range due {
+			s.Chat(r.chatID).LastMonthly = now
+		}
+	})
+
+	for _, r := range due {
+		slog.Debug("Sending monthly recap", "chat_id", r.chatID)
+		if _, err := postToChat(b, store, r.chatID, r.text, tb.ModeMarkdownV2); err != nil {
+			slog.Error("Failed to send monthly recap", "chat_id", r.chatID, "err", err)
+		}
+	}
+}
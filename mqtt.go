@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// MQTTConfig publishes counter events and day counts to an MQTT broker.
+// Topics may use {bot}, {chat} and {counter} ("default" for the default
+// counter). Days are published retained, so a display that connects later
+// gets the current value right away.
+type MQTTConfig struct {
+	// Broker is mqtt://[user:password@]host[:1883] or mqtts:// for TLS
+	Broker      string `yaml:"broker"`
+	ClientID    string `yaml:"client_id"`
+	EventsTopic string `yaml:"events_topic"`
+	DaysTopic   string `yaml:"days_topic"`
+	// Discovery announces every counter as a Home Assistant sensor, see
+	// homeassistant.go
+	Discovery       bool   `yaml:"discovery"`
+	DiscoveryPrefix string `yaml:"discovery_prefix"`
+	AttributesTopic string `yaml:"attributes_topic"`
+}
+
+const (
+	mqttTimeout   = 10 * time.Second
+	mqttKeepAlive = 60 * time.Second
+	mqttQueueSize = 256
+)
+
+// validMQTT checks the broker URL
+func validMQTT(cfg MQTTConfig) error {
+	u, err := url.Parse(cfg.Broker)
+	if err != nil || (u.Scheme != "mqtt" && u.Scheme != "mqtts") || u.Host == "" {
+		return fmt.Errorf("invalid mqtt.broker, expected mqtt://host:port or mqtts://host:port")
+	}
+	return nil
+}
+
+// mqttConn is a minimal MQTT 3.1.1 client that can only publish at QoS 0
+type mqttConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dialMQTT connects and logs in to the broker
+func dialMQTT(raw, clientID string) (*mqttConn, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: mqttTimeout}
+	if u.Scheme == "mqtts" {
+		addr := u.Host
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "8883")
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: u.Hostname()})
+	} else {
+		addr := u.Host
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "1883")
+		}
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &mqttConn{conn: conn, r: bufio.NewReader(conn)}
+
+	flags := byte(0x02) // clean session
+	var payload []byte
+	payload = mqttString(payload, clientID)
+	if u.User != nil {
+		flags |= 0x80
+		payload = mqttString(payload, u.User.Username())
+		if pass, ok := u.User.Password(); ok {
+			flags |= 0x40
+			payload = mqttString(payload, pass)
+		}
+	}
+	keepAlive := int(mqttKeepAlive.Seconds())
+	header := mqttString(nil, "MQTT")
+	header = append(header, 4, flags, byte(keepAlive>>8), byte(keepAlive))
+	if err := c.write(0x10, append(header, payload...)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	typ, body, err := c.read()
+	if err == nil && (typ != 0x20 || len(body) != 2) {
+		err = fmt.Errorf("unexpected packet %#x instead of CONNACK", typ)
+	}
+	if err == nil && body[1] != 0 {
+		err = fmt.Errorf("connection refused, code %d", body[1])
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *mqttConn) Publish(topic string, payload []byte, retain bool) error {
+	typ := byte(0x30)
+	if retain {
+		typ |= 0x01
+	}
+	return c.write(typ, append(mqttString(nil, topic), payload...))
+}
+
+// Ping checks that the broker is still there
+func (c *mqttConn) Ping() error {
+	if err := c.write(0xc0, nil); err != nil {
+		return err
+	}
+	typ, _, err := c.read()
+	if err == nil && typ != 0xd0 {
+		err = fmt.Errorf("unexpected packet %#x instead of PINGRESP", typ)
+	}
+	return err
+}
+
+func (c *mqttConn) write(typ byte, body []byte) error {
+	c.conn.SetDeadline(time.Now().Add(mqttTimeout))
+	packet := []byte{typ}
+	// remaining length, 7 bits per byte
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		if n /= 128; n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := c.conn.Write(append(packet, body...))
+	return err
+}
+
+func (c *mqttConn) read() (byte, []byte, error) {
+	c.conn.SetDeadline(time.Now().Add(mqttTimeout))
+	typ, err := c.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, shift := 0, 0
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return 0, nil, errors.New("malformed MQTT packet length")
+		}
+	}
+	body := make([]byte, n)
+	_, err = io.ReadFull(c.r, body)
+	return typ & 0xf0, body, err
+}
+
+func mqttString(buf []byte, s string) []byte {
+	return append(append(buf, byte(len(s)>>8), byte(len(s))), s...)
+}
+
+type mqttMessage struct {
+	topic   string
+	payload []byte
+	retain  bool
+}
+
+// mqttPublisher is the eventSink publishing to the broker from a single
+// goroutine, reconnecting as needed. Messages published while the broker is
+// unreachable are dropped; the retained ones (day counts, Home Assistant
+// discovery) are republished on reconnect.
+type mqttPublisher struct {
+	cfg   MQTTConfig
+	queue chan mqttMessage
+
+	mu sync.Mutex
+	// retained are the last payloads of the retained topics
+	retained map[string]string
+}
+
+var mqttOut *mqttPublisher
+
+func newMQTTPublisher(cfg MQTTConfig) *mqttPublisher {
+	if cfg.ClientID == "" {
+		id := make([]byte, 4)
+		rand.Read(id)
+		cfg.ClientID = "dayswithout-" + hex.EncodeToString(id)
+	}
+	p := &mqttPublisher{cfg: cfg, queue: make(chan mqttMessage, mqttQueueSize), retained: make(map[string]string)}
+	go p.run()
+	return p
+}
+
+// topic fills the placeholders of a configured topic
+func (p *mqttPublisher) topic(pattern, bot string, chatID int64, counter string) string {
+	if counter == "" {
+		counter = "default"
+	}
+	return strings.NewReplacer("{bot}", bot, "{chat}", strconv.FormatInt(chatID, 10), "{counter}", counter).Replace(pattern)
+}
+
+func (p *mqttPublisher) send(msg mqttMessage) {
+	select {
+	case p.queue <- msg:
+	default:
+		slog.Warn("MQTT queue is full, dropping message", "topic", msg.topic)
+	}
+}
+
+func (p *mqttPublisher) Publish(ev counterEvent) {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		slog.Error("Failed to encode MQTT event", "err", err)
+		return
+	}
+	p.send(mqttMessage{topic: p.topic(p.cfg.EventsTopic, ev.Bot, ev.ChatID, ev.Counter), payload: payload})
+	days := durationDays(time.Duration(ev.Streak) * time.Second)
+	if ev.Type == HookReset {
+		days = 0
+	}
+	p.publishRetained(p.topic(p.cfg.DaysTopic, ev.Bot, ev.ChatID, ev.Counter), strconv.Itoa(days), true)
+}
+
+// publishRetained publishes payload retained to topic when it changed or
+// force is set
+func (p *mqttPublisher) publishRetained(topic, payload string, force bool) {
+	p.mu.Lock()
+	last, known := p.retained[topic]
+	p.retained[topic] = payload
+	p.mu.Unlock()
+	if force || !known || last != payload {
+		p.send(mqttMessage{topic: topic, payload: []byte(payload), retain: true})
+	}
+}
+
+// PublishState is the "mqtt" job, publishing the day counts (and with
+// discovery the Home Assistant sensors) that changed since the last run
+func (p *mqttPublisher) PublishState(b *tb.Bot, cfg Config, store *Store) {
+	type retained struct {
+		topic, payload string
+	}
+	var out []retained
+	store.View(func(s *Storage) {
+		for chatID, st := range s.ActiveChats() {
+			for _, name := range st.CounterNames() {
+				ctr := st.CounterByName(name)
+				if ctr.LastMention.IsZero() {
+					continue
+				}
+				out = append(out, retained{p.topic(p.cfg.DaysTopic, b.Me.Username, chatID, name), strconv.Itoa(ctr.Days())})
+				if p.cfg.Discovery {
+					for topic, payload := range p.sensor(b.Me.Username, cfg, chatID, st, name) {
+						out = append(out, retained{topic, payload})
+					}
+				}
+			}
+		}
+	})
+	for _, r := range out {
+		p.publishRetained(r.topic, r.payload, false)
+	}
+}
+
+func (p *mqttPublisher) run() {
+	var conn *mqttConn
+	ping := time.NewTicker(mqttKeepAlive / 2)
+	defer ping.Stop()
+	for {
+		select {
+		case msg := <-p.queue:
+			if conn == nil {
+				c, err := dialMQTT(p.cfg.Broker, p.cfg.ClientID)
+				if err != nil {
+					slog.Error("Failed to connect to MQTT broker, dropping message", "topic", msg.topic, "err", err)
+					continue
+				}
+				conn = c
+				slog.Info("Connected to MQTT broker", "client_id", p.cfg.ClientID)
+				p.resendRetained(conn)
+			}
+			if err := conn.Publish(msg.topic, msg.payload, msg.retain); err != nil {
+				slog.Error("Failed to publish to MQTT", "topic", msg.topic, "err", err)
+				conn.conn.Close()
+				conn = nil
+			}
+		case <-ping.C:
+			if conn == nil {
+				continue
+			}
+			if err := conn.Ping(); err != nil {
+				slog.Warn("MQTT broker stopped answering, reconnecting on the next message", "err", err)
+				conn.conn.Close()
+				conn = nil
+			}
+		}
+	}
+}
+
+// resendRetained republishes the retained topics on a fresh connection, a
+// broker without persistence may have lost them
+func (p *mqttPublisher) resendRetained(conn *mqttConn) {
+	p.mu.Lock()
+	retained := make(map[string]string, len(p.retained))
+	for topic, payload := range p.retained {
+		retained[topic] = payload
+	}
+	p.mu.Unlock()
+	for topic, payload := range retained {
+		if err := conn.Publish(topic, []byte(payload), true); err != nil {
+			slog.Error("Failed to republish retained MQTT topic", "topic", topic, "err", err)
+			return
+		}
+	}
+}
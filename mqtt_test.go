@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// mqttPipe is a connection to a fake broker, the other end of the pipe
+func mqttPipe(t *testing.T) (*mqttConn, net.Conn) {
+	t.Helper()
+	client, broker := net.Pipe()
+	t.Cleanup(func() { client.Close(); broker.Close() })
+	return &mqttConn{conn: client, r: bufio.NewReader(client)}, broker
+}
+
+// written is what fn writes to c, as the broker receives it
+func written(t *testing.T, c *mqttConn, broker net.Conn, fn func() error) []byte {
+	t.Helper()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- fn()
+		c.conn.Close()
+	}()
+	data, _ := io.ReadAll(broker)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestMQTTRemainingLength(t *testing.T) {
+	// boundaries of the 7 bits per byte encoding
+	tests := []struct {
+		size   int
+		header string
+	}{
+		{0, "3000"},
+		{127, "307f"},
+		{128, "308001"},
+		{16383, "30ff7f"},
+		{16384, "30808001"},
+		{2097152, "3080808001"},
+	}
+	for _, tt := range tests {
+		c, broker := mqttPipe(t)
+		body := bytes.Repeat([]byte{'x'}, tt.size)
+		data := written(t, c, broker, func() error { return c.write(0x30, body) })
+		header := hex.EncodeToString(data[:len(data)-tt.size])
+		if header != tt.header {
+			t.Errorf("header of %d bytes = %s, want %s", tt.size, header, tt.header)
+		}
+		if !bytes.Equal(data[len(data)-tt.size:], body) {
+			t.Errorf("body of %d bytes differs", tt.size)
+		}
+	}
+}
+
+func TestMQTTReadRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, 127, 128, 16384} {
+		writer, broker := mqttPipe(t)
+		reader := &mqttConn{conn: broker, r: bufio.NewReader(broker)}
+		body := bytes.Repeat([]byte{'y'}, size)
+		go writer.write(0x31, body)
+		typ, got, err := reader.read()
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		// the flags of the first byte are not part of the type
+		if typ != 0x30 || !bytes.Equal(got, body) {
+			t.Errorf("%d bytes: read %#x with %d bytes", size, typ, len(got))
+		}
+	}
+}
+
+func TestMQTTReadMalformedLength(t *testing.T) {
+	c, broker := mqttPipe(t)
+	go broker.Write([]byte{0x30, 0xff, 0xff, 0xff, 0xff, 0x01})
+	if _, _, err := c.read(); err == nil || !strings.Contains(err.Error(), "malformed") {
+		t.Errorf("read = %v, want the malformed length error", err)
+	}
+}
+
+func TestMQTTPublish(t *testing.T) {
+	tests := []struct {
+		retain bool
+		want   string
+	}{
+		// type, length, topic length, "a/b", payload "42"
+		{false, "3007" + "0003" + "612f62" + "3432"},
+		{true, "3107" + "0003" + "612f62" + "3432"},
+	}
+	for _, tt := range tests {
+		c, broker := mqttPipe(t)
+		data := written(t, c, broker, func() error { return c.Publish("a/b", []byte("42"), tt.retain) })
+		if got := hex.EncodeToString(data); got != tt.want {
+			t.Errorf("retain %v: PUBLISH = %s, want %s", tt.retain, got, tt.want)
+		}
+	}
+}
+
+func TestMQTTPing(t *testing.T) {
+	c, broker := mqttPipe(t)
+	go func() {
+		req := make([]byte, 2)
+		io.ReadFull(broker, req)
+		if hex.EncodeToString(req) == "c000" {
+			broker.Write([]byte{0xd0, 0x00})
+		}
+	}()
+	if err := c.Ping(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeBroker accepts one connection, records its CONNECT and answers with
+// the CONNACK return code
+func fakeBroker(t *testing.T, code byte) (addr string, connect <-chan []byte) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	got := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, body, err := (&mqttConn{conn: conn, r: bufio.NewReader(conn)}).read()
+		if err != nil {
+			return
+		}
+		got <- body
+		conn.Write([]byte{0x20, 0x02, 0x00, code})
+	}()
+	return ln.Addr().String(), got
+}
+
+func TestDialMQTT(t *testing.T) {
+	addr, connect := fakeBroker(t, 0)
+	c, err := dialMQTT("mqtt://user:secret@"+addr, "days")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.conn.Close()
+	want := "0004" + hex.EncodeToString([]byte("MQTT")) +
+		// level 4, clean session with user name and password, keep alive 60s
+		"04" + "c2" + "003c" +
+		"0004" + hex.EncodeToString([]byte("days")) +
+		"0004" + hex.EncodeToString([]byte("user")) +
+		"0006" + hex.EncodeToString([]byte("secret"))
+	if got := hex.EncodeToString(<-connect); got != want {
+		t.Errorf("CONNECT = %s, want %s", got, want)
+	}
+}
+
+func TestDialMQTTRefused(t *testing.T) {
+	addr, _ := fakeBroker(t, 5)
+	if _, err := dialMQTT("mqtt://"+addr, "days"); err == nil || !strings.Contains(err.Error(), "code 5") {
+		t.Errorf("dialMQTT = %v, want the refusal", err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"log/slog"
+	"math/rand"
+	"strconv"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// NudgeConfig controls the gentle "keep it up" messages sent while a streak
+// runs. Each next nudge comes after Interval shifted randomly by up to ±Jitter.
+// Templates placeholders: {topic} {days} {days_text} {count}
+type NudgeConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Interval  time.Duration `yaml:"interval"`
+	Jitter    time.Duration `yaml:"jitter"`
+	Templates []Template    `yaml:"templates"`
+}
+
+var defaultNudgeTemplates = []Template{
+	{Text: "Мы уже {days_text} без {topic}. Так держать 💪", Weight: 1},
+	{Text: "Напоминаю: {days_text} без {topic}. Не сбавляем темп!", Weight: 1},
+}
+
+// nextNudge picks the time of the nudge after now
+func nextNudge(cfg NudgeConfig, now time.Time) time.Time {
+	d := cfg.Interval
+	if cfg.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(2*cfg.Jitter))) - cfg.Jitter
+	}
+	return now.Add(d)
+}
+
+func checkNudges(b *tb.Bot, cfg Config, store *Store, now time.Time) {
+	type nudge struct {
+		chatID int64
+		text   templated
+	}
+	var due []nudge
+	var schedule []int64
+	store.View(func(s *Storage) {
+		for chatID, st := range s.ActiveChats() {
+			if st.NextNudge.IsZero() {
+				schedule = append(schedule, chatID)
+				continue
+			}
+			if now.Before(st.NextNudge) {
+				continue
+			}
+			schedule = append(schedule, chatID)
+			if st.LastMention.IsZero() || st.Paused() || st.Days() == 0 {
+				continue
+			}
+			tpl := pickTemplate(cfg.Nudges.Templates)
+			due = append(due, nudge{chatID: chatID, text: templated{Template: tpl, Text: renderTemplate(tpl, map[string]string{
+				"topic":     counterTopic(cfg, &st.Counter),
+				"days":      strconv.Itoa(st.Days()),
+				"days_text": plural(st.Days(), "day"),
+				"count":     strconv.Itoa(st.Days()),
+			})}})
+		}
+	})
+	if len(schedule) == 0 {
+		return
+	}
+	store.Update(func(s *Storage) {
+		for _, chatID := range schedule {
+			s.Chat(chatID).NextNudge = nextNudge(cfg.Nudges, now)
+		}
+	})
+
+	for _, n := range due {
+		slog.Debug("Sending nudge", "chat_id", n.chatID)
+		if _, err := postToChat(b, store, n.chatID, n.text); err != nil {
+			slog.Error("Failed to send nudge", "chat_id", n.chatID, "err", err)
+		}
+	}
+}
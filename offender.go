@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log/slog"
+	"strconv"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// OffenderConfig controls the weekly "offender of the week" announcement.
+// Templates placeholders: {who} {count} {times} {topic}
+type OffenderConfig struct {
+	Enabled   bool       `yaml:"enabled"`
+	Weekday   string     `yaml:"weekday"`
+	Time      string     `yaml:"time"`
+	Templates []Template `yaml:"templates"`
+}
+
+var defaultOffenderTemplates = []Template{
+	{Text: "👑 Нарушитель недели — {who}: {times} довёл(а) чат до сброса счётчика {topic}. Поаплодируем.", Weight: 1},
+}
+
+// weekOffender returns who caused the most resets in [from, to), see
+// eachCulprit
+func weekOffender(st *ChatState, from, to time.Time) (string, int) {
+	ps := newPeriodStats()
+	st.eachCulprit(func(reset, culprit Event) {
+		if !reset.Time.Before(from) && reset.Time.Before(to) {
+			ps.blame(culprit)
+		}
+	})
+	return ps.topOffender()
+}
+
+func checkOffender(b *tb.Bot, cfg Config, store *Store, now time.Time) {
+	day, _ := parseWeekday(cfg.Offender.Weekday)
+	at, _ := parseClock(cfg.Offender.Time)
+
+	type announcement struct {
+		chatID int64
+		text   templated
+	}
+	var due []announcement
+	var checked []int64
+	store.View(func(s *Storage) {
+		for chatID, st := range s.ActiveChats() {
+			// a personal counter has no one to shame but its owner
+			if chatID > 0 {
+				continue
+			}
+			if st.LastOffender.IsZero() {
+				// a chat seen for the first time waits for the next slot
+				checked = append(checked, chatID)
+				continue
+			}
+			if !weeklyDue(day, at, st.LastOffender, now) {
+				continue
+			}
+			checked = append(checked, chatID)
+			who, n := weekOffender(st, now.AddDate(0, 0, -7), now)
+			if n == 0 {
+				slog.Debug("No confirmed resets this week, skipping offender", "chat_id", chatID)
+				continue
+			}
+			tpl := pickTemplate(cfg.Offender.Templates)
+			due = append(due, announcement{chatID: chatID, text: templated{Template: tpl, Text: renderTemplate(tpl, map[string]string{
+				"who":   who,
+				"count": strconv.Itoa(n),
+				"times": plural(n, "time"),
+				"topic": counterTopic(cfg, &st.Counter),
+			})}})
+		}
+	})
+	if len(checked) == 0 {
+		return
+	}
+	store.Update(func(s *Storage) {
+		for _, chatID := range checked {
+			s.Chat(chatID).LastOffender = now
+		}
+	})
+
+	for _, a := range due {
+		slog.Info("Announcing offender of the week", "chat_id", a.chatID)
+		if _, err := postToChat(b, store, a.chatID, a.text); err != nil {
+			slog.Error("Failed to send offender of the week", "chat_id", a.chatID, "err", err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	tb "gopkg.in/telebot.v3"
+)
+
+const anonymousName = "аноним"
+
+// Attribute strips the names from ev if its user opted out of attribution
+func (s *Storage) Attribute(ev Event) Event {
+	if s.OptedOut[ev.UserID] {
+		ev.Anonymous = true
+		ev.Username, ev.Name = "", ""
+	}
+	return ev
+}
+
+// OptOut hides userID from all attributions, scrubbing the names from the
+// history of every chat. The events themselves stay and are counted anonymously.
+func (s *Storage) OptOut(userID int64) {
+	if s.OptedOut == nil {
+		s.OptedOut = make(map[int64]bool)
+	}
+	s.OptedOut[userID] = true
+	for _, st := range s.Chats {
+		for i := range st.History {
+			if st.History[i].UserID == userID {
+				st.History[i] = s.Attribute(st.History[i])
+			}
+		}
+		delete(st.Users, userID)
+		st.Trimmed.optOut(userID)
+	}
+}
+
+func handleOptOut(store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		if err := store.UpdateContext(ctxOf(c), func(s *Storage) {
+			s.OptOut(c.Sender().ID)
+		}); err != nil {
+			return err
+		}
+		return c.Send("Готово: ваше имя больше не появится в рейтингах, отчётах и объявлениях. " +
+			"Срабатывания по-прежнему считаются, но анонимно. Вернуть: /optin")
+	}
+}
+
+func handleOptIn(store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		if err := store.UpdateContext(ctxOf(c), func(s *Storage) {
+			delete(s.OptedOut, c.Sender().ID)
+		}); err != nil {
+			return err
+		}
+		return c.Send("Ваше имя снова будет указываться в статистике. Прошлые анонимные события останутся анонимными.")
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// pauseCounter suspends or resumes the counter named in the command payload
+func pauseCounter(cfg Config, store *Store, c tb.Context, pause bool) error {
+	name := strings.ToLower(strings.TrimSpace(c.Message().Payload))
+
+	var topic, reply string
+	if err := store.UpdateContext(ctxOf(c), func(s *Storage) {
+		ctr := s.Chat(c.Chat().ID).CounterByName(name)
+		if ctr == nil {
+			reply = "Нет такого счётчика. Список: /counters"
+			return
+		}
+		topic = counterTopic(cfg, ctr)
+		now := clock()
+		switch {
+		case pause && ctr.Paused():
+			reply = "Счётчик «" + topic + "» уже на паузе с " + ctr.PausedAt.Format("02.01.2006 15:04") + "."
+		case pause:
+			ctr.PausedAt = now
+			reply = "⏸ Счётчик «" + topic + "» на паузе: упоминания не отслеживаются, счёт остановлен. /resume — продолжить."
+		case !ctr.Paused():
+			reply = "Счётчик «" + topic + "» и так не на паузе."
+		default:
+			paused := now.Sub(ctr.PausedAt)
+			if !ctr.LastMention.IsZero() {
+				ctr.PausedTotal += paused
+			}
+			ctr.PausedAt = time.Time{}
+			reply = "▶️ Счётчик «" + topic + "» снова идёт. Пауза длилась " + formatExact(paused.Truncate(time.Minute)) + " и в счёт не вошла."
+		}
+	}); err != nil {
+		return err
+	}
+	ctxLogger(c).Info("Counter pause changed", "counter", name, "paused", pause)
+	return c.Send(reply)
+}
+
+func handlePause(cfg Config, store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		return pauseCounter(cfg, store, c, true)
+	}
+}
+
+func handleResume(cfg Config, store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		return pauseCounter(cfg, store, c, false)
+	}
+}
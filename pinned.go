@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// PinnedConfig controls the live counter message pinned via /pin
+type PinnedConfig struct {
+	// Interval between refreshes of the pinned message, 1h by default
+	Interval time.Duration `yaml:"interval"`
+}
+
+// pinnedText always uses the first /days template so refreshes only
+// change the message when the count does
+func pinnedText(cfg Config, ctr *Counter) string {
+	return formatDays(cfg, ctr, cfg.Templates.Days[0].Text)
+}
+
+func pinnedMessage(chatID int64, id int) tb.StoredMessage {
+	return tb.StoredMessage{ChatID: chatID, MessageID: strconv.Itoa(id)}
+}
+
+// isMessageGone reports whether err means the message can no longer be edited
+func isMessageGone(err error) bool {
+	return errors.Is(err, tb.ErrCantEditMessage) ||
+		strings.Contains(err.Error(), "message to edit not found")
+}
+
+// isChatGone reports whether err means the bot can no longer post to the chat
+func isChatGone(err error) bool {
+	return errors.Is(err, tb.ErrKickedFromGroup) ||
+		errors.Is(err, tb.ErrKickedFromSuperGroup) ||
+		errors.Is(err, tb.ErrChatNotFound)
+}
+
+// postPinned sends a fresh counter message and pins it
+func postPinned(b *tb.Bot, store *Store, chatID int64, text string) (int, error) {
+	msg, err := postToChat(b, store, chatID, text, tb.Silent)
+	if err != nil {
+		return 0, err
+	}
+	if err := b.Pin(msg, tb.Silent); err != nil {
+		// the message is still updated, it just won't be on top
+		slog.Warn("Failed to pin counter", "chat_id", chatID, "err", err)
+	}
+	return msg.ID, nil
+}
+
+func handlePin(b *tb.Bot, cfg Config, store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		chatID := c.Chat().ID
+
+		var oldID int
+		var text string
+		if err := store.ViewContext(ctxOf(c), func(s *Storage) {
+			st := s.Chat(chatID)
+			oldID = st.PinnedID
+			text = pinnedText(cfg, &st.Counter)
+		}); err != nil {
+			return err
+		}
+		if oldID != 0 {
+			if err := b.Unpin(c.Chat(), oldID); err != nil {
+				ctxLogger(c).Debug("Failed to unpin old counter", "message_id", oldID, "err", err)
+			}
+		}
+
+		id, err := postPinned(b, store, chatID, text)
+		if err != nil {
+			return err
+		}
+		if err := store.UpdateContext(ctxOf(c), func(s *Storage) {
+			st := s.Chat(chatID)
+			st.PinnedID = id
+			st.PinnedText = text
+		}); err != nil {
+			return err
+		}
+		return nil
+	}
+}
+
+func handleUnpin(b *tb.Bot, store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		var id int
+		if err := store.UpdateContext(ctxOf(c), func(s *Storage) {
+			st := s.Chat(c.Chat().ID)
+			id = st.PinnedID
+			st.PinnedID = 0
+			st.PinnedText = ""
+		}); err != nil {
+			return err
+		}
+		if id == 0 {
+			return c.Send("Закреплённого счётчика нет.")
+		}
+		if err := b.Unpin(c.Chat(), id); err != nil {
+			ctxLogger(c).Debug("Failed to unpin counter", "message_id", id, "err", err)
+		}
+		return c.Send("Счётчик больше не обновляется.")
+	}
+}
+
+// refreshPinnedChat brings the pinned counter of one chat up to date,
+// reposting it if the original message is gone
+func refreshPinnedChat(b *tb.Bot, cfg Config, store *Store, chatID int64) {
+	var id int
+	var text, oldText string
+	store.View(func(s *Storage) {
+		st := s.Chat(chatID)
+		id, oldText = st.PinnedID, st.PinnedText
+		text = pinnedText(cfg, &st.Counter)
+	})
+	if id == 0 || text == oldText {
+		return
+	}
+
+	_, err := b.Edit(pinnedMessage(chatID, id), text)
+	switch {
+	case err == nil, errors.Is(err, tb.ErrMessageNotModified), errors.Is(err, tb.ErrSameMessageContent):
+	case isChatGone(err):
+		slog.Warn("Chat is gone, dropping pinned counter", "chat_id", chatID, "err", err)
+		id, text = 0, ""
+	case isMessageGone(err):
+		slog.Warn("Pinned counter is gone, reposting", "chat_id", chatID, "message_id", id)
+		if id, err = postPinned(b, store, chatID, text); err != nil {
+			slog.Error("Failed to repost pinned counter", "chat_id", chatID, "err", err)
+			return
+		}
+	default:
+		// transient, try again on the next tick
+		slog.Error("Failed to update pinned counter", "chat_id", chatID, "err", err)
+		return
+	}
+
+	store.Update(func(s *Storage) {
+		st := s.Chat(chatID)
+		st.PinnedID = id
+		st.PinnedText = text
+	})
+}
+
+func refreshPinned(b *tb.Bot, cfg Config, store *Store) {
+	var chats []int64
+	store.View(func(s *Storage) {
+		for chatID, st := range s.ActiveChats() {
+			if st.PinnedID != 0 {
+				chats = append(chats, chatID)
+			}
+		}
+	})
+	for _, chatID := range chats {
+		refreshPinnedChat(b, cfg, store, chatID)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// chatUser is the author of a message. Users of platforms other than
+// Telegram get IDs from externalUserID.
+type chatUser struct {
+	ID       int64
+	Username string
+	Name     string
+}
+
+// ChatPlatform is an incoming message as the core counter logic sees it,
+// whatever chat platform it came from: who wrote what in which chat, and
+// how to answer there. Adapters implement it for their messages and
+// commands, and the core never touches a platform API itself.
+type ChatPlatform interface {
+	// Chat is the platform chat: the chat ID on Telegram, "discord:<guild
+	// id>" and the like elsewhere, see externalChat
+	Chat() string
+	Sender() chatUser
+	// IsAdmin reports whether the sender may manage the chat, false where
+	// the platform doesn't tell
+	IsAdmin() bool
+	// Text is the message, empty for native commands
+	Text() string
+	// Send posts text to the chat and returns a reference for Edit
+	Send(text string) (string, error)
+	// Reply answers the message itself, in its thread where the platform
+	// has threads
+	Reply(text string) (string, error)
+	// Edit replaces the text of a message sent earlier
+	Edit(ref, text string) error
+}
+
+// richPlatform is a ChatPlatform with what only Telegram has so far: forum
+// topics, private chats, media, buttons and a pinned status message. The
+// core falls back to plain text without it.
+type richPlatform interface {
+	ChatPlatform
+	// Context ends when the update is no longer worth handling
+	Context() context.Context
+	Logger() *slog.Logger
+	// Thread is the forum topic of the message, 0 without one
+	Thread() int
+	// MessageID is the message in the chat, 0 for button presses
+	MessageID() int
+	// Personal reports a chat of the sender alone with the bot
+	Personal() bool
+	// ReplyTemplated answers with t, recording its template in the audit
+	ReplyTemplated(t templated) error
+	// ReplyMedia answers with a sticker or GIF of set
+	ReplyMedia(set MediaSet) error
+	// AskApproval posts text with a button approving the reset of counter
+	AskApproval(text, counter string) error
+	// Achieved announces achievements unlocked by the message
+	Achieved(list []awarded)
+	// Changed is called after a reset was saved, to update what shows the
+	// counters
+	Changed()
+	// AllowDetection throttles senders who trigger detections too often
+	AllowDetection() bool
+}
+
+// errEditUnsupported is returned by Edit of answers the platform doesn't
+// let the bot change
+var errEditUnsupported = errors.New("the platform can't edit this message")
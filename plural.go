@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// pluralForms are the one / few / many forms of a noun, e.g. день / дня / дней
+type pluralForms [3]string
+
+// pluralRule maps a count to an index into pluralForms
+type pluralRule func(n int) int
+
+// language bundles the plural rule with the unit nouns of one language
+type language struct {
+	rule  pluralRule
+	units map[string]pluralForms
+}
+
+var languages = map[string]language{
+	"ru": {
+		rule: ruPlural,
+		units: map[string]pluralForms{
+			"day":    {"день", "дня", "дней"},
+			"hour":   {"час", "часа", "часов"},
+			"minute": {"минута", "минуты", "минут"},
+			"second": {"секунда", "секунды", "секунд"},
+			"time":   {"раз", "раза", "раз"},
+			"year":   {"год", "года", "лет"},
+			"chat":   {"чат", "чата", "чатов"},
+		},
+	},
+}
+
+// ruPlural implements the Russian rule: 1, 21 → one; 2-4, 22-24 → few; rest → many
+func ruPlural(n int) int {
+	if n < 0 {
+		n = -n
+	}
+	switch {
+	case n%10 == 1 && n%100 != 11:
+		return 0
+	case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+		return 1
+	default:
+		return 2
+	}
+}
+
+// pluralWord returns the form of unit that agrees with n
+func pluralWord(n int, unit string) string {
+	lang := languages["ru"]
+	return lang.units[unit][lang.rule(n)]
+}
+
+// plural formats n together with the agreeing form of unit: "1 день", "5 дней"
+func plural(n int, unit string) string {
+	return fmt.Sprintf("%d %s", n, pluralWord(n, unit))
+}
+
+// formatStreak renders an elapsed streak. Below one day it shows hours and
+// minutes; above that whole days, plus hours when combined is set.
+func formatStreak(d time.Duration, combined bool) string {
+	days := durationDays(d)
+	hours := int(d.Hours()) % 24
+	if days == 0 {
+		minutes := int(d.Minutes()) % 60
+		if hours == 0 {
+			return plural(minutes, "minute")
+		}
+		return plural(hours, "hour") + " " + plural(minutes, "minute")
+	}
+	if !combined || hours == 0 {
+		return plural(days, "day")
+	}
+	return plural(days, "day") + ", " + plural(hours, "hour")
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+)
+
+// PprofConfig exposes net/http/pprof for profiling. Listen must be a
+// loopback address, the profiles are never served to the network.
+type PprofConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Listen  string `yaml:"listen"`
+}
+
+// isLoopback reports whether addr ("host:port") binds to a loopback interface only
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// startPprof serves the profiling endpoints under /debug/pprof/ in the background
+func startPprof(cfg Config) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	slog.Info("pprof listening", "url", "http://"+cfg.Pprof.Listen+"/debug/pprof/")
+	go func() {
+		if err := http.ListenAndServe(cfg.Pprof.Listen, mux); err != nil {
+			slog.Error("pprof server stopped", "err", err)
+		}
+	}()
+}
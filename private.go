@@ -0,0 +1,28 @@
+package main
+
+import (
+	tb "gopkg.in/telebot.v3"
+)
+
+// isPersonal reports whether chat is a one-on-one chat with the bot. Its ID
+// equals the user's ID, so the personal counter is stored under the user.
+func isPersonal(chat *tb.Chat) bool {
+	return chat.Type == tb.ChatPrivate
+}
+
+func handleStart(cfg Config, store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		var topic string
+		if err := store.ViewContext(ctxOf(c), func(s *Storage) {
+			topic = counterTopic(cfg, &s.Chat(c.Chat().ID).Counter)
+		}); err != nil {
+			return err
+		}
+		if isPersonal(c.Chat()) {
+			return c.Send("Привет! Здесь я веду ваш личный счётчик дней без " + topic + ".\n" +
+				"Если напишете ключевое слово, спрошу, не пора ли сбросить. Сорвались — /reset, " +
+				"посмотреть серию — /days или /since. Свои счётчики: /newcounter, /counters.")
+		}
+		return c.Send("Привет! Я считаю дни без " + topic + " в этом чате. Текущая серия: /days")
+	}
+}
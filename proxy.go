@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// newHTTPClient returns the client for Bot API requests. A configured proxy
+// (http://, https:// or socks5:// URL) wins over the HTTPS_PROXY / ALL_PROXY
+// environment, which is used otherwise. Successful polls are tracked for the
+// health endpoints, messages go through the outbox flood control and failed
+// calls are retried, see retryTransport.
+func newHTTPClient(cfg Config) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.Proxy != "" {
+		u, _ := url.Parse(cfg.Proxy)
+		transport.Proxy = http.ProxyURL(u)
+		slog.Info("Using proxy", "scheme", u.Scheme, "host", u.Host)
+	}
+	return &http.Client{Timeout: time.Minute, Transport: pollTracker{last: &lastPoll, next: newOutbox(retryTransport{next: transport, cfg: cfg.Retry}, cfg.Queue)}}
+}
+
+// validProxy checks that raw is a proxy URL the transport understands
+func validProxy(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+		return true
+	}
+	return false
+}
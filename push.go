@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const (
+	pushQueueSize = 256
+	pushoverAPI   = "https://api.pushover.net/1/messages.json"
+)
+
+// PushConfig enables /push, with which chat admins get resets and record
+// streaks of the chat as phone notifications through ntfy or Pushover
+type PushConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// NtfyURL is the ntfy server, https://ntfy.sh by default
+	NtfyURL string `yaml:"ntfy_url"`
+	// NtfyToken is the access token for servers with access control
+	NtfyToken string `yaml:"ntfy_token"`
+	// PushoverToken is the application token, /push pushover needs it
+	PushoverToken string `yaml:"pushover_token"`
+}
+
+// PushTarget is where the notifications of a chat go
+type PushTarget struct {
+	// Ntfy is the topic on the ntfy server
+	Ntfy string `json:"ntfy,omitempty"`
+	// Pushover is the user or group key
+	Pushover string `json:"pushover,omitempty"`
+}
+
+// pushSink sends the resets and records of chats with a PushTarget in the
+// background. The chats are looked up in the storage of the bot of the
+// event.
+type pushSink struct {
+	cfg    PushConfig
+	bots   []*botInstance
+	queue  chan counterEvent
+	client *http.Client
+}
+
+func newPushSink(cfg PushConfig, bots []*botInstance) *pushSink {
+	s := &pushSink{
+		cfg:    cfg,
+		bots:   bots,
+		queue:  make(chan counterEvent, pushQueueSize),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	go s.run()
+	return s
+}
+
+func (s *pushSink) Publish(ev counterEvent) {
+	if ev.Type != HookReset && ev.Type != HookRecord {
+		return
+	}
+	select {
+	case s.queue <- ev:
+	default:
+		slog.Warn("Push queue is full, dropping event", "type", ev.Type, "chat_id", ev.ChatID)
+	}
+}
+
+func (s *pushSink) run() {
+	for ev := range s.queue {
+		var target PushTarget
+		for _, bi := range s.bots {
+			if bi.bot().Me.Username != ev.Bot {
+				continue
+			}
+			bi.store.View(func(st *Storage) {
+				if chat := st.Chats[ev.ChatID]; chat != nil && chat.Push != nil {
+					target = *chat.Push
+				}
+			})
+		}
+		title := "Дни без " + ev.Topic
+		text := simpleEventOf(ev).Text
+		if target.Ntfy != "" {
+			if err := s.ntfy(target.Ntfy, ev.Type, title, text); err != nil {
+				slog.Error("Failed to send ntfy notification", "chat_id", ev.ChatID, "err", err)
+			}
+		}
+		if target.Pushover != "" && s.cfg.PushoverToken != "" {
+			if err := s.pushover(target.Pushover, title, text); err != nil {
+				slog.Error("Failed to send Pushover notification", "chat_id", ev.ChatID, "err", err)
+			}
+		}
+	}
+}
+
+func (s *pushSink) ntfy(topic, typ, title, text string) error {
+	// headers must be ASCII, the query parameters take UTF-8
+	params := url.Values{"title": {title}, "tags": {"trophy"}}
+	if typ == HookReset {
+		params.Set("tags", "rotating_light")
+		params.Set("priority", "high")
+	}
+	u := s.cfg.NtfyURL + "/" + url.PathEscape(topic) + "?" + params.Encode()
+	req, err := http.NewRequest(http.MethodPost, u, strings.NewReader(text))
+	if err != nil {
+		return err
+	}
+	if s.cfg.NtfyToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.NtfyToken)
+	}
+	return s.do(req)
+}
+
+func (s *pushSink) pushover(user, title, text string) error {
+	form := url.Values{"token": {s.cfg.PushoverToken}, "user": {user}, "title": {title}, "message": {text}}
+	req, err := http.NewRequest(http.MethodPost, pushoverAPI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return s.do(req)
+}
+
+func (s *pushSink) do(req *http.Request) error {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// handlePush sets where the notifications of the chat go:
+// /push ntfy <topic>, /push pushover <user key> or /push off
+func handlePush(b *tb.Bot, cfg Config, store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		args := strings.Fields(c.Message().Payload)
+		if len(args) == 0 {
+			var target PushTarget
+			store.View(func(s *Storage) {
+				if p := s.Chat(c.Chat().ID).Push; p != nil {
+					target = *p
+				}
+			})
+			if target == (PushTarget{}) {
+				return c.Send("Push-уведомления о сбросах и рекордах выключены.\n" +
+					"Включить: /push ntfy <топик> или /push pushover <ключ пользователя>")
+			}
+			var lines []string
+			if target.Ntfy != "" {
+				lines = append(lines, "ntfy: "+cfg.Push.NtfyURL+"/"+target.Ntfy)
+			}
+			if target.Pushover != "" {
+				lines = append(lines, "Pushover: ключ …"+target.Pushover[max(0, len(target.Pushover)-4):])
+			}
+			return c.Send("Push-уведомления о сбросах и рекордах:\n" + strings.Join(lines, "\n") + "\nВыключить: /push off")
+		}
+
+		switch {
+		case len(args) == 1 && strings.EqualFold(args[0], "off"):
+			if err := store.UpdateContext(ctxOf(c), func(s *Storage) { s.Chat(c.Chat().ID).Push = nil }); err != nil {
+				return err
+			}
+			return c.Send("Push-уведомления выключены.")
+		case len(args) == 2 && strings.EqualFold(args[0], "ntfy"):
+			if err := store.UpdateContext(ctxOf(c), func(s *Storage) {
+				st := s.Chat(c.Chat().ID)
+				if st.Push == nil {
+					st.Push = &PushTarget{}
+				}
+				st.Push.Ntfy = args[1]
+			}); err != nil {
+				return err
+			}
+			return c.Send("Готово: сбросы и рекорды придут в " + cfg.Push.NtfyURL + "/" + args[1] + ". Подпишитесь на топик в приложении ntfy.")
+		case len(args) == 2 && strings.EqualFold(args[0], "pushover"):
+			if cfg.Push.PushoverToken == "" {
+				return c.Send("Pushover не настроен у бота.")
+			}
+			if err := store.UpdateContext(ctxOf(c), func(s *Storage) {
+				st := s.Chat(c.Chat().ID)
+				if st.Push == nil {
+					st.Push = &PushTarget{}
+				}
+				st.Push.Pushover = args[1]
+			}); err != nil {
+				return err
+			}
+			// the key lets anyone notify the user, don't leave it in the chat
+			if !isPersonal(c.Chat()) {
+				if err := b.Delete(c.Message()); err != nil {
+					ctxLogger(c).Warn("Failed to delete the /push message", "err", err)
+				}
+			}
+			return c.Send("Готово: сбросы и рекорды придут в Pushover.")
+		default:
+			return c.Send("Использование: /push ntfy <топик> | pushover <ключ пользователя> | off")
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QueueConfig is the flood control of outgoing messages: PerChat is the
+// minimal gap between two messages to the same chat, GlobalRate the number
+// of messages per second across all chats
+type QueueConfig struct {
+	PerChat    time.Duration `yaml:"per_chat"`
+	GlobalRate int           `yaml:"global_rate"`
+}
+
+// outbox serializes message sends per chat and paces them to Telegram's
+// limits. Identical messages to a chat that are in flight at the same time
+// are sent once and all callers get the same answer.
+type outbox struct {
+	next http.RoundTripper
+	cfg  QueueConfig
+
+	mu      sync.Mutex
+	chats   map[string]*chatQueue
+	flights map[[32]byte]*flight
+	global  time.Time
+}
+
+// chatQueue holds the send order of one chat
+type chatQueue struct {
+	mu   sync.Mutex
+	next time.Time
+
+	// users counts the sends holding the queue, idle is when the last of
+	// them no longer holds up the next one; both are guarded by outbox.mu
+	users int
+	idle  time.Time
+}
+
+// flight is a send shared by identical requests
+type flight struct {
+	done chan struct{}
+	resp *http.Response
+	body []byte
+	err  error
+}
+
+func newOutbox(next http.RoundTripper, cfg QueueConfig) *outbox {
+	return &outbox{next: next, cfg: cfg, chats: make(map[string]*chatQueue), flights: make(map[[32]byte]*flight)}
+}
+
+// queued reports whether the Bot API method posts or changes a message
+func queued(method string) bool {
+	for _, prefix := range []string{"send", "forward", "copy", "edit"} {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *outbox) RoundTrip(r *http.Request) (*http.Response, error) {
+	method := methodName(r)
+	if !queued(method) {
+		return o.next.RoundTrip(r)
+	}
+	// multipart uploads are streamed, only the global pace applies to them
+	if r.GetBody == nil {
+		if err := o.waitGlobal(r); err != nil {
+			return nil, err
+		}
+		return o.next.RoundTrip(r)
+	}
+	body, err := r.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	var params struct {
+		ChatID json.RawMessage `json:"chat_id"`
+	}
+	json.Unmarshal(data, &params)
+	key := sha256.Sum256(append([]byte(method+"\x00"), data...))
+
+	o.mu.Lock()
+	if f, ok := o.flights[key]; ok {
+		o.mu.Unlock()
+		return f.wait(r)
+	}
+	f := &flight{done: make(chan struct{})}
+	o.flights[key] = f
+	q := o.chat(string(params.ChatID))
+	o.mu.Unlock()
+
+	f.resp, f.err = o.send(r, q)
+	o.release(q)
+	if f.err == nil {
+		f.body, f.err = io.ReadAll(f.resp.Body)
+		f.resp.Body.Close()
+	}
+	o.mu.Lock()
+	delete(o.flights, key)
+	o.mu.Unlock()
+	close(f.done)
+	return f.response()
+}
+
+// send waits for the turn of the chat and the global pace, then sends r
+func (o *outbox) send(r *http.Request, q *chatQueue) (*http.Response, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := sleepUntil(r, q.next); err != nil {
+		return nil, err
+	}
+	if err := o.waitGlobal(r); err != nil {
+		return nil, err
+	}
+	resp, err := o.next.RoundTrip(r)
+	q.next = time.Now().Add(o.cfg.PerChat)
+	return resp, err
+}
+
+// waitGlobal takes the next free slot of the global pace
+func (o *outbox) waitGlobal(r *http.Request) error {
+	o.mu.Lock()
+	slot := time.Now()
+	if o.global.After(slot) {
+		slot = o.global
+	}
+	o.global = slot.Add(time.Second / time.Duration(o.cfg.GlobalRate))
+	o.mu.Unlock()
+	return sleepUntil(r, slot)
+}
+
+// chat returns the queue of chatID for a send, to be given back with
+// release; o.mu must be held. Beyond limiterSweepSize chats the queues
+// nobody holds and whose last send is past the per chat gap are dropped,
+// so chats the bot left don't stay forever.
+func (o *outbox) chat(chatID string) *chatQueue {
+	if len(o.chats) > limiterSweepSize {
+		now := time.Now()
+		for id, q := range o.chats {
+			if q.users == 0 && !now.Before(q.idle) {
+				delete(o.chats, id)
+			}
+		}
+	}
+	q, ok := o.chats[chatID]
+	if !ok {
+		q = &chatQueue{}
+		o.chats[chatID] = q
+	}
+	q.users++
+	return q
+}
+
+// release gives back a queue taken by chat once its send is done
+func (o *outbox) release(q *chatQueue) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	q.users--
+	q.idle = time.Now().Add(o.cfg.PerChat)
+}
+
+// wait blocks until the shared send is done and returns its answer
+func (f *flight) wait(r *http.Request) (*http.Response, error) {
+	select {
+	case <-f.done:
+		return f.response()
+	case <-r.Context().Done():
+		return nil, r.Context().Err()
+	}
+}
+
+// response is a copy of the answer with its own body
+func (f *flight) response() (*http.Response, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	resp := *f.resp
+	resp.Body = io.NopCloser(bytes.NewReader(f.body))
+	return &resp, nil
+}
+
+func sleepUntil(r *http.Request, t time.Time) error {
+	d := time.Until(t)
+	if d <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(d):
+		return nil
+	case <-r.Context().Done():
+		return r.Context().Err()
+	}
+}
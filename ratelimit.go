@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// slidingLimiter allows at most limit events per key within window
+type slidingLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	hits   map[int64][]time.Time
+}
+
+func newSlidingLimiter(limit int, window time.Duration) *slidingLimiter {
+	return &slidingLimiter{limit: limit, window: window, hits: make(map[int64][]time.Time)}
+}
+
+// Allow records an event for key and reports whether it fits into the limit.
+// A limiter with a non-positive limit allows everything.
+func (l *slidingLimiter) Allow(key int64, now time.Time) bool {
+	if l.limit <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.hits) > limiterSweepSize {
+		l.sweep(now)
+	}
+
+	recent := l.hits[key][:0]
+	for _, t := range l.hits[key] {
+		if now.Sub(t) < l.window {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= l.limit {
+		l.hits[key] = recent
+		return false
+	}
+	l.hits[key] = append(recent, now)
+	return true
+}
+
+// sweep forgets keys without events in the window
+func (l *slidingLimiter) sweep(now time.Time) {
+	for key, hits := range l.hits {
+		if len(hits) == 0 || now.Sub(hits[len(hits)-1]) >= l.window {
+			delete(l.hits, key)
+		}
+	}
+}
+
+// userLimit throttles each user to perMinute events. The first event over
+// the limit in a minute gets a soft warning, the following ones are ignored
+// silently so the warnings don't flood the chat either.
+type userLimit struct {
+	events *slidingLimiter
+	warned *slidingLimiter
+}
+
+func newUserLimit(perMinute int) *userLimit {
+	return &userLimit{
+		events: newSlidingLimiter(perMinute, time.Minute),
+		warned: newSlidingLimiter(1, time.Minute),
+	}
+}
+
+const slowDownText = "Помедленнее, пожалуйста: слишком много подряд. Попробуйте через минуту."
+
+// Allow records an event of the sender of c and reports whether it fits
+// into the limit, warning the sender when it doesn't
+func (l *userLimit) Allow(c tb.Context) bool {
+	user := c.Sender()
+	if user == nil || l.events.Allow(user.ID, clock()) {
+		return true
+	}
+	var err error
+	switch {
+	case c.Callback() != nil:
+		// button answers are only shown to the user, no need to hold back
+		err = c.Respond(&tb.CallbackResponse{Text: slowDownText})
+	case l.warned.Allow(user.ID, clock()):
+		err = c.Reply(slowDownText)
+	}
+	if err != nil {
+		ctxLogger(c).Error("Failed to send rate limit warning", "err", err)
+	}
+	return false
+}
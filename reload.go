@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+	"gopkg.in/yaml.v3"
+)
+
+// reloadRequests carries token reloads asked for with /reload
+var reloadRequests = make(chan struct{}, 1)
+
+// requestReload re-reads the bot tokens as if the process got SIGHUP
+func requestReload() {
+	select {
+	case reloadRequests <- struct{}{}:
+	default:
+	}
+}
+
+// reloadOnSignal re-reads the bot tokens from the config on SIGHUP or
+// /reload and rotates the bots whose token changed
+func reloadOnSignal(bots []*botInstance, cfg Config) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	for {
+		select {
+		case <-sig:
+		case <-reloadRequests:
+		}
+		reloadTokens(bots, cfg.Shutdown.Timeout)
+	}
+}
+
+// configTokens reads only the bot tokens from the config file, in the order
+// of the bots; other settings need a restart
+func configTokens() ([]string, error) {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	var raw struct {
+		BotToken string `yaml:"bot_token"`
+		Bots     []struct {
+			BotToken string `yaml:"bot_token"`
+		} `yaml:"bots"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	if len(raw.Bots) == 0 {
+		return []string{raw.BotToken}, nil
+	}
+	var tokens []string
+	for _, bc := range raw.Bots {
+		if bc.BotToken == "" {
+			bc.BotToken = raw.BotToken
+		}
+		tokens = append(tokens, bc.BotToken)
+	}
+	return tokens, nil
+}
+
+func reloadTokens(bots []*botInstance, timeout time.Duration) {
+	slog.Info("Reloading bot tokens", "file", configFile)
+	tokens, err := configTokens()
+	if err != nil {
+		slog.Error("Failed to read bot tokens", "file", configFile, "err", err)
+		alerts.Alert("смена токена", err.Error())
+		return
+	}
+	if len(tokens) != len(bots) {
+		slog.Error("Number of bots changed, restart to apply", "bots", len(bots), "config", len(tokens))
+		return
+	}
+	for i, bi := range bots {
+		bi.mu.Lock()
+		same := bi.token == tokens[i]
+		bi.mu.Unlock()
+		if same {
+			continue
+		}
+		if err := bi.reconnect(tokens[i], timeout); err != nil {
+			slog.Error("Failed to rotate bot token", "bot", bi.bot().Me.Username, "err", err)
+			alerts.Alert("смена токена", err.Error())
+			continue
+		}
+		slog.Info("Bot token rotated", "bot", bi.bot().Me.Username)
+	}
+}
+
+// reconnect replaces the telebot instance with one using token. Storage,
+// captured updates and limits stay as they are. The old instance stops
+// fetching updates, finishes the received ones and is stopped; run then
+// starts the new one.
+func (bi *botInstance) reconnect(token string, timeout time.Duration) error {
+	old := bi.bot()
+	b, poller, err := bi.connect(token)
+	if err != nil {
+		return fmt.Errorf("new token doesn't work: %w", err)
+	}
+	if b.Me.ID != old.Me.ID {
+		return fmt.Errorf("new token belongs to @%s, not @%s", b.Me.Username, old.Me.Username)
+	}
+
+	bi.mu.Lock()
+	if bi.stopped {
+		bi.mu.Unlock()
+		return nil
+	}
+	oldPoller := bi.poller
+	bi.b, bi.poller, bi.token = b, poller, token
+	bi.mu.Unlock()
+
+	oldPoller.Drain()
+	if !waitHandled([]*tb.Bot{old}, timeout) {
+		slog.Warn("Handlers still running at token rotation", "timeout", timeout)
+	}
+	old.Stop()
+	return nil
+}
+
+// handleReload asks for the tokens to be re-read from the config
+func handleReload() tb.HandlerFunc {
+	return func(c tb.Context) error {
+		requestReload()
+		return c.Send("Перечитываю токены из конфига. Если токен сменился, бот переподключится, ошибки уйдут в лог и алерты.")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// ReminderConfig controls the daily "day N begins" reminders
+type ReminderConfig struct {
+	// Enabled is the default for chats that never ran /reminders
+	Enabled bool `yaml:"enabled"`
+	// Hour of day to post at, in the timezone of the chat (see
+	// chatLocation). When unset the reminder is posted at the anniversary
+	// time of the last reset.
+	Hour *int `yaml:"hour"`
+}
+
+func remindersEnabled(cfg Config, st *ChatState) bool {
+	if st.Reminders != nil {
+		return *st.Reminders
+	}
+	return cfg.Reminders.Enabled
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
+
+// reminderDue reports whether a chat at the given streak should get a reminder now
+func reminderDue(cfg Config, st *ChatState, days int, now time.Time) bool {
+	if days == 0 {
+		return false
+	}
+	if h := cfg.Reminders.Hour; h != nil {
+		loc := chatLocation(cfg, st)
+		now = now.In(loc)
+		return now.Hour() >= *h && !sameDay(st.LastReminder.In(loc), now)
+	}
+	// anniversary mode: day count has grown since the last reminder
+	return st.LastReminder.Before(st.LastMention) ||
+		int(st.LastReminder.Sub(st.LastMention).Hours()/24) < days
+}
+
+func checkReminders(b *tb.Bot, cfg Config, store *Store, now time.Time) {
+	var due []chatDays
+	store.View(func(s *Storage) {
+		for chatID, st := range s.ActiveChats() {
+			if st.LastMention.IsZero() || st.Paused() || !remindersEnabled(cfg, st) {
+				continue
+			}
+			if days := st.Days(); reminderDue(cfg, st, days, now) {
+				due = append(due, chatDays{chatID: chatID, days: days, topic: counterTopic(cfg, &st.Counter)})
+			}
+		}
+	})
+	if len(due) == 0 {
+		return
+	}
+	store.Update(func(s *Storage) {
+		for _, a := range due {
+			s.Chat(a.chatID).LastReminder = now
+		}
+	})
+
+	for _, a := range due {
+		text := fmt.Sprintf("📅 Начался %d-й день без упоминания %s.", a.days+1, a.topic)
+		slog.Debug("Sending reminder", "chat_id", a.chatID, "day", a.days+1)
+		if _, err := postToChat(b, store, a.chatID, text); err != nil {
+			slog.Error("Failed to send reminder", "chat_id", a.chatID, "err", err)
+		}
+	}
+}
+
+func handleReminders(cfg Config, store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		arg := strings.ToLower(strings.TrimSpace(c.Message().Payload))
+
+		var enabled bool
+		switch arg {
+		case "on", "off":
+			if err := store.UpdateContext(ctxOf(c), func(s *Storage) {
+				v := arg == "on"
+				st := s.Chat(c.Chat().ID)
+				st.Reminders = &v
+				if v {
+					// don't fire immediately for the day that is already running
+					st.LastReminder = clock()
+				}
+				enabled = v
+			}); err != nil {
+				return err
+			}
+		case "":
+			if err := store.ViewContext(ctxOf(c), func(s *Storage) {
+				enabled = remindersEnabled(cfg, s.Chat(c.Chat().ID))
+			}); err != nil {
+				return err
+			}
+		default:
+			return c.Send("Использование: /reminders on|off")
+		}
+
+		if enabled {
+			return c.Send("Ежедневные напоминания включены.")
+		}
+		return c.Send("Ежедневные напоминания выключены.")
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// runReplay feeds updates captured with capture.enabled through the
+// handlers of a bot, against a sandbox storage and without network: Bot API
+// calls are printed and answered with stubs. The clock follows the dates of
+// the updates, so cooldowns and streaks behave as they did originally. It
+// returns the process exit code.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	botIndex := fs.Int("bot", 1, "number of the bots entry to replay against")
+	dataFrom := fs.String("data", "", "storage to start from, copied into the sandbox; fresh storage when empty")
+	sandbox := fs.String("sandbox", "replay-data.json", "sandbox storage file, overwritten")
+	username := fs.String("username", "", "bot username, for commands addressed as /days@bot")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: dayswithout replay [flags] updates.jsonl")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	configs := loadConfig()
+	if *botIndex < 1 || *botIndex > len(configs) {
+		fmt.Fprintf(os.Stderr, "No bot %d, the config has %d\n", *botIndex, len(configs))
+		return 2
+	}
+	cfg := offlineConfig(configs[*botIndex-1])
+	cfg.Log.File = LogFileConfig{}
+	setupLogging(cfg)
+
+	if err := prepareSandbox(*dataFrom, *sandbox); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to prepare sandbox storage:", err)
+		return 1
+	}
+	dump, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to open dump:", err)
+		return 1
+	}
+	defer dump.Close()
+
+	store := loadStorage(*sandbox)
+	store.maxHistory = cfg.MaxHistory
+	b, err := tb.NewBot(tb.Settings{
+		Token:       cfg.BotToken,
+		Offline:     true,
+		Synchronous: true,
+		Client:      &http.Client{Transport: &stubTransport{}},
+		OnError:     onError,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to create bot:", err)
+		return 1
+	}
+	id, _, _ := strings.Cut(cfg.BotToken, ":")
+	b.Me.ID, _ = strconv.ParseInt(id, 10, 64)
+	b.Me.Username = *username
+	b.Me.IsBot = true
+	bi := &botInstance{
+		cfg:        cfg,
+		store:      store,
+		prompts:    newSlidingLimiter(cfg.PromptsPerHour, time.Hour),
+		detections: newUserLimit(cfg.DetectionsPerMinute),
+		commands:   newUserLimit(cfg.CommandsPerMinute),
+		b:          b,
+	}
+	bi.register(b)
+
+	lines := bufio.NewScanner(dump)
+	lines.Buffer(nil, 16<<20)
+	count := 0
+	for lines.Scan() {
+		if len(strings.TrimSpace(lines.Text())) == 0 {
+			continue
+		}
+		var u tb.Update
+		if err := json.Unmarshal(lines.Bytes(), &u); err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping malformed line %d: %v\n", count+1, err)
+			continue
+		}
+		if t := updateTime(&u); !t.IsZero() {
+			clock = func() time.Time { return t }
+		}
+		fmt.Printf("#%d %s %s\n", u.ID, clock().Format("02.01.2006 15:04:05"), describeUpdate(&u))
+		b.ProcessUpdate(u)
+		count++
+	}
+	if err := lines.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to read dump:", err)
+		return 1
+	}
+	fmt.Printf("Replayed %d update(s), sandbox storage: %s\n", count, *sandbox)
+	return 0
+}
+
+// offlineConfig turns off what the handlers would call besides the Bot API:
+// the LLM answers with the templates, the classifier counts every mention
+// and voice messages aren't downloaded nor transcribed
+func offlineConfig(cfg Config) Config {
+	if cfg.LLM.URL != "" || cfg.Classifier.Enabled || cfg.Voice.Provider != "" {
+		fmt.Fprintln(os.Stderr, "LLM, classifier and voice transcription are off in replay: "+
+			"answers come from the templates and every mention counts")
+	}
+	cfg.LLM.URL = ""
+	cfg.Classifier.Enabled = false
+	cfg.Voice.Provider = ""
+	return cfg
+}
+
+// prepareSandbox makes sandbox a copy of from, or removes it to start fresh
+func prepareSandbox(from, sandbox string) error {
+	if from == "" {
+		if err := os.Remove(sandbox); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(sandbox, data, 0644)
+}
+
+// updateTime is the date Telegram put on the update, zero when it has none
+func updateTime(u *tb.Update) time.Time {
+	var unix int64
+	switch {
+	case u.Message != nil:
+		unix = u.Message.Unixtime
+	case u.EditedMessage != nil:
+		unix = u.EditedMessage.Unixtime
+	case u.ChannelPost != nil:
+		unix = u.ChannelPost.Unixtime
+	case u.Callback != nil && u.Callback.Message != nil:
+		unix = u.Callback.Message.Unixtime
+	case u.MyChatMember != nil:
+		unix = u.MyChatMember.Unixtime
+	}
+	if unix == 0 {
+		return time.Time{}
+	}
+	return time.Unix(unix, 0)
+}
+
+// describeUpdate is a one-line summary of u for the replay output
+func describeUpdate(u *tb.Update) string {
+	switch {
+	case u.Message != nil:
+		m := u.Message
+		from := ""
+		if m.Sender != nil {
+			from = fmt.Sprintf(" from %d", m.Sender.ID)
+		}
+		return fmt.Sprintf("message in %d%s: %q", m.Chat.ID, from, m.Text)
+	case u.ChannelPost != nil:
+		return fmt.Sprintf("channel post in %d: %q", u.ChannelPost.Chat.ID, u.ChannelPost.Text)
+	case u.Callback != nil:
+		return fmt.Sprintf("button %q from %d", u.Callback.Data, u.Callback.Sender.ID)
+	case u.MyChatMember != nil:
+		return fmt.Sprintf("bot membership in %d: %s", u.MyChatMember.Chat.ID, u.MyChatMember.NewChatMember.Role)
+	}
+	return "other update"
+}
+
+// stubTransport prints Bot API calls instead of making them and answers
+// with minimal successful results. Every user is reported as an admin.
+type stubTransport struct {
+	messageID int
+}
+
+func (t *stubTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	method := methodName(r)
+	params := requestParams(r)
+	var shown []string
+	for _, key := range []string{"chat_id", "message_id", "text", "caption", "sticker", "animation", "description", "title"} {
+		if v := params[key]; v != "" {
+			shown = append(shown, fmt.Sprintf("%s=%q", key, v))
+		}
+	}
+	fmt.Printf("  -> %s %s\n", method, strings.Join(shown, " "))
+
+	chatID, _ := strconv.ParseInt(params["chat_id"], 10, 64)
+	var result any = true
+	switch {
+	case method == "getChatMember":
+		userID, _ := strconv.ParseInt(params["user_id"], 10, 64)
+		result = map[string]any{"status": "administrator", "user": map[string]any{"id": userID}}
+	case method == "getChat":
+		result = map[string]any{"id": chatID, "type": "supergroup"}
+	case strings.HasPrefix(method, "send") || strings.HasPrefix(method, "edit") || method == "copyMessage" || method == "forwardMessage":
+		t.messageID++
+		result = map[string]any{
+			"message_id": t.messageID,
+			"date":       clock().Unix(),
+			"chat":       map[string]any{"id": chatID, "type": "supergroup"},
+			"text":       params["text"],
+		}
+	}
+	body, _ := json.Marshal(map[string]any{"ok": true, "result": result})
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(string(body))),
+		Request:    r,
+	}, nil
+}
+
+// requestParams reads the parameters of a JSON or multipart Bot API call
+func requestParams(r *http.Request) map[string]string {
+	params := make(map[string]string)
+	if r.Body == nil {
+		return params
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(32 << 20); err == nil {
+			for k, v := range r.MultipartForm.Value {
+				params[k] = v[0]
+			}
+		}
+		return params
+	}
+	var raw map[string]any
+	json.NewDecoder(r.Body).Decode(&raw)
+	for k, v := range raw {
+		if s, ok := v.(string); ok {
+			params[k] = s
+		} else {
+			params[k] = fmt.Sprint(v)
+		}
+	}
+	return params
+}
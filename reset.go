@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const (
+	// approvalsNeeded is the number of distinct admins a strict reset needs
+	approvalsNeeded = 2
+	resetVoteTTL    = time.Hour
+)
+
+// ResetVote collects admin approvals of a reset in strict mode
+type ResetVote struct {
+	Admins  []int64   `json:"admins"`
+	Started time.Time `json:"started"`
+	Reason  string    `json:"reason,omitempty"`
+}
+
+var approveResetBtn = tb.Btn{Unique: "approve_reset"}
+
+func handleReset(t *telegramFrontend) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		return t.core.Command(t.message(c, ""), "reset", c.Message().Payload)
+	}
+}
+
+// parseResetArgs splits "/reset [counter] [reason]": the first word names the
+// counter if the chat has one called so, everything else is the reason
+func parseResetArgs(ctx context.Context, store *Store, chatID int64, payload string) (name, reason string, err error) {
+	payload = strings.TrimSpace(payload)
+	first, rest, _ := strings.Cut(payload, " ")
+	var isCounter bool
+	err = store.ViewContext(ctx, func(s *Storage) {
+		isCounter = s.Chat(chatID).Counters[strings.ToLower(first)] != nil
+	})
+	if isCounter {
+		return strings.ToLower(first), strings.TrimSpace(rest), err
+	}
+	return "", payload, err
+}
+
+func handleApproveReset(t *telegramFrontend) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		if err := c.Respond(); err != nil {
+			ctxLogger(c).Warn("Failed to answer callback", "err", err)
+		}
+		return t.core.Reset(t.message(c, ""), c.Data(), "", false)
+	}
+}
+
+// approveReset records the approval of adminID for resetting counter name and
+// returns the number of distinct admins who approved it so far along with
+// the reason given by the latest of them who gave one
+func (st *ChatState) approveReset(name string, adminID int64, reason string, now time.Time) (int, string) {
+	if st.ResetVotes == nil {
+		st.ResetVotes = make(map[string]*ResetVote)
+	}
+	vote := st.ResetVotes[name]
+	if vote == nil || now.Sub(vote.Started) > resetVoteTTL {
+		vote = &ResetVote{Started: now}
+		st.ResetVotes[name] = vote
+	}
+	if !slices.Contains(vote.Admins, adminID) {
+		vote.Admins = append(vote.Admins, adminID)
+	}
+	if reason != "" {
+		vote.Reason = reason
+	}
+	return len(vote.Admins), vote.Reason
+}
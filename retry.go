@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RetryConfig controls how Bot API calls are repeated on flood limits (429,
+// after the retry_after Telegram asks for), 5xx answers and network errors.
+// A call that may have been carried out already is only repeated when the
+// method is idempotent, so a message is never posted twice. Attempts counts
+// the first call; waits double from one second up to MaxDelay, and a
+// retry_after longer than MaxDelay is not waited for.
+type RetryConfig struct {
+	Attempts int           `yaml:"attempts"`
+	MaxDelay time.Duration `yaml:"max_delay"`
+}
+
+const retryBaseDelay = time.Second
+
+// retryTransport repeats failed Bot API requests. getUpdates is left to the
+// poller, which retries on its own, and streamed uploads can't be replayed.
+type retryTransport struct {
+	next http.RoundTripper
+	cfg  RetryConfig
+}
+
+func (t retryTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if strings.HasSuffix(r.URL.Path, "/getUpdates") || (r.Body != nil && r.GetBody == nil) {
+		return t.next.RoundTrip(r)
+	}
+	for attempt := 1; ; attempt++ {
+		req := r
+		if attempt > 1 && r.GetBody != nil {
+			body, err := r.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = r.Clone(r.Context())
+			req.Body = body
+		}
+		resp, err := t.next.RoundTrip(req)
+		delay, ok := t.retryDelay(methodName(r), resp, err, attempt)
+		if !ok || attempt >= t.cfg.Attempts {
+			return resp, err
+		}
+		if deadline, set := r.Context().Deadline(); set && time.Until(deadline) < delay {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		slog.Warn("Retrying Bot API call", "method", methodName(r), "attempt", attempt, "delay", delay, "err", retryReason(resp, err))
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		}
+	}
+}
+
+// retryDelay decides whether the outcome of attempt of method is worth
+// another try and how long to wait before it. A flood limit or a request
+// that never left is safe to repeat; after a 5xx answer or a connection lost
+// midway the call may have gone through, so only idempotent methods are.
+func (t retryTransport) retryDelay(method string, resp *http.Response, err error, attempt int) (time.Duration, bool) {
+	if err != nil {
+		return t.backoff(attempt), notSent(err) || transient(err) && idempotent(method)
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		after := retryAfter(resp)
+		if after > t.cfg.MaxDelay {
+			return 0, false
+		}
+		if after <= 0 {
+			return t.backoff(attempt), true
+		}
+		return after, true
+	case resp.StatusCode >= 500:
+		return t.backoff(attempt), idempotent(method)
+	}
+	return 0, false
+}
+
+// backoff doubles the wait with every attempt, with up to 25% jitter
+func (t retryTransport) backoff(attempt int) time.Duration {
+	d := retryBaseDelay << (attempt - 1)
+	if d > t.cfg.MaxDelay || d <= 0 {
+		d = t.cfg.MaxDelay
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/4+1))
+}
+
+// retryAfter reads parameters.retry_after from a 429 answer, leaving the
+// body in place for the caller
+func retryAfter(resp *http.Response) time.Duration {
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return 0
+	}
+	var body struct {
+		Parameters struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	if json.Unmarshal(data, &body) != nil {
+		return 0
+	}
+	return time.Duration(body.Parameters.RetryAfter) * time.Second
+}
+
+// transient reports network errors that may go away on their own
+func transient(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// notSent reports errors raised before the request was written: Telegram
+// can't have seen it
+func notSent(err error) bool {
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) || errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// idempotentPrefixes start the Bot API methods that leave the same result
+// when repeated. Sending, copying and forwarding post a new message every
+// time.
+var idempotentPrefixes = []string{"get", "set", "edit", "delete", "pin", "unpin", "answer"}
+
+func idempotent(method string) bool {
+	for _, prefix := range idempotentPrefixes {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func retryReason(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return resp.Status
+}
+
+// methodName is the Bot API method of r, the last element of its path
+func methodName(r *http.Request) string {
+	return r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+}
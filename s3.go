@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// S3Config is a bucket of S3 or a compatible object storage (MinIO, R2,
+// Yandex Object Storage), addressed path-style as Endpoint/Bucket/key
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	// Prefix is prepended to the object keys, e.g. "dayswithout/"
+	Prefix string `yaml:"prefix"`
+}
+
+var s3Client = &http.Client{Timeout: time.Minute}
+
+// s3Put uploads an object, signing the request with AWS Signature Version 4
+func s3Put(cfg S3Config, key string, data []byte, contentType string, now time.Time) error {
+	path := "/" + cfg.Bucket + "/" + s3Escape(cfg.Prefix+key)
+	req, err := http.NewRequest(http.MethodPut, strings.TrimSuffix(cfg.Endpoint, "/")+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	payload := hex.EncodeToString(sum[:])
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	const signed = "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		http.MethodPut,
+		path,
+		"",
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payload,
+		"x-amz-date:" + amzDate,
+		"",
+		signed,
+		payload,
+	}, "\n")
+	scope := amzDate[:8] + "/" + cfg.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	signing := []byte("AWS4" + cfg.SecretKey)
+	for _, part := range []string{amzDate[:8], cfg.Region, "s3", "aws4_request"} {
+		signing = hmacSHA256(signing, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		cfg.AccessKey, scope, signed, hex.EncodeToString(hmacSHA256(signing, toSign))))
+
+	resp, err := s3Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape encodes an object key for the canonical request: everything but
+// unreserved characters and slashes is percent-encoded
+func s3Escape(key string) string {
+	var b strings.Builder
+	for _, c := range []byte(key) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', strings.IndexByte("-_.~/", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Job is a background task run by the scheduler at a fixed interval
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(now time.Time)
+}
+
+// Scheduler runs periodic background jobs. Jobs that need to fire at a
+// certain time of day tick every minute and keep their own "last run"
+// marker in storage, so restarts don't cause duplicates or gaps.
+type Scheduler struct {
+	jobs []Job
+}
+
+// Every registers fn to be run every interval
+func (s *Scheduler) Every(name string, interval time.Duration, fn func(now time.Time)) {
+	s.jobs = append(s.jobs, Job{Name: name, Interval: interval, Run: fn})
+}
+
+// Start launches all registered jobs in their own goroutines
+func (s *Scheduler) Start() {
+	for _, j := range s.jobs {
+		slog.Info("Starting scheduled job", "job", j.Name, "interval", j.Interval)
+		go s.loop(j)
+	}
+}
+
+func (s *Scheduler) loop(j Job) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		slog.Debug("Running scheduled job", "job", j.Name)
+		s.run(j, now)
+	}
+}
+
+// run runs the job once; a panic is logged and reported and the job keeps
+// its schedule
+func (s *Scheduler) run(j Job, now time.Time) {
+	// a standby replica leaves the jobs to the leader
+	if !isLeader() {
+		return
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			stack := debug.Stack()
+			slog.Error("Scheduled job panicked", "job", j.Name, "panic", p, "stack", string(stack))
+			reporter.Report("panic", fmt.Errorf("job %s: %v", j.Name, p), nil, stack)
+			alerts.Alert("паника", fmt.Sprintf("%s: %v", j.Name, p))
+		}
+	}()
+	j.Run(now)
+}
+
+// parseClock parses a "HH:MM" time of day into an offset from midnight
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("expected HH:MM: %w", err)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// dailyDue reports whether a once-a-day job scheduled at the given time of
+// day should run now, given when it last ran
+func dailyDue(at time.Duration, last, now time.Time) bool {
+	y, m, d := now.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, now.Location()).Add(at)
+	return !now.Before(today) && last.Before(today)
+}
+
+// parseWeekday parses an English weekday name ("monday", "Mon")
+func parseWeekday(s string) (time.Weekday, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if s == name || s == name[:3] {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown weekday")
+}
+
+// weeklyDue is like dailyDue for a job that runs once a week on the given day
+func weeklyDue(day time.Weekday, at time.Duration, last, now time.Time) bool {
+	y, m, d := now.Date()
+	offset := (int(now.Weekday()) - int(day) + 7) % 7
+	slot := time.Date(y, m, d-offset, 0, 0, 0, 0, now.Location()).Add(at)
+	return !now.Before(slot) && last.Before(slot)
+}
+
+// monthlyDue is like dailyDue for a job that runs on the first day of each month
+func monthlyDue(at time.Duration, last, now time.Time) bool {
+	y, m, _ := now.Date()
+	slot := time.Date(y, m, 1, 0, 0, 0, 0, now.Location()).Add(at)
+	return !now.Before(slot) && last.Before(slot)
+}
+
+// cronSchedule is a five-field cron expression: minute, hour, day of month,
+// month and day of week, each a set of allowed values
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// like cron, a restricted day of month or day of week matches either
+	domAny, dowAny bool
+}
+
+// parseCron parses "*", numbers, ranges "a-b", steps "*/n" or "a-b/n" and
+// comma-separated lists of those; Sunday is 0 or 7
+func parseCron(s string) (*cronSchedule, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+	var c cronSchedule
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := [5]*uint64{&c.minute, &c.hour, &c.dom, &c.month, &c.dow}
+	for i, f := range fields {
+		set, err := parseCronField(f, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", f, err)
+		}
+		*sets[i] = set
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny, c.dowAny = fields[2] == "*", fields[4] == "*"
+	return &c, nil
+}
+
+func parseCronField(f string, lo, hi int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(f, ",") {
+		rng, step, hasStep := strings.Cut(part, "/")
+		n := 1
+		if hasStep {
+			var err error
+			if n, err = strconv.Atoi(step); err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", step)
+			}
+		}
+		from, to := lo, hi
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if from, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("invalid value %q", a)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("invalid value %q", b)
+				}
+			} else if hasStep {
+				to = hi
+			}
+		}
+		if from < lo || to > hi || from > to {
+			return 0, fmt.Errorf("out of range %d-%d", lo, hi)
+		}
+		for v := from; v <= to; v += n {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+func (c *cronSchedule) matches(t time.Time) bool {
+	in := func(set uint64, v int) bool { return set&(1<<v) != 0 }
+	if !in(c.minute, t.Minute()) || !in(c.hour, t.Hour()) || !in(c.month, int(t.Month())) {
+		return false
+	}
+	dom, dow := in(c.dom, t.Day()), in(c.dow, int(t.Weekday()))
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// cronDue is like dailyDue for a cron schedule: whether a slot passed since
+// the last run, looking back at most a day
+func cronDue(c *cronSchedule, last, now time.Time) bool {
+	t := now.Truncate(time.Minute)
+	for i := 0; i < 24*60 && t.After(last); i++ {
+		if c.matches(t) {
+			return true
+		}
+		t = t.Add(-time.Minute)
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// bits is the set of the given values, as parseCronField builds it
+func bits(values ...int) uint64 {
+	var set uint64
+	for _, v := range values {
+		set |= 1 << v
+	}
+	return set
+}
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field  string
+		lo, hi int
+		want   uint64
+	}{
+		{"*", 1, 12, bits(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12)},
+		{"5", 0, 59, bits(5)},
+		{"1-3", 0, 23, bits(1, 2, 3)},
+		{"*/15", 0, 59, bits(0, 15, 30, 45)},
+		{"10-20/5", 0, 59, bits(10, 15, 20)},
+		{"50/5", 0, 59, bits(50, 55)},
+		{"1,3,5-6", 0, 7, bits(1, 3, 5, 6)},
+		{"1-7/3,2", 1, 31, bits(1, 2, 4, 7)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			got, err := parseCronField(tt.field, tt.lo, tt.hi)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("parseCronField(%q) = %b, want %b", tt.field, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1-b * * * *",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestParseCronSunday(t *testing.T) {
+	for _, expr := range []string{"0 0 * * 0", "0 0 * * 7"} {
+		c, err := parseCron(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sunday := time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)
+		if !c.matches(sunday) {
+			t.Errorf("%q doesn't match Sunday", expr)
+		}
+		if c.matches(sunday.AddDate(0, 0, 1)) {
+			t.Errorf("%q matches Monday", expr)
+		}
+	}
+}
+
+func TestCronMatchesDays(t *testing.T) {
+	// 2024-06-01 is a Saturday
+	tests := []struct {
+		name string
+		expr string
+		day  int
+		want bool
+	}{
+		{"any day", "0 9 * * *", 5, true},
+		{"day of month", "0 9 1 * *", 1, true},
+		{"other day of month", "0 9 1 * *", 2, false},
+		{"day of week", "0 9 * * 6", 1, true},
+		{"other day of week", "0 9 * * 6", 2, false},
+		// with both restricted either one matches, like cron
+		{"both, day of month", "0 9 15 * 6", 15, true},
+		{"both, day of week", "0 9 15 * 6", 8, true},
+		{"both, neither", "0 9 15 * 6", 10, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			at := time.Date(2024, 6, tt.day, 9, 0, 0, 0, time.UTC)
+			if got := c.matches(at); got != tt.want {
+				t.Errorf("%q matches %s = %v, want %v", tt.expr, at.Format("Mon 02.01"), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCronDue(t *testing.T) {
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2024, 6, day, hour, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name      string
+		expr      string
+		last, now time.Time
+		want      bool
+	}{
+		{"slot reached", "30 9 * * *", at(1, 9, 0), at(1, 9, 30), true},
+		{"slot passed", "30 9 * * *", at(1, 9, 0), at(1, 11, 0), true},
+		{"before the slot", "30 9 * * *", at(1, 9, 0), at(1, 9, 29), false},
+		{"ran at the slot", "30 9 * * *", at(1, 9, 30), at(1, 9, 45), false},
+		{"ran after the slot", "30 9 * * *", at(1, 10, 0), at(1, 23, 59), false},
+		{"next day", "30 9 * * *", at(1, 9, 30), at(2, 9, 30), true},
+		{"every 15 minutes", "*/15 * * * *", at(1, 9, 0), at(1, 9, 16), true},
+		{"between steps", "*/15 * * * *", at(1, 9, 15), at(1, 9, 29), false},
+		{"never ran", "0 0 1 1 *", time.Time{}, at(1, 12, 0), false},
+		{"older than a day", "0 8 * * *", at(1, 7, 0), at(3, 7, 0), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cronDue(c, tt.last, tt.now); got != tt.want {
+				t.Errorf("cronDue(%q, %s, %s) = %v, want %v", tt.expr, tt.last.Format(time.DateTime), tt.now.Format(time.DateTime), got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+var medals = []string{"🥇", "🥈", "🥉"}
+
+// shameEntry is one line of the hall of shame
+type shameEntry struct {
+	Who    string
+	Resets int
+	// key is the rankKey of the user
+	key string
+}
+
+// eachCulprit calls fn for every reset of the history with the event of
+// the member to blame: the author of the last detection of its counter
+// before it, or whoever ran /reset when nothing was detected
+func (st *ChatState) eachCulprit(fn func(reset, culprit Event)) {
+	last := make(map[string]*Event)
+	for i := range st.History {
+		ev := &st.History[i]
+		switch ev.Type {
+		case EventDetection:
+			last[ev.Counter] = ev
+		case EventReset:
+			culprit := ev
+			if d := last[ev.Counter]; d != nil {
+				culprit = d
+			}
+			fn(*ev, *culprit)
+			delete(last, ev.Counter)
+		}
+	}
+}
+
+// hallOfShame counts resets per culprit over the whole history, see
+// eachCulprit
+func hallOfShame(st *ChatState) []shameEntry {
+	ps := newPeriodStats()
+	if st.Trimmed != nil {
+		for key, c := range st.Trimmed.Culprits {
+			ps.Offenders[key] += c.Resets
+			ps.names[key] = c.Who
+		}
+	}
+	st.eachCulprit(func(_, culprit Event) { ps.blame(culprit) })
+
+	out := make([]shameEntry, 0, len(ps.Offenders))
+	for key, n := range ps.Offenders {
+		if n <= 0 {
+			continue
+		}
+		out = append(out, shameEntry{Who: ps.names[key], Resets: n, key: key})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Resets != out[j].Resets {
+			return out[i].Resets > out[j].Resets
+		}
+		return out[i].Who < out[j].Who
+	})
+	return out
+}
+
+func handleShame(store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		var entries []shameEntry
+		if err := store.ViewContext(ctxOf(c), func(s *Storage) {
+			entries = hallOfShame(s.Chat(c.Chat().ID))
+		}); err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return c.Send("Зал позора пуст: сбросов ещё не было.")
+		}
+		lines := []string{"🙈 Зал позора (сбросы за всё время):"}
+		for i, e := range entries {
+			place := fmt.Sprintf("%d.", i+1)
+			if i < len(medals) {
+				place = medals[i]
+			}
+			lines = append(lines, fmt.Sprintf("%s %s — %s", place, e.Who, plural(e.Resets, "time")))
+		}
+		return c.Send(strings.Join(lines, "\n"))
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// SheetsConfig appends every reset and a daily snapshot of the streaks to a
+// Google Sheet. Credentials is the JSON key of a service account; share the
+// spreadsheet with its client_email as an editor.
+type SheetsConfig struct {
+	Credentials   string `yaml:"credentials"`
+	SpreadsheetID string `yaml:"spreadsheet_id"`
+	// ResetsSheet and SnapshotsSheet are the tab names, Resets and
+	// Snapshots by default
+	ResetsSheet    string `yaml:"resets_sheet"`
+	SnapshotsSheet string `yaml:"snapshots_sheet"`
+	// SnapshotTime is "HH:MM", server time
+	SnapshotTime string `yaml:"snapshot_time"`
+}
+
+const (
+	sheetsAPI       = "https://sheets.googleapis.com/v4/spreadsheets"
+	sheetsScope     = "https://www.googleapis.com/auth/spreadsheets"
+	sheetsQueueSize = 256
+	sheetsAttempts  = 3
+	// sheetsTime is parsed as a date by Sheets with USER_ENTERED
+	sheetsTime = "2006-01-02 15:04:05"
+)
+
+// serviceAccount is the part of a service account key we need
+type serviceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// sheetsAppend is rows to append to a tab
+type sheetsAppend struct {
+	sheet string
+	rows  [][]any
+}
+
+// sheetsWriter is the eventSink appending resets, and the writer of the
+// daily snapshots, from a single goroutine
+type sheetsWriter struct {
+	cfg    SheetsConfig
+	sa     serviceAccount
+	key    *rsa.PrivateKey
+	client *http.Client
+	queue  chan sheetsAppend
+
+	// token is the OAuth access token, valid until expiry
+	token  string
+	expiry time.Time
+}
+
+var sheetsOut *sheetsWriter
+
+func newSheetsWriter(cfg SheetsConfig) (*sheetsWriter, error) {
+	if cfg.ResetsSheet == "" {
+		cfg.ResetsSheet = "Resets"
+	}
+	if cfg.SnapshotsSheet == "" {
+		cfg.SnapshotsSheet = "Snapshots"
+	}
+	if cfg.SnapshotTime == "" {
+		cfg.SnapshotTime = "00:05"
+	}
+	if _, err := parseClock(cfg.SnapshotTime); err != nil {
+		return nil, fmt.Errorf("invalid sheets.snapshot_time: %w", err)
+	}
+	data, err := os.ReadFile(cfg.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	var sa serviceAccount
+	if err := json.Unmarshal(data, &sa); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", cfg.Credentials, err)
+	}
+	if sa.TokenURI == "" {
+		sa.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	block, _ := pem.Decode([]byte(sa.PrivateKey))
+	if block == nil {
+		return nil, errors.New("no private_key in the service account key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse private_key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private_key is not an RSA key")
+	}
+	w := &sheetsWriter{
+		cfg:    cfg,
+		sa:     sa,
+		key:    key,
+		client: &http.Client{Timeout: 30 * time.Second},
+		queue:  make(chan sheetsAppend, sheetsQueueSize),
+	}
+	go w.run()
+	return w, nil
+}
+
+func (w *sheetsWriter) send(a sheetsAppend) {
+	select {
+	case w.queue <- a:
+	default:
+		slog.Warn("Sheets queue is full, dropping rows", "sheet", a.sheet, "rows", len(a.rows))
+	}
+}
+
+func (w *sheetsWriter) Publish(ev counterEvent) {
+	if ev.Type != HookReset {
+		return
+	}
+	w.send(sheetsAppend{sheet: w.cfg.ResetsSheet, rows: [][]any{{
+		ev.Time.Format(sheetsTime), ev.Bot, ev.ChatID, ev.Counter, ev.Topic, ev.Days, ev.Who, ev.Reason,
+	}}})
+}
+
+// Snapshot is the "sheets" job, appending the streaks of every chat once a
+// day at SnapshotTime
+func (w *sheetsWriter) Snapshot(b *tb.Bot, cfg Config, store *Store, now time.Time) {
+	at, _ := parseClock(w.cfg.SnapshotTime)
+	var due bool
+	store.View(func(s *Storage) { due = dailyDue(at, s.LastSheetsSnapshot, now) })
+	if !due {
+		return
+	}
+	var rows [][]any
+	store.Update(func(s *Storage) {
+		s.LastSheetsSnapshot = now
+		for chatID, st := range s.ActiveChats() {
+			for _, name := range st.CounterNames() {
+				ctr := st.CounterByName(name)
+				if ctr.LastMention.IsZero() {
+					continue
+				}
+				rows = append(rows, []any{
+					now.Format("2006-01-02"), b.Me.Username, chatID, name, counterTopic(cfg, ctr), ctr.Days(), durationDays(ctr.Record),
+				})
+			}
+		}
+	})
+	if len(rows) > 0 {
+		w.send(sheetsAppend{sheet: w.cfg.SnapshotsSheet, rows: rows})
+	}
+}
+
+func (w *sheetsWriter) run() {
+	for a := range w.queue {
+		var err error
+		for attempt := 1; attempt <= sheetsAttempts; attempt++ {
+			if err = w.append(a); err == nil {
+				break
+			}
+			if attempt < sheetsAttempts {
+				time.Sleep(time.Duration(attempt) * time.Second)
+			}
+		}
+		if err != nil {
+			slog.Error("Failed to append to Google Sheet", "sheet", a.sheet, "rows", len(a.rows), "err", err)
+		}
+	}
+}
+
+// append adds rows after the last row of the sheet
+func (w *sheetsWriter) append(a sheetsAppend) error {
+	token, err := w.accessToken()
+	if err != nil {
+		return fmt.Errorf("get access token: %w", err)
+	}
+	rows := make([][]any, len(a.rows))
+	for i, row := range a.rows {
+		rows[i] = make([]any, len(row))
+		for j, v := range row {
+			// the first column is the date, which Sheets has to parse
+			if str, ok := v.(string); ok && j > 0 {
+				v = sheetsText(str)
+			}
+			rows[i][j] = v
+		}
+	}
+	body, err := json.Marshal(map[string]any{"values": rows})
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf("%s/%s/values/%s:append?valueInputOption=USER_ENTERED&insertDataOption=INSERT_ROWS",
+		sheetsAPI, url.PathEscape(w.cfg.SpreadsheetID), url.PathEscape(a.sheet+"!A1"))
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		if resp.StatusCode == http.StatusUnauthorized {
+			w.token = ""
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	return nil
+}
+
+// sheetsText keeps USER_ENTERED from reading a name or reason as a formula:
+// a leading ' makes Sheets take the rest as plain text
+func sheetsText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// accessToken exchanges a signed JWT for an OAuth token, reusing it until
+// shortly before it expires
+func (w *sheetsWriter) accessToken() (string, error) {
+	now := time.Now()
+	if w.token != "" && now.Before(w.expiry.Add(-time.Minute)) {
+		return w.token, nil
+	}
+	assertion, err := w.jwt(now)
+	if err != nil {
+		return "", err
+	}
+	resp, err := w.client.PostForm(w.sa.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	var res struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", err
+	}
+	w.token, w.expiry = res.AccessToken, now.Add(time.Duration(res.ExpiresIn)*time.Second)
+	return w.token, nil
+}
+
+// jwt is the RS256 signed assertion of the service account
+func (w *sheetsWriter) jwt(now time.Time) (string, error) {
+	enc := base64.RawURLEncoding
+	header := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]any{
+		"iss":   w.sa.ClientEmail,
+		"scope": sheetsScope,
+		"aud":   w.sa.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := header + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, w.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{signed, enc.EncodeToString(sig)}, "."), nil
+}
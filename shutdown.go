@@ -0,0 +1,194 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// ShutdownConfig limits how long running handlers are waited for on SIGTERM
+type ShutdownConfig struct {
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// inflight counts the handlers currently running and the updates waiting
+// in the chat queues
+var inflight workCounter
+
+// workCounter is like sync.WaitGroup, except that Add may be called while
+// someone waits: updates keep arriving while shutdown waits for handlers
+type workCounter struct {
+	n atomic.Int64
+}
+
+func (w *workCounter) Add(delta int) { w.n.Add(int64(delta)) }
+
+func (w *workCounter) Done() { w.n.Add(-1) }
+
+// Wait returns once the count drops to zero
+func (w *workCounter) Wait() {
+	for w.n.Load() > 0 {
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// trackInflight lets shutdown wait for handlers that are still running
+func trackInflight(next tb.HandlerFunc) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		inflight.Add(1)
+		defer inflight.Done()
+		return next(c)
+	}
+}
+
+// drainPoller can stop fetching updates while the bot keeps running, so
+// the updates already received are still handled. It also hands the
+// wrapped poller a stop channel of its own: telebot's webhook closes the
+// channel it is given, which Bot.Stop has already closed.
+type drainPoller struct {
+	tb.Poller
+	quit chan struct{}
+	once sync.Once
+}
+
+func newDrainPoller(p tb.Poller) *drainPoller {
+	return &drainPoller{Poller: p, quit: make(chan struct{})}
+}
+
+// Poll runs the wrapped poller. With ha it only runs while this replica is
+// the leader: it is stopped when the leadership is lost and started again
+// on takeover.
+func (p *drainPoller) Poll(b *tb.Bot, dest chan tb.Update, stop chan struct{}) {
+	for {
+		active, changed := leaderState()
+		if active {
+			inner, done := make(chan struct{}), make(chan struct{})
+			go stopInner(inner, done, stop, p.quit, changed)
+			p.Poller.Poll(b, dest, inner)
+			close(done)
+		}
+		// Bot.Start waits for Poll to return after it stops the poller
+		select {
+		case <-stop:
+			return
+		case <-p.quit:
+			<-stop
+			return
+		case <-changed:
+		}
+	}
+}
+
+// stopInner asks the inner poller to stop once stop, quit or changed fires.
+// Like Bot.Stop it sends on inner, which telebot's webhook closes
+// afterwards; the webhook also closes inner and returns by itself when
+// SetWebhook fails, so nothing is sent once the inner poller is done.
+func stopInner(inner, done, stop, quit chan struct{}, changed <-chan struct{}) {
+	select {
+	case <-stop:
+	case <-quit:
+	case <-changed:
+	case <-done:
+		return
+	}
+	// the webhook may have closed inner just before returning
+	defer func() { recover() }()
+	select {
+	case inner <- struct{}{}:
+	case <-done:
+	}
+}
+
+// Drain makes Poll stop fetching updates
+func (p *drainPoller) Drain() {
+	p.once.Do(func() { close(p.quit) })
+}
+
+// stopRequests carries the reason of a shutdown the bot decided on itself
+var stopRequests = make(chan string, 1)
+
+// requestStop shuts the bot down as if it got SIGTERM
+func requestStop(reason string) {
+	select {
+	case stopRequests <- reason:
+	default:
+	}
+}
+
+// stopOnSignal shuts the bots down on SIGTERM, SIGINT or requestStop: no
+// more updates are fetched, the received ones and running handlers get up
+// to the timeout to finish, then the bots are stopped, which makes their
+// Start return
+func stopOnSignal(bots []*botInstance, cfg Config) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	var reason string
+	select {
+	case s := <-sig:
+		reason = s.String()
+	case reason = <-stopRequests:
+	}
+	signal.Stop(sig)
+	slog.Info("Shutting down", "reason", reason)
+	sdNotify("STOPPING=1")
+	var instances []*tb.Bot
+	for _, bi := range bots {
+		bi.mu.Lock()
+		bi.stopped = true
+		bi.poller.Drain()
+		instances = append(instances, bi.b)
+		bi.mu.Unlock()
+	}
+	if !waitHandled(instances, cfg.Shutdown.Timeout) {
+		slog.Warn("Handlers still running at shutdown", "timeout", cfg.Shutdown.Timeout)
+	}
+	for _, b := range instances {
+		b.Stop()
+	}
+}
+
+// waitHandled waits up to timeout for the updates the bots received to be
+// handled and for running handlers to finish; it reports whether they did
+func waitHandled(bots []*tb.Bot, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		for _, b := range bots {
+			for len(b.Updates) > 0 {
+				time.Sleep(100 * time.Millisecond)
+			}
+		}
+		inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// shutdown runs after the bots stopped: writes storage and removes webhooks
+// so Telegram keeps new updates for the next start, then hands leadership
+// over to a standby replica
+func shutdown(bots []*botInstance, lock *leaderLock) {
+	for _, bi := range bots {
+		bi.store.Flush()
+		if bi.cfg.Webhook.Enabled {
+			b := bi.bot()
+			if err := b.RemoveWebhook(); err != nil {
+				slog.Error("Failed to remove webhook", "bot", b.Me.Username, "err", err)
+			}
+		}
+	}
+	if lock != nil {
+		lock.Release()
+	}
+	slog.Info("Bot stopped")
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// simKeyword is one keyword of a counter, matched on its own for the trace
+type simKeyword struct {
+	counter string
+	keyword string
+	re      *regexp.Regexp
+	stem    *regexp.Regexp
+}
+
+// runSimulate prints which keyword the current config matches in each line
+// of the given files or stdin, with how the keyword was recognized. With
+// -chat the runtime counters of that chat are checked too. It returns the
+// process exit code.
+func runSimulate(args []string) int {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	botIndex := fs.Int("bot", 1, "number of the bots entry whose keywords to use")
+	chatID := fs.Int64("chat", 0, "also check the runtime counters of this chat from the storage")
+	verbose := fs.Bool("v", false, "trace every keyword, not only the one that matched")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: dayswithout simulate [flags] [file...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	configs := loadConfig()
+	if *botIndex < 1 || *botIndex > len(configs) {
+		fmt.Fprintf(os.Stderr, "No bot %d, the config has %d\n", *botIndex, len(configs))
+		return 2
+	}
+	cfg := configs[*botIndex-1]
+
+	var st ChatState
+	if *chatID != 0 {
+		loadStorage(cfg.DataFile).View(func(s *Storage) {
+			if chat := s.Chats[*chatID]; chat != nil {
+				st = *chat
+			}
+		})
+	}
+	keywords := simKeywords(cfg, &st)
+	defaultRe := buildKeywordRegex(cfg.Keywords, cfg.NoSuffix)
+
+	var inputs []io.Reader
+	for _, name := range fs.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to open input:", err)
+			return 1
+		}
+		defer f.Close()
+		inputs = append(inputs, f)
+	}
+	if len(inputs) == 0 {
+		inputs = append(inputs, os.Stdin)
+	}
+
+	lines := bufio.NewScanner(io.MultiReader(inputs...))
+	matched, total := 0, 0
+	for lines.Scan() {
+		line := lines.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		total++
+		if simulateLine(line, &st, defaultRe, keywords, *verbose) {
+			matched++
+		}
+	}
+	if err := lines.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to read input:", err)
+		return 1
+	}
+	fmt.Printf("%d of %d line(s) matched\n", matched, total)
+	return 0
+}
+
+// simKeywords lists the keywords in matching order: the default counter,
+// then the runtime counters of st in name order
+func simKeywords(cfg Config, st *ChatState) []simKeyword {
+	var out []simKeyword
+	add := func(counter string, words, noSuffix []string) {
+		for _, w := range words {
+			if strings.TrimSpace(w) == "" {
+				continue
+			}
+			out = append(out, simKeyword{
+				counter: counter,
+				keyword: w,
+				re:      buildKeywordRegex([]string{w}, noSuffix),
+				stem:    regexp.MustCompile(`(?i)^` + keywordStem(strings.TrimSpace(w))),
+			})
+		}
+	}
+	add("", cfg.Keywords, cfg.NoSuffix)
+	for _, name := range st.CounterNames()[1:] {
+		add(name, st.Counters[name].Keywords, nil)
+	}
+	return out
+}
+
+// simulateLine prints the result for one line and reports whether it matched
+func simulateLine(line string, st *ChatState, defaultRe *regexp.Regexp, keywords []simKeyword, verbose bool) bool {
+	fmt.Printf("> %s\n", line)
+	if normalized := strings.Join(strings.Fields(strings.ToLower(line)), " "); normalized != line {
+		fmt.Printf("  normalized: %q (case and whitespace are ignored)\n", normalized)
+	}
+
+	name, _, found := st.matchCounter(line, defaultRe)
+	if verbose {
+		for _, kw := range keywords {
+			fmt.Printf("  %s keyword %q: %s\n", counterLabel(kw.counter), kw.keyword, traceKeyword(line, kw))
+		}
+	}
+	if found == "" {
+		fmt.Println("  = no match")
+		return false
+	}
+	if kw := whichKeyword(line, name, found, keywords); kw != nil {
+		fmt.Printf("  = %s, keyword %q: %s\n", counterLabel(name), kw.keyword, traceKeyword(line, *kw))
+		return true
+	}
+	fmt.Printf("  = %s, matched %q\n", counterLabel(name), found)
+	return true
+}
+
+// whichKeyword finds the keyword of counter name that produced the match
+// found in line
+func whichKeyword(line, name, found string, keywords []simKeyword) *simKeyword {
+	for i, kw := range keywords {
+		if kw.counter == name && findKeyword(line, kw.re) == found {
+			return &keywords[i]
+		}
+	}
+	return nil
+}
+
+// traceKeyword explains whether and how kw matches line
+func traceKeyword(line string, kw simKeyword) string {
+	m := kw.re.FindStringSubmatchIndex(line)
+	if m == nil || m[2] < 0 {
+		return "no match"
+	}
+	text := line[m[2]:m[3]]
+	trace := fmt.Sprintf("matched %q at %d", text, m[2])
+	if stem := kw.stem.FindString(text); stem != "" && len(stem) < len(text) {
+		trace += fmt.Sprintf(", suffix %q", text[len(stem):])
+	}
+	if strings.Contains(kw.keyword, " ") {
+		trace += ", spaces matched flexibly"
+	}
+	return trace
+}
+
+func counterLabel(name string) string {
+	if name == "" {
+		return "default counter"
+	}
+	return fmt.Sprintf("counter %q", name)
+}
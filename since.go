@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"time"
+	_ "time/tzdata" // timezones must work on hosts without a zoneinfo database
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// chatLocation returns the chat's timezone, falling back to the configured one
+func chatLocation(cfg Config, st *ChatState) *time.Location {
+	if st.Timezone != "" {
+		if loc, err := time.LoadLocation(st.Timezone); err == nil {
+			return loc
+		}
+	}
+	return cfg.Location
+}
+
+// formatExact renders d as days, hours, minutes and seconds, omitting zero parts
+func formatExact(d time.Duration) string {
+	total := int(d.Seconds())
+	parts := []struct {
+		n    int
+		unit string
+	}{
+		{total / 86400, "day"},
+		{total / 3600 % 24, "hour"},
+		{total / 60 % 60, "minute"},
+		{total % 60, "second"},
+	}
+	var out []string
+	for _, p := range parts {
+		if p.n > 0 {
+			out = append(out, plural(p.n, p.unit))
+		}
+	}
+	if len(out) == 0 {
+		return plural(0, "second")
+	}
+	return strings.Join(out, " ")
+}
+
+func handleSince(cfg Config, store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		var ctr Counter
+		var loc *time.Location
+		if err := store.ViewContext(ctxOf(c), func(s *Storage) {
+			st := s.Chat(c.Chat().ID)
+			ctr = st.Counter
+			loc = chatLocation(cfg, st)
+		}); err != nil {
+			return err
+		}
+		topic := counterTopic(cfg, &ctr)
+		if ctr.LastMention.IsZero() {
+			return c.Send("Ещё ни разу не упоминали '" + topic + "'.")
+		}
+		text := "С последнего упоминания " + topic + " прошло ровно " + formatExact(ctr.Streak()) +
+			".\nЭто было " + ctr.LastMention.In(loc).Format("02.01.2006 15:04:05 MST (-07:00)") + "."
+		if ctr.PausedTotal > 0 || ctr.Paused() {
+			text += "\nВремя на паузе не считается."
+		}
+		return c.Send(text)
+	}
+}
+
+func handleTimezone(cfg Config, store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		name := strings.TrimSpace(c.Message().Payload)
+		if name == "" {
+			var loc *time.Location
+			if err := store.ViewContext(ctxOf(c), func(s *Storage) {
+				loc = chatLocation(cfg, s.Chat(c.Chat().ID))
+			}); err != nil {
+				return err
+			}
+			return c.Send("Часовой пояс чата: " + loc.String() + ". Изменить: /timezone Europe/Moscow")
+		}
+		if _, err := time.LoadLocation(name); err != nil {
+			return c.Send("Не знаю такого часового пояса: " + name)
+		}
+		if err := store.UpdateContext(ctxOf(c), func(s *Storage) {
+			s.Chat(c.Chat().ID).Timezone = name
+		}); err != nil {
+			return err
+		}
+		return c.Send("Часовой пояс чата: " + name)
+	}
+}
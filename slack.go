@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SlackConfig runs the bot as a Slack app: Events API for messages and
+// slash commands, both posted to Listen. Every channel gets its own
+// counters. SigningSecret verifies the requests, BotToken (xoxb-…) posts
+// the answers.
+type SlackConfig struct {
+	Listen        string `yaml:"listen"`
+	BotToken      string `yaml:"bot_token"`
+	SigningSecret string `yaml:"signing_secret"`
+}
+
+const (
+	slackAPI = "https://slack.com/api"
+	// slackMaxSkew is how old a signed request may be, against replays
+	slackMaxSkew = 5 * time.Minute
+	slackSeenIDs = 1000
+)
+
+// slackAdapter serves the Slack request URLs
+type slackAdapter struct {
+	cfg    SlackConfig
+	core   *frontendCore
+	client *http.Client
+
+	// seen are the latest event IDs, Slack retries slow deliveries
+	mu   sync.Mutex
+	seen []string
+}
+
+func newSlackAdapter(cfg Config, store *Store) *slackAdapter {
+	return &slackAdapter{
+		cfg:    cfg.Slack,
+		core:   newFrontendCore("slack", "", cfg, store),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// start serves /slack/events and /slack/commands in the background
+func (s *slackAdapter) start() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /slack/events", s.verified(s.handleEvents))
+	mux.HandleFunc("POST /slack/commands", s.verified(s.handleCommand))
+	slog.Info("Slack endpoints listening", "addr", s.cfg.Listen)
+	go func() {
+		if err := http.ListenAndServe(s.cfg.Listen, mux); err != nil {
+			slog.Error("Slack server stopped", "err", err)
+		}
+	}()
+}
+
+// verified checks the Slack request signature before passing the body on
+func (s *slackAdapter) verified(next func(w http.ResponseWriter, r *http.Request, body []byte)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		ts := r.Header.Get("X-Slack-Request-Timestamp")
+		if !validSlackSignature(s.cfg.SigningSecret, ts, r.Header.Get("X-Slack-Signature"), body, time.Now()) {
+			slog.Warn("Slack request with a bad signature", "remote", r.RemoteAddr)
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		next(w, r, body)
+	}
+}
+
+// validSlackSignature checks the v0 signature of a request sent at ts
+func validSlackSignature(secret, ts, signature string, body []byte, now time.Time) bool {
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	if skew := now.Sub(time.Unix(sec, 0)); skew > slackMaxSkew || skew < -slackMaxSkew {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:", ts)
+	mac.Write(body)
+	return hmac.Equal([]byte(signature), []byte("v0="+hex.EncodeToString(mac.Sum(nil))))
+}
+
+func (s *slackAdapter) handleEvents(w http.ResponseWriter, r *http.Request, body []byte) {
+	var req struct {
+		Type      string `json:"type"`
+		Challenge string `json:"challenge"`
+		TeamID    string `json:"team_id"`
+		EventID   string `json:"event_id"`
+		Event     struct {
+			Type    string `json:"type"`
+			Subtype string `json:"subtype"`
+			BotID   string `json:"bot_id"`
+			User    string `json:"user"`
+			Text    string `json:"text"`
+			Channel string `json:"channel"`
+			TS      string `json:"ts"`
+		} `json:"event"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if req.Type == "url_verification" {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, req.Challenge)
+		return
+	}
+	// Slack wants an answer within 3 seconds, the work happens afterwards
+	w.WriteHeader(http.StatusOK)
+	ev := req.Event
+	if req.Type != "event_callback" || ev.Type != "message" || ev.Subtype != "" || !s.fresh(req.EventID) {
+		return
+	}
+	if ev.BotID != "" && s.core.cfg.IgnoreBots {
+		return
+	}
+	go func() {
+		p := &slackMessage{s: s, team: req.TeamID, channel: ev.Channel, user: ev.User, ts: ev.TS, text: ev.Text}
+		if err := s.core.Detect(p); err != nil {
+			slog.Error("Failed to post Slack message", "channel", ev.Channel, "err", err)
+		}
+	}()
+}
+
+// handleCommand answers /days, /reset, /counters and /history, or one
+// command like /dayswithout with the command as its first word
+func (s *slackAdapter) handleCommand(w http.ResponseWriter, r *http.Request, body []byte) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	cmd, args := strings.TrimPrefix(form.Get("command"), "/"), form.Get("text")
+	switch cmd {
+	case "days", "reset", "counters", "history":
+	default:
+		cmd, args, _ = strings.Cut(strings.TrimSpace(args), " ")
+	}
+	// Slack gives up after 3 seconds, less than a reset with an LLM call may
+	// take, so the command is acknowledged with an empty answer and the
+	// texts go to its response_url
+	w.WriteHeader(http.StatusOK)
+	p := &slackCommand{s: s, responseURL: form.Get("response_url"), team: form.Get("team_id"), channel: form.Get("channel_id"), user: form.Get("user_id"), username: form.Get("user_name")}
+	go func() {
+		if err := s.core.Command(p, cmd, args); err != nil {
+			slog.Error("Failed to answer Slack command", "command", cmd, "err", err)
+		}
+	}()
+}
+
+// fresh reports whether the event wasn't handled yet
+func (s *slackAdapter) fresh(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, seen := range s.seen {
+		if seen == id {
+			return false
+		}
+	}
+	s.seen = append(s.seen, id)
+	if len(s.seen) > slackSeenIDs {
+		s.seen = s.seen[len(s.seen)-slackSeenIDs:]
+	}
+	return true
+}
+
+func slackChat(team, channel string) string {
+	return "slack:" + team + ":" + channel
+}
+
+func slackSender(team, user string) chatUser {
+	// Slack renders <@U…> as the member's name
+	return chatUser{ID: externalUserID("slack", team+":"+user), Name: "<@" + user + ">"}
+}
+
+// slackMessage is a ChatPlatform of a channel message from the Events API
+type slackMessage struct {
+	s              *slackAdapter
+	team, channel  string
+	user, ts, text string
+}
+
+func (m *slackMessage) Chat() string     { return slackChat(m.team, m.channel) }
+func (m *slackMessage) Sender() chatUser { return slackSender(m.team, m.user) }
+func (m *slackMessage) Text() string     { return m.text }
+func (m *slackMessage) IsAdmin() bool    { return false }
+
+func (m *slackMessage) Send(text string) (string, error) {
+	return m.s.call("chat.postMessage", map[string]string{"channel": m.channel, "text": text})
+}
+
+// Reply answers in the thread of the message
+func (m *slackMessage) Reply(text string) (string, error) {
+	return m.s.call("chat.postMessage", map[string]string{"channel": m.channel, "text": text, "thread_ts": m.ts})
+}
+
+func (m *slackMessage) Edit(ref, text string) error {
+	_, err := m.s.call("chat.update", map[string]string{"channel": m.channel, "ts": ref, "text": text})
+	return err
+}
+
+// slackCommand is a ChatPlatform of a slash command, answered through its
+// response_url
+type slackCommand struct {
+	s              *slackAdapter
+	responseURL    string
+	team, channel  string
+	user, username string
+}
+
+func (c *slackCommand) Chat() string  { return slackChat(c.team, c.channel) }
+func (c *slackCommand) Text() string  { return "" }
+func (c *slackCommand) IsAdmin() bool { return false }
+
+func (c *slackCommand) Sender() chatUser {
+	u := slackSender(c.team, c.user)
+	u.Username = c.username
+	return u
+}
+
+// Send answers the command, visible to the whole channel. Every text is a
+// message of its own, Slack takes up to five per command.
+func (c *slackCommand) Send(text string) (string, error) {
+	return "", c.s.respond(c.responseURL, text)
+}
+
+func (c *slackCommand) Reply(text string) (string, error) {
+	return c.Send(text)
+}
+
+func (c *slackCommand) Edit(string, string) error {
+	return errEditUnsupported
+}
+
+// respond posts text to the response_url of a slash command
+func (s *slackAdapter) respond(responseURL, text string) error {
+	data, err := json.Marshal(map[string]string{"response_type": "in_channel", "text": text})
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(responseURL, "application/json; charset=utf-8", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack response_url: %s", resp.Status)
+	}
+	return nil
+}
+
+// call makes a Web API request and returns the ts of the posted message
+func (s *slackAdapter) call(method string, body map[string]string) (string, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, slackAPI+"/"+method, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.cfg.BotToken)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var res struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+		TS    string `json:"ts"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", err
+	}
+	if !res.OK {
+		return "", fmt.Errorf("slack: %s", res.Error)
+	}
+	return res.TS, nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// StaticPageConfig writes a static status page of the chats to Dir, for
+// nginx or GitHub Pages: Index with all chats and <chat id>/index.html with
+// the counters, resets and charts of each. The page is public, so only the
+// listed Chats are written. Files are rewritten only when their content
+// changes.
+type StaticPageConfig struct {
+	Dir   string  `yaml:"dir"`
+	Index string  `yaml:"index"`
+	Chats []int64 `yaml:"chats"`
+}
+
+// staticHistory is how many resets a chat page lists
+const staticHistory = 50
+
+// staticCharts has the data each chart file was last rendered from, so a
+// chart is rendered again only when its data changes
+var staticCharts sync.Map
+
+type staticCounter struct {
+	Topic  string
+	Days   string
+	Since  string
+	Record string
+	Paused bool
+}
+
+type staticReset struct {
+	Time   string
+	Topic  string
+	Who    string
+	Streak string
+	Reason string
+}
+
+type staticChat struct {
+	ID int64
+	// Topic is the topic of the default counter
+	Topic    string
+	Title    string
+	Counters []staticCounter
+	Resets   []staticReset
+}
+
+var staticIndexTmpl = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Дни без…</title>
+<style>` + staticCSS + `</style>
+</head>
+<body>
+<h1>Дни без…</h1>
+<ul class="chats">
+{{range .}}<li><a href="{{.ID}}/">{{.Title}}</a>{{range .Counters}}<span class="days">{{.Topic}}: {{.Days}}</span>{{end}}</li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+var staticChatTmpl = template.Must(template.New("chat").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>` + staticCSS + `</style>
+</head>
+<body>
+<p><a href="../">← все чаты</a></p>
+<h1>{{.Title}}</h1>
+{{range .Counters}}<div class="counter">
+<div class="big">{{.Days}}</div>
+<div>без {{.Topic}}{{if .Paused}} (на паузе){{end}}</div>
+<div class="muted">с {{.Since}}, рекорд: {{.Record}}</div>
+</div>
+{{end}}
+<h2>По неделям</h2>
+<img src="chart-week.png" alt="Упоминания и сбросы по неделям">
+<h2>По месяцам</h2>
+<img src="chart-month.png" alt="Упоминания и сбросы по месяцам">
+<h2>Сбросы</h2>
+{{if .Resets}}<table>
+<tr><th>Когда</th><th>Счётчик</th><th>Кто</th><th>Серия</th><th>Причина</th></tr>
+{{range .Resets}}<tr><td>{{.Time}}</td><td>{{.Topic}}</td><td>{{.Who}}</td><td>{{.Streak}}</td><td>{{.Reason}}</td></tr>
+{{end}}</table>
+{{else}}<p class="muted">Сбросов ещё не было.</p>
+{{end}}</body>
+</html>
+`))
+
+const staticCSS = `body{font-family:system-ui,sans-serif;max-width:960px;margin:2em auto;padding:0 1em;color:#222}
+.counter{display:inline-block;margin:0 2em 1em 0}.big{font-size:3em;font-weight:bold}
+.muted{color:#777}.days{margin-left:1em;color:#555}img{max-width:100%}
+table{border-collapse:collapse;width:100%}td,th{border-bottom:1px solid #ddd;padding:.3em;text-align:left}`
+
+// writeStaticPage is the "static_page" job
+func writeStaticPage(cfg Config, store *Store) {
+	now := clock()
+	var chats []staticChat
+	charts := make(map[int64][2][]chartBucket)
+	store.View(func(s *Storage) {
+		for chatID, st := range s.ActiveChats() {
+			if !slices.Contains(cfg.StaticPage.Chats, chatID) {
+				continue
+			}
+			chats = append(chats, staticChatOf(cfg, chatID, st))
+			charts[chatID] = [2][]chartBucket{chartData(st, now, false), chartData(st, now, true)}
+		}
+	})
+	slices.SortFunc(chats, func(a, b staticChat) int { return cmp.Compare(a.ID, b.ID) })
+
+	var buf bytes.Buffer
+	if err := staticIndexTmpl.Execute(&buf, chats); err != nil {
+		slog.Error("Failed to render static page", "err", err)
+		return
+	}
+	writeStaticFile(filepath.Join(cfg.StaticPage.Dir, cfg.StaticPage.Index), buf.Bytes())
+	for _, chat := range chats {
+		buf.Reset()
+		if err := staticChatTmpl.Execute(&buf, chat); err != nil {
+			slog.Error("Failed to render static page", "chat_id", chat.ID, "err", err)
+			continue
+		}
+		dir := filepath.Join(cfg.StaticPage.Dir, strconv.FormatInt(chat.ID, 10))
+		writeStaticFile(filepath.Join(dir, "index.html"), buf.Bytes())
+		for i, name := range []string{"chart-week.png", "chart-month.png"} {
+			path := filepath.Join(dir, name)
+			data := fmt.Sprint(chat.Topic, charts[chat.ID][i])
+			if last, ok := staticCharts.Load(path); ok && last == data {
+				if _, err := os.Stat(path); err == nil {
+					continue
+				}
+			}
+			png, err := renderChart(chat.Topic, charts[chat.ID][i], i == 1)
+			if err != nil {
+				slog.Error("Failed to render static page chart", "chat_id", chat.ID, "err", err)
+				continue
+			}
+			writeStaticFile(path, png)
+			staticCharts.Store(path, data)
+		}
+	}
+}
+
+func staticChatOf(cfg Config, chatID int64, st *ChatState) staticChat {
+	loc := chatLocation(cfg, st)
+	topic := counterTopic(cfg, &st.Counter)
+	chat := staticChat{ID: chatID, Topic: topic, Title: "Дни без " + topic}
+	topics := make(map[string]string)
+	for _, name := range st.CounterNames() {
+		ctr := st.CounterByName(name)
+		topics[name] = counterTopic(cfg, ctr)
+		sc := staticCounter{Topic: topics[name], Days: "—", Since: "никогда", Record: plural(durationDays(ctr.Record), "day"), Paused: ctr.Paused()}
+		if !ctr.LastMention.IsZero() {
+			sc.Days = plural(ctr.Days(), "day")
+			sc.Since = ctr.LastMention.In(loc).Format("02.01.2006 15:04")
+		}
+		chat.Counters = append(chat.Counters, sc)
+	}
+	resets := st.EventsSince(EventReset, time.Time{})
+	for i := len(resets) - 1; i >= 0 && len(chat.Resets) < staticHistory; i-- {
+		ev := resets[i]
+		topic, ok := topics[ev.Counter]
+		if !ok {
+			topic = ev.Counter
+		}
+		chat.Resets = append(chat.Resets, staticReset{
+			Time:   ev.Time.In(loc).Format("02.01.2006 15:04"),
+			Topic:  topic,
+			Who:    ev.Who(),
+			Streak: formatStreak(ev.Streak, false),
+			Reason: ev.Reason,
+		})
+	}
+	return chat
+}
+
+// writeStaticFile replaces path with data unless it already has it, and
+// reports whether it wrote
+func writeStaticFile(path string, data []byte) bool {
+	if old, err := os.ReadFile(path); err == nil && bytes.Equal(old, data) {
+		return false
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err == nil {
+		tmp := path + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o644); err == nil {
+			err = os.Rename(tmp, path)
+		}
+	}
+	if err != nil {
+		slog.Error("Failed to write static page", "file", path, "err", err)
+		return false
+	}
+	slog.Debug("Static page updated", "file", path)
+	return true
+}
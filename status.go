@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// startedAt is when the process started, for uptime
+var startedAt = time.Now()
+
+// isOperator reports whether the sender runs the bot: listed in operators
+// or the alerts admin
+func isOperator(cfg Config, c tb.Context) bool {
+	id := c.Sender().ID
+	if id == cfg.Alerts.AdminID {
+		return true
+	}
+	for _, op := range cfg.Operators {
+		if op == id {
+			return true
+		}
+	}
+	return false
+}
+
+func handleStatus(cfg Config, store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		var chats int
+		if err := store.ViewContext(ctxOf(c), func(s *Storage) {
+			chats = len(s.Chats)
+		}); err != nil {
+			return err
+		}
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+
+		var sb strings.Builder
+		ver, rev, _ := buildInfo()
+		fmt.Fprintf(&sb, "Версия: %s (%s)\n", ver, rev)
+		fmt.Fprintf(&sb, "Аптайм: %s (с %s)\n", time.Since(startedAt).Truncate(time.Second), startedAt.Format("02.01.2006 15:04:05"))
+		hs, err := pollerStatus(cfg, startedAt)
+		switch {
+		case hs.Poller == "webhook":
+			sb.WriteString("Обновления: вебхук\n")
+		case hs.LastPoll == "":
+			sb.WriteString("Последний опрос: ещё не было\n")
+		default:
+			fmt.Fprintf(&sb, "Последний опрос: %s\n", time.Unix(0, lastPoll.Load()).Format("02.01.2006 15:04:05"))
+		}
+		if err != nil {
+			fmt.Fprintf(&sb, "⚠️ %s\n", err)
+		}
+		if err := storageWritable(store.file); err != nil {
+			fmt.Fprintf(&sb, "Хранилище (%s): ⚠️ %s\n", store.file, err)
+		} else {
+			fmt.Fprintf(&sb, "Хранилище (%s): ок\n", store.file)
+		}
+		fmt.Fprintf(&sb, "Чатов: %d\n", chats)
+		fmt.Fprintf(&sb, "Память: %.1f МБ в куче, %.1f МБ от ОС, горутин: %d",
+			float64(mem.HeapAlloc)/(1<<20), float64(mem.Sys)/(1<<20), runtime.NumGoroutine())
+		return c.Send(sb.String())
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// clock is the current time as handlers see it. The replay subcommand moves
+// it to the dates of the replayed updates.
+var clock = time.Now
+
+// Storage represents persistent storage for per-chat counters
+type Storage struct {
+	// LastMention is the pre-multichat global timestamp. It is handed over
+	// to the first chat that shows up and then cleared.
+	LastMention time.Time `json:"last_mention,omitempty"`
+	// Chats are keyed by chat ID; private chats have the ID of the user,
+	// so personal counters live under the user ID
+	Chats map[int64]*ChatState `json:"chats"`
+	// OptedOut are users who asked to be left out of attributions
+	OptedOut map[int64]bool `json:"opted_out,omitempty"`
+	// SeenUpdates are the IDs of the latest handled updates, see FreshUpdate
+	SeenUpdates []int `json:"seen_updates,omitempty"`
+	// Announcements are reset messages not confirmed as sent yet
+	Announcements []Announcement `json:"announcements,omitempty"`
+	// External maps chats of other platforms to their chat IDs, see
+	// externalChat
+	External map[string]int64 `json:"external,omitempty"`
+	// LastSheetsSnapshot is when the streaks were last appended to the
+	// Google Sheet
+	LastSheetsSnapshot time.Time `json:"last_sheets_snapshot,omitempty"`
+	// LastExport is when the last scheduled export ran
+	LastExport time.Time `json:"last_export,omitempty"`
+}
+
+// Counter is a single "days without" streak. Every chat has the default
+// counter defined in config; admins can add more with /newcounter.
+type Counter struct {
+	// Topic and Keywords are empty for the default counter, which uses config
+	Topic         string    `json:"topic,omitempty"`
+	Keywords      []string  `json:"keywords,omitempty"`
+	LastMention   time.Time `json:"last_mention"`
+	LastMilestone int       `json:"last_milestone,omitempty"`
+	// Record is the longest finished streak
+	Record time.Duration `json:"record,omitempty"`
+	// RecordBroken is set once the current streak has passed Record
+	RecordBroken bool `json:"record_broken,omitempty"`
+	// PausedAt is set while detection is suspended by /pause; PausedTotal
+	// is the paused time of the current streak that doesn't count
+	PausedAt    time.Time     `json:"paused_at,omitempty"`
+	PausedTotal time.Duration `json:"paused_total,omitempty"`
+}
+
+// Paused reports whether the counter is on /pause
+func (c *Counter) Paused() bool {
+	return !c.PausedAt.IsZero()
+}
+
+// Streak is the length of the current streak without paused time
+func (c *Counter) Streak() time.Duration {
+	if c.LastMention.IsZero() {
+		return 0
+	}
+	end := clock()
+	if c.Paused() {
+		end = c.PausedAt
+	}
+	return end.Sub(c.LastMention) - c.PausedTotal
+}
+
+// Days is the current streak in whole days
+func (c *Counter) Days() int {
+	return durationDays(c.Streak())
+}
+
+// ChatState is the state of a single chat
+type ChatState struct {
+	// Counter is the default counter, kept inline for compatibility
+	Counter
+	// Counters are the extra counters created at runtime, by lowercased topic
+	Counters map[string]*Counter `json:"counters,omitempty"`
+	// Pending is the counter of the last detection prompt, the default
+	// target of a bare /reset
+	Pending string `json:"pending,omitempty"`
+	// ResetVotes are the strict mode approvals collected per counter
+	ResetVotes map[string]*ResetVote `json:"reset_votes,omitempty"`
+
+	Reminders    *bool     `json:"reminders,omitempty"`
+	AutoReset    *bool     `json:"auto_reset,omitempty"`
+	LastReminder time.Time `json:"last_reminder,omitempty"`
+	LastDigest   time.Time `json:"last_digest,omitempty"`
+	LastWeekly   time.Time `json:"last_weekly,omitempty"`
+	LastOffender time.Time `json:"last_offender,omitempty"`
+	NextNudge    time.Time `json:"next_nudge,omitempty"`
+	LastMonthly  time.Time `json:"last_monthly,omitempty"`
+	// PinnedID is the live counter message kept up to date by the bot
+	PinnedID   int    `json:"pinned_id,omitempty"`
+	PinnedText string `json:"pinned_text,omitempty"`
+	// Info* track the day count written into the chat description or title
+	InfoText    string    `json:"info_text,omitempty"`
+	InfoBase    string    `json:"info_base,omitempty"`
+	InfoUpdated time.Time `json:"info_updated,omitempty"`
+	Timezone    string    `json:"timezone,omitempty"`
+	// Threads are the forum topics the bot watches, all when empty
+	Threads []int   `json:"threads,omitempty"`
+	History []Event `json:"history,omitempty"`
+	// Trimmed sums up the history events dropped by max_history
+	Trimmed *HistoryTotals `json:"trimmed,omitempty"`
+	// Left is when the bot was removed from the chat; scheduled jobs skip it
+	// and the data is deleted after left_chat_retention
+	Left time.Time `json:"left,omitempty"`
+	// Push is where /push sends phone notifications of the chat
+	Push *PushTarget `json:"push,omitempty"`
+	// Link is the /link group of chats sharing their counters
+	Link string `json:"link,omitempty"`
+	// LinkRequest is the group the chat asked to join, until an admin of
+	// that group accepts
+	LinkRequest string `json:"link_request,omitempty"`
+
+	// Achievements are the chat badges by id with the time they were unlocked
+	Achievements map[string]time.Time `json:"achievements,omitempty"`
+	Users        map[int64]*UserState `json:"users,omitempty"`
+}
+
+const (
+	EventDetection = "detection"
+	EventReset     = "reset"
+)
+
+// Event is a single entry of the chat history
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// Counter is the name of the counter, empty for the default one
+	Counter   string `json:"counter,omitempty"`
+	UserID    int64  `json:"user_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Keyword   string `json:"keyword,omitempty"`
+	Confirmed bool   `json:"confirmed,omitempty"`
+	// Streak is the length of the streak ended by a reset
+	Streak time.Duration `json:"streak,omitempty"`
+	// MessageID is the detected message, for permalinks
+	MessageID int `json:"message_id,omitempty"`
+	// Reason is the optional note given to /reset
+	Reason string `json:"reason,omitempty"`
+	// Anonymous events belong to a user who used /optout
+	Anonymous bool `json:"anonymous,omitempty"`
+}
+
+// Who returns a human readable name of the user behind the event
+func (ev Event) Who() string {
+	switch {
+	case ev.Anonymous:
+		return anonymousName
+	case ev.Username != "":
+		return "@" + ev.Username
+	case ev.Name != "":
+		return ev.Name
+	default:
+		return fmt.Sprintf("id%d", ev.UserID)
+	}
+}
+
+// RecordDetection appends a keyword detection to the history
+func (st *ChatState) RecordDetection(ev Event) {
+	ev.Type = EventDetection
+	st.History = append(st.History, ev)
+}
+
+// CounterByName returns the counter called name ("" is the default one), or nil
+func (st *ChatState) CounterByName(name string) *Counter {
+	if name == "" {
+		return &st.Counter
+	}
+	return st.Counters[name]
+}
+
+// CounterNames returns "" for the default counter followed by the sorted extra names
+func (st *ChatState) CounterNames() []string {
+	names := make([]string, 0, len(st.Counters))
+	for name := range st.Counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append([]string{""}, names...)
+}
+
+// Reset ends the streak of counter ev.Counter at ev.Time, updating the
+// record and history
+func (st *ChatState) Reset(ev Event) {
+	ctr := st.CounterByName(ev.Counter)
+	if ctr == nil {
+		return
+	}
+	if !ctr.LastMention.IsZero() {
+		ev.Streak = ctr.Streak()
+		if ev.Streak > ctr.Record {
+			ctr.Record = ev.Streak
+		}
+	}
+	ctr.LastMention = ev.Time
+	ctr.LastMilestone = 0
+	ctr.RecordBroken = false
+	ctr.PausedAt = time.Time{}
+	ctr.PausedTotal = 0
+	st.Pending = ""
+	st.RecordReset(ev)
+}
+
+// RecordReset appends a reset to the history and marks all detections of
+// the same counter since its previous reset as confirmed
+func (st *ChatState) RecordReset(ev Event) {
+	for i := len(st.History) - 1; i >= 0; i-- {
+		if st.History[i].Counter != ev.Counter {
+			continue
+		}
+		if st.History[i].Type == EventReset {
+			break
+		}
+		st.History[i].Confirmed = true
+	}
+	ev.Type = EventReset
+	st.History = append(st.History, ev)
+}
+
+// PendingDetection returns the last detection of counter that no reset has
+// confirmed yet, or nil
+func (st *ChatState) PendingDetection(counter string) *Event {
+	for i := len(st.History) - 1; i >= 0; i-- {
+		ev := &st.History[i]
+		if ev.Counter != counter {
+			continue
+		}
+		if ev.Type == EventReset {
+			return nil
+		}
+		return ev
+	}
+	return nil
+}
+
+// EventsSince returns history events of type typ newer than t
+func (st *ChatState) EventsSince(typ string, t time.Time) []Event {
+	var out []Event
+	for _, ev := range st.History {
+		if ev.Type == typ && ev.Time.After(t) {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+// Chat returns the state for chatID, creating it if needed
+func (s *Storage) Chat(chatID int64) *ChatState {
+	if s.Chats == nil {
+		s.Chats = make(map[int64]*ChatState)
+	}
+	st, ok := s.Chats[chatID]
+	if !ok {
+		st = &ChatState{}
+		if !s.LastMention.IsZero() {
+			slog.Debug("Migrating legacy last mention", "chat_id", chatID, "last_mention", s.LastMention)
+			st.LastMention = s.LastMention
+			s.LastMention = time.Time{}
+		}
+		s.Chats[chatID] = st
+	}
+	return st
+}
+
+// Store guards Storage for concurrent handlers and background jobs. The
+// lock is a channel so that handlers can stop waiting for it when their
+// context ends.
+type Store struct {
+	lock chan struct{}
+	data Storage
+	// file is where the storage is persisted
+	file string
+	// maxHistory caps the history of each chat, see Storage.trim
+	maxHistory int
+}
+
+func newStore(data Storage, file string) *Store {
+	return &Store{lock: make(chan struct{}, 1), data: data, file: file}
+}
+
+func (s *Store) acquire(ctx context.Context) error {
+	select {
+	case s.lock <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("storage is busy: %w", ctx.Err())
+	}
+}
+
+func (s *Store) release() {
+	<-s.lock
+}
+
+// View runs fn with the storage locked
+func (s *Store) View(fn func(*Storage)) {
+	s.ViewContext(context.Background(), fn)
+}
+
+// Update runs fn with the storage locked and persists the result, returning
+// a failed write
+func (s *Store) Update(fn func(*Storage)) error {
+	return s.UpdateContext(context.Background(), fn)
+}
+
+// ViewContext is View giving up when ctx ends before the lock is free
+func (s *Store) ViewContext(ctx context.Context, fn func(*Storage)) error {
+	if err := s.acquire(ctx); err != nil {
+		return err
+	}
+	defer s.release()
+	fn(&s.data)
+	return nil
+}
+
+// UpdateContext is Update giving up when ctx ends before the lock is free.
+// Once fn ran the result is persisted regardless of ctx; a failed write is
+// returned, the change stays in memory for the next write.
+func (s *Store) UpdateContext(ctx context.Context, fn func(*Storage)) error {
+	if err := s.acquire(ctx); err != nil {
+		return err
+	}
+	defer s.release()
+	fn(&s.data)
+	s.data.trim(s.maxHistory, clock())
+	return saveStorage(s.file, s.data)
+}
+
+// Backup writes a copy of the storage into dir, named after the storage file
+// and now, and returns its path
+func (s *Store) Backup(dir string, now time.Time) (string, error) {
+	var data []byte
+	var err error
+	s.View(func(st *Storage) { data, err = json.MarshalIndent(st, "", "  ") })
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, backupName(s.file, now))
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Reload reads the storage from its file again, which another replica may
+// have written meanwhile
+func (s *Store) Reload() {
+	fresh := loadStorage(s.file)
+	s.acquire(context.Background())
+	defer s.release()
+	s.data = fresh.data
+}
+
+// Flush writes the storage once more, waiting for a running update
+func (s *Store) Flush() {
+	s.Update(func(*Storage) {})
+}
+
+func loadStorage(path string) *Store {
+	slog.Debug("Loading storage", "file", path)
+	var s Storage
+	file, err := os.ReadFile(path)
+	if err != nil {
+		slog.Warn("No storage found, starting fresh", "file", path)
+		return newStore(s, path)
+	}
+	err = json.Unmarshal(file, &s)
+	if err != nil {
+		slog.Error("Failed to parse storage", "file", path, "err", err)
+		s = Storage{}
+	}
+	slog.Debug("Storage loaded", "chats", len(s.Chats))
+	return newStore(s, path)
+}
+
+// saveStorage writes s to a temporary file and renames it over path, so a
+// crash never leaves a half-written storage behind
+func saveStorage(path string, s Storage) error {
+	slog.Debug("Saving storage", "chats", len(s.Chats))
+	if err := checkFence(path); err != nil {
+		slog.Error("Refusing to write storage", "file", path, "err", err)
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		slog.Error("Failed to serialize storage", "err", err)
+		return err
+	}
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		slog.Error("Failed to write storage", "file", path, "err", err)
+		err = fmt.Errorf("failed to write storage: %w", err)
+	}
+	alerts.StorageResult(err)
+	return err
+}
+
+func durationDays(d time.Duration) int {
+	return int(d.Hours() / 24)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends a state like "READY=1" to systemd. Outside of a
+// Type=notify unit NOTIFY_SOCKET is unset and nothing is sent.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// a leading @ stands for the abstract namespace
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval is the WatchdogSec of the unit, zero when it has none
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// notifySystemd reports readiness and then pings the watchdog at half its
+// interval for as long as the poller is healthy, so systemd restarts a bot
+// whose polling got stuck
+func notifySystemd(cfg Config) {
+	if err := sdNotify("READY=1"); err != nil {
+		slog.Error("Failed to notify systemd", "err", err)
+		return
+	}
+	interval := watchdogInterval()
+	if interval == 0 {
+		return
+	}
+	slog.Info("Systemd watchdog enabled", "interval", interval)
+	started := time.Now()
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := pollerStatus(cfg, started); err != nil {
+				slog.Warn("Skipping watchdog ping", "err", err)
+				continue
+			}
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				slog.Error("Failed to ping systemd watchdog", "err", err)
+			}
+		}
+	}()
+}
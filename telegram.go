@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// telegramFrontend hands the updates of one bot over to the core
+type telegramFrontend struct {
+	b          *tb.Bot
+	cfg        Config
+	store      *Store
+	detections *userLimit
+	core       *frontendCore
+}
+
+// message is the ChatPlatform of update c, with text the message or its
+// transcription
+func (t *telegramFrontend) message(c tb.Context, text string) *telegramMessage {
+	return &telegramMessage{t: t, c: c, text: text}
+}
+
+// telegramMessage is a ChatPlatform of a Telegram update. Answers go into
+// the forum topic of the update, like those of the other commands.
+type telegramMessage struct {
+	t    *telegramFrontend
+	c    tb.Context
+	text string
+}
+
+func (m *telegramMessage) Chat() string             { return strconv.FormatInt(m.c.Chat().ID, 10) }
+func (m *telegramMessage) IsAdmin() bool            { return isAdmin(m.t.b, m.c) }
+func (m *telegramMessage) Text() string             { return m.text }
+func (m *telegramMessage) Context() context.Context { return ctxOf(m.c) }
+func (m *telegramMessage) Logger() *slog.Logger     { return ctxLogger(m.c) }
+func (m *telegramMessage) Thread() int              { return threadOf(m.c.Message()) }
+func (m *telegramMessage) Personal() bool           { return isPersonal(m.c.Chat()) }
+
+func (m *telegramMessage) Sender() chatUser {
+	u := m.c.Sender()
+	if u == nil {
+		return chatUser{}
+	}
+	return chatUser{ID: u.ID, Username: u.Username, Name: u.FirstName}
+}
+
+func (m *telegramMessage) MessageID() int {
+	if m.c.Callback() != nil || m.c.Message() == nil {
+		return 0
+	}
+	return m.c.Message().ID
+}
+
+func (m *telegramMessage) send(what any, opts ...any) (string, error) {
+	msg, err := m.t.b.Send(m.c.Chat(), what, withThread(m.Thread(), opts)...)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(msg.ID), nil
+}
+
+func (m *telegramMessage) Send(text string) (string, error) {
+	return m.send(text)
+}
+
+func (m *telegramMessage) Reply(text string) (string, error) {
+	return m.send(text)
+}
+
+func (m *telegramMessage) ReplyTemplated(t templated) error {
+	_, err := m.send(t)
+	return err
+}
+
+func (m *telegramMessage) Edit(ref, text string) error {
+	_, err := m.t.b.Edit(tb.StoredMessage{MessageID: ref, ChatID: m.c.Chat().ID}, text)
+	return err
+}
+
+func (m *telegramMessage) ReplyMedia(set MediaSet) error {
+	_, err := m.send(set.pick())
+	return err
+}
+
+func (m *telegramMessage) AskApproval(text, counter string) error {
+	menu := &tb.ReplyMarkup{}
+	menu.Inline(menu.Row(menu.Data("✅ Подтвердить сброс", approveResetBtn.Unique, counter)))
+	_, err := m.send(text, menu)
+	return err
+}
+
+func (m *telegramMessage) Achieved(list []awarded) {
+	announceAchievements(m.t.b, m.t.store, m.c.Chat().ID, list)
+}
+
+func (m *telegramMessage) Changed() {
+	refreshPinnedChat(m.t.b, m.t.cfg, m.t.store, m.c.Chat().ID)
+}
+
+func (m *telegramMessage) AllowDetection() bool {
+	return m.t.detections.Allow(m.c)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+)
+
+// Template is one response variant. In config it is either a plain string
+// or a mapping with text and weight.
+type Template struct {
+	Text   string `yaml:"text"`
+	Weight int    `yaml:"weight"`
+}
+
+// UnmarshalYAML accepts both `- "text"` and `- {text: "...", weight: 3}`
+func (t *Template) UnmarshalYAML(unmarshal func(any) error) error {
+	var text string
+	if err := unmarshal(&text); err == nil {
+		t.Text, t.Weight = text, 1
+		return nil
+	}
+	type plain Template
+	if err := unmarshal((*plain)(t)); err != nil {
+		return err
+	}
+	if t.Weight <= 0 {
+		t.Weight = 1
+	}
+	return nil
+}
+
+// TemplatesConfig holds response variants per event. Placeholders:
+//
+//	detection: {keyword} {topic}
+//	reset:     {topic} {now} {days} {days_text} {count} {prev}
+//	days:      {topic} {days} {days_text} {count} {last}
+//
+// {days} and {count} are the bare number, {days_text} comes with the
+// agreeing noun ("5 дней").
+type TemplatesConfig struct {
+	Detection []Template `yaml:"detection"`
+	Reset     []Template `yaml:"reset"`
+	Days      []Template `yaml:"days"`
+}
+
+var defaultTemplates = TemplatesConfig{
+	Detection: []Template{{Text: "Кто-то сказал «{keyword}»?\nСбросить счётчик дней без {topic}? Используйте /reset для подтверждения.", Weight: 1}},
+	Reset:     []Template{{Text: "Кто-то что-то написал про {topic} {now} 💀💀💀 запомнили, мы продержались {days_text}.\nПоследнее упоминание до этого было: {prev}", Weight: 1}},
+	Days:      []Template{{Text: "{days_text} без упоминания {topic}.\nПоследнее упоминание было: {last}", Weight: 1}},
+}
+
+// pickTemplate returns the text of a weighted random variant
+func pickTemplate(ts []Template) string {
+	total := 0
+	for _, t := range ts {
+		total += t.Weight
+	}
+	if total == 0 {
+		return ""
+	}
+	n := rand.Intn(total)
+	for _, t := range ts {
+		if n < t.Weight {
+			return t.Text
+		}
+		n -= t.Weight
+	}
+	return ts[len(ts)-1].Text
+}
+
+// renderTemplate replaces {name} placeholders with vars
+func renderTemplate(tpl string, vars map[string]string) string {
+	pairs := make([]string, 0, len(vars)*2)
+	for k, v := range vars {
+		pairs = append(pairs, "{"+k+"}", v)
+	}
+	return strings.NewReplacer(pairs...).Replace(tpl)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// threadOf returns the forum topic of msg, 0 for the General topic and
+// chats without topics
+func threadOf(msg *tb.Message) int {
+	if msg == nil || !msg.TopicMessage {
+		return 0
+	}
+	return msg.ThreadID
+}
+
+// withThread puts the message into forum topic thread, keeping the other options
+func withThread(thread int, opts []any) []any {
+	if thread == 0 {
+		return opts
+	}
+	return append([]any{&tb.SendOptions{ThreadID: thread}}, opts...)
+}
+
+// threadContext answers into the forum topic of the update instead of General
+type threadContext struct {
+	tb.Context
+	thread int
+}
+
+func (c threadContext) Send(what any, opts ...any) error {
+	return c.Context.Send(what, withThread(c.thread, opts)...)
+}
+
+// forumThreads makes handlers reply into the topic the update came from
+func forumThreads(next tb.HandlerFunc) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		if thread := threadOf(c.Message()); thread != 0 {
+			return next(threadContext{Context: c, thread: thread})
+		}
+		return next(c)
+	}
+}
+
+// watchesThread reports whether detection runs in thread. Chats without
+// configured threads are watched everywhere.
+func (st *ChatState) watchesThread(thread int) bool {
+	return len(st.Threads) == 0 || slices.Contains(st.Threads, thread)
+}
+
+// homeThread is where scheduled messages of the chat go
+func (st *ChatState) homeThread() int {
+	if len(st.Threads) == 0 {
+		return 0
+	}
+	return st.Threads[0]
+}
+
+// postToChat sends a bot-initiated message to the chat's home thread
+func postToChat(b *tb.Bot, store *Store, chatID int64, what any, opts ...any) (*tb.Message, error) {
+	var thread int
+	store.View(func(s *Storage) {
+		thread = s.Chat(chatID).homeThread()
+	})
+	msg, err := b.Send(&tb.Chat{ID: chatID}, what, withThread(thread, opts)...)
+	// a group that became a supergroup without us noticing
+	if to := followMigration(store, chatID, err); to != 0 {
+		return b.Send(&tb.Chat{ID: to}, what, withThread(thread, opts)...)
+	}
+	return msg, err
+}
+
+func handleThread(store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		arg := strings.ToLower(strings.TrimSpace(c.Message().Payload))
+		thread := threadOf(c.Message())
+
+		var threads []int
+		switch arg {
+		case "on", "off", "all":
+			if err := store.UpdateContext(ctxOf(c), func(s *Storage) {
+				st := s.Chat(c.Chat().ID)
+				switch arg {
+				case "on":
+					if !slices.Contains(st.Threads, thread) {
+						st.Threads = append(st.Threads, thread)
+					}
+				case "off":
+					st.Threads = slices.DeleteFunc(st.Threads, func(t int) bool { return t == thread })
+				case "all":
+					st.Threads = nil
+				}
+				threads = st.Threads
+			}); err != nil {
+				return err
+			}
+		case "":
+			if err := store.ViewContext(ctxOf(c), func(s *Storage) {
+				threads = s.Chat(c.Chat().ID).Threads
+			}); err != nil {
+				return err
+			}
+		default:
+			return c.Send("Использование: /thread on|off|all — в нужной теме форума")
+		}
+
+		if len(threads) == 0 {
+			return c.Send("Бот следит за всеми темами чата.")
+		}
+		ids := make([]string, len(threads))
+		for i, t := range threads {
+			ids[i] = fmt.Sprint(t)
+		}
+		return c.Send("Бот следит только за темами: " + strings.Join(ids, ", ") +
+			".\nПлановые сообщения уходят в первую из них.")
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// VoiceConfig enables keyword detection in voice messages: they are
+// transcribed by Provider and the text goes through the usual matching.
+type VoiceConfig struct {
+	// Provider is "openai" (Whisper API), "whisper.cpp" (a local
+	// whisper.cpp server) or "yandex" (SpeechKit); empty disables voice
+	Provider string `yaml:"provider"`
+	// URL overrides the endpoint, required for whisper.cpp
+	URL    string `yaml:"url"`
+	APIKey string `yaml:"api_key"`
+	// Model is the Whisper model of the OpenAI API, whisper-1 by default
+	Model string `yaml:"model"`
+	// FolderID is the Yandex Cloud folder billed for SpeechKit
+	FolderID string `yaml:"folder_id"`
+	Language string `yaml:"language"`
+	// MaxDuration skips longer voice messages, 1 minute by default
+	MaxDuration time.Duration `yaml:"max_duration"`
+}
+
+// Transcriber turns a voice message into text
+type Transcriber interface {
+	// Transcribe converts OGG/Opus audio, as sent by Telegram, into text
+	Transcribe(ctx context.Context, audio []byte) (string, error)
+}
+
+// newTranscriber returns the configured provider, nil when voice messages
+// are not transcribed
+func newTranscriber(cfg VoiceConfig) Transcriber {
+	client := &http.Client{Timeout: time.Minute}
+	switch cfg.Provider {
+	case "openai":
+		return &whisperTranscriber{cfg: cfg, client: client}
+	case "whisper.cpp":
+		return &whisperCppTranscriber{cfg: cfg, client: client}
+	case "yandex":
+		return &yandexTranscriber{cfg: cfg, client: client}
+	}
+	return nil
+}
+
+// validVoice checks the provider settings and fills in the defaults
+func validVoice(cfg *VoiceConfig) error {
+	if cfg.MaxDuration <= 0 {
+		cfg.MaxDuration = time.Minute
+	}
+	if cfg.Language == "" {
+		cfg.Language = "ru"
+	}
+	switch cfg.Provider {
+	case "":
+	case "openai":
+		if cfg.APIKey == "" {
+			return fmt.Errorf("voice.api_key is required for the openai provider")
+		}
+		if cfg.URL == "" {
+			cfg.URL = "https://api.openai.com/v1/audio/transcriptions"
+		}
+		if cfg.Model == "" {
+			cfg.Model = "whisper-1"
+		}
+	case "whisper.cpp":
+		if cfg.URL == "" {
+			return fmt.Errorf("voice.url is required for the whisper.cpp provider, e.g. http://localhost:8080/inference")
+		}
+	case "yandex":
+		if cfg.APIKey == "" || cfg.FolderID == "" {
+			return fmt.Errorf("voice.api_key and voice.folder_id are required for the yandex provider")
+		}
+		if cfg.URL == "" {
+			cfg.URL = "https://stt.api.cloud.yandex.net/speech/v1/stt:recognize"
+		}
+		// SpeechKit wants a locale
+		if !strings.Contains(cfg.Language, "-") {
+			cfg.Language += "-" + strings.ToUpper(cfg.Language)
+		}
+	default:
+		return fmt.Errorf("invalid voice.provider %q, expected openai, whisper.cpp or yandex", cfg.Provider)
+	}
+	return nil
+}
+
+// whisperTranscriber uses the OpenAI audio transcription API
+type whisperTranscriber struct {
+	cfg    VoiceConfig
+	client *http.Client
+}
+
+func (w *whisperTranscriber) Transcribe(ctx context.Context, audio []byte) (string, error) {
+	body, contentType, err := transcriptionForm(audio, map[string]string{
+		"model":           w.cfg.Model,
+		"language":        w.cfg.Language,
+		"response_format": "json",
+	})
+	if err != nil {
+		return "", err
+	}
+	return postTranscription(ctx, w.client, w.cfg.URL, contentType, "Bearer "+w.cfg.APIKey, body)
+}
+
+// whisperCppTranscriber uses the /inference endpoint of the whisper.cpp
+// server, started with --convert to accept OGG
+type whisperCppTranscriber struct {
+	cfg    VoiceConfig
+	client *http.Client
+}
+
+func (w *whisperCppTranscriber) Transcribe(ctx context.Context, audio []byte) (string, error) {
+	body, contentType, err := transcriptionForm(audio, map[string]string{
+		"language":        w.cfg.Language,
+		"response_format": "json",
+	})
+	if err != nil {
+		return "", err
+	}
+	auth := ""
+	if w.cfg.APIKey != "" {
+		auth = "Bearer " + w.cfg.APIKey
+	}
+	return postTranscription(ctx, w.client, w.cfg.URL, contentType, auth, body)
+}
+
+// yandexTranscriber uses the synchronous SpeechKit recognition, which takes
+// up to 30 seconds of audio
+type yandexTranscriber struct {
+	cfg    VoiceConfig
+	client *http.Client
+}
+
+func (y *yandexTranscriber) Transcribe(ctx context.Context, audio []byte) (string, error) {
+	q := url.Values{"lang": {y.cfg.Language}, "folderId": {y.cfg.FolderID}, "format": {"oggopus"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, y.cfg.URL+"?"+q.Encode(), bytes.NewReader(audio))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Api-Key "+y.cfg.APIKey)
+	var res struct {
+		Result string `json:"result"`
+	}
+	if err := doTranscription(y.client, req, &res); err != nil {
+		return "", err
+	}
+	return res.Result, nil
+}
+
+// transcriptionForm builds the multipart form of the Whisper style APIs
+func transcriptionForm(audio []byte, fields map[string]string) (*bytes.Buffer, string, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		if err := mw.WriteField(k, v); err != nil {
+			return nil, "", err
+		}
+	}
+	fw, err := mw.CreateFormFile("file", "voice.ogg")
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := fw.Write(audio); err != nil {
+		return nil, "", err
+	}
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+	return &buf, mw.FormDataContentType(), nil
+}
+
+// postTranscription posts a Whisper style form and returns its "text"
+func postTranscription(ctx context.Context, client *http.Client, endpoint, contentType, auth string, body io.Reader) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	var res struct {
+		Text string `json:"text"`
+	}
+	if err := doTranscription(client, req, &res); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(res.Text), nil
+}
+
+func doTranscription(client *http.Client, req *http.Request, out any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("transcription failed: %s: %s", resp.Status, msg)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// maxVoiceSize is the largest file the Bot API lets bots download
+const maxVoiceSize = 20 << 20
+
+// downloadFile fetches file from the Bot API like b.File, giving up when
+// ctx ends
+func downloadFile(ctx context.Context, b *tb.Bot, client *http.Client, file *tb.File) (io.ReadCloser, error) {
+	f, err := b.FileByID(file.FileID)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.URL+"/file/bot"+b.Token+"/"+f.FilePath, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("expected status 200 but got %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// handleVoice transcribes voice messages and passes the text to detect
+func handleVoice(b *tb.Bot, cfg Config, t Transcriber, detect func(c tb.Context, text string) error) tb.HandlerFunc {
+	client := newHTTPClient(cfg)
+	return func(c tb.Context) error {
+		msg := c.Message()
+		if cfg.IgnoreBots && fromBot(msg) {
+			return nil
+		}
+		if d := time.Duration(msg.Voice.Duration) * time.Second; d > cfg.Voice.MaxDuration {
+			ctxLogger(c).Debug("Skipping long voice message", "duration", d)
+			return nil
+		}
+		rc, err := downloadFile(ctxOf(c), b, client, &msg.Voice.File)
+		if err != nil {
+			return fmt.Errorf("download voice: %w", err)
+		}
+		audio, err := io.ReadAll(io.LimitReader(rc, maxVoiceSize))
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("download voice: %w", err)
+		}
+		text, err := t.Transcribe(ctxOf(c), audio)
+		if err != nil {
+			// the message stays unchecked, like one sent while the bot was down
+			ctxLogger(c).Warn("Failed to transcribe voice message", "provider", cfg.Voice.Provider, "err", err)
+			return nil
+		}
+		ctxLogger(c).Debug("Transcribed voice message", "text", text)
+		return detect(c, text)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// userStats summarizes the detections of a single user
+type userStats struct {
+	Who       string
+	Hits      int
+	Confirmed int
+	Keyword   string
+	LastHit   time.Time
+	keywords  map[string]int
+}
+
+// statsForUser aggregates detections of the user matching match, trimmed
+// ones included
+func statsForUser(st *ChatState, match func(Event) bool) userStats {
+	us := userStats{keywords: make(map[string]int)}
+	if st.Trimmed != nil {
+		for _, t := range st.Trimmed.Users {
+			if !match(t.Last) {
+				continue
+			}
+			if t.Last.Time.After(us.LastHit) {
+				us.Who, us.LastHit = t.Last.Who(), t.Last.Time
+			}
+			us.Hits += t.Hits
+			us.Confirmed += t.Confirmed
+			for kw, n := range t.Keywords {
+				us.keywords[kw] += n
+			}
+		}
+	}
+	for _, ev := range st.History {
+		if ev.Type != EventDetection || !match(ev) {
+			continue
+		}
+		us.Who = ev.Who()
+		us.Hits++
+		us.LastHit = ev.Time
+		if ev.Confirmed {
+			us.Confirmed++
+		}
+		us.keywords[strings.ToLower(ev.Keyword)]++
+	}
+	for kw, n := range us.keywords {
+		if best := us.keywords[us.Keyword]; n > best || (n == best && kw < us.Keyword) {
+			us.Keyword = kw
+		}
+	}
+	return us
+}
+
+// userMatcher selects events of the replied-to user or of the @username / name
+// in arg. Anonymous events never match, and id is the replied-to user.
+func userMatcher(c tb.Context, arg string) (match func(Event) bool, id int64, ok bool) {
+	if reply := c.Message().ReplyTo; reply != nil && reply.Sender != nil {
+		id = reply.Sender.ID
+		return func(ev Event) bool { return ev.UserID == id && !ev.Anonymous }, id, true
+	}
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return nil, 0, false
+	}
+	if strings.HasPrefix(arg, "@") {
+		name := strings.TrimPrefix(arg, "@")
+		return func(ev Event) bool { return strings.EqualFold(ev.Username, name) }, 0, true
+	}
+	return func(ev Event) bool { return ev.Username == "" && strings.EqualFold(ev.Name, arg) }, 0, true
+}
+
+func handleUserStats(cfg Config, store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		match, id, ok := userMatcher(c, c.Message().Payload)
+		if !ok {
+			return c.Send("Использование: /userstats @username или ответом на сообщение участника.")
+		}
+		var us userStats
+		var loc *time.Location
+		var hidden bool
+		if err := store.ViewContext(ctxOf(c), func(s *Storage) {
+			hidden = s.OptedOut[id]
+			st := s.Chat(c.Chat().ID)
+			us = statsForUser(st, match)
+			loc = chatLocation(cfg, st)
+		}); err != nil {
+			return err
+		}
+		if hidden {
+			return c.Send("Участник скрыл себя из статистики (/optout).")
+		}
+		if us.Hits == 0 {
+			return c.Send("Этот участник ещё ни разу не попадался. Так держать!")
+		}
+		return c.Send(fmt.Sprintf("📊 %s\nСрабатываний: %d (из них привели к сбросу: %d)\nЛюбимое слово: «%s»\nПоследний раз: %s",
+			us.Who, us.Hits, us.Confirmed, us.Keyword, us.LastHit.In(loc).Format("02.01.2006 15:04")))
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// Build information, set with
+// -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=..."
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// buildInfo fills in commit and date from the VCS stamp of the Go toolchain
+// when they were not set by ldflags
+func buildInfo() (ver, rev, date string) {
+	ver, rev, date = version, commit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && rev == "":
+				rev = s.Value
+			case s.Key == "vcs.time" && date == "":
+				date = s.Value
+			}
+		}
+	}
+	if len(rev) > 12 {
+		rev = rev[:12]
+	}
+	return ver, rev, date
+}
+
+func versionText() string {
+	ver, rev, date := buildInfo()
+	if rev == "" {
+		rev = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+	return fmt.Sprintf("dayswithout %s\nкоммит: %s\nсобран: %s\n%s", ver, rev, date, runtime.Version())
+}
+
+func handleVersion() tb.HandlerFunc {
+	return func(c tb.Context) error {
+		return c.Send(versionText())
+	}
+}
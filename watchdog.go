@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// WatchdogConfig restarts the long poller of a bot when no getUpdates call
+// succeeded for Stall, and alerts when that doesn't help
+type WatchdogConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Stall   time.Duration `yaml:"stall"`
+}
+
+// watchPolling is the "watchdog" job. A stalled poller is replaced with a
+// fresh telebot instance; the admin is alerted when the restart fails or
+// polling is still stalled a Stall after it.
+func (bi *botInstance) watchPolling(now time.Time) {
+	if bi.lastPoll.Load() == 0 {
+		// the first poll counts from the start
+		bi.lastPoll.CompareAndSwap(0, now.UnixNano())
+		return
+	}
+	stalled := now.Sub(time.Unix(0, bi.lastPoll.Load()))
+	if stalled < bi.cfg.Watchdog.Stall {
+		if bi.restarts > 0 {
+			slog.Info("Polling recovered", "bot", bi.bot().Me.Username, "restarts", bi.restarts)
+			bi.restarts = 0
+		}
+		return
+	}
+
+	username := bi.bot().Me.Username
+	if bi.restarts > 0 {
+		alerts.Alert("опрос завис", fmt.Sprintf("@%s не получает апдейты %s, перезапуск не помог", username, stalled.Truncate(time.Second)))
+	}
+	slog.Warn("Polling stalled, restarting the poller", "bot", username, "stalled", stalled.Truncate(time.Second), "restarts", bi.restarts)
+	bi.restarts++
+	// the restarted poller gets a full Stall to recover
+	bi.lastPoll.Store(now.UnixNano())
+	bi.mu.Lock()
+	token := bi.token
+	bi.mu.Unlock()
+	if err := bi.reconnect(token, bi.cfg.Shutdown.Timeout); err != nil {
+		slog.Error("Failed to restart the poller", "bot", username, "err", err)
+		alerts.Alert("опрос завис", fmt.Sprintf("@%s не получает апдейты, перезапуск не удался: %v", username, err))
+	}
+}
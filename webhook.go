@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// WebhookConfig switches update delivery from long polling to a webhook.
+// Telegram posts updates to URL, the bot listens on Listen. With Cert and
+// Key set the listener serves HTTPS itself; SelfSigned uploads Cert to
+// Telegram so it trusts it. BehindProxy serves plain HTTP for a reverse proxy
+// that terminates TLS. SecretToken is checked on every request.
+type WebhookConfig struct {
+	Enabled     bool   `yaml:"enabled"`
+	Listen      string `yaml:"listen"`
+	URL         string `yaml:"url"`
+	Cert        string `yaml:"cert"`
+	Key         string `yaml:"key"`
+	SelfSigned  bool   `yaml:"self_signed"`
+	SecretToken string `yaml:"secret_token"`
+	BehindProxy bool   `yaml:"behind_proxy"`
+}
+
+// PollingConfig tunes update delivery: Timeout of a long poll, Limit of
+// updates per getUpdates call (Telegram's 100 when 0) and AllowedUpdates,
+// the update types to receive. AllowedUpdates also applies to the webhook.
+type PollingConfig struct {
+	Timeout        time.Duration `yaml:"timeout"`
+	Limit          int           `yaml:"limit"`
+	AllowedUpdates []string      `yaml:"allowed_updates"`
+}
+
+// maxPollTimeout keeps long polls within the timeout of the HTTP client
+const maxPollTimeout = 50 * time.Second
+
+// handledUpdates are the update types the handlers of cfg react to
+func handledUpdates(cfg Config) []string {
+	types := []string{"message", "callback_query", "my_chat_member"}
+	if cfg.Channels.Enabled {
+		types = append(types, "channel_post")
+	}
+	return types
+}
+
+// validPolling checks the polling settings
+func validPolling(p PollingConfig) error {
+	if p.Timeout > maxPollTimeout {
+		return fmt.Errorf("polling.timeout is over %s", maxPollTimeout)
+	}
+	if p.Limit < 0 || p.Limit > 100 {
+		return fmt.Errorf("polling.limit must be between 1 and 100")
+	}
+	for _, t := range p.AllowedUpdates {
+		if !slices.Contains(tb.AllowedUpdates, t) {
+			return fmt.Errorf("unknown update type %q in polling.allowed_updates", t)
+		}
+	}
+	return nil
+}
+
+// newPoller returns the update source selected in config
+func newPoller(cfg Config) tb.Poller {
+	if !cfg.Webhook.Enabled {
+		return &tb.LongPoller{
+			Timeout:        cfg.Polling.Timeout,
+			Limit:          cfg.Polling.Limit,
+			AllowedUpdates: cfg.Polling.AllowedUpdates,
+		}
+	}
+	wh := &tb.Webhook{
+		Listen:         cfg.Webhook.Listen,
+		SecretToken:    cfg.Webhook.SecretToken,
+		Endpoint:       &tb.WebhookEndpoint{PublicURL: cfg.Webhook.URL},
+		AllowedUpdates: cfg.Polling.AllowedUpdates,
+	}
+	if cfg.Webhook.SelfSigned {
+		wh.Endpoint.Cert = cfg.Webhook.Cert
+	}
+	slog.Info("Using webhook", "url", cfg.Webhook.URL, "addr", cfg.Webhook.Listen)
+	if cfg.Webhook.BehindProxy {
+		wh.Listen = ""
+		return &proxiedWebhook{Webhook: wh, listen: cfg.Webhook.Listen}
+	}
+	if cfg.Webhook.Cert != "" {
+		wh.TLS = &tb.WebhookTLS{Cert: cfg.Webhook.Cert, Key: cfg.Webhook.Key}
+	}
+	return wh
+}
+
+// proxiedWebhook serves the webhook over plain HTTP behind a TLS-terminating
+// reverse proxy
+type proxiedWebhook struct {
+	*tb.Webhook
+	listen string
+}
+
+// Poll registers the webhook and only then starts the listener, so no
+// update arrives before there is somewhere to put it
+func (p *proxiedWebhook) Poll(b *tb.Bot, dest chan tb.Update, stop chan struct{}) {
+	if err := b.SetWebhook(p.Webhook); err != nil {
+		b.OnError(err, nil)
+		close(stop)
+		return
+	}
+	srv := &http.Server{Addr: p.listen, Handler: forwarded(p.handler(dest))}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("Webhook listener failed", "err", err)
+		}
+	}()
+	<-stop
+	if err := srv.Shutdown(context.Background()); err != nil {
+		slog.Error("Failed to stop webhook listener", "err", err)
+	}
+	close(stop)
+}
+
+// handler passes the updates Telegram posts to dest
+func (p *proxiedWebhook) handler(dest chan tb.Update) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p.SecretToken != "" && r.Header.Get("X-Telegram-Bot-Api-Secret-Token") != p.SecretToken {
+			slog.Debug("Rejecting webhook request with a wrong secret token")
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		var update tb.Update
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			slog.Debug("Failed to decode webhook update", "err", err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		dest <- update
+	})
+}
+
+// forwarded accepts only POSTs and logs the client address reported by the proxy
+func forwarded(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := r.RemoteAddr
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			client, _, _ = strings.Cut(fwd, ",")
+		} else if real := r.Header.Get("X-Real-IP"); real != "" {
+			client = real
+		}
+		if r.Method != http.MethodPost {
+			slog.Debug("Rejecting webhook request", "method", r.Method, "client", client)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		slog.Debug("Webhook request", "client", strings.TrimSpace(client), "proto", r.Header.Get("X-Forwarded-Proto"))
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// wsPipe is a client connection to a fake server, the other end of the pipe
+func wsPipe(t *testing.T) (*wsConn, net.Conn) {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close(); server.Close() })
+	return &wsConn{conn: client, r: bufio.NewReader(client)}, server
+}
+
+// clientFrame reads a frame written by the client, checking that it is
+// masked, and returns it unmasked
+func clientFrame(t *testing.T, r io.Reader) (head, len7 byte, data []byte) {
+	t.Helper()
+	head, len7, data, err := readClientFrame(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return head, len7, data
+}
+
+func readClientFrame(r io.Reader) (head, len7 byte, data []byte, err error) {
+	var h [2]byte
+	if _, err = io.ReadFull(r, h[:]); err != nil {
+		return
+	}
+	if h[1]&0x80 == 0 {
+		return 0, 0, nil, errors.New("client frame is not masked")
+	}
+	head, len7 = h[0], h[1]&0x7f
+	n := uint64(len7)
+	switch n {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(r, ext[:])
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	var mask [4]byte
+	io.ReadFull(r, mask[:])
+	data = make([]byte, n)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return
+	}
+	for i := range data {
+		data[i] ^= mask[i%4]
+	}
+	return
+}
+
+// serverFrame is an unmasked frame as servers send them
+func serverFrame(fin bool, op byte, data []byte) []byte {
+	head := op
+	if fin {
+		head |= 0x80
+	}
+	frame := []byte{head}
+	switch n := len(data); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = binary.BigEndian.AppendUint16(append(frame, 126), uint16(n))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, 127), uint64(n))
+	}
+	return append(frame, data...)
+}
+
+func TestWebsocketWriteFrame(t *testing.T) {
+	// the payload length takes 7 bits, then 16 and 64 bits after 126 and 127
+	tests := []struct {
+		size int
+		len7 byte
+	}{
+		{0, 0},
+		{125, 125},
+		{126, 126},
+		{0xffff, 126},
+		{0x10000, 127},
+	}
+	for _, tt := range tests {
+		c, server := wsPipe(t)
+		data := bytes.Repeat([]byte{'z'}, tt.size)
+		go c.WriteText(data)
+		head, len7, got := clientFrame(t, server)
+		if head != 0x80|wsText {
+			t.Errorf("%d bytes: first byte %#x, want a final text frame", tt.size, head)
+		}
+		if len7 != tt.len7 {
+			t.Errorf("%d bytes: length field %d, want %d", tt.size, len7, tt.len7)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%d bytes: payload differs after unmasking", tt.size)
+		}
+	}
+}
+
+func TestWebsocketReadText(t *testing.T) {
+	c, server := wsPipe(t)
+	long := bytes.Repeat([]byte{'l'}, 70000)
+	go func() {
+		server.Write(serverFrame(true, wsText, []byte("hello")))
+		// a fragmented message with a ping in between
+		server.Write(serverFrame(false, wsText, []byte("frag")))
+		server.Write(serverFrame(true, wsPing, []byte("p")))
+		server.Write(serverFrame(true, 0x0, []byte("mented")))
+		server.Write(serverFrame(true, wsPong, nil))
+		server.Write(serverFrame(true, wsText, long))
+	}()
+
+	want := [][]byte{[]byte("hello"), []byte("fragmented"), long}
+	pong := make(chan []byte, 1)
+	for i, w := range want {
+		if i == 1 {
+			// the pong goes out while the fragments are read
+			go func() {
+				_, _, data, _ := readClientFrame(server)
+				pong <- data
+			}()
+		}
+		got, err := c.ReadText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, w) {
+			t.Errorf("message %d = %.20q (%d bytes), want %.20q", i, got, len(got), w)
+		}
+	}
+	if got := <-pong; string(got) != "p" {
+		t.Errorf("pong = %q, want the ping payload", got)
+	}
+}
+
+func TestWebsocketReadClose(t *testing.T) {
+	c, server := wsPipe(t)
+	go server.Write(serverFrame(true, wsClose, append([]byte{0x0f, 0xa4}, "Authentication failed."...)))
+	_, err := c.ReadText()
+	var closed *wsCloseError
+	if !errors.As(err, &closed) || closed.Code != 4004 || closed.Reason != "Authentication failed." {
+		t.Errorf("ReadText = %v, want the close with code 4004", err)
+	}
+}
+
+func TestWebsocketFrameTooLarge(t *testing.T) {
+	c, server := wsPipe(t)
+	go server.Write(binary.BigEndian.AppendUint64([]byte{0x80 | wsText, 127}, wsMaxFrame+1))
+	if _, err := c.ReadText(); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("ReadText = %v, want the size error", err)
+	}
+}
+
+func TestWebsocketClose(t *testing.T) {
+	c, server := wsPipe(t)
+	go c.Close()
+	head, _, data := clientFrame(t, server)
+	if head != 0x80|wsClose || !bytes.Equal(data, []byte{0x03, 0xe8}) {
+		t.Errorf("close frame %#x %x, want a normal close 1000", head, data)
+	}
+}
+
+// wsServer answers the handshake, with the accept key spoiled when bad
+func wsServer(t *testing.T, bad bool) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" || r.Header.Get("Sec-Websocket-Version") != "13" {
+			http.Error(w, "not a websocket handshake", http.StatusBadRequest)
+			return
+		}
+		sum := sha1.Sum([]byte(r.Header.Get("Sec-Websocket-Key") + wsGUID))
+		accept := base64.StdEncoding.EncodeToString(sum[:])
+		if bad {
+			accept = "x" + accept
+		}
+		w.Header().Set("Upgrade", "websocket")
+		w.Header().Set("Connection", "Upgrade")
+		w.Header().Set("Sec-Websocket-Accept", accept)
+		w.WriteHeader(http.StatusSwitchingProtocols)
+		conn, buf, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf.Write(serverFrame(true, wsText, []byte("ready")))
+		buf.Flush()
+		io.Copy(io.Discard, conn)
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + "/gateway"
+}
+
+func TestDialWebsocket(t *testing.T) {
+	c, err := dialWebsocket(wsServer(t, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	got, err := c.ReadText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "ready" {
+		t.Errorf("first message = %q, want ready", got)
+	}
+}
+
+func TestDialWebsocketBadAccept(t *testing.T) {
+	if _, err := dialWebsocket(wsServer(t, true)); err == nil {
+		t.Error("dialWebsocket accepted a wrong Sec-WebSocket-Accept")
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// WeeklyConfig controls the weekly summary report
+type WeeklyConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Weekday string `yaml:"weekday"` // "monday", "sun", ...
+	Time    string `yaml:"time"`    // "HH:MM", server time
+}
+
+// periodStats aggregates the history of a chat over a time window
+type periodStats struct {
+	Detections int
+	Resets     int
+	// Offenders counts per rankKey, names holds their latest names
+	Offenders map[string]int
+	names     map[string]string
+}
+
+func newPeriodStats() periodStats {
+	return periodStats{Offenders: make(map[string]int), names: make(map[string]string)}
+}
+
+// blame counts ev against its user
+func (ps periodStats) blame(ev Event) {
+	key := rankKey(ev)
+	ps.Offenders[key]++
+	ps.names[key] = ev.Who()
+}
+
+// rankKey is the user behind ev in rankings: the ID, so that a member who
+// changes the name stays one entry, and a single entry for all anonymous
+// events. Events without a user, like channel posts, go by name.
+func rankKey(ev Event) string {
+	switch {
+	case ev.Anonymous:
+		return anonymousName
+	case ev.UserID != 0:
+		return "id:" + strconv.FormatInt(ev.UserID, 10)
+	}
+	return "name:" + ev.Who()
+}
+
+func collectStats(st *ChatState, from, to time.Time) periodStats {
+	ps := newPeriodStats()
+	for _, ev := range st.History {
+		if ev.Time.Before(from) || !ev.Time.Before(to) {
+			continue
+		}
+		switch ev.Type {
+		case EventDetection:
+			ps.Detections++
+			ps.blame(ev)
+		case EventReset:
+			ps.Resets++
+		}
+	}
+	return ps
+}
+
+// topOffender returns the name of the user counted most, ties broken by
+// name
+func (ps periodStats) topOffender() (string, int) {
+	var who string
+	var max int
+	for key, n := range ps.Offenders {
+		if name := ps.names[key]; n > max || (n == max && name < who) {
+			who, max = name, n
+		}
+	}
+	return who, max
+}
+
+// trend formats the difference between this and the previous period
+func trend(cur, prev int) string {
+	switch {
+	case cur > prev:
+		return fmt.Sprintf("↑ +%d к прошлой неделе", cur-prev)
+	case cur < prev:
+		return fmt.Sprintf("↓ −%d к прошлой неделе", prev-cur)
+	default:
+		return "как на прошлой неделе"
+	}
+}
+
+func buildWeekly(cfg Config, st *ChatState, now time.Time) string {
+	week := collectStats(st, now.AddDate(0, 0, -7), now)
+	prev := collectStats(st, now.AddDate(0, 0, -14), now.AddDate(0, 0, -7))
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🗓 Неделя без %s: итоги\n", counterTopic(cfg, &st.Counter))
+	fmt.Fprintf(&sb, "Срабатываний: %d (%s)\n", week.Detections, trend(week.Detections, prev.Detections))
+	fmt.Fprintf(&sb, "Сбросов: %d (%s)\n", week.Resets, trend(week.Resets, prev.Resets))
+	if who, n := week.topOffender(); n > 0 {
+		fmt.Fprintf(&sb, "Главный нарушитель недели: %s (%d)\n", who, n)
+	}
+	if !st.LastMention.IsZero() {
+		fmt.Fprintf(&sb, "Текущая серия: %s.", plural(st.Days(), "day"))
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+func checkWeekly(b *tb.Bot, cfg Config, store *Store, now time.Time) {
+	day, _ := parseWeekday(cfg.Weekly.Weekday)
+	at, _ := parseClock(cfg.Weekly.Time)
+
+	type report struct {
+		chatID int64
+		text   string
+	}
+	var due []report
+	var first []int64
+	store.View(func(s *Storage) {
+		for chatID, st := range s.ActiveChats() {
+			switch {
+			case st.LastWeekly.IsZero():
+				// a chat seen for the first time starts with the next slot
+				// instead of getting one right away
+				first = append(first, chatID)
+			case weeklyDue(day, at, st.LastWeekly, now):
+				due = append(due, report{chatID: chatID, text: buildWeekly(cfg, st, now)})
+			}
+		}
+	})
+	if len(due) == 0 && len(first) == 0 {
+		return
+	}
+	store.Update(func(s *Storage) {
+		for _, chatID := range first {
+			s.Chat(chatID).LastWeekly = now
+		}
+		for _, r := range due {
+			s.Chat(r.chatID).LastWeekly = now
+		}
+	})
+
+	for _, r := range due {
+		slog.Debug("Sending weekly report", "chat_id", r.chatID)
+		if _, err := postToChat(b, store, r.chatID, r.text); err != nil {
+			slog.Error("Failed to send weekly report", "chat_id", r.chatID, "err", err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// messageLink returns a t.me permalink to message id in chat, or "" when
+// the chat has no linkable messages (private chats and basic groups)
+func messageLink(chat *tb.Chat, id int) string {
+	if id == 0 {
+		return ""
+	}
+	if chat.Username != "" {
+		return fmt.Sprintf("https://t.me/%s/%d", chat.Username, id)
+	}
+	if s := strconv.FormatInt(chat.ID, 10); strings.HasPrefix(s, "-100") {
+		return fmt.Sprintf("https://t.me/c/%s/%d", strings.TrimPrefix(s, "-100"), id)
+	}
+	return ""
+}
+
+// lastReset returns the latest reset and the detection it confirmed, if any
+func lastReset(st *ChatState) (reset, trigger *Event) {
+	for i := len(st.History) - 1; i >= 0; i-- {
+		ev := &st.History[i]
+		if reset == nil {
+			if ev.Type == EventReset {
+				reset = ev
+			}
+			continue
+		}
+		if ev.Counter != reset.Counter {
+			continue
+		}
+		if ev.Type == EventDetection {
+			trigger = ev
+		}
+		break
+	}
+	return reset, trigger
+}
+
+func handleWhoReset(cfg Config, store *Store) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		var reset, trigger Event
+		var found, triggered bool
+		var topic string
+		var loc *time.Location
+		if err := store.ViewContext(ctxOf(c), func(s *Storage) {
+			st := s.Chat(c.Chat().ID)
+			r, t := lastReset(st)
+			if found = r != nil; !found {
+				return
+			}
+			reset = *r
+			if triggered = t != nil; triggered {
+				trigger = *t
+			}
+			topic = reset.Counter
+			if ctr := st.CounterByName(reset.Counter); ctr != nil {
+				topic = counterTopic(cfg, ctr)
+			}
+			loc = chatLocation(cfg, st)
+		}); err != nil {
+			return err
+		}
+		if !found {
+			return c.Send("Сбросов ещё не было.")
+		}
+
+		lines := []string{fmt.Sprintf("Последний сброс счётчика %s: %s, %s.",
+			topic, reset.Who(), reset.Time.In(loc).Format("02.01.2006 15:04"))}
+		if triggered {
+			lines = append(lines, fmt.Sprintf("Сработало на «%s» от %s.", trigger.Keyword, trigger.Who()))
+			if link := messageLink(c.Chat(), trigger.MessageID); link != "" {
+				lines = append(lines, "Сообщение: "+link)
+			}
+		} else {
+			lines = append(lines, "Сброшен вручную, без срабатывания.")
+		}
+		if reset.Reason != "" {
+			lines = append(lines, "Причина: "+reset.Reason)
+		}
+		return c.Send(strings.Join(lines, "\n"), tb.NoPreview)
+	}
+}